@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/domains"
+	"github.com/jingy/Go-Shortener/internal/export"
+	"github.com/jingy/Go-Shortener/internal/storage"
+	"github.com/jingy/Go-Shortener/internal/sweeper"
+)
+
+// command is an admin subcommand
+type command struct {
+	name        string
+	description string
+	run         func(ctx context.Context, cfg *config.Config, backend *storage.Backend, args []string) error
+}
+
+var commands = []command{
+	{
+		name:        "sweep",
+		description: "Archive and delete expired URLs",
+		run:         runSweep,
+	},
+	{
+		name:        "cleanup",
+		description: "Delete old counter buckets",
+		run:         runCleanup,
+	},
+	{
+		name:        "export",
+		description: "Export clicks or link aggregates as CSV, JSON Lines or Parquet",
+		run:         runExport,
+	},
+	{
+		name:        "domains",
+		description: "List, block, allow or remove domain rules of the table source",
+		run:         runDomains,
+	},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == os.Args[1] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		usage()
+		os.Exit(2)
+	}
+
+	// Load configuration from the environment
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to load config: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	backend, err := storage.Open(ctx, cfg.Storage)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to open storage: %v\n", err)
+		os.Exit(1)
+	}
+	defer backend.Close()
+
+	if err := cmd.run(ctx, cfg, backend, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.name, err)
+		backend.Close()
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: admin <command> [flags]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+}
+
+func runSweep(ctx context.Context, cfg *config.Config, backend *storage.Backend, args []string) error {
+	flags := flag.NewFlagSet("sweep", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report expired URLs without archiving or deleting them")
+	flags.Parse(args)
+
+	report, err := sweeper.New(backend.URLs, backend.Archiver).Sweep(ctx, sweeper.Options{DryRun: *dryRun})
+	if report != nil {
+		printJSON(report)
+	}
+	return err
+}
+
+func runCleanup(ctx context.Context, cfg *config.Config, backend *storage.Backend, args []string) error {
+	flags := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	days := flags.Int("days", cfg.Storage.CounterRetentionDays, "number of past days of buckets to keep")
+	dryRun := flags.Bool("dry-run", false, "report old buckets without deleting them")
+	concurrency := flags.Int("concurrency", 0, "maximum batch deletes in flight")
+	flags.Parse(args)
+
+	cleaner, ok := backend.Counter.(storage.BucketCleaner)
+	if !ok {
+		return fmt.Errorf("the %s backend has no counter buckets to clean up", cfg.Storage.Backend)
+	}
+
+	report, err := cleaner.CleanupOldBuckets(ctx, storage.CleanupOptions{
+		DaysToKeep:  *days,
+		DryRun:      *dryRun,
+		Concurrency: *concurrency,
+	})
+	if report != nil {
+		printJSON(report)
+	}
+	return err
+}
+
+func runExport(ctx context.Context, cfg *config.Config, backend *storage.Backend, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	shortCode := flags.String("code", "", "short code to export")
+	all := flags.Bool("all", false, "export every short URL")
+	from := flags.String("from", "", "RFC3339 time of the first click to export")
+	to := flags.String("to", "", "RFC3339 time of the end of the export, exclusive")
+	format := flags.String("format", string(export.CSV), "csv, jsonl or parquet")
+	kind := flags.String("kind", string(export.Clicks), "clicks or links")
+	output := flags.String("o", "", "file to write, stdout when empty")
+	flags.Parse(args)
+
+	if *all == (*shortCode != "") {
+		return errors.New("exactly one of -code and -all is required")
+	}
+	req := export.Request{
+		Kind:        export.Kind(*kind),
+		Format:      export.Format(*format),
+		ExportQuery: analytics.ExportQuery{ShortCode: *shortCode},
+	}
+	var err error
+	if req.From, err = parseTime(*from); err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	if req.To, err = parseTime(*to); err != nil {
+		return fmt.Errorf("invalid -to: %w", err)
+	}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
+	stats, err := analytics.Open(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer stats.Close()
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+	}
+	w := bufio.NewWriter(out)
+
+	rows, err := export.Export(ctx, req, backend.URLs, stats, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if out != os.Stdout {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d rows\n", rows)
+	return nil
+}
+
+func runDomains(ctx context.Context, cfg *config.Config, backend *storage.Backend, args []string) error {
+	flags := flag.NewFlagSet("domains", flag.ExitOnError)
+	block := flags.String("block", "", "domain or *.domain pattern to block")
+	allow := flags.String("allow", "", "domain or *.domain pattern to allow")
+	remove := flags.String("remove", "", "pattern whose rule is removed")
+	flags.Parse(args)
+
+	if cfg.Domains.RulesSource != config.DomainRulesTable {
+		return fmt.Errorf("DOMAIN_RULES_SOURCE must be %s to manage rules here", config.DomainRulesTable)
+	}
+	client, err := storage.NewDynamoDBClient(ctx, cfg.Storage)
+	if err != nil {
+		return err
+	}
+	table := domains.NewTable(client)
+
+	switch {
+	case *block != "":
+		err = table.Put(ctx, domains.Rule{Pattern: *block, Action: domains.Block})
+	case *allow != "":
+		err = table.Put(ctx, domains.Rule{Pattern: *allow, Action: domains.Allow})
+	case *remove != "":
+		err = table.Delete(ctx, *remove)
+	}
+	if err != nil {
+		return err
+	}
+
+	rules, err := table.List(ctx)
+	if err != nil {
+		return err
+	}
+	printJSON(rules)
+	return nil
+}
+
+// parseTime parses an optional RFC3339 time
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
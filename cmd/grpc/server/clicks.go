@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	pb "github.com/jingy/Go-Shortener/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// maxWatchedCodes bounds the short codes of a single WatchClicks call
+const maxWatchedCodes = 100
+
+// WatchClicks streams the clicks published by the broker until the client
+// goes away. A client that reads too slowly is disconnected with
+// RESOURCE_EXHAUSTED rather than slowing down publishing, and can resume
+// from the last event it received.
+func (s *server) WatchClicks(req *pb.WatchClicksRequest, stream pb.URLShortener_WatchClicksServer) error {
+	if s.broker == nil {
+		return status.Error(codes.Unimplemented, "live clicks are not enabled")
+	}
+
+	match, err := watchFilter(req)
+	if err != nil {
+		return err
+	}
+	sub, err := s.broker.Subscribe(match, req.ResumeAfter)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	// Send the headers right away so clients know the subscription is live
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case click, ok := <-sub.Clicks():
+			if !ok {
+				return sub.Err()
+			}
+			if err := stream.Send(clickEvent(click)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// watchFilter matches the short codes selected by a request
+func watchFilter(req *pb.WatchClicksRequest) (func(string) bool, error) {
+	if req.All {
+		if len(req.ShortCodes) > 0 {
+			return nil, status.Error(codes.InvalidArgument, "short codes cannot be combined with all")
+		}
+		return func(string) bool { return true }, nil
+	}
+
+	if len(req.ShortCodes) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one short code or all is required")
+	}
+	if len(req.ShortCodes) > maxWatchedCodes {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d short codes can be watched per call", maxWatchedCodes)
+	}
+	watched := make(map[string]struct{}, len(req.ShortCodes))
+	for _, shortCode := range req.ShortCodes {
+		watched[shortCode] = struct{}{}
+	}
+	return func(shortCode string) bool {
+		_, ok := watched[shortCode]
+		return ok
+	}, nil
+}
+
+// clickEvent converts a published click
+func clickEvent(click analytics.LiveClick) *pb.ClickEvent {
+	return &pb.ClickEvent{
+		EventId:         click.ID,
+		ShortCode:       click.Event.ShortCode,
+		TimestampMillis: click.Event.Timestamp.UnixMilli(),
+		VisitorId:       click.Event.VisitorID,
+		Country:         click.Event.Country,
+		Referrer:        click.Event.Referrer,
+		UserAgent:       click.Event.UserAgent,
+		Bot:             click.Event.Bot,
+		UtmSource:       click.Event.UTMSource,
+		UtmMedium:       click.Event.UTMMedium,
+		UtmCampaign:     click.Event.UTMCampaign,
+		Device:          click.Event.Device,
+		Os:              click.Event.OS,
+		Browser:         click.Event.Browser,
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/export"
+	pb "github.com/jingy/Go-Shortener/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// exportChunkSize is the most bytes sent per ExportChunk
+const exportChunkSize = 64 * 1024
+
+var exportFormats = map[pb.ExportFormat]export.Format{
+	pb.ExportFormat_EXPORT_FORMAT_UNSPECIFIED: export.CSV,
+	pb.ExportFormat_EXPORT_FORMAT_CSV:         export.CSV,
+	pb.ExportFormat_EXPORT_FORMAT_JSONL:       export.JSONL,
+	pb.ExportFormat_EXPORT_FORMAT_PARQUET:     export.Parquet,
+}
+
+var exportKinds = map[pb.ExportKind]export.Kind{
+	pb.ExportKind_EXPORT_KIND_UNSPECIFIED: export.Clicks,
+	pb.ExportKind_EXPORT_KIND_CLICKS:      export.Clicks,
+	pb.ExportKind_EXPORT_KIND_LINKS:       export.Links,
+}
+
+// ExportClicks streams an export file as it is written. Rows are read from
+// the stores as the client receives them, so a slow client slows the export
+// down instead of buffering it.
+func (s *server) ExportClicks(req *pb.ExportClicksRequest, stream pb.URLShortener_ExportClicksServer) error {
+	exportReq, err := exportRequest(req)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriterSize(chunkWriter{stream}, exportChunkSize)
+	if _, err := export.Export(stream.Context(), exportReq, s.storage, s.stats, w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// exportRequest converts and validates a request
+func exportRequest(req *pb.ExportClicksRequest) (export.Request, error) {
+	if req.All == (req.ShortCode != "") {
+		return export.Request{}, status.Error(codes.InvalidArgument, "exactly one of short_code and all is required")
+	}
+	format, ok := exportFormats[req.Format]
+	if !ok {
+		return export.Request{}, status.Errorf(codes.InvalidArgument, "unknown export format %v", req.Format)
+	}
+	kind, ok := exportKinds[req.Kind]
+	if !ok {
+		return export.Request{}, status.Errorf(codes.InvalidArgument, "unknown export kind %v", req.Kind)
+	}
+
+	exportReq := export.Request{
+		Kind:        kind,
+		Format:      format,
+		ExportQuery: analytics.ExportQuery{ShortCode: req.ShortCode},
+	}
+	if req.StartTime != 0 {
+		exportReq.From = time.Unix(req.StartTime, 0).UTC()
+	}
+	if req.EndTime != 0 {
+		exportReq.To = time.Unix(req.EndTime, 0).UTC()
+	}
+	return exportReq, exportReq.Validate()
+}
+
+// chunkWriter sends what is written to it as ExportChunks of at most
+// exportChunkSize bytes
+type chunkWriter struct {
+	stream pb.URLShortener_ExportClicksServer
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), exportChunkSize)
+		if err := w.stream.Send(&pb.ExportChunk{Data: p[:n]}); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
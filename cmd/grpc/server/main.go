@@ -2,116 +2,306 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"time"
 
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/domains"
+	"github.com/jingy/Go-Shortener/internal/geoip"
+	"github.com/jingy/Go-Shortener/internal/grpcerr"
+	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/screening"
 	"github.com/jingy/Go-Shortener/internal/storage"
 	"github.com/jingy/Go-Shortener/pkg/shortener"
 	pb "github.com/jingy/Go-Shortener/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
 )
 
 type server struct {
 	pb.UnimplementedURLShortenerServer
-	shortener *shortener.URLShortener
-	storage   *storage.URLStorage
+	shortener *shortener.Shortener
+	storage   storage.URLStore
+	stats     analytics.Store
+	// recorder counts GetOriginalURL lookups as clicks when set
+	recorder *analytics.Recorder
+	// broker streams clicks to WatchClicks, either those of the url-clicks
+	// table stream or those of recorder
+	broker *analytics.Broker
+	// trustedHops is how many x-forwarded-for entries come from our proxies
+	trustedHops int
+	// screener quarantines links whose destination became flagged
+	screener *screening.Screener
 }
 
 func (s *server) CreateShortURL(ctx context.Context, req *pb.CreateShortURLRequest) (*pb.CreateShortURLResponse, error) {
-	// Create short URL using existing shortener
-	shortCode, err := s.shortener.CreateShortURL(ctx, req.Url)
-	if err != nil {
-		return nil, err
-	}
-
-	// Get URL details from storage
-	url, err := s.storage.GetURL(ctx, shortCode)
+	// Create and store the short URL
+	url, err := s.shortener.CreateShortURL(ctx, req.Url, createOptions(req))
 	if err != nil {
 		return nil, err
 	}
 
 	return &pb.CreateShortURLResponse{
-		ShortCode:  shortCode,
-		ShortUrl:   url.ShortURL,
-		CreatedAt:  url.CreatedAt.Unix(),
-		ExpiresAt:  url.ExpiresAt.Unix(),
+		ShortCode: url.ShortCode,
+		ShortUrl:  url.ShortURL,
+		CreatedAt: url.CreatedAt.Unix(),
+		ExpiresAt: unixOrZero(url.ExpiresAt),
+		Status:    createStatus(url),
 	}, nil
 }
 
+// createStatus reports a quarantined status of a created URL
+func createStatus(url *models.URL) string {
+	if url.IsQuarantined() {
+		return url.Status
+	}
+	return ""
+}
+
 func (s *server) GetOriginalURL(ctx context.Context, req *pb.GetOriginalURLRequest) (*pb.GetOriginalURLResponse, error) {
 	// Get URL from storage
-	url, err := s.storage.GetURL(ctx, req.ShortCode)
+	url, err := s.storage.Get(ctx, req.ShortCode)
 	if err != nil {
 		return nil, err
 	}
+	// Quarantined links are not resolved, like redirects
+	if err := s.screener.CheckRedirect(ctx, s.storage, url); err != nil {
+		return nil, err
+	}
+
+	// Frontends resolving links over gRPC are clicks like redirects
+	if s.recorder != nil {
+		s.recorder.Record(url.ShortCode, s.visitor(ctx))
+	}
 
 	return &pb.GetOriginalURLResponse{
 		OriginalUrl: url.OriginalURL,
 		CreatedAt:   url.CreatedAt.Unix(),
-		ExpiresAt:   url.ExpiresAt.Unix(),
+		ExpiresAt:   unixOrZero(url.ExpiresAt),
 	}, nil
 }
 
 func (s *server) GetURLStats(ctx context.Context, req *pb.GetURLStatsRequest) (*pb.GetURLStatsResponse, error) {
+	top, err := analytics.TopLimit(int(req.Top))
+	if err != nil {
+		return nil, err
+	}
+
 	// Get URL from storage
-	url, err := s.storage.GetURL(ctx, req.ShortCode)
+	url, err := s.storage.Get(ctx, req.ShortCode)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get statistics (you'll need to implement this in your storage layer)
-	stats, err := s.storage.GetURLStats(ctx, req.ShortCode)
+	// Get the clicks recorded by the redirect handlers
+	stats, err := s.stats.GetStats(ctx, req.ShortCode)
 	if err != nil {
 		return nil, err
 	}
+	stats = stats.Top(top)
+
+	clicksByHour := make(map[int32]int64, len(stats.ClicksByHour))
+	for hour, clicks := range stats.ClicksByHour {
+		clicksByHour[int32(hour)] = clicks
+	}
 
+	now := time.Now()
 	return &pb.GetURLStatsResponse{
-		ShortCode:      req.ShortCode,
-		TotalClicks:    stats.TotalClicks,
-		UniqueVisitors: stats.UniqueVisitors,
-		CreatedAt:      url.CreatedAt.Unix(),
-		ExpiresAt:      url.ExpiresAt.Unix(),
-		ClicksByCountry: stats.ClicksByCountry,
-		ClicksByHour:    stats.ClicksByHour,
+		ShortCode:           req.ShortCode,
+		TotalClicks:         stats.TotalClicks,
+		HumanClicks:         stats.HumanClicks(),
+		UniqueVisitors:      stats.Visitors.All.Estimate(),
+		UniqueVisitorsDay:   stats.Visitors.Since(now),
+		UniqueVisitorsWeek:  stats.Visitors.Since(now.AddDate(0, 0, -6)),
+		CreatedAt:           url.CreatedAt.Unix(),
+		ExpiresAt:           unixOrZero(url.ExpiresAt),
+		ClicksByCountry:     stats.ClicksByCountry,
+		ClicksByHour:        clicksByHour,
+		BotClicks:           stats.BotClicks,
+		ClicksByReferrer:    stats.ClicksByReferrer,
+		ClicksByUtmSource:   stats.ClicksBySource,
+		ClicksByUtmMedium:   stats.ClicksByMedium,
+		ClicksByUtmCampaign: stats.ClicksByCampaign,
+		ClicksByDevice:      stats.ClicksByDevice,
+		ClicksByOs:          stats.ClicksByOS,
+		ClicksByBrowser:     stats.ClicksByBrowser,
 	}, nil
 }
 
+func (s *server) GetURLTimeSeries(ctx context.Context, req *pb.GetURLTimeSeriesRequest) (*pb.GetURLTimeSeriesResponse, error) {
+	query := analytics.TimeSeriesQuery{Granularity: granularities[req.Granularity]}
+	if req.Granularity != pb.Granularity_GRANULARITY_UNSPECIFIED && query.Granularity == 0 {
+		return nil, fmt.Errorf("%w: %s", models.ErrInvalidGranularity, req.Granularity)
+	}
+	if req.StartTime != 0 {
+		query.From = time.Unix(req.StartTime, 0)
+	}
+	if req.EndTime != 0 {
+		query.To = time.Unix(req.EndTime, 0)
+	}
+	if err := query.Normalize(time.Now()); err != nil {
+		return nil, err
+	}
+
+	// Get URL from storage
+	if _, err := s.storage.Get(ctx, req.ShortCode); err != nil {
+		return nil, err
+	}
+
+	points, err := s.stats.GetTimeSeries(ctx, req.ShortCode, query)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.GetURLTimeSeriesResponse{
+		ShortCode: req.ShortCode,
+		Points:    make([]*pb.TimeSeriesPoint, len(points)),
+	}
+	for g, granularity := range granularities {
+		if granularity == query.Granularity {
+			resp.Granularity = g
+		}
+	}
+	for i, point := range points {
+		resp.Points[i] = &pb.TimeSeriesPoint{StartTime: point.Start.Unix(), Clicks: point.Clicks}
+	}
+	return resp, nil
+}
+
+// granularities maps the granularities of the API to analytics ones
+var granularities = map[pb.Granularity]analytics.Granularity{
+	pb.Granularity_GRANULARITY_MINUTE: analytics.Minute,
+	pb.Granularity_GRANULARITY_HOUR:   analytics.Hour,
+	pb.Granularity_GRANULARITY_DAY:    analytics.Day,
+}
+
+// visitor describes the client of a gRPC call
+func (s *server) visitor(ctx context.Context) analytics.Visitor {
+	visitor := analytics.Visitor{IP: geoip.PeerIP(ctx, s.trustedHops)}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if userAgent := md.Get("user-agent"); len(userAgent) > 0 {
+			visitor.UserAgent = userAgent[0]
+		}
+	}
+	return visitor
+}
+
+// createOptions converts the optional fields of a create request
+func createOptions(req *pb.CreateShortURLRequest) shortener.CreateOptions {
+	opts := shortener.CreateOptions{
+		ExpiresIn: time.Duration(req.ExpirationSeconds) * time.Second,
+		Alias:     req.Alias,
+	}
+	if req.ExpiresAt != 0 {
+		opts.ExpiresAt = time.Unix(req.ExpiresAt, 0)
+	}
+	return opts
+}
+
+// unixOrZero converts t to Unix seconds, mapping the zero time to 0
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
 func main() {
-	// Initialize AWS config
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	// Load configuration from the environment
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Unable to load config: %v", err)
+	}
+
+	// Initialize the configured storage backend
+	backend, err := storage.Open(context.Background(), cfg.Storage)
+	if err != nil {
+		log.Fatalf("Unable to open storage: %v", err)
+	}
+	defer backend.Close()
+
+	// Open the click statistics and count lookups as clicks
+	stats, err := analytics.Open(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Unable to open analytics: %v", err)
+	}
+	defer stats.Close()
+
+	// Publish the clicks of every process to WatchClicks subscribers when
+	// they share the url-clicks table, and this server's own otherwise
+	broker := analytics.NewBroker()
+	var recorderOpts []analytics.RecorderOption
+	clickStream, err := analytics.OpenClickStream(context.Background(), cfg, broker)
+	switch {
+	case errors.Is(err, analytics.ErrNoClickStream):
+		log.Printf("The %v, WatchClicks only streams the lookups of this server", err)
+		recorderOpts = append(recorderOpts, analytics.WithBroker(broker))
+	case err != nil:
+		log.Fatalf("Unable to follow click stream: %v", err)
+	case clickStream == nil:
+		recorderOpts = append(recorderOpts, analytics.WithBroker(broker))
+	}
+	defer clickStream.Close()
+	recorder, err := analytics.NewRecorderFromConfig(cfg.Analytics, stats, recorderOpts...)
 	if err != nil {
-		log.Fatalf("Unable to load SDK config: %v", err)
+		log.Fatalf("Unable to start click recorder: %v", err)
 	}
+	defer recorder.Close(context.Background())
 
-	// Create DynamoDB client
-	dynamoClient := dynamodb.NewFromConfig(cfg)
+	// Load the domain rules, reloading them in the background
+	domainPolicy, err := domains.OpenFromConfig(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Unable to load domain rules: %v", err)
+	}
+	defer domainPolicy.Close()
 
-	// Initialize storage
-	urlStorage := storage.NewURLStorage(dynamoClient)
-	counterStorage := storage.NewCounterStorage(dynamoClient)
+	// Load the screening lists, watching for new ones
+	screener, err := screening.OpenFromConfig(cfg.Screening)
+	if err != nil {
+		log.Fatalf("Unable to load screening lists: %v", err)
+	}
+	defer screener.Close()
 
 	// Initialize shortener
-	urlShortener := shortener.NewURLShortener(urlStorage, counterStorage)
+	urlShortener, err := shortener.NewFromConfig(cfg, backend,
+		shortener.WithDomainPolicy(domainPolicy),
+		shortener.WithScreener(screener, cfg.Screening.Action == config.ScreeningQuarantine),
+	)
+	if err != nil {
+		log.Fatalf("Unable to create shortener: %v", err)
+	}
 
 	// Create gRPC server
-	lis, err := net.Listen("tcp", ":50051")
+	lis, err := net.Listen("tcp", cfg.GRPCAddr)
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
+	// Translate domain errors into gRPC status codes
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcerr.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(grpcerr.StreamServerInterceptor()),
+	)
 	pb.RegisterURLShortenerServer(s, &server{
-		shortener: urlShortener,
-		storage:   urlStorage,
+		shortener:   urlShortener,
+		storage:     backend.URLs,
+		stats:       stats,
+		recorder:    recorder,
+		broker:      broker,
+		trustedHops: cfg.Analytics.TrustedProxyHops,
+		screener:    screener,
 	})
 
 	// Register reflection service on gRPC server
 	reflection.Register(s)
 
-	log.Printf("Starting gRPC server on :50051")
+	log.Printf("Starting gRPC server on %s", cfg.GRPCAddr)
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("Failed to serve: %v", err)
 	}
-} 
\ No newline at end of file
+}
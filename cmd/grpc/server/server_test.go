@@ -2,93 +2,120 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/grpcerr"
+	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/screening"
 	"github.com/jingy/Go-Shortener/internal/storage"
 	"github.com/jingy/Go-Shortener/pkg/shortener"
 	pb "github.com/jingy/Go-Shortener/proto"
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
-	"net"
 )
 
 const bufSize = 1024 * 1024
 
-// MockDynamoDBClient is a mock implementation of the DynamoDB client
-type MockDynamoDBClient struct {
-	mock.Mock
-}
+// setupTestServer creates a test server backed by in-memory storage
+func setupTestServer(t *testing.T) (*grpc.Server, pb.URLShortenerClient, *bufconn.Listener) {
+	// Initialize in-memory storage
+	urlStorage := storage.NewMemoryStorage()
+	counterStorage := storage.NewMemoryCounter()
 
-func (m *MockDynamoDBClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
-	args := m.Called(ctx, params, optFns)
-	if args.Get(0) == nil {
-		return nil, args.Error(1)
+	// Seed a known short code
+	seeded := models.NewURL("https://example.com/seeded", "abc123")
+	seeded.ExpiresAt = time.Now().Add(time.Hour).UTC()
+	if err := urlStorage.Create(context.Background(), seeded); err != nil {
+		t.Fatalf("Failed to seed storage: %v", err)
+	}
+	expired := models.NewURL("https://example.com/expired", "old123")
+	expired.SetExpiresAt(time.Now().Add(-time.Hour))
+	if err := urlStorage.Create(context.Background(), expired); err != nil {
+		t.Fatalf("Failed to seed storage: %v", err)
 	}
-	return args.Get(0).(*dynamodb.PutItemOutput), args.Error(1)
-}
 
-func (m *MockDynamoDBClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
-	args := m.Called(ctx, params, optFns)
-	if args.Get(0) == nil {
-		return nil, args.Error(1)
+	// Seed clicks of the known short code
+	statsStore := analytics.NewMemoryStore()
+	clicks := []analytics.ClickEvent{
+		{ShortCode: "abc123", Timestamp: time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC), Country: "US", VisitorID: "a", Referrer: "https://www.google.com/", Device: "desktop"},
+		{ShortCode: "abc123", Timestamp: time.Date(2024, 5, 1, 10, 45, 0, 0, time.UTC), Country: "US", VisitorID: "a", Referrer: "https://www.google.com/", Device: "desktop"},
+		{ShortCode: "abc123", Timestamp: time.Date(2024, 5, 2, 13, 0, 0, 0, time.UTC), Country: "DE", VisitorID: "b", Referrer: "https://t.co/x", UTMSource: "twitter", Device: "mobile"},
+	}
+	if err := statsStore.RecordClicks(context.Background(), clicks); err != nil {
+		t.Fatalf("Failed to seed stats: %v", err)
 	}
-	return args.Get(0).(*dynamodb.GetItemOutput), args.Error(1)
-}
 
-func (m *MockDynamoDBClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
-	args := m.Called(ctx, params, optFns)
-	if args.Get(0) == nil {
-		return nil, args.Error(1)
+	// Flag malware.example, quarantining new links to it
+	listsDir := t.TempDir()
+	hash := sha256.Sum256([]byte("malware.example/"))
+	if err := os.WriteFile(filepath.Join(listsDir, "malware.txt"), []byte(hex.EncodeToString(hash[:])+"\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write screening list: %v", err)
 	}
-	return args.Get(0).(*dynamodb.UpdateItemOutput), args.Error(1)
-}
+	screener, err := screening.Open(listsDir)
+	if err != nil {
+		t.Fatalf("Failed to open screening lists: %v", err)
+	}
+	t.Cleanup(func() { screener.Close() })
 
-// setupTestServer creates a test server with mocked dependencies
-func setupTestServer(t *testing.T) (*grpc.Server, pb.URLShortenerClient, *bufconn.Listener) {
-	// Create mock DynamoDB client
-	mockDynamoClient := new(MockDynamoDBClient)
-	
-	// Initialize storage with mock client
-	urlStorage := storage.NewURLStorage(mockDynamoClient)
-	counterStorage := storage.NewCounterStorage(mockDynamoClient)
-	
 	// Initialize shortener
-	urlShortener := shortener.NewURLShortener(urlStorage, counterStorage)
-	
+	urlShortener := shortener.NewShortener("https://sho.rt", counterStorage, shortener.WithStore(urlStorage), shortener.WithScreener(screener, true))
+
 	// Create a buffer listener
 	lis := bufconn.Listen(bufSize)
-	
+
+	// Publish lookups to WatchClicks, keeping them out of the seeded stats
+	broker := analytics.NewBroker()
+	recorder := analytics.NewRecorder(analytics.NewMemoryStore(), analytics.WithBroker(broker))
+	t.Cleanup(func() { recorder.Close(context.Background()) })
+
 	// Create gRPC server
-	s := grpc.NewServer()
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcerr.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(grpcerr.StreamServerInterceptor()),
+	)
 	pb.RegisterURLShortenerServer(s, &server{
 		shortener: urlShortener,
 		storage:   urlStorage,
+		stats:     statsStore,
+		recorder:  recorder,
+		broker:    broker,
+		screener:  screener,
 	})
-	
+
 	// Start server in a goroutine
 	go func() {
 		if err := s.Serve(lis); err != nil && err != grpc.ErrServerStopped {
 			t.Errorf("Failed to serve: %v", err)
 		}
 	}()
-	
+
 	// Create a client connection
 	conn, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
 		return lis.Dial()
-	}), grpc.WithInsecure())
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		t.Fatalf("Failed to dial bufnet: %v", err)
 	}
-	
+	t.Cleanup(func() { conn.Close() })
+
 	// Create client
 	client := pb.NewURLShortenerClient(conn)
-	
+
 	return s, client, lis
 }
 
@@ -102,7 +129,7 @@ func TestCreateShortURL(t *testing.T) {
 	// Setup
 	s, client, lis := setupTestServer(t)
 	defer teardownTestServer(s, lis)
-	
+
 	// Test cases
 	tests := []struct {
 		name           string
@@ -128,43 +155,119 @@ func TestCreateShortURL(t *testing.T) {
 			expirationSecs: 3600,
 			expectError:    true,
 		},
+		{
+			name:           "expiration in the past",
+			url:            "https://example.com",
+			expirationSecs: -60,
+			expectError:    true,
+		},
 	}
-	
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create request
 			req := &pb.CreateShortURLRequest{
-				Url:              tt.url,
+				Url:               tt.url,
 				ExpirationSeconds: tt.expirationSecs,
 			}
-			
+
 			// Call the service
 			resp, err := client.CreateShortURL(context.Background(), req)
-			
+
 			// Check error
 			if tt.expectError {
 				assert.Error(t, err)
 				return
 			}
-			
+
 			// Check response
 			assert.NoError(t, err)
 			assert.NotEmpty(t, resp.ShortCode)
 			assert.NotEmpty(t, resp.ShortUrl)
 			assert.NotZero(t, resp.CreatedAt)
 			assert.NotZero(t, resp.ExpiresAt)
-			
+
 			// Verify short URL format
 			assert.Contains(t, resp.ShortUrl, resp.ShortCode)
 		})
 	}
 }
 
+func TestCreateShortURLExpiration(t *testing.T) {
+	// Setup
+	s, client, lis := setupTestServer(t)
+	defer teardownTestServer(s, lis)
+
+	// Relative expiration is applied from the creation time
+	resp, err := client.CreateShortURL(context.Background(), &pb.CreateShortURLRequest{
+		Url:               "https://example.com",
+		ExpirationSeconds: 3600,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, resp.CreatedAt+3600, resp.ExpiresAt)
+
+	// Absolute expiration is echoed back
+	expiresAt := time.Now().Add(2 * time.Hour).Unix()
+	resp, err = client.CreateShortURL(context.Background(), &pb.CreateShortURLRequest{
+		Url:       "https://example.com",
+		ExpiresAt: expiresAt,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, expiresAt, resp.ExpiresAt)
+
+	// No expiration is reported as zero
+	resp, err = client.CreateShortURL(context.Background(), &pb.CreateShortURLRequest{
+		Url: "https://example.com",
+	})
+	assert.NoError(t, err)
+	assert.Zero(t, resp.ExpiresAt)
+
+	getResp, err := client.GetOriginalURL(context.Background(), &pb.GetOriginalURLRequest{ShortCode: resp.ShortCode})
+	assert.NoError(t, err)
+	assert.Zero(t, getResp.ExpiresAt)
+
+	// Both forms at once are rejected
+	_, err = client.CreateShortURL(context.Background(), &pb.CreateShortURLRequest{
+		Url:               "https://example.com",
+		ExpirationSeconds: 3600,
+		ExpiresAt:         expiresAt,
+	})
+	assert.Error(t, err)
+}
+
+func TestCreateShortURLAlias(t *testing.T) {
+	// Setup
+	s, client, lis := setupTestServer(t)
+	defer teardownTestServer(s, lis)
+
+	resp, err := client.CreateShortURL(context.Background(), &pb.CreateShortURLRequest{
+		Url:   "https://example.com/sale",
+		Alias: "spring-sale",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "spring-sale", resp.ShortCode)
+	assert.Equal(t, "https://sho.rt/spring-sale", resp.ShortUrl)
+
+	// Claiming the same alias again is a conflict
+	_, err = client.CreateShortURL(context.Background(), &pb.CreateShortURLRequest{
+		Url:   "https://example.com/other",
+		Alias: "spring-sale",
+	})
+	assert.ErrorContains(t, err, models.ErrAliasTaken.Error())
+
+	// Reserved words are rejected
+	_, err = client.CreateShortURL(context.Background(), &pb.CreateShortURLRequest{
+		Url:   "https://example.com",
+		Alias: "create",
+	})
+	assert.Error(t, err)
+}
+
 func TestGetOriginalURL(t *testing.T) {
 	// Setup
 	s, client, lis := setupTestServer(t)
 	defer teardownTestServer(s, lis)
-	
+
 	// Test cases
 	tests := []struct {
 		name        string
@@ -187,23 +290,23 @@ func TestGetOriginalURL(t *testing.T) {
 			expectError: true,
 		},
 	}
-	
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create request
 			req := &pb.GetOriginalURLRequest{
 				ShortCode: tt.shortCode,
 			}
-			
+
 			// Call the service
 			resp, err := client.GetOriginalURL(context.Background(), req)
-			
+
 			// Check error
 			if tt.expectError {
 				assert.Error(t, err)
 				return
 			}
-			
+
 			// Check response
 			assert.NoError(t, err)
 			assert.NotEmpty(t, resp.OriginalUrl)
@@ -217,17 +320,33 @@ func TestGetURLStats(t *testing.T) {
 	// Setup
 	s, client, lis := setupTestServer(t)
 	defer teardownTestServer(s, lis)
-	
+
 	// Test cases
 	tests := []struct {
-		name        string
-		shortCode   string
-		expectError bool
+		name             string
+		shortCode        string
+		top              int32
+		expectError      bool
+		expectedReferrer map[string]int64
 	}{
 		{
-			name:        "valid short code",
+			name:             "valid short code",
+			shortCode:        "abc123",
+			expectError:      false,
+			expectedReferrer: map[string]int64{"google.com": 2, "t.co": 1},
+		},
+		{
+			name:             "top referrer",
+			shortCode:        "abc123",
+			top:              1,
+			expectError:      false,
+			expectedReferrer: map[string]int64{"google.com": 2, "(other)": 1},
+		},
+		{
+			name:        "invalid top",
 			shortCode:   "abc123",
-			expectError: false,
+			top:         -1,
+			expectError: true,
 		},
 		{
 			name:        "invalid short code",
@@ -240,23 +359,24 @@ func TestGetURLStats(t *testing.T) {
 			expectError: true,
 		},
 	}
-	
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create request
 			req := &pb.GetURLStatsRequest{
 				ShortCode: tt.shortCode,
+				Top:       tt.top,
 			}
-			
+
 			// Call the service
 			resp, err := client.GetURLStats(context.Background(), req)
-			
+
 			// Check error
 			if tt.expectError {
 				assert.Error(t, err)
 				return
 			}
-			
+
 			// Check response
 			assert.NoError(t, err)
 			assert.Equal(t, tt.shortCode, resp.ShortCode)
@@ -264,43 +384,623 @@ func TestGetURLStats(t *testing.T) {
 			assert.NotZero(t, resp.ExpiresAt)
 			assert.NotNil(t, resp.ClicksByCountry)
 			assert.NotNil(t, resp.ClicksByHour)
+			assert.Equal(t, int64(3), resp.TotalClicks)
+			assert.Equal(t, int64(3), resp.HumanClicks)
+			assert.Empty(t, resp.BotClicks)
+			assert.Equal(t, tt.expectedReferrer, resp.ClicksByReferrer)
+			assert.Equal(t, map[string]int64{"twitter": 1}, resp.ClicksByUtmSource)
+			assert.Empty(t, resp.ClicksByUtmMedium)
+			assert.Equal(t, int64(2), resp.UniqueVisitors)
+			assert.Equal(t, map[string]int64{"US": 2, "DE": 1}, resp.ClicksByCountry)
+			assert.Equal(t, map[int32]int64{10: 2, 13: 1}, resp.ClicksByHour)
 		})
 	}
 }
 
+func TestGetURLTimeSeries(t *testing.T) {
+	// Setup
+	s, client, lis := setupTestServer(t)
+	defer teardownTestServer(s, lis)
+
+	may1 := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	// Test cases
+	tests := []struct {
+		name         string
+		req          *pb.GetURLTimeSeriesRequest
+		expectedCode codes.Code
+		expected     map[int64]int64
+		points       int
+	}{
+		{
+			name: "hours",
+			req: &pb.GetURLTimeSeriesRequest{
+				ShortCode:   "abc123",
+				Granularity: pb.Granularity_GRANULARITY_HOUR,
+				StartTime:   may1.Unix(),
+				EndTime:     may1.AddDate(0, 0, 2).Unix(),
+			},
+			expected: map[int64]int64{may1.Add(10 * time.Hour).Unix(): 2, may1.Add(37 * time.Hour).Unix(): 1},
+			points:   48,
+		},
+		{
+			name: "days from a start inside a bucket",
+			req: &pb.GetURLTimeSeriesRequest{
+				ShortCode:   "abc123",
+				Granularity: pb.Granularity_GRANULARITY_DAY,
+				StartTime:   may1.Add(12 * time.Hour).Unix(),
+				EndTime:     may1.AddDate(0, 0, 7).Unix(),
+			},
+			expected: map[int64]int64{may1.Unix(): 2, may1.AddDate(0, 0, 1).Unix(): 1},
+			points:   7,
+		},
+		{
+			name: "minutes",
+			req: &pb.GetURLTimeSeriesRequest{
+				ShortCode:   "abc123",
+				Granularity: pb.Granularity_GRANULARITY_MINUTE,
+				StartTime:   may1.Add(10 * time.Hour).Unix(),
+				EndTime:     may1.Add(11 * time.Hour).Unix(),
+			},
+			expected: map[int64]int64{may1.Add(615 * time.Minute).Unix(): 1, may1.Add(645 * time.Minute).Unix(): 1},
+			points:   60,
+		},
+		{
+			name: "too many points",
+			req: &pb.GetURLTimeSeriesRequest{
+				ShortCode:   "abc123",
+				Granularity: pb.Granularity_GRANULARITY_MINUTE,
+				StartTime:   may1.Unix(),
+				EndTime:     may1.AddDate(0, 0, 2).Unix(),
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "end before start",
+			req: &pb.GetURLTimeSeriesRequest{
+				ShortCode: "abc123",
+				StartTime: may1.Unix(),
+				EndTime:   may1.Add(-time.Hour).Unix(),
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "unknown granularity",
+			req:          &pb.GetURLTimeSeriesRequest{ShortCode: "abc123", Granularity: 42},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "invalid short code",
+			req:          &pb.GetURLTimeSeriesRequest{ShortCode: "nonexistent"},
+			expectedCode: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Call the service
+			resp, err := client.GetURLTimeSeries(context.Background(), tt.req)
+
+			// Check error
+			if tt.expectedCode != codes.OK {
+				assert.Equal(t, tt.expectedCode, status.Code(err))
+				return
+			}
+
+			// Check response
+			assert.NoError(t, err)
+			assert.Equal(t, tt.req.Granularity, resp.Granularity)
+			assert.Len(t, resp.Points, tt.points)
+			clicks := make(map[int64]int64)
+			for _, point := range resp.Points {
+				if point.Clicks != 0 {
+					clicks[point.StartTime] = point.Clicks
+				}
+			}
+			assert.Equal(t, tt.expected, clicks)
+		})
+	}
+
+	// Without a range the last day of hours is returned, including the
+	// current hour
+	resp, err := client.GetURLTimeSeries(context.Background(), &pb.GetURLTimeSeriesRequest{ShortCode: "abc123"})
+	assert.NoError(t, err)
+	assert.Equal(t, pb.Granularity_GRANULARITY_HOUR, resp.Granularity)
+	assert.Contains(t, []int{24, 25}, len(resp.Points))
+}
+
 // TestServerIntegration tests the integration of all three endpoints
 func TestServerIntegration(t *testing.T) {
 	// Setup
 	s, client, lis := setupTestServer(t)
 	defer teardownTestServer(s, lis)
-	
+
 	// Step 1: Create a short URL
 	createReq := &pb.CreateShortURLRequest{
-		Url:              "https://example.com",
+		Url:               "https://example.com",
 		ExpirationSeconds: 3600,
 	}
-	
+
 	createResp, err := client.CreateShortURL(context.Background(), createReq)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, createResp.ShortCode)
-	
+
 	// Step 2: Get the original URL
 	getReq := &pb.GetOriginalURLRequest{
 		ShortCode: createResp.ShortCode,
 	}
-	
+
 	getResp, err := client.GetOriginalURL(context.Background(), getReq)
 	assert.NoError(t, err)
 	assert.Equal(t, createReq.Url, getResp.OriginalUrl)
-	
+
 	// Step 3: Get URL stats
 	statsReq := &pb.GetURLStatsRequest{
 		ShortCode: createResp.ShortCode,
 	}
-	
+
 	statsResp, err := client.GetURLStats(context.Background(), statsReq)
 	assert.NoError(t, err)
 	assert.Equal(t, createResp.ShortCode, statsResp.ShortCode)
 	assert.Equal(t, createResp.CreatedAt, statsResp.CreatedAt)
 	assert.Equal(t, createResp.ExpiresAt, statsResp.ExpiresAt)
-} 
\ No newline at end of file
+	assert.Zero(t, statsResp.TotalClicks)
+}
+
+func TestUpdateShortURL(t *testing.T) {
+	// Setup
+	s, client, lis := setupTestServer(t)
+	defer teardownTestServer(s, lis)
+
+	// Change the destination only
+	resp, err := client.UpdateShortURL(context.Background(), &pb.UpdateShortURLRequest{
+		ShortCode:   "abc123",
+		OriginalUrl: "https://example.org/moved",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.org/moved", resp.OriginalUrl)
+	assert.Equal(t, "https://sho.rt/abc123", resp.ShortUrl)
+	assert.NotZero(t, resp.ExpiresAt)
+
+	// Extend the expiration
+	expiresAt := time.Now().Add(48 * time.Hour).Unix()
+	resp, err = client.UpdateShortURL(context.Background(), &pb.UpdateShortURLRequest{
+		ShortCode: "abc123",
+		ExpiresAt: expiresAt,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, expiresAt, resp.ExpiresAt)
+	assert.Equal(t, "https://example.org/moved", resp.OriginalUrl)
+
+	// Remove the expiration
+	resp, err = client.UpdateShortURL(context.Background(), &pb.UpdateShortURLRequest{
+		ShortCode:       "abc123",
+		ClearExpiration: true,
+	})
+	assert.NoError(t, err)
+	assert.Zero(t, resp.ExpiresAt)
+
+	getResp, err := client.GetOriginalURL(context.Background(), &pb.GetOriginalURLRequest{ShortCode: "abc123"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.org/moved", getResp.OriginalUrl)
+	assert.Zero(t, getResp.ExpiresAt)
+
+	// Invalid changes are rejected
+	invalid := []*pb.UpdateShortURLRequest{
+		{ShortCode: "abc123", OriginalUrl: "not-a-url"},
+		{ShortCode: "abc123", ExpirationSeconds: -60},
+		{ShortCode: "abc123", ExpirationSeconds: 60, ClearExpiration: true},
+		{ShortCode: "nonexistent", OriginalUrl: "https://example.org"},
+	}
+	for _, req := range invalid {
+		_, err := client.UpdateShortURL(context.Background(), req)
+		assert.Error(t, err, "request %v", req)
+	}
+}
+
+func TestDeleteShortURL(t *testing.T) {
+	// Setup
+	s, client, lis := setupTestServer(t)
+	defer teardownTestServer(s, lis)
+
+	_, err := client.DeleteShortURL(context.Background(), &pb.DeleteShortURLRequest{ShortCode: "abc123"})
+	assert.NoError(t, err)
+
+	_, err = client.GetOriginalURL(context.Background(), &pb.GetOriginalURLRequest{ShortCode: "abc123"})
+	assert.Error(t, err)
+
+	// Deleting twice fails
+	_, err = client.DeleteShortURL(context.Background(), &pb.DeleteShortURLRequest{ShortCode: "abc123"})
+	assert.Error(t, err)
+}
+
+func TestListShortURLs(t *testing.T) {
+	// Setup
+	s, client, lis := setupTestServer(t)
+	defer teardownTestServer(s, lis)
+
+	for i := 0; i < 7; i++ {
+		host := "example.com"
+		if i%2 == 1 {
+			host = "example.org"
+		}
+		_, err := client.CreateShortURL(context.Background(), &pb.CreateShortURLRequest{
+			Url: fmt.Sprintf("https://%s/%d", host, i),
+		})
+		assert.NoError(t, err)
+	}
+
+	// listAll follows next_page_token until the last page
+	listAll := func(req *pb.ListShortURLsRequest) []*pb.ShortURL {
+		var urls []*pb.ShortURL
+		for {
+			resp, err := client.ListShortURLs(context.Background(), req)
+			if !assert.NoError(t, err) {
+				return urls
+			}
+			assert.LessOrEqual(t, len(resp.Urls), int(req.PageSize))
+			urls = append(urls, resp.Urls...)
+			if resp.NextPageToken == "" {
+				return urls
+			}
+			req.PageToken = resp.NextPageToken
+		}
+	}
+
+	// The seeded URL plus the seven created ones, without duplicates
+	urls := listAll(&pb.ListShortURLsRequest{PageSize: 3})
+	assert.Len(t, urls, 8)
+	assert.Len(t, listAll(&pb.ListShortURLsRequest{PageSize: 3, IncludeExpired: true}), 9)
+	seen := make(map[string]bool)
+	for _, url := range urls {
+		assert.False(t, seen[url.ShortCode], "duplicate %s", url.ShortCode)
+		seen[url.ShortCode] = true
+	}
+
+	// Filter by destination host
+	urls = listAll(&pb.ListShortURLsRequest{PageSize: 2, Host: "example.org"})
+	assert.Len(t, urls, 3)
+	for _, url := range urls {
+		assert.Contains(t, url.OriginalUrl, "https://example.org/")
+	}
+
+	// Filter by creation time
+	urls = listAll(&pb.ListShortURLsRequest{PageSize: 10, CreatedAfter: time.Now().Add(time.Hour).Unix()})
+	assert.Empty(t, urls)
+
+	_, err := client.ListShortURLs(context.Background(), &pb.ListShortURLsRequest{PageSize: -1})
+	assert.Error(t, err)
+}
+
+func TestFlaggedURLs(t *testing.T) {
+	// Setup
+	s, client, lis := setupTestServer(t)
+	defer teardownTestServer(s, lis)
+	ctx := context.Background()
+
+	// Links to flagged URLs are created quarantined
+	created, err := client.CreateShortURL(ctx, &pb.CreateShortURLRequest{Url: "https://malware.example/download"})
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusQuarantined, created.Status)
+	clean, err := client.CreateShortURL(ctx, &pb.CreateShortURLRequest{Url: "https://example.com/clean"})
+	assert.NoError(t, err)
+	assert.Empty(t, clean.Status)
+
+	_, err = client.GetOriginalURL(ctx, &pb.GetOriginalURLRequest{ShortCode: created.ShortCode})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	resp, err := client.ListFlaggedURLs(ctx, &pb.ListFlaggedURLsRequest{PageSize: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.Urls, 1) {
+		assert.Equal(t, created.ShortCode, resp.Urls[0].ShortCode)
+		assert.Equal(t, []string{"malware"}, resp.Urls[0].Threats)
+	}
+
+	// Released links resolve and are no longer listed
+	released, err := client.ReleaseFlaggedURL(ctx, &pb.ReleaseFlaggedURLRequest{ShortCode: created.ShortCode})
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusReleased, released.Status)
+	_, err = client.GetOriginalURL(ctx, &pb.GetOriginalURLRequest{ShortCode: created.ShortCode})
+	assert.NoError(t, err)
+	resp, err = client.ListFlaggedURLs(ctx, &pb.ListFlaggedURLsRequest{})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Urls)
+
+	_, err = client.ReleaseFlaggedURL(ctx, &pb.ReleaseFlaggedURLRequest{ShortCode: clean.ShortCode})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = client.ReleaseFlaggedURL(ctx, &pb.ReleaseFlaggedURLRequest{ShortCode: "nonexistent"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestBatchCreateShortURLs(t *testing.T) {
+	// Setup
+	s, client, lis := setupTestServer(t)
+	defer teardownTestServer(s, lis)
+
+	resp, err := client.BatchCreateShortURLs(context.Background(), &pb.BatchCreateShortURLsRequest{
+		Requests: []*pb.CreateShortURLRequest{
+			{Url: "https://example.com/a"},
+			{Url: "not-a-url"},
+			{Url: "https://example.com/b", Alias: "batch-alias"},
+			{Url: "https://example.com/c", Alias: "batch-alias"},
+		},
+	})
+	assert.NoError(t, err)
+	if !assert.Len(t, resp.Results, 4) {
+		return
+	}
+
+	assert.Equal(t, "https://example.com/a", resp.Results[0].GetUrl().GetOriginalUrl())
+	assert.Contains(t, resp.Results[1].GetError(), models.ErrInvalidURL.Error())
+	assert.Equal(t, int32(codes.InvalidArgument), resp.Results[1].ErrorCode)
+	assert.Equal(t, "batch-alias", resp.Results[2].GetUrl().GetShortCode())
+	assert.Contains(t, resp.Results[3].GetError(), models.ErrAliasTaken.Error())
+	assert.Equal(t, int32(codes.AlreadyExists), resp.Results[3].ErrorCode)
+
+	// Oversized batches are rejected as a whole
+	requests := make([]*pb.CreateShortURLRequest, maxBatchSize+1)
+	for i := range requests {
+		requests[i] = &pb.CreateShortURLRequest{Url: "https://example.com"}
+	}
+	_, err = client.BatchCreateShortURLs(context.Background(), &pb.BatchCreateShortURLsRequest{Requests: requests})
+	assert.Error(t, err)
+}
+
+func TestErrorCodes(t *testing.T) {
+	// Setup
+	s, client, lis := setupTestServer(t)
+	defer teardownTestServer(s, lis)
+
+	ctx := context.Background()
+	tests := []struct {
+		name         string
+		call         func() error
+		expectedCode codes.Code
+	}{
+		{
+			name: "empty URL",
+			call: func() error {
+				_, err := client.CreateShortURL(ctx, &pb.CreateShortURLRequest{})
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "invalid URL",
+			call: func() error {
+				_, err := client.CreateShortURL(ctx, &pb.CreateShortURLRequest{Url: "not-a-url"})
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "link to a short URL",
+			call: func() error {
+				_, err := client.CreateShortURL(ctx, &pb.CreateShortURLRequest{Url: "https://sho.rt/abc123"})
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "private host",
+			call: func() error {
+				_, err := client.CreateShortURL(ctx, &pb.CreateShortURLRequest{Url: "http://192.168.0.1/admin"})
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "unknown short code",
+			call: func() error {
+				_, err := client.GetOriginalURL(ctx, &pb.GetOriginalURLRequest{ShortCode: "nonexistent"})
+				return err
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name: "expired short code",
+			call: func() error {
+				_, err := client.GetOriginalURL(ctx, &pb.GetOriginalURLRequest{ShortCode: "old123"})
+				return err
+			},
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			name: "alias taken",
+			call: func() error {
+				_, err := client.CreateShortURL(ctx, &pb.CreateShortURLRequest{Url: "https://example.com", Alias: "abc123"})
+				return err
+			},
+			expectedCode: codes.AlreadyExists,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedCode, status.Code(tt.call()))
+		})
+	}
+
+	// Expired errors carry details naming the short code
+	_, err := client.GetOriginalURL(ctx, &pb.GetOriginalURLRequest{ShortCode: "old123"})
+	var info *errdetails.ErrorInfo
+	var failure *errdetails.PreconditionFailure
+	for _, detail := range status.Convert(err).Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			info = d
+		case *errdetails.PreconditionFailure:
+			failure = d
+		}
+	}
+	if assert.NotNil(t, info) && assert.NotNil(t, failure) {
+		assert.Equal(t, grpcerr.ReasonURLExpired, info.Reason)
+		assert.Equal(t, "old123", info.Metadata["short_code"])
+		assert.Equal(t, "old123", failure.Violations[0].Subject)
+	}
+}
+
+func TestWatchClicks(t *testing.T) {
+	// Setup
+	s, client, lis := setupTestServer(t)
+	defer teardownTestServer(s, lis)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// watch opens a stream and waits until it is subscribed
+	watch := func(req *pb.WatchClicksRequest) (pb.URLShortener_WatchClicksClient, context.CancelFunc) {
+		streamCtx, stop := context.WithCancel(ctx)
+		stream, err := client.WatchClicks(streamCtx, req)
+		assert.NoError(t, err)
+		_, err = stream.Header()
+		assert.NoError(t, err)
+		return stream, stop
+	}
+	click := func(shortCode string) {
+		_, err := client.GetOriginalURL(ctx, &pb.GetOriginalURLRequest{ShortCode: shortCode})
+		assert.NoError(t, err)
+	}
+
+	// Create a second URL whose clicks are not watched
+	other, err := client.CreateShortURL(ctx, &pb.CreateShortURLRequest{Url: "https://example.com/other"})
+	assert.NoError(t, err)
+
+	stream, stop := watch(&pb.WatchClicksRequest{ShortCodes: []string{"abc123"}})
+	click(other.ShortCode)
+	click("abc123")
+	first, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", first.ShortCode)
+	assert.NotEmpty(t, first.EventId)
+	assert.NotZero(t, first.TimestampMillis)
+	stop()
+
+	// Clicks missed while disconnected are replayed on resume
+	click("abc123")
+	click(other.ShortCode)
+	click("abc123")
+	stream, stop = watch(&pb.WatchClicksRequest{All: true, ResumeAfter: first.EventId})
+	defer stop()
+	var shortCodes []string
+	for i := 0; i < 3; i++ {
+		event, err := stream.Recv()
+		assert.NoError(t, err)
+		shortCodes = append(shortCodes, event.ShortCode)
+	}
+	assert.Equal(t, []string{"abc123", other.ShortCode, "abc123"}, shortCodes)
+
+	// Invalid requests
+	tests := []struct {
+		name         string
+		req          *pb.WatchClicksRequest
+		expectedCode codes.Code
+	}{
+		{"nothing to watch", &pb.WatchClicksRequest{}, codes.InvalidArgument},
+		{"codes and all", &pb.WatchClicksRequest{ShortCodes: []string{"abc123"}, All: true}, codes.InvalidArgument},
+		{"malformed event ID", &pb.WatchClicksRequest{All: true, ResumeAfter: "abc"}, codes.InvalidArgument},
+		{"event of a previous process", &pb.WatchClicksRequest{All: true, ResumeAfter: "old-1"}, codes.OutOfRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := client.WatchClicks(ctx, tt.req)
+			assert.NoError(t, err)
+			_, err = stream.Recv()
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func TestExportClicks(t *testing.T) {
+	// Setup
+	s, client, lis := setupTestServer(t)
+	defer teardownTestServer(s, lis)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// download concatenates the chunks of an export
+	download := func(req *pb.ExportClicksRequest) (string, error) {
+		stream, err := client.ExportClicks(ctx, req)
+		if err != nil {
+			return "", err
+		}
+		var data []byte
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				return string(data), nil
+			}
+			if err != nil {
+				return "", err
+			}
+			data = append(data, chunk.Data...)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		req           *pb.ExportClicksRequest
+		expectedLines int
+		expectedText  string
+	}{
+		{
+			name:          "clicks of a link as CSV by default",
+			req:           &pb.ExportClicksRequest{ShortCode: "abc123"},
+			expectedLines: 4,
+			expectedText:  "short_code,timestamp,visitor_id",
+		},
+		{
+			name: "clicks in a range",
+			req: &pb.ExportClicksRequest{
+				All:       true,
+				StartTime: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC).Unix(),
+				EndTime:   time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC).Unix(),
+				Format:    pb.ExportFormat_EXPORT_FORMAT_JSONL,
+			},
+			expectedLines: 1,
+			expectedText:  `"utm_source":"twitter"`,
+		},
+		{
+			name:          "links including expired ones",
+			req:           &pb.ExportClicksRequest{All: true, Format: pb.ExportFormat_EXPORT_FORMAT_JSONL, Kind: pb.ExportKind_EXPORT_KIND_LINKS},
+			expectedLines: 2,
+			expectedText:  `"short_code":"old123"`,
+		},
+		{
+			name:         "parquet",
+			req:          &pb.ExportClicksRequest{ShortCode: "abc123", Format: pb.ExportFormat_EXPORT_FORMAT_PARQUET},
+			expectedText: "PAR1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := download(tt.req)
+			assert.NoError(t, err)
+			assert.Contains(t, data, tt.expectedText)
+			if tt.expectedLines > 0 {
+				assert.Equal(t, tt.expectedLines, strings.Count(data, "\n"))
+			}
+		})
+	}
+
+	// Invalid requests
+	errorTests := []struct {
+		name         string
+		req          *pb.ExportClicksRequest
+		expectedCode codes.Code
+	}{
+		{"nothing to export", &pb.ExportClicksRequest{}, codes.InvalidArgument},
+		{"code and all", &pb.ExportClicksRequest{ShortCode: "abc123", All: true}, codes.InvalidArgument},
+		{"unknown format", &pb.ExportClicksRequest{All: true, Format: 42}, codes.InvalidArgument},
+		{"reversed range", &pb.ExportClicksRequest{All: true, StartTime: 200, EndTime: 100}, codes.InvalidArgument},
+		{"links of a missing code", &pb.ExportClicksRequest{ShortCode: "missing", Kind: pb.ExportKind_EXPORT_KIND_LINKS}, codes.NotFound},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := download(tt.req)
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+}
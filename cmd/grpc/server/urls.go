@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/grpcerr"
+	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/storage"
+	"github.com/jingy/Go-Shortener/pkg/shortener"
+	pb "github.com/jingy/Go-Shortener/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+	// maxListScans bounds the store pages a filtered list reads per call
+	maxListScans = 10
+	maxBatchSize = 100
+)
+
+func (s *server) UpdateShortURL(ctx context.Context, req *pb.UpdateShortURLRequest) (*pb.ShortURL, error) {
+	opts := shortener.UpdateOptions{
+		OriginalURL:     req.OriginalUrl,
+		ExpiresIn:       time.Duration(req.ExpirationSeconds) * time.Second,
+		ClearExpiration: req.ClearExpiration,
+	}
+	if req.ExpiresAt != 0 {
+		opts.ExpiresAt = time.Unix(req.ExpiresAt, 0)
+	}
+
+	url, err := s.shortener.UpdateShortURL(ctx, req.ShortCode, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.toProto(url), nil
+}
+
+func (s *server) DeleteShortURL(ctx context.Context, req *pb.DeleteShortURLRequest) (*pb.DeleteShortURLResponse, error) {
+	if err := s.storage.Delete(ctx, req.ShortCode); err != nil {
+		return nil, err
+	}
+	return &pb.DeleteShortURLResponse{}, nil
+}
+
+func (s *server) ListShortURLs(ctx context.Context, req *pb.ListShortURLsRequest) (*pb.ListShortURLsResponse, error) {
+	listOpts := storage.ListOptions{Cursor: req.PageToken, IncludeExpired: req.IncludeExpired}
+	return s.list(ctx, req.PageSize, listOpts, func(url *models.URL) bool {
+		return matchesFilters(url, req)
+	})
+}
+
+func (s *server) ListFlaggedURLs(ctx context.Context, req *pb.ListFlaggedURLsRequest) (*pb.ListShortURLsResponse, error) {
+	listOpts := storage.ListOptions{Cursor: req.PageToken}
+	return s.list(ctx, req.PageSize, listOpts, (*models.URL).IsQuarantined)
+}
+
+func (s *server) ReleaseFlaggedURL(ctx context.Context, req *pb.ReleaseFlaggedURLRequest) (*pb.ShortURL, error) {
+	url, err := s.storage.Get(ctx, req.ShortCode)
+	if err != nil {
+		return nil, err
+	}
+	if !url.IsQuarantined() {
+		return nil, models.ErrNotQuarantined
+	}
+
+	if err := s.release(ctx, url); err != nil {
+		return nil, err
+	}
+	url.Release()
+	return s.toProto(url), nil
+}
+
+// release releases a quarantined URL, on condition that it is unchanged
+// when the store supports it
+func (s *server) release(ctx context.Context, url *models.URL) error {
+	if releaser, ok := s.storage.(storage.Releaser); ok {
+		return releaser.Release(ctx, url.ShortCode, url.OriginalURL)
+	}
+	released := *url
+	released.Release()
+	return s.storage.Update(ctx, &released)
+}
+
+// list reads a page of the URLs matching match. Filters are applied after
+// reading from the store, so keep reading until the page is full, the
+// store is exhausted or the scan budget is spent. Never read more than
+// still fits so the cursor stays exact.
+func (s *server) list(ctx context.Context, size int32, listOpts storage.ListOptions, match func(*models.URL) bool) (*pb.ListShortURLsResponse, error) {
+	pageSize := int(size)
+	switch {
+	case pageSize < 0:
+		return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	case pageSize == 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	resp := &pb.ListShortURLsResponse{}
+	for scans := 0; len(resp.Urls) < pageSize && scans < maxListScans; scans++ {
+		listOpts.Limit = pageSize - len(resp.Urls)
+		page, err := s.storage.List(ctx, listOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, url := range page.URLs {
+			if match(url) {
+				resp.Urls = append(resp.Urls, s.toProto(url))
+			}
+		}
+
+		listOpts.Cursor = page.NextCursor
+		if page.NextCursor == "" {
+			break
+		}
+	}
+
+	resp.NextPageToken = listOpts.Cursor
+	return resp, nil
+}
+
+// matchesFilters reports whether url passes the optional filters of req
+func matchesFilters(u *models.URL, req *pb.ListShortURLsRequest) bool {
+	if req.CreatedAfter != 0 && u.CreatedAt.Before(time.Unix(req.CreatedAfter, 0)) {
+		return false
+	}
+	if req.CreatedBefore != 0 && !u.CreatedAt.Before(time.Unix(req.CreatedBefore, 0)) {
+		return false
+	}
+	if req.Host != "" {
+		parsed, err := url.Parse(u.OriginalURL)
+		if err != nil || parsed.Hostname() != req.Host {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *server) BatchCreateShortURLs(ctx context.Context, req *pb.BatchCreateShortURLsRequest) (*pb.BatchCreateShortURLsResponse, error) {
+	if len(req.Requests) > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d URLs can be created per batch", maxBatchSize)
+	}
+
+	// Items are independent, so one failure does not stop the others
+	resp := &pb.BatchCreateShortURLsResponse{
+		Results: make([]*pb.BatchCreateShortURLsResult, len(req.Requests)),
+	}
+	for i, item := range req.Requests {
+		url, err := s.shortener.CreateShortURL(ctx, item.Url, createOptions(item))
+		if err != nil {
+			st := grpcerr.Status(err, item.Alias)
+			if st.Code() == codes.Internal {
+				log.Printf("BatchCreateShortURLs item %d: %v", i, err)
+			}
+			resp.Results[i] = &pb.BatchCreateShortURLsResult{
+				Result:    &pb.BatchCreateShortURLsResult_Error{Error: st.Message()},
+				ErrorCode: int32(st.Code()),
+			}
+			continue
+		}
+		resp.Results[i] = &pb.BatchCreateShortURLsResult{
+			Result: &pb.BatchCreateShortURLsResult_Url{Url: s.toProto(url)},
+		}
+	}
+	return resp, nil
+}
+
+// toProto converts a stored URL to its API representation
+func (s *server) toProto(url *models.URL) *pb.ShortURL {
+	status := url.Status
+	if status == "" {
+		status = models.StatusActive
+	}
+	return &pb.ShortURL{
+		ShortCode:   url.ShortCode,
+		ShortUrl:    s.shortener.GetShortURL(url.ShortCode),
+		OriginalUrl: url.OriginalURL,
+		CreatedAt:   url.CreatedAt.Unix(),
+		ExpiresAt:   unixOrZero(url.ExpiresAt),
+		Status:      status,
+		Threats:     url.Threats,
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/storage"
+)
+
+var (
+	cleaner       storage.BucketCleaner
+	retentionDays int
+)
+
+func init() {
+	// Load configuration from the environment
+	cfg, err := config.Load()
+	if err != nil {
+		panic(fmt.Sprintf("unable to load config: %v", err))
+	}
+
+	// Initialize the configured storage backend
+	backend, err := storage.Open(context.TODO(), cfg.Storage)
+	if err != nil {
+		panic(fmt.Sprintf("unable to open storage: %v", err))
+	}
+
+	var ok bool
+	if cleaner, ok = backend.Counter.(storage.BucketCleaner); !ok {
+		panic(fmt.Sprintf("the %s backend has no counter buckets to clean up", cfg.Storage.Backend))
+	}
+	retentionDays = cfg.Storage.CounterRetentionDays
+}
+
+// handleRequest runs on a schedule and deletes old counter buckets
+func handleRequest(ctx context.Context, event events.CloudWatchEvent) (*storage.CleanupReport, error) {
+	report, err := cleaner.CleanupOldBuckets(ctx, storage.CleanupOptions{DaysToKeep: retentionDays})
+	if err != nil {
+		return report, err
+	}
+
+	log.Printf("Cleaned up counter buckets: scanned=%d stale=%d deleted=%d skipped=%d failed=%d",
+		report.Scanned, report.Stale, report.Deleted, report.Skipped, len(report.Failed))
+	return report, nil
+}
+
+func main() {
+	lambda.Start(handleRequest)
+}
@@ -2,95 +2,60 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"os"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/domains"
+	"github.com/jingy/Go-Shortener/internal/handler"
+	"github.com/jingy/Go-Shortener/internal/screening"
 	"github.com/jingy/Go-Shortener/internal/storage"
 	"github.com/jingy/Go-Shortener/pkg/shortener"
 )
 
-var (
-	shortenerService *shortener.Shortener
-	urlStorage      *storage.DynamoDBStorage
-	counterStorage  *storage.CounterStorage
-)
+var apiHandler *handler.Handler
 
 func init() {
-	// Initialize AWS config
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	// Load configuration from the environment
+	cfg, err := config.Load()
 	if err != nil {
-		panic(fmt.Sprintf("unable to load SDK config: %v", err))
-	}
-
-	// Initialize DynamoDB client
-	dynamoClient := dynamodb.NewFromConfig(cfg)
-	urlStorage = storage.NewDynamoDBStorage(dynamoClient)
-	counterStorage = storage.NewCounterStorage(dynamoClient)
-
-	// Initialize shortener service
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		baseURL = "https://your-domain.com" // Replace with your actual domain
+		panic(fmt.Sprintf("unable to load config: %v", err))
 	}
-	shortenerService = shortener.NewShortener(baseURL, counterStorage)
-}
 
-func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// Parse request body
-	var req models.CreateURLRequest
-	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: 400,
-			Body:       `{"error": "Invalid request body"}`,
-		}, nil
-	}
-
-	// Create short URL
-	url, err := shortenerService.CreateShortURL(ctx, req.URL)
+	// Initialize the configured storage backend
+	backend, err := storage.Open(context.TODO(), cfg.Storage)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: 400,
-			Body:       fmt.Sprintf(`{"error": "%v"}`, err),
-		}, nil
+		panic(fmt.Sprintf("unable to open storage: %v", err))
 	}
 
-	// Store in DynamoDB
-	if err := urlStorage.Create(ctx, url); err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Body:       `{"error": "Failed to create short URL"}`,
-		}, nil
+	// Load the domain rules, reloading them in the background
+	domainPolicy, err := domains.OpenFromConfig(context.TODO(), cfg)
+	if err != nil {
+		panic(fmt.Sprintf("unable to load domain rules: %v", err))
 	}
 
-	// Prepare response
-	response := models.CreateURLResponse{
-		ShortCode: url.ShortCode,
-		ShortURL:  url.ShortURL,
+	// Load the screening lists, watching for new ones
+	screener, err := screening.OpenFromConfig(cfg.Screening)
+	if err != nil {
+		panic(fmt.Sprintf("unable to load screening lists: %v", err))
 	}
 
-	responseBody, err := json.Marshal(response)
+	// Initialize shortener service
+	shortenerService, err := shortener.NewFromConfig(cfg, backend,
+		shortener.WithDomainPolicy(domainPolicy),
+		shortener.WithScreener(screener, cfg.Screening.Action == config.ScreeningQuarantine),
+	)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Body:       `{"error": "Failed to generate response"}`,
-		}, nil
+		panic(fmt.Sprintf("unable to create shortener: %v", err))
 	}
+	apiHandler = handler.New(shortenerService, backend.URLs)
+}
 
-	return events.APIGatewayProxyResponse{
-		StatusCode: 201,
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-		},
-		Body: string(responseBody),
-	}, nil
+func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return handler.ToAPIGateway(apiHandler.Create(ctx, []byte(request.Body))), nil
 }
 
 func main() {
 	lambda.Start(handleRequest)
-} 
\ No newline at end of file
+}
@@ -3,71 +3,92 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
+	"log"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/domains"
+	"github.com/jingy/Go-Shortener/internal/handler"
+	"github.com/jingy/Go-Shortener/internal/screening"
 	"github.com/jingy/Go-Shortener/internal/storage"
 )
 
-var urlStorage *storage.DynamoDBStorage
+// shutdownTimeout bounds the final flush of clicks. Lambda allows 500ms after
+// SIGTERM.
+const shutdownTimeout = 400 * time.Millisecond
+
+var (
+	apiHandler *handler.Handler
+	recorder   *analytics.Recorder
+)
 
 func init() {
-	// Initialize AWS config
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	// Load configuration from the environment
+	cfg, err := config.Load()
 	if err != nil {
-		panic(fmt.Sprintf("unable to load SDK config: %v", err))
+		panic(fmt.Sprintf("unable to load config: %v", err))
 	}
 
-	// Initialize DynamoDB client
-	dynamoClient := dynamodb.NewFromConfig(cfg)
-	urlStorage = storage.NewDynamoDBStorage(dynamoClient)
-}
+	// Initialize the configured storage backend
+	backend, err := storage.Open(context.TODO(), cfg.Storage)
+	if err != nil {
+		panic(fmt.Sprintf("unable to open storage: %v", err))
+	}
 
-func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// Extract short code from path
-	shortCode := request.PathParameters["shortCode"]
-	if shortCode == "" {
-		return events.APIGatewayProxyResponse{
-			StatusCode: 400,
-			Body:       `{"error": "Missing short code"}`,
-		}, nil
+	// Record clicks in the background. Events still buffered when the
+	// execution environment is frozen are written on the next invocation.
+	stats, err := analytics.Open(context.TODO(), cfg)
+	if err != nil {
+		panic(fmt.Sprintf("unable to open analytics: %v", err))
+	}
+	recorder, err = analytics.NewRecorderFromConfig(cfg.Analytics, stats)
+	if err != nil {
+		panic(fmt.Sprintf("unable to start click recorder: %v", err))
 	}
 
-	// Get URL from storage
-	url, err := urlStorage.Get(ctx, shortCode)
+	// Quarantine links whose destination became flagged
+	screener, err := screening.OpenFromConfig(cfg.Screening)
 	if err != nil {
-		if err == models.ErrURLNotFound {
-			return events.APIGatewayProxyResponse{
-				StatusCode: 404,
-				Body:       `{"error": "URL not found"}`,
-			}, nil
-		}
-		if err == models.ErrURLExpired {
-			return events.APIGatewayProxyResponse{
-				StatusCode: 410,
-				Body:       `{"error": "URL has expired"}`,
-			}, nil
+		panic(fmt.Sprintf("unable to load screening lists: %v", err))
+	}
+	page, err := handler.NewBlockPage(cfg.Domains.BlockPagePath, cfg.Domains.BlockPageStatus)
+	if err != nil {
+		panic(fmt.Sprintf("unable to load block page: %v", err))
+	}
+
+	opts := []handler.Option{
+		handler.WithRecorder(recorder),
+		handler.WithScreener(screener, page),
+		handler.WithTrustedProxyHops(cfg.Analytics.TrustedProxyHops),
+	}
+	if cfg.Domains.CheckRedirects {
+		domainPolicy, err := domains.OpenFromConfig(context.TODO(), cfg)
+		if err != nil {
+			panic(fmt.Sprintf("unable to load domain rules: %v", err))
 		}
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Body:       `{"error": "Failed to retrieve URL"}`,
-		}, nil
+		opts = append(opts, handler.WithDomainPolicy(domainPolicy, page))
 	}
 
-	// Return redirect response
-	return events.APIGatewayProxyResponse{
-		StatusCode: 302,
-		Headers: map[string]string{
-			"Location": url.OriginalURL,
-		},
-		Body: "",
-	}, nil
+	apiHandler = handler.New(nil, backend.URLs, opts...)
+}
+
+func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	visitor := apiHandler.VisitorFromAPIGateway(request)
+	return handler.ToAPIGateway(apiHandler.Redirect(ctx, request.PathParameters["shortCode"], visitor)), nil
+}
+
+// flushClicks writes the buffered clicks before the environment shuts down
+func flushClicks() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := recorder.Close(ctx); err != nil {
+		log.Printf("Failed to flush clicks: %v", err)
+	}
 }
 
 func main() {
-	lambda.Start(handleRequest)
-} 
\ No newline at end of file
+	lambda.StartWithOptions(handleRequest, lambda.WithEnableSIGTERM(flushClicks))
+}
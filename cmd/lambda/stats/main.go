@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/handler"
+	"github.com/jingy/Go-Shortener/internal/storage"
+)
+
+var apiHandler *handler.Handler
+
+func init() {
+	// Load configuration from the environment
+	cfg, err := config.Load()
+	if err != nil {
+		panic(fmt.Sprintf("unable to load config: %v", err))
+	}
+
+	// Initialize the configured storage backend
+	backend, err := storage.Open(context.TODO(), cfg.Storage)
+	if err != nil {
+		panic(fmt.Sprintf("unable to open storage: %v", err))
+	}
+
+	// Read the statistics written by the redirect function
+	stats, err := analytics.Open(context.TODO(), cfg)
+	if err != nil {
+		panic(fmt.Sprintf("unable to open analytics: %v", err))
+	}
+
+	apiHandler = handler.New(nil, backend.URLs, handler.WithStats(stats))
+}
+
+func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	shortCode := request.PathParameters["shortCode"]
+	if strings.HasSuffix(request.Resource, "/timeseries") {
+		return handler.ToAPIGateway(apiHandler.TimeSeries(ctx, shortCode, request.QueryStringParameters)), nil
+	}
+	return handler.ToAPIGateway(apiHandler.Stats(ctx, shortCode, request.QueryStringParameters)), nil
+}
+
+func main() {
+	lambda.Start(handleRequest)
+}
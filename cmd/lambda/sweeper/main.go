@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/storage"
+	"github.com/jingy/Go-Shortener/internal/sweeper"
+)
+
+var urlSweeper *sweeper.Sweeper
+
+func init() {
+	// Load configuration from the environment
+	cfg, err := config.Load()
+	if err != nil {
+		panic(fmt.Sprintf("unable to load config: %v", err))
+	}
+
+	// Initialize the configured storage backend
+	backend, err := storage.Open(context.TODO(), cfg.Storage)
+	if err != nil {
+		panic(fmt.Sprintf("unable to open storage: %v", err))
+	}
+	urlSweeper = sweeper.New(backend.URLs, backend.Archiver)
+}
+
+// handleRequest runs on a schedule and sweeps expired URLs
+func handleRequest(ctx context.Context, event events.CloudWatchEvent) (*sweeper.Report, error) {
+	report, err := urlSweeper.Sweep(ctx, sweeper.Options{})
+	if err != nil {
+		return report, err
+	}
+
+	log.Printf("Swept expired URLs: scanned=%d expired=%d archived=%d deleted=%d failed=%d",
+		report.Scanned, report.Expired, report.Archived, report.Deleted, len(report.Failed))
+	return report, nil
+}
+
+func main() {
+	lambda.Start(handleRequest)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/domains"
+	"github.com/jingy/Go-Shortener/internal/handler"
+	"github.com/jingy/Go-Shortener/internal/screening"
+	"github.com/jingy/Go-Shortener/internal/storage"
+	"github.com/jingy/Go-Shortener/pkg/shortener"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	// Load configuration from the environment
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Unable to load config: %v", err)
+	}
+
+	// Initialize the configured storage backend
+	backend, err := storage.Open(context.Background(), cfg.Storage)
+	if err != nil {
+		log.Fatalf("Unable to open storage: %v", err)
+	}
+	defer backend.Close()
+
+	// Record clicks in the background
+	stats, err := analytics.Open(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Unable to open analytics: %v", err)
+	}
+	defer stats.Close()
+	recorder, err := analytics.NewRecorderFromConfig(cfg.Analytics, stats)
+	if err != nil {
+		log.Fatalf("Unable to start click recorder: %v", err)
+	}
+
+	// Load the domain rules, reloading them in the background
+	domainPolicy, err := domains.OpenFromConfig(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Unable to load domain rules: %v", err)
+	}
+	defer domainPolicy.Close()
+
+	// Load the screening lists, watching for new ones
+	screener, err := screening.OpenFromConfig(cfg.Screening)
+	if err != nil {
+		log.Fatalf("Unable to load screening lists: %v", err)
+	}
+	defer screener.Close()
+
+	// Initialize shortener and REST handlers
+	urlShortener, err := shortener.NewFromConfig(cfg, backend,
+		shortener.WithDomainPolicy(domainPolicy),
+		shortener.WithScreener(screener, cfg.Screening.Action == config.ScreeningQuarantine),
+	)
+	if err != nil {
+		log.Fatalf("Unable to create shortener: %v", err)
+	}
+	page, err := handler.NewBlockPage(cfg.Domains.BlockPagePath, cfg.Domains.BlockPageStatus)
+	if err != nil {
+		log.Fatalf("Unable to load block page: %v", err)
+	}
+	handlerOpts := []handler.Option{
+		handler.WithRecorder(recorder),
+		handler.WithStats(stats),
+		handler.WithTrustedProxyHops(cfg.Analytics.TrustedProxyHops),
+		handler.WithScreener(screener, page),
+	}
+	if cfg.Domains.CheckRedirects {
+		handlerOpts = append(handlerOpts, handler.WithDomainPolicy(domainPolicy, page))
+	}
+
+	srv := &http.Server{
+		Addr:              cfg.HTTPAddr,
+		Handler:           handler.New(urlShortener, backend.URLs, handlerOpts...),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Shut down gracefully on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down cleanly: %v", err)
+		}
+	}()
+
+	log.Printf("Starting REST server on %s", cfg.HTTPAddr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Failed to serve: %v", err)
+	}
+
+	// Write the clicks still buffered
+	flushCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := recorder.Close(flushCtx); err != nil {
+		log.Printf("Failed to flush clicks: %v", err)
+	}
+}
@@ -4,4 +4,4 @@
 /app/grpc-server &
 
 # Start the REST API server in the foreground
-exec /app/rest-server 
\ No newline at end of file
+exec /app/server 
\ No newline at end of file
@@ -0,0 +1,445 @@
+// Package analytics records redirects of short URLs as click events and
+// aggregates them into the statistics served by GetURLStats.
+package analytics
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+const (
+	// ipHashSize is the number of bytes of the HMAC kept in ClickEvent.IPHash
+	// and ClickEvent.VisitorID
+	ipHashSize = 16
+	// dayFormat keys the daily visitor sketches
+	dayFormat = "2006-01-02"
+	// VisitorRetentionDays is how many days of daily visitor sketches are
+	// kept. All-time sketches are kept forever.
+	VisitorRetentionDays = 35
+	// maxDimensionValueLength bounds the client-supplied values counted per
+	// dimension, such as UTM parameters
+	maxDimensionValueLength = 100
+	// OtherKey collects the clicks of the values dropped by TopN
+	OtherKey = "(other)"
+	// maxStoredValues bounds the values of a dimension stores count clicks of
+	// separately, leaving room above MaxTopN
+	maxStoredValues = 200
+	// DefaultTopN and MaxTopN bound the values returned per breakdown
+	DefaultTopN = 10
+	MaxTopN     = 100
+)
+
+// ClickEvent is a single redirect of a short URL. The client IP is only kept
+// as a salted hash.
+type ClickEvent struct {
+	ShortCode string    `json:"shortCode"`
+	Timestamp time.Time `json:"timestamp"`
+	IPHash    string    `json:"ipHash,omitempty"`
+	// VisitorID is a salted fingerprint of the client IP and user agent
+	VisitorID string `json:"visitorId,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	Referrer  string `json:"referrer,omitempty"`
+	// Country is an ISO 3166-1 alpha-2 code, empty when unknown
+	Country string `json:"country,omitempty"`
+	// Bot is the category of an automated click, such as "crawler" or
+	// "preview", and empty for people
+	Bot         string `json:"bot,omitempty"`
+	UTMSource   string `json:"utmSource,omitempty"`
+	UTMMedium   string `json:"utmMedium,omitempty"`
+	UTMCampaign string `json:"utmCampaign,omitempty"`
+	// Device is desktop, mobile, tablet, bot or other; Device, OS and
+	// Browser are derived from the user agent
+	Device  string `json:"device,omitempty"`
+	OS      string `json:"os,omitempty"`
+	Browser string `json:"browser,omitempty"`
+}
+
+// Visitor describes the client that followed a short URL
+type Visitor struct {
+	IP        string
+	UserAgent string
+	Referrer  string
+	Country   string
+	Campaign  Campaign
+}
+
+// Campaign holds the UTM parameters of the link a visitor followed
+type Campaign struct {
+	Source string
+	Medium string
+	Name   string
+}
+
+// CampaignFromQuery reads utm_source, utm_medium and utm_campaign from the
+// query string of a redirect
+func CampaignFromQuery(query url.Values) Campaign {
+	return Campaign{
+		Source: dimensionValue(query.Get("utm_source")),
+		Medium: dimensionValue(query.Get("utm_medium")),
+		Name:   dimensionValue(query.Get("utm_campaign")),
+	}
+}
+
+// ReferrerDomain returns the host of a referrer URL without a "www." prefix,
+// or an empty string when it has none
+func ReferrerDomain(referrer string) string {
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// dimensionValue trims a client-supplied value and bounds its length
+func dimensionValue(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) > maxDimensionValueLength {
+		value = strings.ToValidUTF8(value[:maxDimensionValueLength], "")
+	}
+	return value
+}
+
+// Stats aggregates the clicks of a short URL
+type Stats struct {
+	TotalClicks int64
+	// ClicksByCountry only counts clicks whose country is known
+	ClicksByCountry map[string]int64
+	// ClicksByHour maps the UTC hour of day (0-23) to clicks
+	ClicksByHour map[int]int64
+	// BotClicks maps bot categories to their clicks, which are also part of
+	// the counts above
+	BotClicks map[string]int64
+	// ClicksByReferrer maps referrer domains to clicks; the remaining maps
+	// are keyed by UTM parameter, device class, OS and browser. Clicks
+	// without a value are left out of each.
+	ClicksByReferrer map[string]int64
+	ClicksBySource   map[string]int64
+	ClicksByMedium   map[string]int64
+	ClicksByCampaign map[string]int64
+	ClicksByDevice   map[string]int64
+	ClicksByOS       map[string]int64
+	ClicksByBrowser  map[string]int64
+	// Visitors estimates the distinct human visitors of all time and of
+	// each UTC day
+	Visitors *VisitorSketches
+}
+
+// NewStats returns empty statistics
+func NewStats() *Stats {
+	return &Stats{
+		ClicksByCountry:  make(map[string]int64),
+		ClicksByHour:     make(map[int]int64),
+		BotClicks:        make(map[string]int64),
+		ClicksByReferrer: make(map[string]int64),
+		ClicksBySource:   make(map[string]int64),
+		ClicksByMedium:   make(map[string]int64),
+		ClicksByCampaign: make(map[string]int64),
+		ClicksByDevice:   make(map[string]int64),
+		ClicksByOS:       make(map[string]int64),
+		ClicksByBrowser:  make(map[string]int64),
+		Visitors:         NewVisitorSketches(),
+	}
+}
+
+// dimension is a map of Stats counting clicks per value
+type dimension struct {
+	// name prefixes the counters of the dimension in stores
+	name   string
+	counts func(*Stats) map[string]int64
+	value  func(ClickEvent) string
+}
+
+// dimensions lists every per-value breakdown of Stats except the hour
+var dimensions = []dimension{
+	{"Country", func(s *Stats) map[string]int64 { return s.ClicksByCountry }, func(e ClickEvent) string { return e.Country }},
+	{"Bot", func(s *Stats) map[string]int64 { return s.BotClicks }, func(e ClickEvent) string { return e.Bot }},
+	{"Referrer", func(s *Stats) map[string]int64 { return s.ClicksByReferrer }, func(e ClickEvent) string { return ReferrerDomain(e.Referrer) }},
+	{"Source", func(s *Stats) map[string]int64 { return s.ClicksBySource }, func(e ClickEvent) string { return e.UTMSource }},
+	{"Medium", func(s *Stats) map[string]int64 { return s.ClicksByMedium }, func(e ClickEvent) string { return e.UTMMedium }},
+	{"Campaign", func(s *Stats) map[string]int64 { return s.ClicksByCampaign }, func(e ClickEvent) string { return e.UTMCampaign }},
+	{"Device", func(s *Stats) map[string]int64 { return s.ClicksByDevice }, func(e ClickEvent) string { return e.Device }},
+	{"OS", func(s *Stats) map[string]int64 { return s.ClicksByOS }, func(e ClickEvent) string { return e.OS }},
+	{"Browser", func(s *Stats) map[string]int64 { return s.ClicksByBrowser }, func(e ClickEvent) string { return e.Browser }},
+}
+
+// Add counts a single click
+func (s *Stats) Add(event ClickEvent) {
+	s.add(event, false)
+}
+
+// addBounded counts a single click like Add, except that a dimension already
+// counting maxStoredValues values counts the clicks of new ones under
+// OtherKey, so client-supplied values such as UTM parameters cannot grow the
+// stats of a link without bound
+func (s *Stats) addBounded(event ClickEvent) {
+	s.add(event, true)
+}
+
+func (s *Stats) add(event ClickEvent, bounded bool) {
+	s.TotalClicks++
+	for _, d := range dimensions {
+		value := d.value(event)
+		if value == "" {
+			continue
+		}
+		counts := d.counts(s)
+		if _, ok := counts[value]; !ok && bounded && distinctValues(counts) >= maxStoredValues {
+			value = OtherKey
+		}
+		counts[value]++
+	}
+	s.ClicksByHour[event.Timestamp.UTC().Hour()]++
+	if event.Bot == "" && event.VisitorID != "" {
+		s.Visitors.Add(event.Timestamp, event.VisitorID)
+	}
+}
+
+// distinctValues returns the values counted separately in counts
+func distinctValues(counts map[string]int64) int {
+	if _, ok := counts[OtherKey]; ok {
+		return len(counts) - 1
+	}
+	return len(counts)
+}
+
+// HumanClicks returns the clicks that are not from bots
+func (s *Stats) HumanClicks() int64 {
+	clicks := s.TotalClicks
+	for _, bot := range s.BotClicks {
+		clicks -= bot
+	}
+	return clicks
+}
+
+// Merge adds the counts of other to s
+func (s *Stats) Merge(other *Stats) {
+	s.TotalClicks += other.TotalClicks
+	for _, d := range dimensions {
+		counts := d.counts(s)
+		for value, clicks := range d.counts(other) {
+			counts[value] += clicks
+		}
+	}
+	for hour, clicks := range other.ClicksByHour {
+		s.ClicksByHour[hour] += clicks
+	}
+	s.Visitors.Merge(other.Visitors)
+}
+
+// TopLimit validates the number of values requested per breakdown, zero
+// meaning DefaultTopN
+func TopLimit(n int) (int, error) {
+	if n == 0 {
+		return DefaultTopN, nil
+	}
+	if n < 0 || n > MaxTopN {
+		return 0, fmt.Errorf("%w: %d is not between 1 and %d", models.ErrInvalidTop, n, MaxTopN)
+	}
+	return n, nil
+}
+
+// Top returns a copy of s keeping the n values with the most clicks of the
+// referrer, UTM, device, OS and browser breakdowns
+func (s *Stats) Top(n int) *Stats {
+	top := *s
+	top.ClicksByReferrer = TopN(s.ClicksByReferrer, n)
+	top.ClicksBySource = TopN(s.ClicksBySource, n)
+	top.ClicksByMedium = TopN(s.ClicksByMedium, n)
+	top.ClicksByCampaign = TopN(s.ClicksByCampaign, n)
+	top.ClicksByDevice = TopN(s.ClicksByDevice, n)
+	top.ClicksByOS = TopN(s.ClicksByOS, n)
+	top.ClicksByBrowser = TopN(s.ClicksByBrowser, n)
+	return &top
+}
+
+// TopN returns the n values of counts with the most clicks, ties broken by
+// value, and sums the clicks of the others under OtherKey. counts is returned
+// as is when it has at most n values.
+func TopN(counts map[string]int64, n int) map[string]int64 {
+	if len(counts) <= n {
+		return counts
+	}
+
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+
+	top := make(map[string]int64, n+1)
+	for i, value := range values {
+		if i < n {
+			top[value] = counts[value]
+		} else {
+			top[OtherKey] += counts[value]
+		}
+	}
+	return top
+}
+
+// VisitorSketches holds a HyperLogLog of the visitors of all time and one per
+// UTC day, keyed "YYYY-MM-DD"
+type VisitorSketches struct {
+	All  *HyperLogLog
+	Days map[string]*HyperLogLog
+}
+
+func NewVisitorSketches() *VisitorSketches {
+	return &VisitorSketches{
+		All:  NewHyperLogLog(),
+		Days: make(map[string]*HyperLogLog),
+	}
+}
+
+// Add records a visit by visitorID at t
+func (v *VisitorSketches) Add(t time.Time, visitorID string) {
+	v.All.AddString(visitorID)
+	v.day(t.UTC().Format(dayFormat)).AddString(visitorID)
+}
+
+// Merge adds the visitors of other
+func (v *VisitorSketches) Merge(other *VisitorSketches) {
+	v.All.Merge(other.All)
+	for day, sketch := range other.Days {
+		v.day(day).Merge(sketch)
+	}
+}
+
+// Since estimates the distinct visitors from the UTC day of from onwards
+func (v *VisitorSketches) Since(from time.Time) int64 {
+	first := from.UTC().Format(dayFormat)
+	window := NewHyperLogLog()
+	for day, sketch := range v.Days {
+		if day >= first {
+			window.Merge(sketch)
+		}
+	}
+	return window.Estimate()
+}
+
+// Prune drops the daily sketches of days before the UTC day of before
+func (v *VisitorSketches) Prune(before time.Time) {
+	first := before.UTC().Format(dayFormat)
+	for day := range v.Days {
+		if day < first {
+			delete(v.Days, day)
+		}
+	}
+}
+
+// day returns the sketch of a day, creating it if needed
+func (v *VisitorSketches) day(day string) *HyperLogLog {
+	sketch, ok := v.Days[day]
+	if !ok {
+		sketch = NewHyperLogLog()
+		v.Days[day] = sketch
+	}
+	return sketch
+}
+
+// Store persists click events as aggregated statistics. Every storage
+// backend has a matching Store so callers never depend on a concrete one.
+type Store interface {
+	// RecordClicks adds a batch of events to the statistics. Implementations
+	// must not keep a reference to events.
+	RecordClicks(ctx context.Context, events []ClickEvent) error
+	// GetStats returns the statistics of a short code, which are empty when
+	// it was never clicked
+	GetStats(ctx context.Context, shortCode string) (*Stats, error)
+	// GetTimeSeries returns the clicks of a short code in every bucket of a
+	// normalized query, including empty ones
+	GetTimeSeries(ctx context.Context, shortCode string, query TimeSeriesQuery) ([]Point, error)
+	// ExportClicks calls fn with every retained event matching query and
+	// stops at the first error fn returns. The events of a short code come in
+	// the order they were recorded; they are read incrementally rather than
+	// loaded all at once.
+	ExportClicks(ctx context.Context, query ExportQuery, fn func(ClickEvent) error) error
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// ExportQuery selects the events of [From, To) of a short code, or of every
+// short code when ShortCode is empty. Zero times leave the range open.
+type ExportQuery struct {
+	ShortCode string
+	From      time.Time
+	To        time.Time
+}
+
+// Match reports whether event is selected by q
+func (q ExportQuery) Match(event ClickEvent) bool {
+	if q.ShortCode != "" && event.ShortCode != q.ShortCode {
+		return false
+	}
+	if !q.From.IsZero() && event.Timestamp.Before(q.From) {
+		return false
+	}
+	return q.To.IsZero() || event.Timestamp.Before(q.To)
+}
+
+// storeOptions are the settings shared by every Store
+type storeOptions struct {
+	retention      Retention
+	eventRetention time.Duration
+}
+
+// StoreOption configures a Store
+type StoreOption func(*storeOptions)
+
+// WithRetention sets how long the time series buckets of each granularity
+// are kept
+func WithRetention(retention Retention) StoreOption {
+	return func(o *storeOptions) {
+		o.retention = retention
+	}
+}
+
+// WithEventRetention sets how long stores that expire raw click events keep
+// them. Zero keeps them forever.
+func WithEventRetention(retention time.Duration) StoreOption {
+	return func(o *storeOptions) {
+		o.eventRetention = retention
+	}
+}
+
+func newStoreOptions(opts []StoreOption) storeOptions {
+	o := storeOptions{retention: DefaultRetention, eventRetention: DefaultEventRetention}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Fingerprint returns a salted hash identifying a visitor by IP and user
+// agent. It returns an empty string when the IP is unknown.
+func Fingerprint(salt []byte, ip, userAgent string) string {
+	if ip == "" {
+		return ""
+	}
+	return HashIP(salt, ip+"\x00"+userAgent)
+}
+
+// HashIP returns a salted hash of ip so visitors can be told apart without
+// storing their address. It returns an empty string for an empty ip.
+func HashIP(salt []byte, ip string) string {
+	if ip == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil)[:ipHashSize])
+}
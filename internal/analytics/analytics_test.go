@@ -0,0 +1,1449 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+// testClicks are three clicks of abc123 by two visitors and a crawler, and one
+// of xyz789
+func testClicks() []ClickEvent {
+	return []ClickEvent{
+		{ShortCode: "abc123", Timestamp: time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC), Country: "US", VisitorID: "a", Referrer: "https://www.google.com/search?q=go", UTMSource: "newsletter", Device: "desktop", OS: "macOS", Browser: "Safari"},
+		{ShortCode: "abc123", Timestamp: time.Date(2024, 5, 1, 10, 45, 0, 0, time.UTC), Country: "US", VisitorID: "b", Referrer: "https://news.ycombinator.com/item?id=1", UTMSource: "newsletter", UTMMedium: "email", UTMCampaign: "spring sale"},
+		{ShortCode: "abc123", Timestamp: time.Date(2024, 5, 2, 13, 0, 0, 0, time.UTC), VisitorID: "a"},
+		{ShortCode: "abc123", Timestamp: time.Date(2024, 5, 2, 13, 30, 0, 0, time.UTC), VisitorID: "d", Bot: "crawler", Device: "bot"},
+		{ShortCode: "xyz789", Timestamp: time.Date(2024, 5, 2, 13, 0, 0, 0, time.UTC), Country: "DE", VisitorID: "c"},
+	}
+}
+
+// testStore records testClicks in store and checks the aggregated result
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	if err := store.RecordClicks(ctx, testClicks()); err != nil {
+		t.Fatalf("RecordClicks() error = %v", err)
+	}
+
+	stats, err := store.GetStats(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	if stats.TotalClicks != 4 || stats.HumanClicks() != 3 {
+		t.Errorf("TotalClicks = %d with %d human, want 4 with 3 human", stats.TotalClicks, stats.HumanClicks())
+	}
+	if want := map[string]int64{"US": 2}; !reflect.DeepEqual(stats.ClicksByCountry, want) {
+		t.Errorf("ClicksByCountry = %v, want %v", stats.ClicksByCountry, want)
+	}
+	if want := map[int]int64{10: 2, 13: 2}; !reflect.DeepEqual(stats.ClicksByHour, want) {
+		t.Errorf("ClicksByHour = %v, want %v", stats.ClicksByHour, want)
+	}
+	if want := map[string]int64{"crawler": 1}; !reflect.DeepEqual(stats.BotClicks, want) {
+		t.Errorf("BotClicks = %v, want %v", stats.BotClicks, want)
+	}
+	breakdowns := map[string][2]map[string]int64{
+		"ClicksByReferrer": {stats.ClicksByReferrer, {"google.com": 1, "news.ycombinator.com": 1}},
+		"ClicksBySource":   {stats.ClicksBySource, {"newsletter": 2}},
+		"ClicksByMedium":   {stats.ClicksByMedium, {"email": 1}},
+		"ClicksByCampaign": {stats.ClicksByCampaign, {"spring sale": 1}},
+		"ClicksByDevice":   {stats.ClicksByDevice, {"desktop": 1, "bot": 1}},
+		"ClicksByOS":       {stats.ClicksByOS, {"macOS": 1}},
+		"ClicksByBrowser":  {stats.ClicksByBrowser, {"Safari": 1}},
+	}
+	for name, breakdown := range breakdowns {
+		if !reflect.DeepEqual(breakdown[0], breakdown[1]) {
+			t.Errorf("%s = %v, want %v", name, breakdown[0], breakdown[1])
+		}
+	}
+	// The crawler is not a visitor
+	if visitors := stats.Visitors.All.Estimate(); visitors != 2 {
+		t.Errorf("unique visitors = %d, want 2", visitors)
+	}
+	if visitors := stats.Visitors.Since(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)); visitors != 1 {
+		t.Errorf("unique visitors since May 2 = %d, want 1", visitors)
+	}
+
+	// Hourly buckets of the two days, with the empty ones
+	query := TimeSeriesQuery{
+		Granularity: Hour,
+		From:        time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC),
+	}
+	points, err := store.GetTimeSeries(ctx, "abc123", query)
+	if err != nil {
+		t.Fatalf("GetTimeSeries() error = %v", err)
+	}
+	if len(points) != 48 {
+		t.Fatalf("GetTimeSeries() returned %d points, want 48", len(points))
+	}
+	if points[10].Clicks != 2 || points[37].Clicks != 2 || points[0].Clicks != 0 {
+		t.Errorf("GetTimeSeries() = %v, want 2 clicks at 10:00 and 13:00 the next day", points)
+	}
+	if !points[37].Start.Equal(time.Date(2024, 5, 2, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("point 37 starts at %v", points[37].Start)
+	}
+
+	// Minute buckets only cover their own minute
+	query = TimeSeriesQuery{
+		Granularity: Minute,
+		From:        time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC),
+		To:          time.Date(2024, 5, 1, 10, 17, 0, 0, time.UTC),
+	}
+	points, err = store.GetTimeSeries(ctx, "abc123", query)
+	if err != nil {
+		t.Fatalf("GetTimeSeries() error = %v", err)
+	}
+	if want := []int64{1, 0}; len(points) != 2 || points[0].Clicks != want[0] || points[1].Clicks != want[1] {
+		t.Errorf("GetTimeSeries() by minute = %v, want clicks %v", points, want)
+	}
+
+	// Raw events are exported by short code and time range, ordered within
+	// each short code
+	exports := []struct {
+		name  string
+		query ExportQuery
+		want  int
+	}{
+		{"all", ExportQuery{}, 5},
+		{"one code", ExportQuery{ShortCode: "abc123"}, 4},
+		{"range", ExportQuery{From: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 5, 2, 13, 30, 0, 0, time.UTC)}, 2},
+		{"code from", ExportQuery{ShortCode: "abc123", From: time.Date(2024, 5, 1, 10, 45, 0, 0, time.UTC)}, 3},
+		{"code to", ExportQuery{ShortCode: "abc123", To: time.Date(2024, 5, 1, 10, 45, 0, 0, time.UTC)}, 1},
+	}
+	for _, tt := range exports {
+		var events []ClickEvent
+		err := store.ExportClicks(ctx, tt.query, func(event ClickEvent) error {
+			events = append(events, event)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ExportClicks(%s) error = %v", tt.name, err)
+		}
+		if len(events) != tt.want {
+			t.Errorf("ExportClicks(%s) returned %d events, want %d", tt.name, len(events), tt.want)
+		}
+		for i, event := range events {
+			if !tt.query.Match(event) {
+				t.Errorf("ExportClicks(%s) returned %+v", tt.name, event)
+			}
+			if i > 0 && event.ShortCode == events[i-1].ShortCode && event.Timestamp.Before(events[i-1].Timestamp) {
+				t.Errorf("ExportClicks(%s) returned events out of order", tt.name)
+			}
+		}
+	}
+
+	// Events round trip unchanged
+	var exported []ClickEvent
+	store.ExportClicks(ctx, ExportQuery{ShortCode: "abc123"}, func(event ClickEvent) error {
+		exported = append(exported, event)
+		return nil
+	})
+	if want := testClicks()[:4]; !reflect.DeepEqual(exported, want) {
+		t.Errorf("exported events = %+v, want %+v", exported, want)
+	}
+	stop := errors.New("stop")
+	if err := store.ExportClicks(ctx, ExportQuery{}, func(ClickEvent) error { return stop }); !errors.Is(err, stop) {
+		t.Errorf("ExportClicks() error = %v, want the callback's error", err)
+	}
+
+	// Short codes that were never clicked have empty stats
+	stats, err = store.GetStats(ctx, "never")
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	if stats.TotalClicks != 0 || stats.ClicksByCountry == nil || stats.ClicksByHour == nil {
+		t.Errorf("GetStats() of unknown code = %+v, want empty stats", stats)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clicks.jsonl")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	testStore(t, store)
+	store.Close()
+
+	// Simulate a crash in the middle of a write
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	file.WriteString(`{"shortCode":"abc`)
+	file.Close()
+
+	// Reopening replays the log
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+
+	stats, _ := reopened.GetStats(context.Background(), "abc123")
+	if stats.TotalClicks != 4 || stats.ClicksByCountry["US"] != 2 || stats.BotClicks["crawler"] != 1 {
+		t.Errorf("replayed stats = %+v, want 4 clicks with 2 from US and 1 crawler", stats)
+	}
+
+	// The torn line is cut off, so clicks recorded after the crash survive
+	// the next restart
+	click := ClickEvent{ShortCode: "abc123", Timestamp: time.Date(2024, 5, 3, 9, 0, 0, 0, time.UTC), Country: "FR"}
+	if err := reopened.RecordClicks(context.Background(), []ClickEvent{click}); err != nil {
+		t.Fatalf("RecordClicks() error = %v", err)
+	}
+	reopened.Close()
+
+	reopened, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore() after appending to a torn log error = %v", err)
+	}
+	defer reopened.Close()
+
+	stats, _ = reopened.GetStats(context.Background(), "abc123")
+	if stats.TotalClicks != 5 || stats.ClicksByCountry["FR"] != 1 {
+		t.Errorf("replayed stats = %+v, want 5 clicks with 1 from FR", stats)
+	}
+}
+
+// statsTable is an in-memory url-stats table that applies ADD expressions,
+// along with the url-visitors, url-timeseries and url-clicks tables
+type statsTable struct {
+	mu       sync.Mutex
+	items    map[string]map[string]int64
+	updates  int
+	visitors map[string][]map[string]types.AttributeValue
+	// buckets maps short codes to bucket keys to clicks and ttls to their TTL
+	buckets map[string]map[string]int64
+	ttls    map[string]string
+	// events holds url-clicks items in write order. The first unprocessed
+	// items of a batch write are left unprocessed.
+	events      []map[string]types.AttributeValue
+	unprocessed int
+	// beforePut runs before each visitor write, outside the lock
+	beforePut func()
+	// beforeUpdate runs before each url-stats update, outside the lock
+	beforeUpdate func()
+	// failStats fails the url-stats updates of a short code
+	failStats string
+}
+
+func newStatsTable() *statsTable {
+	return &statsTable{
+		items:    make(map[string]map[string]int64),
+		visitors: make(map[string][]map[string]types.AttributeValue),
+		buckets:  make(map[string]map[string]int64),
+		ttls:     make(map[string]string),
+	}
+}
+
+// visitorItem returns the position of the sketch item of a window
+func (s *statsTable) visitorItem(shortCode, window string) int {
+	for i, item := range s.visitors[shortCode] {
+		if item["Window"].(*types.AttributeValueMemberS).Value == window {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *statsTable) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := checkExpressions(params.ProjectionExpression); err != nil {
+		return nil, err
+	}
+	shortCode := params.Key["ShortCode"].(*types.AttributeValueMemberS).Value
+	if *params.TableName == visitorsTableName {
+		i := s.visitorItem(shortCode, params.Key["Window"].(*types.AttributeValueMemberS).Value)
+		if i < 0 {
+			return &dynamodb.GetItemOutput{}, nil
+		}
+		return &dynamodb.GetItemOutput{Item: s.visitors[shortCode][i]}, nil
+	}
+
+	counters, ok := s.items[shortCode]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+
+	item := map[string]types.AttributeValue{"ShortCode": &types.AttributeValueMemberS{Value: shortCode}}
+	for attribute, value := range counters {
+		item[attribute] = &types.AttributeValueMemberN{Value: strconv.FormatInt(value, 10)}
+	}
+	return &dynamodb.GetItemOutput{Item: item}, nil
+}
+
+func (s *statsTable) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if s.beforeUpdate != nil && *params.TableName == statsTableName {
+		s.beforeUpdate()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shortCode := params.Key["ShortCode"].(*types.AttributeValueMemberS).Value
+	if *params.TableName == timeSeriesTableName {
+		bucket := params.Key["Bucket"].(*types.AttributeValueMemberS).Value
+		n, err := strconv.ParseInt(params.ExpressionAttributeValues[":clicks"].(*types.AttributeValueMemberN).Value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if s.buckets[shortCode] == nil {
+			s.buckets[shortCode] = make(map[string]int64)
+		}
+		s.buckets[shortCode][bucket] += n
+		if ttl, ok := params.ExpressionAttributeValues[":ttl"].(*types.AttributeValueMemberN); ok {
+			s.ttls[bucket] = ttl.Value
+		}
+		return &dynamodb.UpdateItemOutput{}, nil
+	}
+
+	if err := checkExpressions(params.UpdateExpression, params.ConditionExpression); err != nil {
+		return nil, err
+	}
+	if shortCode == s.failStats {
+		return nil, errors.New("throttled")
+	}
+	s.updates++
+	counters, ok := s.items[shortCode]
+	if !ok {
+		counters = make(map[string]int64)
+		s.items[shortCode] = counters
+	}
+
+	// Conditions are "(attribute_not_exists(#d) OR #d = :h)" joined by AND
+	if params.ConditionExpression != nil {
+		for _, condition := range strings.Split(*params.ConditionExpression, " AND ") {
+			var name, value string
+			fmt.Sscanf(strings.NewReplacer("(", " ", ")", " ").Replace(condition), " attribute_not_exists %s OR %s = %s", &name, &name, &value)
+			stored, ok := counters[params.ExpressionAttributeNames[name]]
+			expected := params.ExpressionAttributeValues[value].(*types.AttributeValueMemberN).Value
+			if ok && strconv.FormatInt(stored, 10) != expected {
+				return nil, &types.ConditionalCheckFailedException{}
+			}
+		}
+	}
+
+	for _, add := range strings.Split(strings.TrimPrefix(*params.UpdateExpression, "ADD "), ", ") {
+		name, value, _ := strings.Cut(add, " ")
+		n, err := strconv.ParseInt(params.ExpressionAttributeValues[value].(*types.AttributeValueMemberN).Value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		counters[params.ExpressionAttributeNames[name]] += n
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// checkExpressions fails like DynamoDB when an expression is over 4 KB
+func checkExpressions(expressions ...*string) error {
+	for _, expression := range expressions {
+		if len(aws.ToString(expression)) > 4096 {
+			return errors.New("ValidationException: expression size exceeds 4 KB")
+		}
+	}
+	return nil
+}
+
+// PutItem writes a sketch item, honouring the optimistic lock conditions
+func (s *statsTable) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if s.beforePut != nil {
+		s.beforePut()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shortCode := params.Item["ShortCode"].(*types.AttributeValueMemberS).Value
+	i := s.visitorItem(shortCode, params.Item["Window"].(*types.AttributeValueMemberS).Value)
+	switch *params.ConditionExpression {
+	case "attribute_not_exists(ShortCode)":
+		if i >= 0 {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+		s.visitors[shortCode] = append(s.visitors[shortCode], params.Item)
+	default:
+		expected := params.ExpressionAttributeValues[":version"].(*types.AttributeValueMemberN).Value
+		if i < 0 || s.visitors[shortCode][i]["Version"].(*types.AttributeValueMemberN).Value != expected {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+		s.visitors[shortCode][i] = params.Item
+	}
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (s *statsTable) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shortCode := params.ExpressionAttributeValues[":shortCode"].(*types.AttributeValueMemberS).Value
+	if *params.TableName == clicksTableName {
+		items, lastKey := s.eventPage(shortCode, params.ExpressionAttributeValues, params.ExclusiveStartKey)
+		return &dynamodb.QueryOutput{Items: items, LastEvaluatedKey: lastKey}, nil
+	}
+	if *params.TableName != timeSeriesTableName {
+		return &dynamodb.QueryOutput{Items: s.visitors[shortCode]}, nil
+	}
+
+	from := params.ExpressionAttributeValues[":from"].(*types.AttributeValueMemberS).Value
+	to := params.ExpressionAttributeValues[":to"].(*types.AttributeValueMemberS).Value
+	var items []map[string]types.AttributeValue
+	for bucket, clicks := range s.buckets[shortCode] {
+		if bucket >= from && bucket <= to {
+			items = append(items, map[string]types.AttributeValue{
+				"ShortCode": &types.AttributeValueMemberS{Value: shortCode},
+				"Bucket":    &types.AttributeValueMemberS{Value: bucket},
+				"Clicks":    &types.AttributeValueMemberN{Value: strconv.FormatInt(clicks, 10)},
+			})
+		}
+	}
+	return &dynamodb.QueryOutput{Items: items}, nil
+}
+
+func (s *statsTable) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := params.RequestItems[clicksTableName]
+	skipped := min(s.unprocessed, len(requests))
+	s.unprocessed -= skipped
+	for _, request := range requests[skipped:] {
+		s.events = append(s.events, request.PutRequest.Item)
+	}
+	output := &dynamodb.BatchWriteItemOutput{}
+	if skipped > 0 {
+		output.UnprocessedItems = map[string][]types.WriteRequest{clicksTableName: requests[:skipped]}
+	}
+	return output, nil
+}
+
+// eventPageSize is how many url-clicks items a Query or Scan page holds
+const eventPageSize = 2
+
+// eventPage pages through the events matching shortCode and the :from and
+// :to bounds, sorted by key like a Query. The start key holds an offset.
+// The caller holds s.mu.
+func (s *statsTable) eventPage(shortCode string, values map[string]types.AttributeValue, startKey map[string]types.AttributeValue) ([]map[string]types.AttributeValue, map[string]types.AttributeValue) {
+	var matched []map[string]types.AttributeValue
+	for _, item := range s.events {
+		key := item["EventKey"].(*types.AttributeValueMemberS).Value
+		if shortCode != "" && item["ShortCode"].(*types.AttributeValueMemberS).Value != shortCode {
+			continue
+		}
+		if from, ok := values[":from"].(*types.AttributeValueMemberS); ok && key < from.Value {
+			continue
+		}
+		if to, ok := values[":to"].(*types.AttributeValueMemberS); ok && key >= to.Value {
+			continue
+		}
+		matched = append(matched, item)
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i]["EventKey"].(*types.AttributeValueMemberS).Value < matched[j]["EventKey"].(*types.AttributeValueMemberS).Value
+	})
+
+	offset := 0
+	if startKey != nil {
+		offset, _ = strconv.Atoi(startKey["Offset"].(*types.AttributeValueMemberN).Value)
+	}
+	end := min(offset+eventPageSize, len(matched))
+	var lastKey map[string]types.AttributeValue
+	if end < len(matched) {
+		lastKey = map[string]types.AttributeValue{"Offset": &types.AttributeValueMemberN{Value: strconv.Itoa(end)}}
+	}
+	return matched[offset:end], lastKey
+}
+
+func (s *statsTable) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items, lastKey := s.eventPage("", params.ExpressionAttributeValues, params.ExclusiveStartKey)
+	return &dynamodb.ScanOutput{Items: items, LastEvaluatedKey: lastKey}, nil
+}
+
+func TestDynamoDBStore(t *testing.T) {
+	table := newStatsTable()
+	// The first click event is left unprocessed and retried
+	table.unprocessed = 1
+	testStore(t, NewDynamoDBStore(table))
+
+	if len(table.events) != 5 {
+		t.Errorf("click events written = %d, want 5", len(table.events))
+	}
+	// Click events expire after their retention
+	wantTTL := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC).Add(DefaultEventRetention).Unix()
+	for _, item := range table.events {
+		if item["EventKey"].(*types.AttributeValueMemberS).Value < "2024-05-01T10:16" {
+			if ttl := item["TTL"].(*types.AttributeValueMemberN).Value; ttl != strconv.FormatInt(wantTTL, 10) {
+				t.Errorf("click event TTL = %s, want %d", ttl, wantTTL)
+			}
+		}
+	}
+
+	// One update per short code in the batch
+	if table.updates != 2 {
+		t.Errorf("UpdateItem calls = %d, want 2", table.updates)
+	}
+
+	// Day buckets are kept forever, the others expire after their retention
+	if _, ok := table.ttls["day#2024-05-01"]; ok {
+		t.Error("day bucket has a TTL")
+	}
+	wantTTL = time.Date(2024, 5, 3, 10, 16, 0, 0, time.UTC).Unix()
+	if ttl := table.ttls["minute#2024-05-01T10:15"]; ttl != strconv.FormatInt(wantTTL, 10) {
+		t.Errorf("minute bucket TTL = %s, want %d", ttl, wantTTL)
+	}
+
+	// Daily sketches expire through native TTL, the all-time one never does
+	for _, item := range table.visitors["abc123"] {
+		_, hasTTL := item["TTL"]
+		if window := item["Window"].(*types.AttributeValueMemberS).Value; hasTTL == (window == windowAll) {
+			t.Errorf("sketch %s has TTL = %v", window, hasTTL)
+		}
+	}
+}
+
+func TestDynamoDBStore_ConcurrentVisitorWrites(t *testing.T) {
+	table := newStatsTable()
+	store := NewDynamoDBStore(table)
+	ctx := context.Background()
+	day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	// Another writer merges its visitor between our read and our write once.
+	// It shares the table's items but not its hook.
+	other := NewDynamoDBStore(&statsTable{items: table.items, visitors: table.visitors, buckets: table.buckets, ttls: table.ttls})
+	raced := false
+	table.beforePut = func() {
+		if raced {
+			return
+		}
+		raced = true
+		if err := other.RecordClicks(ctx, []ClickEvent{{ShortCode: "abc123", Timestamp: day, VisitorID: "other"}}); err != nil {
+			t.Errorf("RecordClicks() of the other writer error = %v", err)
+		}
+	}
+
+	if err := store.RecordClicks(ctx, []ClickEvent{{ShortCode: "abc123", Timestamp: day, VisitorID: "ours"}}); err != nil {
+		t.Fatalf("RecordClicks() error = %v", err)
+	}
+
+	stats, err := store.GetStats(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	if visitors := stats.Visitors.All.Estimate(); visitors != 2 {
+		t.Errorf("unique visitors = %d, want both writers' visitors", visitors)
+	}
+}
+
+func TestMemoryStore_HighCardinality(t *testing.T) {
+	file, err := OpenFileStore(filepath.Join(t.TempDir(), "clicks.jsonl"))
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	defer file.Close()
+
+	stores := []struct {
+		name  string
+		store Store
+	}{
+		{"memory", NewMemoryStore()},
+		{"file", file},
+	}
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+			// Every click has a campaign of its own, then the first one again
+			var events []ClickEvent
+			for i := 0; i < maxStoredValues+100; i++ {
+				events = append(events, ClickEvent{ShortCode: "abc123", Timestamp: day, UTMCampaign: fmt.Sprintf("campaign-%03d", i)})
+			}
+			events = append(events, ClickEvent{ShortCode: "abc123", Timestamp: day, UTMCampaign: "campaign-000"})
+			if err := tt.store.RecordClicks(ctx, events); err != nil {
+				t.Fatalf("RecordClicks() error = %v", err)
+			}
+
+			stats, err := tt.store.GetStats(ctx, "abc123")
+			if err != nil {
+				t.Fatalf("GetStats() error = %v", err)
+			}
+			campaigns := stats.ClicksByCampaign
+			if len(campaigns) != maxStoredValues+1 || campaigns["campaign-000"] != 2 || campaigns[OtherKey] != 100 {
+				t.Errorf("GetStats() = %d campaigns with %d clicks of campaign-000 and %d other, want %d, 2 and 100",
+					len(campaigns), campaigns["campaign-000"], campaigns[OtherKey], maxStoredValues+1)
+			}
+			if stats.TotalClicks != maxStoredValues+101 {
+				t.Errorf("GetStats() total clicks = %d, want %d", stats.TotalClicks, maxStoredValues+101)
+			}
+		})
+	}
+}
+
+func TestDynamoDBStore_HighCardinality(t *testing.T) {
+	table := newStatsTable()
+	store := NewDynamoDBStore(table)
+	ctx := context.Background()
+	day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	// Every click has a campaign of its own, the first one clicked twice
+	events := []ClickEvent{{ShortCode: "abc123", Timestamp: day, UTMCampaign: "campaign-000"}}
+	for i := 0; i < maxStoredValues+100; i++ {
+		events = append(events, ClickEvent{ShortCode: "abc123", Timestamp: day, UTMCampaign: fmt.Sprintf("campaign-%03d", i)})
+	}
+	if err := store.RecordClicks(ctx, events); err != nil {
+		t.Fatalf("RecordClicks() error = %v", err)
+	}
+	// Then an existing and a new campaign
+	events = []ClickEvent{
+		{ShortCode: "abc123", Timestamp: day, UTMCampaign: "campaign-000"},
+		{ShortCode: "abc123", Timestamp: day, UTMCampaign: "campaign-new"},
+	}
+	if err := store.RecordClicks(ctx, events); err != nil {
+		t.Fatalf("RecordClicks() error = %v", err)
+	}
+
+	// Only maxStoredValues campaigns get counters, the rest is under other
+	var campaigns, clicks int64
+	for attribute, n := range table.items["abc123"] {
+		if strings.HasPrefix(attribute, "Campaign#") {
+			campaigns++
+			clicks += n
+		}
+	}
+	counters := table.items["abc123"]
+	if campaigns != maxStoredValues+1 || counters["Distinct#Campaign"] != maxStoredValues {
+		t.Errorf("campaign counters = %d with distinct count %d, want %d", campaigns, counters["Distinct#Campaign"], maxStoredValues+1)
+	}
+	if clicks != maxStoredValues+103 || counters["Campaign#campaign-000"] != 3 || counters["Campaign#"+OtherKey] != 101 {
+		t.Errorf("campaign clicks = %d with %d of campaign-000 and %d other, want %d, 3 and 101",
+			clicks, counters["Campaign#campaign-000"], counters["Campaign#"+OtherKey], maxStoredValues+103)
+	}
+
+	stats, err := store.GetStats(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	if stats.TotalClicks != maxStoredValues+103 || stats.ClicksByCampaign["campaign-000"] != 3 {
+		t.Errorf("GetStats() = %d clicks with %d of campaign-000", stats.TotalClicks, stats.ClicksByCampaign["campaign-000"])
+	}
+}
+
+func TestDynamoDBStore_ManyValues(t *testing.T) {
+	table := newStatsTable()
+	store := NewDynamoDBStore(table)
+	ctx := context.Background()
+	day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	// A single batch with more new values in every dimension than a single
+	// update can name
+	var events []ClickEvent
+	for i := 0; i < maxStoredValues+50; i++ {
+		value := fmt.Sprintf("value-%03d", i)
+		events = append(events, ClickEvent{
+			ShortCode: "abc123", Timestamp: day, Country: value, Bot: value, Referrer: "https://" + value + ".example.com/",
+			UTMSource: value, UTMMedium: value, UTMCampaign: value, Device: value, OS: value, Browser: value,
+		})
+	}
+	if err := store.RecordClicks(ctx, events); err != nil {
+		t.Fatalf("RecordClicks() error = %v", err)
+	}
+
+	stats, err := store.GetStats(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	if stats.TotalClicks != maxStoredValues+50 || stats.ClicksByHour[10] != maxStoredValues+50 {
+		t.Errorf("GetStats() = %d clicks with %d at 10h, want %d", stats.TotalClicks, stats.ClicksByHour[10], maxStoredValues+50)
+	}
+	for _, d := range dimensions {
+		counts := d.counts(stats)
+		if len(counts) != maxStoredValues+1 || counts[OtherKey] != 50 {
+			t.Errorf("%s has %d values with %d other, want %d and 50", d.name, len(counts), counts[OtherKey], maxStoredValues+1)
+		}
+	}
+}
+
+func TestDynamoDBStore_ConcurrentNewValues(t *testing.T) {
+	table := newStatsTable()
+	store := NewDynamoDBStore(table)
+	ctx := context.Background()
+	day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	// Another writer adds a source between every read and write of ours
+	other := NewDynamoDBStore(&statsTable{items: table.items, visitors: table.visitors, buckets: table.buckets, ttls: table.ttls})
+	writes := 0
+	table.beforeUpdate = func() {
+		writes++
+		source := fmt.Sprintf("other-%d", writes)
+		if err := other.RecordClicks(ctx, []ClickEvent{{ShortCode: "abc123", Timestamp: day, UTMSource: source}}); err != nil {
+			t.Errorf("RecordClicks() of the other writer error = %v", err)
+		}
+	}
+
+	if err := store.RecordClicks(ctx, []ClickEvent{{ShortCode: "abc123", Timestamp: day, UTMSource: "ours"}}); err != nil {
+		t.Fatalf("RecordClicks() error = %v", err)
+	}
+
+	// The last attempt counts our new source under other
+	counters := table.items["abc123"]
+	if writes != maxCounterAttempts || counters["Source#"+OtherKey] != 1 || counters["Distinct#Source"] != maxCounterAttempts {
+		t.Errorf("after %d attempts counters = %v, want ours under other", writes, counters)
+	}
+	if counters[totalClicksAttribute] != maxCounterAttempts+1 {
+		t.Errorf("TotalClicks = %d, want %d", counters[totalClicksAttribute], maxCounterAttempts+1)
+	}
+}
+
+func TestDynamoDBStore_FailedShortCode(t *testing.T) {
+	table := newStatsTable()
+	table.failStats = "xyz789"
+	store := NewDynamoDBStore(table)
+	ctx := context.Background()
+
+	if err := store.RecordClicks(ctx, testClicks()); err == nil {
+		t.Error("RecordClicks() with a failing short code should fail")
+	}
+
+	// The other short codes and the click events are still recorded
+	stats, err := store.GetStats(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	if stats.TotalClicks != 4 {
+		t.Errorf("TotalClicks = %d, want 4", stats.TotalClicks)
+	}
+	if len(table.events) != 5 {
+		t.Errorf("click events written = %d, want 5", len(table.events))
+	}
+}
+
+func TestTimeSeries_Prune(t *testing.T) {
+	series := NewTimeSeries()
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	series.Add(start, 1)
+	series.Add(start.Add(90*time.Minute), 1)
+	series.Add(start.AddDate(0, 0, 3), 1)
+	series.Prune(Retention{Minute: time.Hour, Hour: 48 * time.Hour})
+
+	if len(series.buckets[Minute]) != 1 {
+		t.Errorf("minute buckets = %v, want only the newest", series.buckets[Minute])
+	}
+	if len(series.buckets[Hour]) != 1 {
+		t.Errorf("hour buckets = %v, want only the newest", series.buckets[Hour])
+	}
+	if len(series.buckets[Day]) != 2 {
+		t.Errorf("day buckets = %v, want both days kept", series.buckets[Day])
+	}
+}
+
+func TestTimeSeriesQuery_Normalize(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		query    TimeSeriesQuery
+		wantFrom time.Time
+		wantTo   time.Time
+		wantErr  error
+	}{
+		{
+			name:     "defaults",
+			query:    TimeSeriesQuery{},
+			wantFrom: time.Date(2024, 4, 30, 10, 0, 0, 0, time.UTC),
+			wantTo:   now,
+		},
+		{
+			name:     "start truncated to its bucket",
+			query:    TimeSeriesQuery{Granularity: Day, From: time.Date(2024, 4, 20, 15, 0, 0, 0, time.UTC)},
+			wantFrom: time.Date(2024, 4, 20, 0, 0, 0, 0, time.UTC),
+			wantTo:   now,
+		},
+		{
+			name:    "unknown granularity",
+			query:   TimeSeriesQuery{Granularity: 9},
+			wantErr: models.ErrInvalidGranularity,
+		},
+		{
+			name:    "empty range",
+			query:   TimeSeriesQuery{From: now, To: now},
+			wantErr: models.ErrInvalidTimeRange,
+		},
+		{
+			name:    "too many points",
+			query:   TimeSeriesQuery{Granularity: Minute, From: now.AddDate(0, 0, -2)},
+			wantErr: models.ErrInvalidTimeRange,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := tt.query
+			err := query.Normalize(now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Normalize() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !query.From.Equal(tt.wantFrom) || !query.To.Equal(tt.wantTo) {
+				t.Errorf("Normalize() range = %v - %v, want %v - %v", query.From, query.To, tt.wantFrom, tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestHashIP(t *testing.T) {
+	hash := HashIP([]byte("salt"), "203.0.113.7")
+	if len(hash) != 2*ipHashSize {
+		t.Errorf("HashIP() = %q, want %d hex characters", hash, 2*ipHashSize)
+	}
+	if HashIP([]byte("salt"), "203.0.113.7") != hash {
+		t.Error("HashIP() is not deterministic")
+	}
+	if HashIP([]byte("other"), "203.0.113.7") == hash {
+		t.Error("HashIP() ignores the salt")
+	}
+	if HashIP([]byte("salt"), "") != "" {
+		t.Error("HashIP() of an empty IP should be empty")
+	}
+}
+
+// blockingStore holds every write until release is closed
+type blockingStore struct {
+	*MemoryStore
+	release chan struct{}
+}
+
+func (s *blockingStore) RecordClicks(ctx context.Context, events []ClickEvent) error {
+	<-s.release
+	return s.MemoryStore.RecordClicks(ctx, events)
+}
+
+// failingStore rejects every write
+type failingStore struct {
+	*MemoryStore
+}
+
+func (s *failingStore) RecordClicks(ctx context.Context, events []ClickEvent) error {
+	return errors.New("table unavailable")
+}
+
+func TestRecorder(t *testing.T) {
+	store := NewMemoryStore()
+	recorder := NewRecorder(store, WithSalt([]byte("salt")), WithFlushInterval(time.Hour))
+	recorder.now = func() time.Time { return time.Date(2024, 5, 1, 10, 0, 0, 0, time.FixedZone("CEST", 2*3600)) }
+
+	// More than a batch, so a write happens before Close
+	for i := 0; i < maxBatchSize+10; i++ {
+		recorder.Record("abc123", Visitor{IP: "203.0.113.7", Country: "US"})
+	}
+	if err := recorder.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	stats, _ := store.GetStats(context.Background(), "abc123")
+	if stats.TotalClicks != maxBatchSize+10 {
+		t.Errorf("TotalClicks = %d, want %d", stats.TotalClicks, maxBatchSize+10)
+	}
+	if stats.ClicksByHour[8] != maxBatchSize+10 {
+		t.Errorf("ClicksByHour = %v, want clicks at 8 UTC", stats.ClicksByHour)
+	}
+
+	// Clicks after Close are dropped instead of panicking
+	recorder.Record("abc123", Visitor{})
+	if recorder.Dropped() != 1 {
+		t.Errorf("Dropped() = %d, want 1", recorder.Dropped())
+	}
+}
+
+func TestRecorder_FlushInterval(t *testing.T) {
+	store := NewMemoryStore()
+	recorder := NewRecorder(store, WithFlushInterval(10*time.Millisecond))
+	defer recorder.Close(context.Background())
+
+	recorder.Record("abc123", Visitor{})
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if stats, _ := store.GetStats(context.Background(), "abc123"); stats.TotalClicks == 1 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("click was not written after the flush interval")
+}
+
+func TestRecorder_FullBuffer(t *testing.T) {
+	store := &blockingStore{MemoryStore: NewMemoryStore(), release: make(chan struct{})}
+	recorder := NewRecorder(store, WithBufferSize(10), WithFlushInterval(time.Hour))
+
+	// The first batch blocks in the store, the buffer then fills up and
+	// Record keeps returning instead of waiting
+	const clicks = maxBatchSize + 50
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < clicks; i++ {
+			recorder.Record("abc123", Visitor{})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Record() blocked on a full buffer")
+	}
+
+	close(store.release)
+	if err := recorder.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	stats, _ := store.GetStats(context.Background(), "abc123")
+	if recorder.Dropped() == 0 {
+		t.Error("Dropped() = 0, want clicks dropped on a full buffer")
+	}
+	if stats.TotalClicks+recorder.Dropped() != clicks {
+		t.Errorf("recorded %d + dropped %d, want %d", stats.TotalClicks, recorder.Dropped(), clicks)
+	}
+}
+
+func TestRecorder_StoreFailure(t *testing.T) {
+	recorder := NewRecorder(&failingStore{MemoryStore: NewMemoryStore()})
+	recorder.Record("abc123", Visitor{})
+	recorder.Record("abc123", Visitor{})
+	if err := recorder.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if recorder.Dropped() != 2 {
+		t.Errorf("Dropped() = %d, want 2", recorder.Dropped())
+	}
+}
+
+// countryMap resolves countries from a fixed map
+type countryMap map[string]string
+
+func (m countryMap) Country(ip string) string {
+	return m[ip]
+}
+
+func TestRecorder_CountryResolver(t *testing.T) {
+	store := NewMemoryStore()
+	recorder := NewRecorder(store, WithCountryResolver(countryMap{"81.2.69.142": "GB"}))
+
+	recorder.Record("abc123", Visitor{IP: "81.2.69.142"})
+	// An edge-provided country wins over the lookup
+	recorder.Record("abc123", Visitor{IP: "81.2.69.142", Country: "IE"})
+	recorder.Record("abc123", Visitor{IP: "8.8.8.8"})
+	if err := recorder.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	stats, _ := store.GetStats(context.Background(), "abc123")
+	expected := map[string]int64{"GB": 1, "IE": 1}
+	if len(stats.ClicksByCountry) != len(expected) {
+		t.Errorf("ClicksByCountry = %v, want %v", stats.ClicksByCountry, expected)
+	}
+	for country, clicks := range expected {
+		if stats.ClicksByCountry[country] != clicks {
+			t.Errorf("ClicksByCountry[%s] = %d, want %d", country, stats.ClicksByCountry[country], clicks)
+		}
+	}
+}
+
+func TestRecorder_Bots(t *testing.T) {
+	store := NewMemoryStore()
+	recorder := NewRecorder(store)
+
+	recorder.Record("abc123", Visitor{IP: "1.1.1.1", UserAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"})
+	recorder.Record("abc123", Visitor{IP: "2.2.2.2", UserAgent: "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"})
+	recorder.Record("abc123", Visitor{IP: "3.3.3.3", UserAgent: "curl/8.4.0"})
+	recorder.Record("abc123", Visitor{IP: "4.4.4.4", UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36"})
+	if err := recorder.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	stats, _ := store.GetStats(context.Background(), "abc123")
+	if stats.TotalClicks != 4 || stats.HumanClicks() != 1 {
+		t.Errorf("TotalClicks = %d with %d human, want 4 with 1 human", stats.TotalClicks, stats.HumanClicks())
+	}
+	if want := map[string]int64{"crawler": 1, "preview": 1, "tool": 1}; !reflect.DeepEqual(stats.BotClicks, want) {
+		t.Errorf("BotClicks = %v, want %v", stats.BotClicks, want)
+	}
+	if visitors := stats.Visitors.All.Estimate(); visitors != 1 {
+		t.Errorf("unique visitors = %d, want 1", visitors)
+	}
+}
+
+func TestRecorder_Dimensions(t *testing.T) {
+	store := NewMemoryStore()
+	recorder := NewRecorder(store)
+
+	recorder.Record("abc123", Visitor{
+		UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+		Referrer:  "https://t.co/abc",
+		Campaign:  Campaign{Source: "twitter", Medium: "social", Name: "launch"},
+	})
+	recorder.Record("abc123", Visitor{UserAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"})
+	if err := recorder.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	stats, _ := store.GetStats(context.Background(), "abc123")
+	breakdowns := map[string][2]map[string]int64{
+		"ClicksByReferrer": {stats.ClicksByReferrer, {"t.co": 1}},
+		"ClicksBySource":   {stats.ClicksBySource, {"twitter": 1}},
+		"ClicksByMedium":   {stats.ClicksByMedium, {"social": 1}},
+		"ClicksByCampaign": {stats.ClicksByCampaign, {"launch": 1}},
+		"ClicksByDevice":   {stats.ClicksByDevice, {"mobile": 1, "bot": 1}},
+		"ClicksByOS":       {stats.ClicksByOS, {"iOS": 1}},
+		"ClicksByBrowser":  {stats.ClicksByBrowser, {"Safari": 1}},
+	}
+	for name, breakdown := range breakdowns {
+		if !reflect.DeepEqual(breakdown[0], breakdown[1]) {
+			t.Errorf("%s = %v, want %v", name, breakdown[0], breakdown[1])
+		}
+	}
+}
+
+func TestTopN(t *testing.T) {
+	counts := map[string]int64{"a": 5, "b": 3, "c": 3, "d": 1, "e": 1}
+
+	tests := []struct {
+		n    int
+		want map[string]int64
+	}{
+		{5, counts},
+		{10, counts},
+		// Ties are broken by value
+		{2, map[string]int64{"a": 5, "b": 3, OtherKey: 5}},
+		{1, map[string]int64{"a": 5, OtherKey: 8}},
+	}
+
+	for _, tt := range tests {
+		if got := TopN(counts, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TopN(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTopLimit(t *testing.T) {
+	tests := []struct {
+		n       int
+		want    int
+		wantErr bool
+	}{
+		{0, DefaultTopN, false},
+		{1, 1, false},
+		{MaxTopN, MaxTopN, false},
+		{MaxTopN + 1, 0, true},
+		{-1, 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := TopLimit(tt.n)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("TopLimit(%d) = %d, %v, want %d, error %v", tt.n, got, err, tt.want, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, models.ErrInvalidTop) {
+			t.Errorf("TopLimit(%d) error = %v, want ErrInvalidTop", tt.n, err)
+		}
+	}
+}
+
+func TestReferrerDomain(t *testing.T) {
+	tests := map[string]string{
+		"https://www.google.com/search?q=go": "google.com",
+		"https://News.YCombinator.com/item":  "news.ycombinator.com",
+		"http://localhost:8080/page":         "localhost",
+		"android-app://com.slack/":           "com.slack",
+		"":                                   "",
+		"not a url":                          "",
+		"https://[::1":                       "",
+	}
+
+	for referrer, want := range tests {
+		if got := ReferrerDomain(referrer); got != want {
+			t.Errorf("ReferrerDomain(%q) = %q, want %q", referrer, got, want)
+		}
+	}
+}
+
+func TestCampaignFromQuery(t *testing.T) {
+	query := url.Values{
+		"utm_source":   {" newsletter "},
+		"utm_medium":   {"email", "ignored"},
+		"utm_campaign": {strings.Repeat("x", 2*maxDimensionValueLength)},
+	}
+
+	got := CampaignFromQuery(query)
+	want := Campaign{Source: "newsletter", Medium: "email", Name: strings.Repeat("x", maxDimensionValueLength)}
+	if got != want {
+		t.Errorf("CampaignFromQuery() = %+v, want %+v", got, want)
+	}
+}
+
+func TestHyperLogLog_Estimate(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 1000, 10_000, 100_000} {
+		h := NewHyperLogLog()
+		for i := 0; i < n; i++ {
+			h.AddString(strconv.Itoa(i))
+			// Duplicates never change the estimate
+			h.AddString(strconv.Itoa(i))
+		}
+
+		// Three standard errors
+		estimate := h.Estimate()
+		tolerance := 3 * 1.04 / math.Sqrt(hllRegisters) * float64(n)
+		if math.Abs(float64(estimate-int64(n))) > tolerance {
+			t.Errorf("Estimate() of %d values = %d, want within %.0f", n, estimate, tolerance)
+		}
+	}
+}
+
+func TestHyperLogLog_Merge(t *testing.T) {
+	// Two shards with overlapping visitors
+	a, b, union := NewHyperLogLog(), NewHyperLogLog(), NewHyperLogLog()
+	for i := 0; i < 3000; i++ {
+		a.AddString(strconv.Itoa(i))
+		union.AddString(strconv.Itoa(i))
+	}
+	for i := 2000; i < 5000; i++ {
+		b.AddString(strconv.Itoa(i))
+		union.AddString(strconv.Itoa(i))
+	}
+
+	a.Merge(b)
+	if a.Estimate() != union.Estimate() {
+		t.Errorf("merged Estimate() = %d, want %d as if added directly", a.Estimate(), union.Estimate())
+	}
+}
+
+func TestHyperLogLog_MarshalBinary(t *testing.T) {
+	for _, n := range []int{0, 10, hllSparseLimit + 1, 50_000} {
+		h := NewHyperLogLog()
+		for i := 0; i < n; i++ {
+			h.AddString(strconv.Itoa(i))
+		}
+
+		data, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary() error = %v", err)
+		}
+		// Memory is bounded by the dense form
+		if len(data) > 3+hllRegisters {
+			t.Errorf("MarshalBinary() of %d values is %d bytes", n, len(data))
+		}
+
+		decoded := NewHyperLogLog()
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary() error = %v", err)
+		}
+		if decoded.Estimate() != h.Estimate() {
+			t.Errorf("decoded Estimate() = %d, want %d", decoded.Estimate(), h.Estimate())
+		}
+	}
+
+	for _, data := range [][]byte{nil, {hllVersion, 14, hllFormatSparse}, {hllVersion, hllPrecision, hllFormatDense, 1}} {
+		if err := NewHyperLogLog().UnmarshalBinary(data); err == nil {
+			t.Errorf("UnmarshalBinary(%v) should fail", data)
+		}
+	}
+}
+
+func TestVisitorSketches(t *testing.T) {
+	v := NewVisitorSketches()
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for day := 0; day < 10; day++ {
+		// Visitor "regular" comes every day, visitor "dayN" only once
+		v.Add(start.AddDate(0, 0, day), "regular")
+		v.Add(start.AddDate(0, 0, day), fmt.Sprintf("day%d", day))
+	}
+
+	if got := v.All.Estimate(); got != 11 {
+		t.Errorf("all-time visitors = %d, want 11", got)
+	}
+	last := start.AddDate(0, 0, 9)
+	if got := v.Since(last); got != 2 {
+		t.Errorf("visitors of the last day = %d, want 2", got)
+	}
+	if got := v.Since(last.AddDate(0, 0, -6)); got != 8 {
+		t.Errorf("visitors of the last week = %d, want 8", got)
+	}
+
+	v.Prune(last.AddDate(0, 0, -1))
+	if len(v.Days) != 2 || v.All.Estimate() != 11 {
+		t.Errorf("after Prune() days = %d and all-time = %d, want 2 and 11", len(v.Days), v.All.Estimate())
+	}
+}
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker(WithHistorySize(4), WithSubscriptionBuffer(2))
+	onlyABC := func(shortCode string) bool { return shortCode == "abc123" }
+
+	sub, err := broker.Subscribe(onlyABC, "")
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	broker.Publish(ClickEvent{ShortCode: "xyz789"})
+	broker.Publish(ClickEvent{ShortCode: "abc123"})
+	first := <-sub.Clicks()
+	if first.Event.ShortCode != "abc123" {
+		t.Errorf("received %+v, want only abc123 clicks", first)
+	}
+
+	// A subscriber that falls behind its buffer is disconnected
+	for i := 0; i < 3; i++ {
+		broker.Publish(ClickEvent{ShortCode: "abc123"})
+	}
+	var received int
+	for range sub.Clicks() {
+		received++
+	}
+	if received != 2 || !errors.Is(sub.Err(), models.ErrSubscriberLagging) {
+		t.Errorf("received %d clicks before error %v, want 2 and ErrSubscriberLagging", received, sub.Err())
+	}
+
+	// It can resume after the last click it received
+	resumed, err := broker.Subscribe(onlyABC, first.ID)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	resumed.Close()
+	var replayed int
+	for range resumed.Clicks() {
+		replayed++
+	}
+	if replayed != 3 || resumed.Err() != nil {
+		t.Errorf("replayed %d clicks with error %v, want 3 and no error", replayed, resumed.Err())
+	}
+
+	// Until the clicks after it leave the history
+	broker.Publish(ClickEvent{ShortCode: "abc123"})
+	broker.Publish(ClickEvent{ShortCode: "abc123"})
+	if _, err := broker.Subscribe(onlyABC, first.ID); !errors.Is(err, models.ErrResumeExpired) {
+		t.Errorf("Subscribe() after an evicted click error = %v, want ErrResumeExpired", err)
+	}
+	if _, err := broker.Subscribe(onlyABC, "0-1"); !errors.Is(err, models.ErrResumeExpired) {
+		t.Errorf("Subscribe() after a click of another broker error = %v, want ErrResumeExpired", err)
+	}
+	if _, err := broker.Subscribe(onlyABC, "abc"); !errors.Is(err, models.ErrInvalidWatch) {
+		t.Errorf("Subscribe() after a malformed ID error = %v, want ErrInvalidWatch", err)
+	}
+}
+
+// fakeStream is a DynamoDB stream of url-clicks items. Iterators are a shard
+// ID and the position of the next record in it.
+type fakeStream struct {
+	shards []*fakeShard
+	// expire fails the next read with an expired iterator
+	expire bool
+}
+
+type fakeShard struct {
+	id      string
+	parent  string
+	closed  bool
+	records []streamtypes.Record
+}
+
+func (f *fakeStream) shard(id string) *fakeShard {
+	for _, shard := range f.shards {
+		if shard.id == id {
+			return shard
+		}
+	}
+	return nil
+}
+
+// insert appends an INSERT record of a click to a shard, with the sequence
+// number of its position
+func (f *fakeStream) insert(id string, event ClickEvent) {
+	data, _ := json.Marshal(event)
+	f.record(id, streamtypes.OperationTypeInsert, map[string]streamtypes.AttributeValue{
+		"Event": &streamtypes.AttributeValueMemberS{Value: string(data)},
+	})
+}
+
+func (f *fakeStream) record(id string, name streamtypes.OperationType, image map[string]streamtypes.AttributeValue) {
+	shard := f.shard(id)
+	shard.records = append(shard.records, streamtypes.Record{
+		EventName: name,
+		Dynamodb: &streamtypes.StreamRecord{
+			SequenceNumber: aws.String(strconv.Itoa(len(shard.records))),
+			NewImage:       image,
+		},
+	})
+}
+
+func (f *fakeStream) DescribeStream(ctx context.Context, params *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error) {
+	// One shard per page
+	next := 0
+	if params.ExclusiveStartShardId != nil {
+		for i, shard := range f.shards {
+			if shard.id == *params.ExclusiveStartShardId {
+				next = i + 1
+			}
+		}
+	}
+	shard := f.shards[next]
+	description := &streamtypes.StreamDescription{Shards: []streamtypes.Shard{{
+		ShardId:             aws.String(shard.id),
+		SequenceNumberRange: &streamtypes.SequenceNumberRange{},
+	}}}
+	if shard.parent != "" {
+		description.Shards[0].ParentShardId = aws.String(shard.parent)
+	}
+	if shard.closed {
+		description.Shards[0].SequenceNumberRange.EndingSequenceNumber = aws.String(strconv.Itoa(len(shard.records) - 1))
+	}
+	if next < len(f.shards)-1 {
+		description.LastEvaluatedShardId = aws.String(shard.id)
+	}
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: description}, nil
+}
+
+func (f *fakeStream) GetShardIterator(ctx context.Context, params *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	shard := f.shard(*params.ShardId)
+	var position int
+	switch params.ShardIteratorType {
+	case streamtypes.ShardIteratorTypeLatest:
+		position = len(shard.records)
+	case streamtypes.ShardIteratorTypeAfterSequenceNumber:
+		sequence, _ := strconv.Atoi(*params.SequenceNumber)
+		position = sequence + 1
+	}
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String(fmt.Sprintf("%s/%d", shard.id, position))}, nil
+}
+
+func (f *fakeStream) GetRecords(ctx context.Context, params *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error) {
+	if f.expire {
+		f.expire = false
+		return nil, &streamtypes.ExpiredIteratorException{}
+	}
+	id, positionText, _ := strings.Cut(*params.ShardIterator, "/")
+	position, _ := strconv.Atoi(positionText)
+	shard := f.shard(id)
+
+	result := &dynamodbstreams.GetRecordsOutput{Records: shard.records[position:]}
+	if !shard.closed {
+		result.NextShardIterator = aws.String(fmt.Sprintf("%s/%d", id, len(shard.records)))
+	}
+	return result, nil
+}
+
+func TestClickStream(t *testing.T) {
+	ctx := context.Background()
+	stream := &fakeStream{shards: []*fakeShard{
+		{id: "old", closed: true},
+		{id: "first"},
+	}}
+	stream.insert("old", ClickEvent{ShortCode: "past"})
+	stream.insert("first", ClickEvent{ShortCode: "past"})
+
+	broker := NewBroker()
+	sub, err := broker.Subscribe(func(string) bool { return true }, "")
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	defer sub.Close()
+	received := func() []string {
+		var codes []string
+		for {
+			select {
+			case click := <-sub.Clicks():
+				codes = append(codes, click.Event.ShortCode)
+			default:
+				return codes
+			}
+		}
+	}
+
+	// Clicks written before the stream was opened are not published
+	clicks := newClickStream(stream, "arn", broker)
+	if err := clicks.refresh(ctx, streamtypes.ShardIteratorTypeLatest); err != nil {
+		t.Fatalf("refresh() error = %v", err)
+	}
+	clicks.poll(ctx)
+	if got := received(); got != nil {
+		t.Errorf("published %v before any new click", got)
+	}
+
+	// Only inserted items are clicks, not updates or TTL deletions
+	stream.insert("first", ClickEvent{ShortCode: "abc123"})
+	stream.record("first", streamtypes.OperationTypeRemove, nil)
+	stream.record("first", streamtypes.OperationTypeInsert, map[string]streamtypes.AttributeValue{})
+	clicks.poll(ctx)
+	if got := received(); !reflect.DeepEqual(got, []string{"abc123"}) {
+		t.Errorf("published %v, want [abc123]", got)
+	}
+
+	// Expired iterators continue after the last record read
+	stream.expire = true
+	stream.insert("first", ClickEvent{ShortCode: "xyz789"})
+	clicks.poll(ctx)
+	if got := received(); !reflect.DeepEqual(got, []string{"xyz789"}) {
+		t.Errorf("published %v after an expired iterator, want [xyz789]", got)
+	}
+
+	// A split shard is read to its end before its child is read from its
+	// start
+	stream.insert("first", ClickEvent{ShortCode: "parent"})
+	stream.shard("first").closed = true
+	stream.shards = append(stream.shards, &fakeShard{id: "second", parent: "first"})
+	stream.insert("second", ClickEvent{ShortCode: "child"})
+	if err := clicks.refresh(ctx, streamtypes.ShardIteratorTypeTrimHorizon); err != nil {
+		t.Fatalf("refresh() error = %v", err)
+	}
+	clicks.poll(ctx)
+	clicks.poll(ctx)
+	if got := received(); !reflect.DeepEqual(got, []string{"parent", "child"}) {
+		t.Errorf("published %v, want [parent child]", got)
+	}
+	if _, ok := clicks.shards["first"]; ok {
+		t.Error("closed shard is still read")
+	}
+}
+
+func TestRecorder_Broker(t *testing.T) {
+	broker := NewBroker()
+	sub, _ := broker.Subscribe(func(string) bool { return true }, "")
+	recorder := NewRecorder(NewMemoryStore(), WithBroker(broker), WithCountryResolver(countryMap{"81.2.69.142": "GB"}))
+	recorder.Record("abc123", Visitor{IP: "81.2.69.142"})
+	recorder.Close(context.Background())
+
+	select {
+	case click := <-sub.Clicks():
+		if click.Event.ShortCode != "abc123" || click.Event.Country != "GB" {
+			t.Errorf("published %+v, want the click with its resolved country", click.Event)
+		}
+	default:
+		t.Error("click was not published")
+	}
+}
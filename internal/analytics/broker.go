@@ -0,0 +1,201 @@
+package analytics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+const (
+	defaultHistorySize        = 4096
+	defaultSubscriptionBuffer = 256
+)
+
+// LiveClick is a click published to subscribers. ID identifies it to resume
+// a subscription after it.
+type LiveClick struct {
+	ID    string
+	Event ClickEvent
+}
+
+// Broker fans clicks out to live subscribers as they are recorded. It keeps
+// the most recent clicks so a subscriber that disconnects can resume after
+// the last one it saw. Publishing never blocks: a subscriber that does not
+// keep up is disconnected with models.ErrSubscriberLagging and can resume
+// from its last click.
+//
+// Click IDs are only meaningful to the broker that published them; they
+// combine a per-broker epoch with a sequence number so IDs of a previous
+// process are recognized as expired.
+type Broker struct {
+	historySize int
+	bufferSize  int
+	epoch       string
+
+	mu      sync.Mutex
+	next    uint64
+	history []LiveClick
+	subs    map[*Subscription]struct{}
+}
+
+// BrokerOption configures a Broker
+type BrokerOption func(*Broker)
+
+// WithHistorySize sets how many recent clicks are kept for resuming
+func WithHistorySize(size int) BrokerOption {
+	return func(b *Broker) {
+		if size > 0 {
+			b.historySize = size
+		}
+	}
+}
+
+// WithSubscriptionBuffer sets how many clicks a subscriber may fall behind
+// before it is disconnected
+func WithSubscriptionBuffer(size int) BrokerOption {
+	return func(b *Broker) {
+		if size > 0 {
+			b.bufferSize = size
+		}
+	}
+}
+
+func NewBroker(opts ...BrokerOption) *Broker {
+	b := &Broker{
+		historySize: defaultHistorySize,
+		bufferSize:  defaultSubscriptionBuffer,
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		next:        1,
+		subs:        make(map[*Subscription]struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.history = make([]LiveClick, b.historySize)
+	return b
+}
+
+// Publish assigns the next ID to event and sends it to the matching
+// subscribers
+func (b *Broker) Publish(event ClickEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	click := LiveClick{ID: b.id(b.next), Event: event}
+	b.history[b.next%uint64(b.historySize)] = click
+	b.next++
+
+	for sub := range b.subs {
+		if !sub.match(event.ShortCode) {
+			continue
+		}
+		select {
+		case sub.clicks <- click:
+		default:
+			b.remove(sub, models.ErrSubscriberLagging)
+		}
+	}
+}
+
+// Subscribe starts a subscription to the clicks whose short code matches.
+// With a resumeAfter ID, the retained clicks published after it are sent
+// first; models.ErrResumeExpired is returned when they are no longer
+// retained.
+func (b *Broker) Subscribe(match func(shortCode string) bool, resumeAfter string) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var backlog []LiveClick
+	if resumeAfter != "" {
+		seq, err := b.parseID(resumeAfter)
+		if err != nil {
+			return nil, err
+		}
+		for next := seq + 1; next < b.next; next++ {
+			click := b.history[next%uint64(b.historySize)]
+			if match(click.Event.ShortCode) {
+				backlog = append(backlog, click)
+			}
+		}
+	}
+
+	sub := &Subscription{
+		broker: b,
+		match:  match,
+		clicks: make(chan LiveClick, len(backlog)+b.bufferSize),
+	}
+	for _, click := range backlog {
+		sub.clicks <- click
+	}
+	b.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// id formats the ID of sequence number seq
+func (b *Broker) id(seq uint64) string {
+	return b.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// parseID returns the sequence number of a click ID that can be resumed
+// after, the caller must hold the lock
+func (b *Broker) parseID(id string) (uint64, error) {
+	epoch, seqText, ok := strings.Cut(id, "-")
+	seq, err := strconv.ParseUint(seqText, 10, 64)
+	if !ok || err != nil || seq == 0 {
+		return 0, fmt.Errorf("%w: malformed event ID %q", models.ErrInvalidWatch, id)
+	}
+	if epoch != b.epoch {
+		return 0, fmt.Errorf("%w: event %s was published before a restart", models.ErrResumeExpired, id)
+	}
+	if seq >= b.next {
+		return 0, fmt.Errorf("%w: unknown event ID %q", models.ErrInvalidWatch, id)
+	}
+	// Clicks after seq must all still be in the history
+	if b.next-seq-1 > uint64(b.historySize) {
+		return 0, fmt.Errorf("%w: event %s is too old", models.ErrResumeExpired, id)
+	}
+	return seq, nil
+}
+
+// remove ends a subscription with err, the caller must hold the lock
+func (b *Broker) remove(sub *Subscription, err error) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	sub.err = err
+	close(sub.clicks)
+}
+
+// Subscription receives the clicks published to a Broker
+type Subscription struct {
+	broker *Broker
+	match  func(shortCode string) bool
+	clicks chan LiveClick
+	// err is why the broker ended the subscription, set before clicks is
+	// closed
+	err error
+}
+
+// Clicks returns the channel of clicks, which is closed when the
+// subscription ends
+func (s *Subscription) Clicks() <-chan LiveClick {
+	return s.clicks
+}
+
+// Err returns why the subscription ended once Clicks is closed, nil when it
+// was closed by the subscriber
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.remove(s, nil)
+}
@@ -0,0 +1,377 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	statsTableName = "url-stats"
+
+	totalClicksAttribute = "TotalClicks"
+	// Per-dimension counters are stored as top-level attributes named
+	// dimension#value, e.g. "Country#US" or "Hour#13", because ADD can create
+	// those without the item existing first
+	dimensionSeparator = "#"
+	hourPrefix         = "Hour#"
+	// distinctPrefix names the count of values with counters of a dimension
+	distinctPrefix = "Distinct#"
+	// maxUpdateCounters bounds the counters read and written per request,
+	// keeping their expressions well below the 4 KB DynamoDB limit
+	maxUpdateCounters = 100
+	// maxCounterAttempts bounds the updates of a short code whose distinct
+	// counts changed concurrently
+	maxCounterAttempts = 3
+)
+
+// StatsTableAPI is the subset of the DynamoDB client used by DynamoDBStore
+type StatsTableAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+}
+
+// DynamoDBStore keeps one item of counters per short code in the url-stats
+// table, the visitor sketches of each short code in url-visitors, one item
+// per time window, and the time series in url-timeseries, one item per
+// bucket. A batch is aggregated in memory first, so each short code in it
+// costs a GetItem of its known values and a single atomic UpdateItem, plus
+// one write per window and bucket.
+type DynamoDBStore struct {
+	client         StatsTableAPI
+	retention      Retention
+	eventRetention time.Duration
+}
+
+func NewDynamoDBStore(client StatsTableAPI, opts ...StoreOption) *DynamoDBStore {
+	o := newStoreOptions(opts)
+	return &DynamoDBStore{
+		client:         client,
+		retention:      o.retention,
+		eventRetention: o.eventRetention,
+	}
+}
+
+func (s *DynamoDBStore) RecordClicks(ctx context.Context, events []ClickEvent) error {
+	batch := make(map[string]*Stats)
+	series := make(map[string]*TimeSeries)
+	for _, event := range events {
+		stats, ok := batch[event.ShortCode]
+		if !ok {
+			stats = NewStats()
+			batch[event.ShortCode] = stats
+			series[event.ShortCode] = NewTimeSeries()
+		}
+		stats.Add(event)
+		series[event.ShortCode].Add(event.Timestamp, 1)
+	}
+
+	// A short code that fails does not hold back the others
+	var errs []error
+	for shortCode, stats := range batch {
+		if err := s.record(ctx, shortCode, stats, series[shortCode]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := s.recordEvents(ctx, events); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// record writes the counters, visitors and time series of a short code
+func (s *DynamoDBStore) record(ctx context.Context, shortCode string, stats *Stats, series *TimeSeries) error {
+	if err := s.add(ctx, shortCode, stats); err != nil {
+		return err
+	}
+	if err := s.recordVisitors(ctx, shortCode, stats.Visitors); err != nil {
+		return err
+	}
+	return s.recordTimeSeries(ctx, shortCode, series)
+}
+
+// add increments the counters of a short code by stats. Each dimension has
+// counters of its own for at most maxStoredValues values, counted by its
+// Distinct# attribute; clicks of further values are added to OtherKey, so
+// client-supplied values such as UTM parameters cannot grow the item past
+// the DynamoDB item size limit. New values are only added while the
+// Distinct# counts are the ones read, and the last attempt adds none.
+//
+// Counters are written at most maxUpdateCounters at a time, those with the
+// most clicks first, so the expressions stay within the DynamoDB limit.
+func (s *DynamoDBStore) add(ctx context.Context, shortCode string, stats *Stats) error {
+	pending := statsCounters(stats)
+	for failures := 0; len(pending) > 0; {
+		next := nextCounters(pending)
+		stored, err := s.storedCounters(ctx, shortCode, next)
+		if err != nil {
+			return err
+		}
+		counters, distinct := counterUpdate(pending, next, stored, failures+1 < maxCounterAttempts)
+
+		err = s.addCounters(ctx, shortCode, counters, distinct)
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			failures++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update stats of %s: %w", shortCode, err)
+		}
+		for _, attribute := range next {
+			delete(pending, attribute)
+		}
+		failures = 0
+	}
+	return nil
+}
+
+// statsCounters returns the clicks of stats by counter attribute
+func statsCounters(stats *Stats) map[string]int64 {
+	counters := map[string]int64{totalClicksAttribute: stats.TotalClicks}
+	for _, d := range dimensions {
+		for value, clicks := range d.counts(stats) {
+			counters[d.name+dimensionSeparator+value] = clicks
+		}
+	}
+	for hour, clicks := range stats.ClicksByHour {
+		counters[hourPrefix+strconv.Itoa(hour)] = clicks
+	}
+	return counters
+}
+
+// nextCounters returns the next maxUpdateCounters attributes of pending to
+// write, those with the most clicks first
+func nextCounters(pending map[string]int64) []string {
+	attributes := make([]string, 0, len(pending))
+	for attribute := range pending {
+		attributes = append(attributes, attribute)
+	}
+	sort.Slice(attributes, func(i, j int) bool {
+		if pending[attributes[i]] != pending[attributes[j]] {
+			return pending[attributes[i]] > pending[attributes[j]]
+		}
+		return attributes[i] < attributes[j]
+	})
+	if len(attributes) > maxUpdateCounters {
+		attributes = attributes[:maxUpdateCounters]
+	}
+	return attributes
+}
+
+// dimensionOf returns the dimension and value of a counter attribute, false
+// for the total and hourly counters
+func dimensionOf(attribute string) (string, string, bool) {
+	name, value, ok := strings.Cut(attribute, dimensionSeparator)
+	if !ok || name+dimensionSeparator == hourPrefix {
+		return "", "", false
+	}
+	return name, value, true
+}
+
+// storedCounters reads the Distinct# counts of a short code and which of the
+// dimension counters of attributes already exist
+func (s *DynamoDBStore) storedCounters(ctx context.Context, shortCode string, attributes []string) (map[string]types.AttributeValue, error) {
+	var projected []string
+	seen := make(map[string]bool)
+	for _, attribute := range attributes {
+		name, _, ok := dimensionOf(attribute)
+		if !ok {
+			continue
+		}
+		if !seen[name] {
+			seen[name] = true
+			projected = append(projected, distinctPrefix+name)
+		}
+		projected = append(projected, attribute)
+	}
+	if len(projected) == 0 {
+		return nil, nil
+	}
+
+	names := make(map[string]string, len(projected))
+	projection := make([]string, len(projected))
+	for i, attribute := range projected {
+		projection[i] = fmt.Sprintf("#p%d", i)
+		names[projection[i]] = attribute
+	}
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(statsTableName),
+		Key: map[string]types.AttributeValue{
+			"ShortCode": &types.AttributeValueMemberS{Value: shortCode},
+		},
+		ProjectionExpression:     aws.String(strings.Join(projection, ", ")),
+		ExpressionAttributeNames: names,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats of %s: %w", shortCode, err)
+	}
+	return result.Item, nil
+}
+
+// distinctCount is how many values of a dimension had counters when read,
+// and how many an update adds
+type distinctCount struct {
+	had, added int64
+}
+
+// counterUpdate returns the counters to add for the attributes of pending
+// given the stored ones, and the distinct counts of the dimensions gaining
+// values. Without room, the values that have no counter yet are all counted
+// under OtherKey.
+func counterUpdate(pending map[string]int64, attributes []string, stored map[string]types.AttributeValue, room bool) (map[string]int64, map[string]distinctCount) {
+	counters := make(map[string]int64, len(attributes))
+	// fresh holds the values without counters by dimension, in the order of
+	// attributes so those with the most clicks get the remaining counters
+	fresh := make(map[string][]string)
+	for _, attribute := range attributes {
+		name, value, ok := dimensionOf(attribute)
+		if _, exists := stored[attribute]; !ok || exists || value == OtherKey {
+			counters[attribute] += pending[attribute]
+			continue
+		}
+		fresh[name] = append(fresh[name], attribute)
+	}
+
+	distinct := make(map[string]distinctCount)
+	for name, values := range fresh {
+		count := distinctCount{had: storedNumber(stored[distinctPrefix+name])}
+		for _, attribute := range values {
+			if room && count.had+count.added < maxStoredValues {
+				counters[attribute] = pending[attribute]
+				count.added++
+			} else {
+				counters[name+dimensionSeparator+OtherKey] += pending[attribute]
+			}
+		}
+		if count.added > 0 {
+			distinct[name] = count
+		}
+	}
+	return counters, distinct
+}
+
+// storedNumber returns the value of a number attribute, zero when missing
+func storedNumber(av types.AttributeValue) int64 {
+	n, ok := av.(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+	value, _ := strconv.ParseInt(n.Value, 10, 64)
+	return value
+}
+
+// addCounters adds counters to the item of a short code along with the
+// distinct counts, on condition that those are unchanged
+func (s *DynamoDBStore) addCounters(ctx context.Context, shortCode string, counters map[string]int64, distinct map[string]distinctCount) error {
+	// Sort the attributes so expressions are stable
+	attributes := make([]string, 0, len(counters))
+	for attribute := range counters {
+		attributes = append(attributes, attribute)
+	}
+	sort.Strings(attributes)
+
+	names := make(map[string]string, len(attributes))
+	values := make(map[string]types.AttributeValue, len(attributes))
+	adds := make([]string, 0, len(attributes))
+	for i, attribute := range attributes {
+		name, value := fmt.Sprintf("#a%d", i), fmt.Sprintf(":v%d", i)
+		names[name] = attribute
+		values[value] = &types.AttributeValueMemberN{Value: strconv.FormatInt(counters[attribute], 10)}
+		adds = append(adds, name+" "+value)
+	}
+
+	var conditions []string
+	for i, d := range dimensions {
+		count, ok := distinct[d.name]
+		if !ok {
+			continue
+		}
+		name, had, added := fmt.Sprintf("#d%d", i), fmt.Sprintf(":h%d", i), fmt.Sprintf(":n%d", i)
+		names[name] = distinctPrefix + d.name
+		values[had] = &types.AttributeValueMemberN{Value: strconv.FormatInt(count.had, 10)}
+		values[added] = &types.AttributeValueMemberN{Value: strconv.FormatInt(count.added, 10)}
+		adds = append(adds, name+" "+added)
+		conditions = append(conditions, fmt.Sprintf("(attribute_not_exists(%s) OR %s = %s)", name, name, had))
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(statsTableName),
+		Key: map[string]types.AttributeValue{
+			"ShortCode": &types.AttributeValueMemberS{Value: shortCode},
+		},
+		UpdateExpression:          aws.String("ADD " + strings.Join(adds, ", ")),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}
+	if len(conditions) > 0 {
+		input.ConditionExpression = aws.String(strings.Join(conditions, " AND "))
+	}
+	_, err := s.client.UpdateItem(ctx, input)
+	return err
+}
+
+func (s *DynamoDBStore) GetStats(ctx context.Context, shortCode string) (*Stats, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(statsTableName),
+		Key: map[string]types.AttributeValue{
+			"ShortCode": &types.AttributeValueMemberS{Value: shortCode},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats of %s: %w", shortCode, err)
+	}
+
+	stats := NewStats()
+	for attribute, av := range result.Item {
+		n, ok := av.(*types.AttributeValueMemberN)
+		if !ok {
+			continue
+		}
+		clicks, err := strconv.ParseInt(n.Value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s of %s: %w", attribute, shortCode, err)
+		}
+
+		if attribute == totalClicksAttribute {
+			stats.TotalClicks = clicks
+			continue
+		}
+		name, value, ok := strings.Cut(attribute, dimensionSeparator)
+		if !ok {
+			continue
+		}
+		if name+dimensionSeparator == hourPrefix {
+			if hour, err := strconv.Atoi(value); err == nil {
+				stats.ClicksByHour[hour] = clicks
+			}
+			continue
+		}
+		for _, d := range dimensions {
+			if d.name == name {
+				d.counts(stats)[value] = clicks
+			}
+		}
+	}
+
+	if stats.Visitors, err = s.getVisitors(ctx, shortCode); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (s *DynamoDBStore) Close() error {
+	return nil
+}
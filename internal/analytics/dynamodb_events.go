@@ -0,0 +1,192 @@
+package analytics
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	clicksTableName = "url-clicks"
+
+	// eventKeyFormat has a fixed width so event keys sort chronologically
+	eventKeyFormat = "2006-01-02T15:04:05.000000000Z"
+	// maxBatchWriteItems is the most items BatchWriteItem accepts at once
+	maxBatchWriteItems = 25
+	// maxBatchWriteAttempts bounds the retries of unprocessed items
+	maxBatchWriteAttempts = 5
+)
+
+// eventKey returns the sort key of an event at t. A random suffix keeps
+// clicks of the same instant apart; bare prefixes bound key ranges.
+func eventKey(t time.Time, suffix string) string {
+	key := t.UTC().Format(eventKeyFormat)
+	if suffix != "" {
+		key += "#" + suffix
+	}
+	return key
+}
+
+// recordEvents keeps the raw events of a batch in the url-clicks table, one
+// item per event holding it as JSON. Items expire through native TTL once out
+// of retention.
+func (s *DynamoDBStore) recordEvents(ctx context.Context, events []ClickEvent) error {
+	requests := make([]types.WriteRequest, 0, len(events))
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal click event: %w", err)
+		}
+		suffix := make([]byte, 8)
+		if _, err := rand.Read(suffix); err != nil {
+			return fmt.Errorf("failed to generate event key: %w", err)
+		}
+
+		item := map[string]types.AttributeValue{
+			"ShortCode": &types.AttributeValueMemberS{Value: event.ShortCode},
+			"EventKey":  &types.AttributeValueMemberS{Value: eventKey(event.Timestamp, hex.EncodeToString(suffix))},
+			"Event":     &types.AttributeValueMemberS{Value: string(data)},
+		}
+		if s.eventRetention > 0 {
+			item["TTL"] = &types.AttributeValueMemberN{
+				Value: strconv.FormatInt(event.Timestamp.Add(s.eventRetention).Unix(), 10),
+			}
+		}
+		requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+
+	for start := 0; start < len(requests); start += maxBatchWriteItems {
+		end := min(start+maxBatchWriteItems, len(requests))
+		if err := s.batchWrite(ctx, requests[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchWrite writes up to maxBatchWriteItems events, retrying the items
+// DynamoDB leaves unprocessed with exponential backoff
+func (s *DynamoDBStore) batchWrite(ctx context.Context, requests []types.WriteRequest) error {
+	backoff := 50 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		result, err := s.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{clicksTableName: requests},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write click events: %w", err)
+		}
+
+		requests = result.UnprocessedItems[clicksTableName]
+		if len(requests) == 0 {
+			return nil
+		}
+		if attempt == maxBatchWriteAttempts {
+			return fmt.Errorf("failed to write %d click events after %d attempts", len(requests), attempt)
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// eventPage reads the page of events after startKey, returning the key to
+// continue from or nil after the last page
+type eventPage func(startKey map[string]types.AttributeValue) (items []map[string]types.AttributeValue, lastKey map[string]types.AttributeValue, err error)
+
+// ExportClicks queries the events of a single short code in key order, or
+// scans the whole table page by page when query has no short code. The table
+// has no index by time, so the time range of a scan is only a filter: every
+// item is read and paid for whatever the range.
+func (s *DynamoDBStore) ExportClicks(ctx context.Context, query ExportQuery, fn func(ClickEvent) error) error {
+	values := make(map[string]types.AttributeValue)
+	var keyRange string
+	switch {
+	case !query.From.IsZero() && !query.To.IsZero():
+		keyRange = "EventKey BETWEEN :from AND :to"
+	case !query.From.IsZero():
+		keyRange = "EventKey >= :from"
+	case !query.To.IsZero():
+		keyRange = "EventKey < :to"
+	}
+	if !query.From.IsZero() {
+		values[":from"] = &types.AttributeValueMemberS{Value: eventKey(query.From, "")}
+	}
+	// Stored keys at To sort after the bare prefix, so BETWEEN excludes them
+	if !query.To.IsZero() {
+		values[":to"] = &types.AttributeValueMemberS{Value: eventKey(query.To, "")}
+	}
+
+	var page eventPage
+	if query.ShortCode != "" {
+		condition := "ShortCode = :shortCode"
+		if keyRange != "" {
+			condition += " AND " + keyRange
+		}
+		values[":shortCode"] = &types.AttributeValueMemberS{Value: query.ShortCode}
+		page = func(startKey map[string]types.AttributeValue) ([]map[string]types.AttributeValue, map[string]types.AttributeValue, error) {
+			result, err := s.client.Query(ctx, &dynamodb.QueryInput{
+				TableName:                 aws.String(clicksTableName),
+				KeyConditionExpression:    aws.String(condition),
+				ExpressionAttributeValues: values,
+				ExclusiveStartKey:         startKey,
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to query click events of %s: %w", query.ShortCode, err)
+			}
+			return result.Items, result.LastEvaluatedKey, nil
+		}
+	} else {
+		input := &dynamodb.ScanInput{TableName: aws.String(clicksTableName)}
+		if keyRange != "" {
+			input.FilterExpression = aws.String(keyRange)
+			input.ExpressionAttributeValues = values
+		}
+		page = func(startKey map[string]types.AttributeValue) ([]map[string]types.AttributeValue, map[string]types.AttributeValue, error) {
+			input.ExclusiveStartKey = startKey
+			result, err := s.client.Scan(ctx, input)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to scan click events: %w", err)
+			}
+			return result.Items, result.LastEvaluatedKey, nil
+		}
+	}
+
+	var startKey map[string]types.AttributeValue
+	for {
+		items, lastKey, err := page(startKey)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			data, ok := item["Event"].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			var event ClickEvent
+			if err := json.Unmarshal([]byte(data.Value), &event); err != nil {
+				return fmt.Errorf("failed to unmarshal click event: %w", err)
+			}
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+
+		if len(lastKey) == 0 {
+			return nil
+		}
+		startKey = lastKey
+	}
+}
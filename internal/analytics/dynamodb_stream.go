@@ -0,0 +1,292 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+const (
+	defaultStreamPollInterval = time.Second
+	// shardRefreshInterval is how often the stream is described to find the
+	// shards DynamoDB opens as it splits and rotates them
+	shardRefreshInterval = 30 * time.Second
+	// maxReadsPerPoll bounds the GetRecords calls per shard and poll, which
+	// DynamoDB limits to 5 per second
+	maxReadsPerPoll = 5
+)
+
+// ErrNoClickStream is returned when the url-clicks table has no stream
+var ErrNoClickStream = errors.New("url-clicks table has no stream")
+
+// ClickStreamAPI is the subset of the DynamoDB Streams client used by
+// ClickStream
+type ClickStreamAPI interface {
+	DescribeStream(ctx context.Context, params *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error)
+	GetShardIterator(ctx context.Context, params *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error)
+	GetRecords(ctx context.Context, params *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error)
+}
+
+// ClickStream publishes the click events written to the url-clicks table to
+// a Broker by following the table's stream. Every process recording clicks
+// into DynamoDB writes there, so subscribers see the redirects of the REST
+// server and the redirect Lambda as well as gRPC lookups, once their
+// recorder has flushed them.
+//
+// It starts at the tip of the stream: clicks written before it was opened
+// are not published. Shards opened later are read from their start, after
+// their parent shard has been read to its end.
+type ClickStream struct {
+	client    ClickStreamAPI
+	streamARN string
+	broker    *Broker
+	interval  time.Duration
+
+	// shards are the shards being read, by ID. finished holds the IDs of
+	// shards read to their end, or closed before the stream was opened.
+	shards      map[string]*streamShard
+	finished    map[string]struct{}
+	lastRefresh time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// streamShard is the read position in a shard
+type streamShard struct {
+	parent   string
+	iterator string
+	// lastSequence is the sequence number of the last record read, to get a
+	// new iterator when the current one expires
+	lastSequence string
+}
+
+// ClickStreamOption configures a ClickStream
+type ClickStreamOption func(*ClickStream)
+
+// WithStreamPollInterval sets how often the shards are read
+func WithStreamPollInterval(interval time.Duration) ClickStreamOption {
+	return func(s *ClickStream) {
+		if interval > 0 {
+			s.interval = interval
+		}
+	}
+}
+
+// NewClickStream starts publishing the clicks of the stream streamARN to
+// broker until Close is called
+func NewClickStream(ctx context.Context, client ClickStreamAPI, streamARN string, broker *Broker, opts ...ClickStreamOption) (*ClickStream, error) {
+	s := newClickStream(client, streamARN, broker, opts...)
+	if err := s.refresh(ctx, types.ShardIteratorTypeLatest); err != nil {
+		return nil, err
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.run(runCtx)
+	return s, nil
+}
+
+func newClickStream(client ClickStreamAPI, streamARN string, broker *Broker, opts ...ClickStreamOption) *ClickStream {
+	s := &ClickStream{
+		client:    client,
+		streamARN: streamARN,
+		broker:    broker,
+		interval:  defaultStreamPollInterval,
+		shards:    make(map[string]*streamShard),
+		finished:  make(map[string]struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// run polls the shards every interval until ctx is canceled
+func (s *ClickStream) run(ctx context.Context) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if time.Since(s.lastRefresh) >= shardRefreshInterval {
+				if err := s.refresh(ctx, types.ShardIteratorTypeTrimHorizon); err != nil && ctx.Err() == nil {
+					log.Printf("Failed to refresh click stream shards: %v", err)
+				}
+			}
+			s.poll(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refresh describes the stream and starts reading the shards it does not
+// know yet at iteratorType. Before the first read, shards that are already
+// closed hold past clicks only and are skipped.
+func (s *ClickStream) refresh(ctx context.Context, iteratorType types.ShardIteratorType) error {
+	var shards []types.Shard
+	input := &dynamodbstreams.DescribeStreamInput{StreamArn: aws.String(s.streamARN)}
+	for {
+		result, err := s.client.DescribeStream(ctx, input)
+		if err != nil {
+			return fmt.Errorf("failed to describe click stream: %w", err)
+		}
+		shards = append(shards, result.StreamDescription.Shards...)
+		if result.StreamDescription.LastEvaluatedShardId == nil {
+			break
+		}
+		input.ExclusiveStartShardId = result.StreamDescription.LastEvaluatedShardId
+	}
+
+	// Forget the finished shards that were trimmed from the stream
+	finished := make(map[string]struct{}, len(s.finished))
+	for _, shard := range shards {
+		id := aws.ToString(shard.ShardId)
+		if _, ok := s.finished[id]; ok {
+			finished[id] = struct{}{}
+		}
+	}
+	s.finished = finished
+
+	initial := s.lastRefresh.IsZero()
+	for _, shard := range shards {
+		id := aws.ToString(shard.ShardId)
+		if _, ok := s.shards[id]; ok {
+			continue
+		}
+		if _, ok := s.finished[id]; ok {
+			continue
+		}
+		if initial && shard.SequenceNumberRange != nil && shard.SequenceNumberRange.EndingSequenceNumber != nil {
+			s.finished[id] = struct{}{}
+			continue
+		}
+
+		iterator, err := s.iterator(ctx, id, iteratorType, "")
+		if err != nil {
+			return err
+		}
+		s.shards[id] = &streamShard{parent: aws.ToString(shard.ParentShardId), iterator: iterator}
+	}
+
+	s.lastRefresh = time.Now()
+	return nil
+}
+
+// iterator returns an iterator of shard id at iteratorType
+func (s *ClickStream) iterator(ctx context.Context, id string, iteratorType types.ShardIteratorType, sequence string) (string, error) {
+	input := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(s.streamARN),
+		ShardId:           aws.String(id),
+		ShardIteratorType: iteratorType,
+	}
+	if sequence != "" {
+		input.SequenceNumber = aws.String(sequence)
+	}
+	result, err := s.client.GetShardIterator(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to get iterator of click stream shard %s: %w", id, err)
+	}
+	return aws.ToString(result.ShardIterator), nil
+}
+
+// poll reads the new records of every shard whose parent has been read to
+// its end, so the clicks of a link are published in order
+func (s *ClickStream) poll(ctx context.Context) {
+	for id, shard := range s.shards {
+		if _, ok := s.shards[shard.parent]; ok {
+			continue
+		}
+		if err := s.read(ctx, id, shard); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to read click stream shard %s: %v", id, err)
+		}
+	}
+}
+
+// read publishes the new records of a shard
+func (s *ClickStream) read(ctx context.Context, id string, shard *streamShard) error {
+	for i := 0; i < maxReadsPerPoll; i++ {
+		result, err := s.client.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{
+			ShardIterator: aws.String(shard.iterator),
+		})
+		var expired *types.ExpiredIteratorException
+		if errors.As(err, &expired) {
+			// Iterators expire after 15 minutes of failed reads
+			iteratorType := types.ShardIteratorTypeAfterSequenceNumber
+			if shard.lastSequence == "" {
+				iteratorType = types.ShardIteratorTypeTrimHorizon
+			}
+			shard.iterator, err = s.iterator(ctx, id, iteratorType, shard.lastSequence)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, record := range result.Records {
+			if record.Dynamodb == nil {
+				continue
+			}
+			shard.lastSequence = aws.ToString(record.Dynamodb.SequenceNumber)
+			// Updates and TTL deletions are not clicks
+			if record.EventName != types.OperationTypeInsert {
+				continue
+			}
+			event, err := streamEvent(record.Dynamodb.NewImage)
+			if err != nil {
+				log.Printf("Skipping click stream record %s: %v", shard.lastSequence, err)
+				continue
+			}
+			s.broker.Publish(event)
+		}
+
+		if result.NextShardIterator == nil {
+			delete(s.shards, id)
+			s.finished[id] = struct{}{}
+			return nil
+		}
+		shard.iterator = aws.ToString(result.NextShardIterator)
+		if len(result.Records) == 0 {
+			return nil
+		}
+	}
+	return nil
+}
+
+// streamEvent decodes the click event of a url-clicks item image
+func streamEvent(image map[string]types.AttributeValue) (ClickEvent, error) {
+	var event ClickEvent
+	data, ok := image["Event"].(*types.AttributeValueMemberS)
+	if !ok {
+		return event, errors.New("missing event")
+	}
+	if err := json.Unmarshal([]byte(data.Value), &event); err != nil {
+		return event, fmt.Errorf("failed to unmarshal click event: %w", err)
+	}
+	return event, nil
+}
+
+// Close stops following the stream
+func (s *ClickStream) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.cancel()
+	<-s.done
+	return nil
+}
@@ -0,0 +1,115 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const timeSeriesTableName = "url-timeseries"
+
+// bucketFormats format the start of a bucket in its sort key, so the keys of
+// a granularity sort chronologically
+var bucketFormats = map[Granularity]string{
+	Minute: "2006-01-02T15:04",
+	Hour:   "2006-01-02T15",
+	Day:    dayFormat,
+}
+
+// bucketKey returns the sort key of a bucket, e.g. "hour#2024-05-01T10"
+func bucketKey(g Granularity, start time.Time) string {
+	return g.String() + "#" + start.UTC().Format(bucketFormats[g])
+}
+
+// recordTimeSeries adds the buckets of series to the stored ones with one
+// atomic ADD per bucket. Buckets expire through native TTL once out of
+// retention.
+func (s *DynamoDBStore) recordTimeSeries(ctx context.Context, shortCode string, series *TimeSeries) error {
+	for _, g := range granularities {
+		starts := make([]int64, 0, len(series.buckets[g]))
+		for start := range series.buckets[g] {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+		for _, unix := range starts {
+			start := time.Unix(unix, 0)
+			input := &dynamodb.UpdateItemInput{
+				TableName: aws.String(timeSeriesTableName),
+				Key: map[string]types.AttributeValue{
+					"ShortCode": &types.AttributeValueMemberS{Value: shortCode},
+					"Bucket":    &types.AttributeValueMemberS{Value: bucketKey(g, start)},
+				},
+				UpdateExpression: aws.String("ADD Clicks :clicks"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":clicks": &types.AttributeValueMemberN{Value: strconv.FormatInt(series.buckets[g][unix], 10)},
+				},
+			}
+			if keep := s.retention.For(g); keep > 0 {
+				input.UpdateExpression = aws.String("ADD Clicks :clicks SET #ttl = :ttl")
+				input.ExpressionAttributeNames = map[string]string{"#ttl": "TTL"}
+				input.ExpressionAttributeValues[":ttl"] = &types.AttributeValueMemberN{
+					Value: strconv.FormatInt(start.Add(g.Duration()+keep).Unix(), 10),
+				}
+			}
+
+			if _, err := s.client.UpdateItem(ctx, input); err != nil {
+				return fmt.Errorf("failed to update time series of %s: %w", shortCode, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *DynamoDBStore) GetTimeSeries(ctx context.Context, shortCode string, query TimeSeriesQuery) ([]Point, error) {
+	g := query.Granularity
+	last := g.Truncate(query.To.Add(-time.Nanosecond))
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(timeSeriesTableName),
+		KeyConditionExpression: aws.String("ShortCode = :shortCode AND Bucket BETWEEN :from AND :to"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":shortCode": &types.AttributeValueMemberS{Value: shortCode},
+			":from":      &types.AttributeValueMemberS{Value: bucketKey(g, query.From)},
+			":to":        &types.AttributeValueMemberS{Value: bucketKey(g, last)},
+		},
+	}
+
+	counts := make(map[int64]int64)
+	for {
+		result, err := s.client.Query(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query time series of %s: %w", shortCode, err)
+		}
+
+		for _, item := range result.Items {
+			bucket, ok := item["Bucket"].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			start, err := time.Parse(bucketFormats[g], strings.TrimPrefix(bucket.Value, g.String()+"#"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid time series bucket %q of %s: %w", bucket.Value, shortCode, err)
+			}
+			if n, ok := item["Clicks"].(*types.AttributeValueMemberN); ok {
+				clicks, err := strconv.ParseInt(n.Value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse clicks of %s: %w", shortCode, err)
+				}
+				counts[start.Unix()] = clicks
+			}
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return query.fill(counts), nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
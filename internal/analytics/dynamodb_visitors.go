@@ -0,0 +1,189 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	visitorsTableName = "url-visitors"
+
+	// windowAll is the sort key of the all-time sketch; daily sketches use
+	// windowDayPrefix followed by the day
+	windowAll       = "all"
+	windowDayPrefix = "day#"
+
+	// maxSketchAttempts bounds the retries of a sketch update that lost a
+	// race with another writer
+	maxSketchAttempts = 5
+)
+
+// recordVisitors merges the visitors of a batch into the sketches of
+// shortCode. Sketches cannot be incremented atomically like counters, so
+// each one is read, merged and written back under an optimistic lock on its
+// version.
+func (s *DynamoDBStore) recordVisitors(ctx context.Context, shortCode string, visitors *VisitorSketches) error {
+	if len(visitors.Days) == 0 {
+		return nil
+	}
+	if err := s.mergeSketch(ctx, shortCode, windowAll, visitors.All, time.Time{}); err != nil {
+		return err
+	}
+
+	days := make([]string, 0, len(visitors.Days))
+	for day := range visitors.Days {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	for _, day := range days {
+		date, err := time.Parse(dayFormat, day)
+		if err != nil {
+			return fmt.Errorf("invalid visitor day %q: %w", day, err)
+		}
+		// Daily sketches expire through native TTL once out of retention
+		expires := date.AddDate(0, 0, VisitorRetentionDays+1)
+		if err := s.mergeSketch(ctx, shortCode, windowDayPrefix+day, visitors.Days[day], expires); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeSketch merges sketch into the stored sketch of a window. A zero
+// expires keeps the item forever.
+func (s *DynamoDBStore) mergeSketch(ctx context.Context, shortCode, window string, sketch *HyperLogLog, expires time.Time) error {
+	key := map[string]types.AttributeValue{
+		"ShortCode": &types.AttributeValueMemberS{Value: shortCode},
+		"Window":    &types.AttributeValueMemberS{Value: window},
+	}
+
+	for attempt := 0; attempt < maxSketchAttempts; attempt++ {
+		result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(visitorsTableName),
+			Key:            key,
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get visitors of %s: %w", shortCode, err)
+		}
+
+		merged, version, err := decodeSketch(result.Item)
+		if err != nil {
+			return fmt.Errorf("failed to decode visitors of %s: %w", shortCode, err)
+		}
+		merged.Merge(sketch)
+		data, err := merged.MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		item := map[string]types.AttributeValue{
+			"ShortCode": key["ShortCode"],
+			"Window":    key["Window"],
+			"Sketch":    &types.AttributeValueMemberB{Value: data},
+			"Version":   &types.AttributeValueMemberN{Value: strconv.FormatInt(version+1, 10)},
+		}
+		if !expires.IsZero() {
+			item["TTL"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(expires.Unix(), 10)}
+		}
+
+		input := &dynamodb.PutItemInput{
+			TableName:           aws.String(visitorsTableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(ShortCode)"),
+		}
+		if version > 0 {
+			input.ConditionExpression = aws.String("Version = :version")
+			input.ExpressionAttributeValues = map[string]types.AttributeValue{
+				":version": &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)},
+			}
+		}
+
+		_, err = s.client.PutItem(ctx, input)
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to put visitors of %s: %w", shortCode, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("visitors of %s changed concurrently %d times", shortCode, maxSketchAttempts)
+}
+
+// getVisitors loads every sketch of shortCode
+func (s *DynamoDBStore) getVisitors(ctx context.Context, shortCode string) (*VisitorSketches, error) {
+	visitors := NewVisitorSketches()
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(visitorsTableName),
+		KeyConditionExpression: aws.String("ShortCode = :shortCode"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":shortCode": &types.AttributeValueMemberS{Value: shortCode},
+		},
+	}
+	for {
+		result, err := s.client.Query(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query visitors of %s: %w", shortCode, err)
+		}
+
+		for _, item := range result.Items {
+			window, ok := item["Window"].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			sketch, _, err := decodeSketch(item)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode visitors of %s: %w", shortCode, err)
+			}
+
+			switch {
+			case window.Value == windowAll:
+				visitors.All = sketch
+			case strings.HasPrefix(window.Value, windowDayPrefix):
+				visitors.Days[strings.TrimPrefix(window.Value, windowDayPrefix)] = sketch
+			}
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return visitors, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// decodeSketch reads the sketch and version of an item, returning an empty
+// sketch and version 0 when there is no item
+func decodeSketch(item map[string]types.AttributeValue) (*HyperLogLog, int64, error) {
+	sketch := NewHyperLogLog()
+	if item == nil {
+		return sketch, 0, nil
+	}
+
+	var version int64
+	if n, ok := item["Version"].(*types.AttributeValueMemberN); ok {
+		var err error
+		if version, err = strconv.ParseInt(n.Value, 10, 64); err != nil {
+			return nil, 0, err
+		}
+	}
+	if b, ok := item["Sketch"].(*types.AttributeValueMemberB); ok {
+		if err := sketch.UnmarshalBinary(b.Value); err != nil {
+			return nil, 0, err
+		}
+	}
+	return sketch, version, nil
+}
@@ -0,0 +1,210 @@
+package analytics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// maxEventSize bounds a single log line when replaying the file
+const maxEventSize = 64 * 1024
+
+// FileStore is the Store of the embedded file backend. Every event is
+// appended to a JSON lines log, which is replayed into memory when the file
+// is opened.
+type FileStore struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	memory *MemoryStore
+}
+
+// OpenFileStore opens or creates the log at path
+func OpenFileStore(path string, opts ...StoreOption) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create analytics directory: %w", err)
+	}
+
+	// Raw events are exported from the log rather than kept in memory
+	s := &FileStore{path: path, memory: NewMemoryStore(opts...)}
+	s.memory.keepEvents = false
+	valid, err := s.replay(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open analytics file: %w", err)
+	}
+	if err := repairTail(file, valid); err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.file = file
+
+	return s, nil
+}
+
+// replay aggregates the events of the log and returns the length of its
+// valid part
+func (s *FileStore) replay(path string) (int64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open analytics file: %w", err)
+	}
+	defer file.Close()
+
+	valid, err := readEvents(file, func(event ClickEvent) error {
+		s.memory.add(event)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.memory.prune()
+	return valid, nil
+}
+
+// repairTail cuts a line torn by a crash off the end of the log and ends the
+// last line, so the next batch starts on a line of its own instead of
+// corrupting the torn one for the next replay
+func repairTail(file *os.File, valid int64) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat analytics file: %w", err)
+	}
+	if info.Size() > valid {
+		if err := file.Truncate(valid); err != nil {
+			return fmt.Errorf("failed to truncate analytics file: %w", err)
+		}
+	}
+	if valid == 0 {
+		return nil
+	}
+
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, valid-1); err != nil {
+		return fmt.Errorf("failed to read analytics file: %w", err)
+	}
+	if last[0] != '\n' {
+		if _, err := file.Write([]byte{'\n'}); err != nil {
+			return fmt.Errorf("failed to write analytics file: %w", err)
+		}
+	}
+	return file.Sync()
+}
+
+// readEvents calls fn with every event of a log and returns the length of the
+// log up to the end of the last valid line. A malformed final line is treated
+// as a write interrupted by a crash and ignored.
+func readEvents(r io.Reader, fn func(ClickEvent) error) (int64, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4*1024), maxEventSize)
+	var read int64
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		read += int64(advance)
+		return advance, token, err
+	})
+
+	var valid int64
+	var badLine error
+	for line := 1; scanner.Scan(); line++ {
+		if badLine != nil {
+			return 0, badLine
+		}
+
+		var event ClickEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			badLine = fmt.Errorf("corrupt analytics file at line %d: %w", line, err)
+			continue
+		}
+		if err := fn(event); err != nil {
+			return 0, err
+		}
+		valid = read
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read analytics file: %w", err)
+	}
+	return valid, nil
+}
+
+// RecordClicks appends the batch to the log with a single write and counts
+// it once it is durable
+func (s *FileStore) RecordClicks(ctx context.Context, events []ClickEvent) error {
+	var data []byte
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal click event: %w", err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write analytics file: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync analytics file: %w", err)
+	}
+	return s.memory.RecordClicks(ctx, events)
+}
+
+func (s *FileStore) GetStats(ctx context.Context, shortCode string) (*Stats, error) {
+	return s.memory.GetStats(ctx, shortCode)
+}
+
+func (s *FileStore) GetTimeSeries(ctx context.Context, shortCode string, query TimeSeriesQuery) ([]Point, error) {
+	return s.memory.GetTimeSeries(ctx, shortCode, query)
+}
+
+// ExportClicks reads the log from disk up to the last batch written when the
+// export starts
+func (s *FileStore) ExportClicks(ctx context.Context, query ExportQuery, fn func(ClickEvent) error) error {
+	// Batches are written under the lock, so the size is at a batch boundary
+	s.mu.Lock()
+	info, err := s.file.Stat()
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to stat analytics file: %w", err)
+	}
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open analytics file: %w", err)
+	}
+	defer file.Close()
+
+	_, err = readEvents(io.LimitReader(file, info.Size()), func(event ClickEvent) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !query.Match(event) {
+			return nil
+		}
+		return fn(event)
+	})
+	return err
+}
+
+// Close closes the underlying log file
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
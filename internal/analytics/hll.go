@@ -0,0 +1,175 @@
+package analytics
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// hllPrecision is the number of hash bits that select a register. 2^12
+	// registers give a standard error of about 1.6%.
+	hllPrecision = 12
+	hllRegisters = 1 << hllPrecision
+	// hllSparseLimit is how many registers a sketch tracks individually
+	// before switching to a dense array, which is smaller from there on
+	hllSparseLimit = hllRegisters / 4
+
+	hllVersion      = 1
+	hllFormatSparse = 1
+	hllFormatDense  = 2
+)
+
+// errInvalidSketch is returned when decoding a malformed sketch
+var errInvalidSketch = errors.New("invalid HyperLogLog sketch")
+
+// HyperLogLog estimates the number of distinct values added to it in at most
+// hllRegisters bytes. Small sketches only store the registers that are set.
+// Sketches of different time windows or shards combine with Merge.
+type HyperLogLog struct {
+	sparse map[uint16]uint8
+	dense  []uint8
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{sparse: make(map[uint16]uint8)}
+}
+
+// Add records a 64-bit hash of a value
+func (h *HyperLogLog) Add(hash uint64) {
+	index := uint16(hash >> (64 - hllPrecision))
+	// The guard bit bounds the rank when the remaining bits are all zero
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1))) + 1
+	h.set(index, rank)
+}
+
+// AddString records value
+func (h *HyperLogLog) AddString(value string) {
+	sum := sha256.Sum256([]byte(value))
+	h.Add(binary.BigEndian.Uint64(sum[:8]))
+}
+
+// set raises a register to rank
+func (h *HyperLogLog) set(index uint16, rank uint8) {
+	if h.dense != nil {
+		if rank > h.dense[index] {
+			h.dense[index] = rank
+		}
+		return
+	}
+
+	if rank > h.sparse[index] {
+		h.sparse[index] = rank
+	}
+	if len(h.sparse) > hllSparseLimit {
+		h.dense = make([]uint8, hllRegisters)
+		for index, rank := range h.sparse {
+			h.dense[index] = rank
+		}
+		h.sparse = nil
+	}
+}
+
+// Merge adds the values of other, as if they had been added to h directly
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	if other.dense != nil {
+		for index, rank := range other.dense {
+			if rank > 0 {
+				h.set(uint16(index), rank)
+			}
+		}
+		return
+	}
+	for index, rank := range other.sparse {
+		h.set(index, rank)
+	}
+}
+
+// Estimate returns the approximate number of distinct values
+func (h *HyperLogLog) Estimate() int64 {
+	m := float64(hllRegisters)
+
+	zeros := hllRegisters
+	sum := 0.0
+	if h.dense != nil {
+		for _, rank := range h.dense {
+			if rank > 0 {
+				zeros--
+			}
+			sum += math.Ldexp(1, -int(rank))
+		}
+	} else {
+		zeros -= len(h.sparse)
+		sum = float64(zeros)
+		for _, rank := range h.sparse {
+			sum += math.Ldexp(1, -int(rank))
+		}
+	}
+
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// Linear counting is more accurate while many registers are empty
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
+
+// MarshalBinary encodes the sketch as a version, the precision, the format
+// and either the set registers as (index, rank) pairs or every register
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	if h.dense != nil {
+		data := make([]byte, 3, 3+hllRegisters)
+		data[0], data[1], data[2] = hllVersion, hllPrecision, hllFormatDense
+		return append(data, h.dense...), nil
+	}
+
+	indexes := make([]int, 0, len(h.sparse))
+	for index := range h.sparse {
+		indexes = append(indexes, int(index))
+	}
+	sort.Ints(indexes)
+
+	data := make([]byte, 3, 3+3*len(indexes))
+	data[0], data[1], data[2] = hllVersion, hllPrecision, hllFormatSparse
+	for _, index := range indexes {
+		data = binary.BigEndian.AppendUint16(data, uint16(index))
+		data = append(data, h.sparse[uint16(index)])
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes a sketch written by MarshalBinary
+func (h *HyperLogLog) UnmarshalBinary(data []byte) error {
+	if len(data) < 3 || data[0] != hllVersion || data[1] != hllPrecision {
+		return errInvalidSketch
+	}
+
+	payload := data[3:]
+	switch data[2] {
+	case hllFormatDense:
+		if len(payload) != hllRegisters {
+			return errInvalidSketch
+		}
+		h.sparse = nil
+		h.dense = append([]uint8(nil), payload...)
+	case hllFormatSparse:
+		if len(payload)%3 != 0 {
+			return errInvalidSketch
+		}
+		h.sparse = make(map[uint16]uint8, len(payload)/3)
+		h.dense = nil
+		for i := 0; i < len(payload); i += 3 {
+			index := binary.BigEndian.Uint16(payload[i:])
+			if index >= hllRegisters {
+				return errInvalidSketch
+			}
+			h.set(index, payload[i+2])
+		}
+	default:
+		return errInvalidSketch
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package analytics
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryStore keeps statistics and raw events in process memory. It is the
+// in-process sink used for local development and tests; data is lost when the
+// process exits.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	stats     map[string]*Stats
+	series    map[string]*TimeSeries
+	events    []ClickEvent
+	retention Retention
+	// keepEvents is false when the raw events are kept elsewhere
+	keepEvents bool
+}
+
+func NewMemoryStore(opts ...StoreOption) *MemoryStore {
+	return &MemoryStore{
+		stats:      make(map[string]*Stats),
+		series:     make(map[string]*TimeSeries),
+		retention:  newStoreOptions(opts).retention,
+		keepEvents: true,
+	}
+}
+
+func (s *MemoryStore) RecordClicks(ctx context.Context, events []ClickEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clicked := make(map[string]struct{})
+	for _, event := range events {
+		s.add(event)
+		clicked[event.ShortCode] = struct{}{}
+	}
+	if s.keepEvents {
+		s.events = append(s.events, events...)
+	}
+	// Prune once per batch rather than per click
+	for shortCode := range clicked {
+		s.series[shortCode].Prune(s.retention)
+	}
+	return nil
+}
+
+// add counts an event, the caller must hold the write lock. Time series are
+// pruned separately. Each dimension counts at most maxStoredValues values, the
+// clicks of further ones are counted under OtherKey.
+func (s *MemoryStore) add(event ClickEvent) {
+	stats, ok := s.stats[event.ShortCode]
+	if !ok {
+		stats = NewStats()
+		s.stats[event.ShortCode] = stats
+	}
+	stats.addBounded(event)
+	stats.Visitors.Prune(event.Timestamp.AddDate(0, 0, -VisitorRetentionDays))
+
+	series, ok := s.series[event.ShortCode]
+	if !ok {
+		series = NewTimeSeries()
+		s.series[event.ShortCode] = series
+	}
+	series.Add(event.Timestamp, 1)
+}
+
+// prune drops the time series buckets out of retention, the caller must hold
+// the write lock
+func (s *MemoryStore) prune() {
+	for _, series := range s.series {
+		series.Prune(s.retention)
+	}
+}
+
+func (s *MemoryStore) GetStats(ctx context.Context, shortCode string) (*Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Return a copy so callers never share maps with the store
+	stats := NewStats()
+	if stored, ok := s.stats[shortCode]; ok {
+		stats.Merge(stored)
+	}
+	return stats, nil
+}
+
+func (s *MemoryStore) GetTimeSeries(ctx context.Context, shortCode string, query TimeSeriesQuery) ([]Point, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	series, ok := s.series[shortCode]
+	if !ok {
+		series = NewTimeSeries()
+	}
+	return series.Range(query), nil
+}
+
+// ExportClicks copies the matching events before calling fn so recording is
+// not blocked while they are exported
+func (s *MemoryStore) ExportClicks(ctx context.Context, query ExportQuery, fn func(ClickEvent) error) error {
+	s.mu.RLock()
+	var matched []ClickEvent
+	for _, event := range s.events {
+		if query.Match(event) {
+			matched = append(matched, event)
+		}
+	}
+	s.mu.RUnlock()
+	for _, event := range matched {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
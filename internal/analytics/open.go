@@ -0,0 +1,108 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/geoip"
+	"github.com/jingy/Go-Shortener/internal/storage"
+	"github.com/jingy/Go-Shortener/internal/useragent"
+)
+
+// Open creates the Store matching the configured storage backend
+func Open(ctx context.Context, cfg *config.Config) (Store, error) {
+	retention := WithRetention(Retention{
+		Minute: cfg.Analytics.MinuteRetention,
+		Hour:   cfg.Analytics.HourRetention,
+		Day:    cfg.Analytics.DayRetention,
+	})
+
+	switch cfg.Storage.Backend {
+	case config.BackendMemory:
+		return NewMemoryStore(retention), nil
+	case config.BackendFile:
+		return OpenFileStore(cfg.Analytics.FilePath, retention)
+	case config.BackendDynamoDB:
+		client, err := storage.NewDynamoDBClient(ctx, cfg.Storage)
+		if err != nil {
+			return nil, err
+		}
+		return NewDynamoDBStore(client, retention, WithEventRetention(cfg.Analytics.EventRetention)), nil
+	}
+
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+}
+
+// OpenClickStream publishes the clicks every process writes to the url-clicks
+// table to broker when the analytics are kept in DynamoDB. It returns a nil
+// ClickStream for the other backends, whose clicks only reach the broker of
+// the process recording them, and ErrNoClickStream when the table has no
+// stream.
+func OpenClickStream(ctx context.Context, cfg *config.Config, broker *Broker, opts ...ClickStreamOption) (*ClickStream, error) {
+	if cfg.Storage.Backend != config.BackendDynamoDB {
+		return nil, nil
+	}
+
+	client, err := storage.NewDynamoDBClient(ctx, cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+	table, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(clicksTableName)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe %s table: %w", clicksTableName, err)
+	}
+	spec := table.Table.StreamSpecification
+	if spec == nil || !aws.ToBool(spec.StreamEnabled) || table.Table.LatestStreamArn == nil {
+		return nil, ErrNoClickStream
+	}
+	if spec.StreamViewType != types.StreamViewTypeNewImage && spec.StreamViewType != types.StreamViewTypeNewAndOldImages {
+		return nil, fmt.Errorf("stream of %s table has %s records, it needs new images", clicksTableName, spec.StreamViewType)
+	}
+
+	streams, err := storage.NewDynamoDBStreamsClient(ctx, cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return NewClickStream(ctx, streams, *table.Table.LatestStreamArn, broker, opts...)
+}
+
+// NewRecorderFromConfig starts a Recorder writing to store with the
+// configured salt, buffer and flush interval, followed by extra options. When
+// a GeoIP database is configured it resolves countries and is watched until
+// the recorder closes. Bot rules are read from BotRulesPath when set instead
+// of the embedded ones.
+func NewRecorderFromConfig(cfg config.AnalyticsConfig, store Store, extra ...RecorderOption) (*Recorder, error) {
+	opts := []RecorderOption{
+		WithSalt([]byte(cfg.Salt)),
+		WithBufferSize(cfg.BufferSize),
+		WithFlushInterval(cfg.FlushInterval),
+	}
+	if cfg.BotRulesPath != "" {
+		rules, err := useragent.Load(cfg.BotRulesPath)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithBotClassifier(rules))
+	}
+	opts = append(opts, extra...)
+
+	var resolver *geoip.Resolver
+	if cfg.GeoIPPath != "" {
+		var err error
+		resolver, err = geoip.Open(cfg.GeoIPPath, geoip.WithReloadInterval(cfg.GeoIPReloadInterval))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithCountryResolver(resolver))
+	}
+
+	recorder := NewRecorder(store, opts...)
+	if resolver != nil {
+		recorder.onClose = func() { resolver.Close() }
+	}
+	return recorder, nil
+}
@@ -0,0 +1,252 @@
+package analytics
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/useragent"
+)
+
+const (
+	defaultBufferSize    = 1024
+	defaultFlushInterval = time.Second
+	// maxBatchSize is the most events handed to Store.RecordClicks at once
+	maxBatchSize = 100
+	// writeTimeout bounds a single Store.RecordClicks call
+	writeTimeout = 5 * time.Second
+)
+
+// Recorder collects click events off the request path. Record never blocks:
+// events are queued in a bounded buffer and written to the store in batches
+// by a background goroutine, and events that do not fit are dropped.
+type Recorder struct {
+	store         Store
+	salt          []byte
+	countries     CountryResolver
+	bots          BotClassifier
+	broker        *Broker
+	now           func() time.Time
+	bufferSize    int
+	flushInterval time.Duration
+	// onClose releases resources opened along with the recorder
+	onClose   func()
+	closeOnce sync.Once
+
+	mu      sync.RWMutex
+	closed  bool
+	clicks  chan pendingClick
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// CountryResolver maps a client IP to an ISO 3166-1 alpha-2 country code,
+// returning an empty string when it is unknown
+type CountryResolver interface {
+	Country(ip string) string
+}
+
+// BotClassifier returns the bot category of a user agent, or an empty string
+// for people
+type BotClassifier interface {
+	Classify(userAgent string) string
+}
+
+// pendingClick is a queued event along with the client IP, which is only
+// kept until the country has been resolved
+type pendingClick struct {
+	event ClickEvent
+	ip    string
+}
+
+// RecorderOption configures a Recorder
+type RecorderOption func(*Recorder)
+
+// WithSalt sets the secret mixed into visitor IP hashes
+func WithSalt(salt []byte) RecorderOption {
+	return func(r *Recorder) {
+		r.salt = salt
+	}
+}
+
+// WithCountryResolver resolves the country of clicks that arrive without one.
+// Lookups happen on the background goroutine.
+func WithCountryResolver(countries CountryResolver) RecorderOption {
+	return func(r *Recorder) {
+		r.countries = countries
+	}
+}
+
+// WithBotClassifier replaces the embedded rules that tag clicks from bots.
+// Classification happens on the background goroutine.
+func WithBotClassifier(bots BotClassifier) RecorderOption {
+	return func(r *Recorder) {
+		r.bots = bots
+	}
+}
+
+// WithBroker publishes every click to the live subscribers of broker before
+// it is written
+func WithBroker(broker *Broker) RecorderOption {
+	return func(r *Recorder) {
+		r.broker = broker
+	}
+}
+
+// WithBufferSize sets how many events can wait to be written before new ones
+// are dropped
+func WithBufferSize(size int) RecorderOption {
+	return func(r *Recorder) {
+		if size > 0 {
+			r.bufferSize = size
+		}
+	}
+}
+
+// WithFlushInterval sets how long events wait for a batch to fill up
+func WithFlushInterval(interval time.Duration) RecorderOption {
+	return func(r *Recorder) {
+		if interval > 0 {
+			r.flushInterval = interval
+		}
+	}
+}
+
+// NewRecorder starts a Recorder writing to store. Clicks are tagged with the
+// bot rules embedded in the binary unless WithBotClassifier is given. Close
+// must be called to write the remaining events.
+func NewRecorder(store Store, opts ...RecorderOption) *Recorder {
+	r := &Recorder{
+		store:         store,
+		bots:          useragent.Default(),
+		now:           time.Now,
+		bufferSize:    defaultBufferSize,
+		flushInterval: defaultFlushInterval,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.clicks = make(chan pendingClick, r.bufferSize)
+
+	go r.run()
+	return r
+}
+
+// Record queues a click of shortCode by visitor
+func (r *Recorder) Record(shortCode string, visitor Visitor) {
+	event := ClickEvent{
+		ShortCode:   shortCode,
+		Timestamp:   r.now().UTC(),
+		IPHash:      HashIP(r.salt, visitor.IP),
+		VisitorID:   Fingerprint(r.salt, visitor.IP, visitor.UserAgent),
+		UserAgent:   visitor.UserAgent,
+		Referrer:    visitor.Referrer,
+		Country:     visitor.Country,
+		UTMSource:   visitor.Campaign.Source,
+		UTMMedium:   visitor.Campaign.Medium,
+		UTMCampaign: visitor.Campaign.Name,
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		r.dropped.Add(1)
+		return
+	}
+
+	select {
+	case r.clicks <- pendingClick{event: event, ip: visitor.IP}:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many events were discarded because the buffer was full
+// or the recorder was closed
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Close stops accepting events and waits until the queued ones are written
+// or ctx is done
+func (r *Recorder) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.clicks)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		if r.onClose != nil {
+			r.closeOnce.Do(r.onClose)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run writes queued events until the recorder is closed. A batch is written
+// when it is full or flushInterval after the last write.
+func (r *Recorder) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]ClickEvent, 0, maxBatchSize)
+	for {
+		select {
+		case click, ok := <-r.clicks:
+			if !ok {
+				r.write(batch)
+				return
+			}
+			if click.event.Country == "" && r.countries != nil {
+				click.event.Country = r.countries.Country(click.ip)
+			}
+			click.event.Bot = r.bots.Classify(click.event.UserAgent)
+			describe(&click.event)
+			if r.broker != nil {
+				r.broker.Publish(click.event)
+			}
+			batch = append(batch, click.event)
+			if len(batch) == maxBatchSize {
+				r.write(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			r.write(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// describe sets the device, OS and browser of an event from its user agent
+func describe(event *ClickEvent) {
+	client := useragent.Detect(event.UserAgent)
+	if event.Bot != "" {
+		client.Device = useragent.Bot
+	}
+	event.Device, event.OS, event.Browser = client.Device, client.OS, client.Browser
+}
+
+// write stores a batch. Failures are logged and the batch is dropped so a
+// broken store cannot back up the redirect path.
+func (r *Recorder) write(batch []ClickEvent) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	if err := r.store.RecordClicks(ctx, batch); err != nil {
+		r.dropped.Add(int64(len(batch)))
+		log.Printf("Failed to record %d clicks: %v", len(batch), err)
+	}
+}
@@ -0,0 +1,211 @@
+package analytics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+// MaxTimeSeriesPoints bounds the buckets returned by a single query
+const MaxTimeSeriesPoints = 1500
+
+// Granularity is the size of the buckets of a time series
+type Granularity int
+
+const (
+	Minute Granularity = iota + 1
+	Hour
+	Day
+)
+
+// granularities lists every granularity, smallest first
+var granularities = []Granularity{Minute, Hour, Day}
+
+// ParseGranularity parses "minute", "hour" or "day"
+func ParseGranularity(s string) (Granularity, error) {
+	for _, g := range granularities {
+		if strings.EqualFold(s, g.String()) {
+			return g, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", models.ErrInvalidGranularity, s)
+}
+
+func (g Granularity) String() string {
+	switch g {
+	case Minute:
+		return "minute"
+	case Hour:
+		return "hour"
+	case Day:
+		return "day"
+	}
+	return fmt.Sprintf("Granularity(%d)", int(g))
+}
+
+// Duration returns the length of a bucket
+func (g Granularity) Duration() time.Duration {
+	switch g {
+	case Minute:
+		return time.Minute
+	case Hour:
+		return time.Hour
+	case Day:
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+// Truncate returns the start of the UTC bucket containing t
+func (g Granularity) Truncate(t time.Time) time.Time {
+	return t.UTC().Truncate(g.Duration())
+}
+
+// defaultWindow is the range queried when a query has no start
+func (g Granularity) defaultWindow() time.Duration {
+	switch g {
+	case Minute:
+		return time.Hour
+	case Hour:
+		return 24 * time.Hour
+	}
+	return 30 * 24 * time.Hour
+}
+
+// Retention is how long the buckets of each granularity are kept. Zero keeps
+// them forever.
+type Retention struct {
+	Minute time.Duration
+	Hour   time.Duration
+	Day    time.Duration
+}
+
+// DefaultRetention keeps two days of minutes, 90 days of hours and every day
+var DefaultRetention = Retention{
+	Minute: 48 * time.Hour,
+	Hour:   90 * 24 * time.Hour,
+}
+
+// DefaultEventRetention is how long raw click events are kept by stores that
+// expire them
+const DefaultEventRetention = 90 * 24 * time.Hour
+
+// For returns the retention of g
+func (r Retention) For(g Granularity) time.Duration {
+	switch g {
+	case Minute:
+		return r.Minute
+	case Hour:
+		return r.Hour
+	}
+	return r.Day
+}
+
+// Point is the clicks of one bucket of a time series
+type Point struct {
+	Start  time.Time `json:"start"`
+	Clicks int64     `json:"clicks"`
+}
+
+// TimeSeriesQuery selects the buckets of [From, To) at a granularity. A
+// bucket is included when it starts in the range, after truncating From to
+// the start of its bucket.
+type TimeSeriesQuery struct {
+	Granularity Granularity
+	From        time.Time
+	To          time.Time
+}
+
+// Normalize fills in the defaults of a query relative to now and validates
+// it. Granularity defaults to Hour, To to now and From to a window ending at
+// To that depends on the granularity.
+func (q *TimeSeriesQuery) Normalize(now time.Time) error {
+	if q.Granularity == 0 {
+		q.Granularity = Hour
+	}
+	if q.Granularity.Duration() == 0 {
+		return fmt.Errorf("%w: %d", models.ErrInvalidGranularity, int(q.Granularity))
+	}
+	if q.To.IsZero() {
+		q.To = now
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-q.Granularity.defaultWindow())
+	}
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("%w: start must be before end", models.ErrInvalidTimeRange)
+	}
+	q.From = q.Granularity.Truncate(q.From)
+	q.To = q.To.UTC()
+
+	if points := q.points(); points > MaxTimeSeriesPoints {
+		return fmt.Errorf("%w: %d %s buckets exceed the maximum of %d", models.ErrInvalidTimeRange, points, q.Granularity, MaxTimeSeriesPoints)
+	}
+	return nil
+}
+
+// points counts the buckets of a normalized query
+func (q *TimeSeriesQuery) points() int64 {
+	d := q.Granularity.Duration()
+	return int64((q.To.Sub(q.From) + d - 1) / d)
+}
+
+// fill returns every bucket of a normalized query with the clicks of counts,
+// keyed by bucket start in Unix seconds
+func (q *TimeSeriesQuery) fill(counts map[int64]int64) []Point {
+	points := make([]Point, 0, q.points())
+	for start := q.From; start.Before(q.To); start = start.Add(q.Granularity.Duration()) {
+		points = append(points, Point{Start: start, Clicks: counts[start.Unix()]})
+	}
+	return points
+}
+
+// TimeSeries counts clicks per minute, hour and day bucket. Buckets are keyed
+// by their UTC start in Unix seconds.
+type TimeSeries struct {
+	buckets map[Granularity]map[int64]int64
+	// latest is the newest click, which retention is measured from
+	latest time.Time
+}
+
+func NewTimeSeries() *TimeSeries {
+	ts := &TimeSeries{buckets: make(map[Granularity]map[int64]int64, len(granularities))}
+	for _, g := range granularities {
+		ts.buckets[g] = make(map[int64]int64)
+	}
+	return ts
+}
+
+// Add counts clicks at t in the bucket of every granularity
+func (ts *TimeSeries) Add(t time.Time, clicks int64) {
+	for _, g := range granularities {
+		ts.buckets[g][g.Truncate(t).Unix()] += clicks
+	}
+	if t.After(ts.latest) {
+		ts.latest = t
+	}
+}
+
+// Prune drops the buckets that are out of retention as of the newest click
+func (ts *TimeSeries) Prune(retention Retention) {
+	for _, g := range granularities {
+		keep := retention.For(g)
+		if keep <= 0 {
+			continue
+		}
+		first := g.Truncate(ts.latest.Add(-keep)).Unix()
+		for start := range ts.buckets[g] {
+			if start < first {
+				delete(ts.buckets[g], start)
+			}
+		}
+	}
+}
+
+// Range returns the buckets selected by a normalized query, including empty
+// ones
+func (ts *TimeSeries) Range(q TimeSeriesQuery) []Point {
+	return q.fill(ts.buckets[q.Granularity])
+}
@@ -0,0 +1,494 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// BackendDynamoDB stores URLs and counters in DynamoDB
+	BackendDynamoDB = "dynamodb"
+	// BackendMemory keeps everything in process memory
+	BackendMemory = "memory"
+	// BackendFile stores everything in an embedded file on local disk
+	BackendFile = "file"
+
+	// StrategyCounter encodes the next value of a storage counter
+	StrategyCounter = "counter"
+	// StrategyRandom draws codes from crypto/rand
+	StrategyRandom = "random"
+	// StrategyHash derives codes from a hash of the URL
+	StrategyHash = "hash"
+	// StrategySnowflake builds codes from a timestamp, node ID and sequence
+	StrategySnowflake = "snowflake"
+
+	// ShortCodeModeSequential encodes counter values as they are
+	ShortCodeModeSequential = "sequential"
+	// ShortCodeModeObfuscated permutes counter values with a secret key so
+	// codes cannot be enumerated
+	ShortCodeModeObfuscated = "obfuscated"
+
+	// DomainRulesFile reads domain rules from a local file
+	DomainRulesFile = "file"
+	// DomainRulesTable reads domain rules from a DynamoDB table
+	DomainRulesTable = "table"
+
+	// ScreeningRefuse rejects new links to flagged URLs
+	ScreeningRefuse = "refuse"
+	// ScreeningQuarantine stores new links to flagged URLs quarantined so
+	// they can be reviewed
+	ScreeningQuarantine = "quarantine"
+
+	// minShortCodeKeyLength is the shortest key accepted for obfuscation
+	minShortCodeKeyLength = 16
+
+	defaultBaseURL     = "https://your-domain.com"
+	defaultFilePath    = "data/urls.db"
+	defaultArchivePath = "data/archive.jsonl"
+	defaultClicksPath  = "data/clicks.jsonl"
+	defaultHTTPAddr    = ":8080"
+	defaultGRPCAddr    = ":50051"
+	defaultCodeLength  = 6
+	defaultLeaseSize   = 1000
+	defaultRetention   = 7
+	defaultAlphabet    = "base62"
+	defaultBufferSize  = 1024
+	defaultFlushDelay  = time.Second
+	defaultGeoIPReload = time.Minute
+	defaultMinuteKeep  = 48 * time.Hour
+	defaultHourKeep    = 90 * 24 * time.Hour
+	defaultEventKeep   = 90 * 24 * time.Hour
+	defaultURLLength   = 2048
+	defaultRuleReload  = time.Minute
+	defaultBlockStatus = 403
+	defaultListReload  = time.Minute
+)
+
+// Config holds the settings shared by every entrypoint. Values are read from
+// environment variables so the same binaries work in Lambda, Docker and tests.
+type Config struct {
+	// BaseURL is prepended to short codes to build short URLs
+	BaseURL string
+	// HTTPAddr is the listen address of the standalone REST server
+	HTTPAddr string
+	// GRPCAddr is the listen address of the gRPC server
+	GRPCAddr string
+	// MaxTTL bounds requested expirations, zero means unbounded
+	MaxTTL time.Duration
+	// DedupURLs returns the existing short URL when the same canonical
+	// destination is shortened again
+	DedupURLs bool
+	// ReservedAliases are extra words that cannot be claimed as aliases
+	ReservedAliases []string
+	ShortCode       ShortCodeConfig
+	URLPolicy       URLPolicyConfig
+	Domains         DomainConfig
+	Screening       ScreeningConfig
+	Storage         StorageConfig
+	Analytics       AnalyticsConfig
+}
+
+// ShortCodeConfig selects how short codes are generated
+type ShortCodeConfig struct {
+	// Strategy is one of StrategyCounter, StrategyRandom, StrategyHash or
+	// StrategySnowflake
+	Strategy string
+	// Mode is ShortCodeModeSequential or ShortCodeModeObfuscated and only
+	// applies to StrategyCounter
+	Mode string
+	// Key is the secret used by ShortCodeModeObfuscated
+	Key string
+	// Length is the length of generated codes
+	Length int
+	// Alphabet is "base62", "unambiguous" or the literal characters to use
+	Alphabet string
+	// NodeID identifies this process to StrategySnowflake
+	NodeID int
+}
+
+// URLPolicyConfig restricts the destination URLs that may be shortened
+type URLPolicyConfig struct {
+	// AllowedSchemes lists the accepted URL schemes
+	AllowedSchemes []string
+	// MaxLength bounds the length of URLs in bytes, zero means unbounded
+	MaxLength int
+	// AllowIPHosts accepts public IP addresses as hosts
+	AllowIPHosts bool
+	// AllowPrivateHosts accepts loopback, private and link-local addresses,
+	// localhost and single-label hosts
+	AllowPrivateHosts bool
+	// ShortDomains are hosts serving short URLs besides the one of BaseURL
+	ShortDomains []string
+}
+
+// DomainConfig configures the rules blocking and allowing destination
+// domains
+type DomainConfig struct {
+	// RulesSource is DomainRulesFile, DomainRulesTable or empty to allow
+	// every domain
+	RulesSource string
+	// RulesPath is the rules file of DomainRulesFile
+	RulesPath string
+	// ReloadInterval is how often the rules are loaded again
+	ReloadInterval time.Duration
+	// CheckRedirects also applies the rules to redirects, so links to
+	// domains blocked after they were created stop working
+	CheckRedirects bool
+	// BlockPagePath is an HTML template served instead of redirecting to a
+	// blocked domain or a quarantined link, empty to use the embedded page
+	BlockPagePath string
+	// BlockPageStatus is the HTTP status of the block page
+	BlockPageStatus int
+}
+
+// ScreeningConfig configures the screening of URLs against local lists of
+// malicious URL hashes
+type ScreeningConfig struct {
+	// ListsDir is the directory of list files, empty to disable screening
+	ListsDir string
+	// ReloadInterval is how often the directory is checked for changes
+	ReloadInterval time.Duration
+	// Action is ScreeningRefuse or ScreeningQuarantine and applies to new
+	// links. Existing links that become flagged are always quarantined.
+	Action string
+}
+
+// StorageConfig selects and configures the storage backend
+type StorageConfig struct {
+	// Backend is one of BackendDynamoDB, BackendMemory or BackendFile
+	Backend string
+	// FilePath is the location of the embedded file backend
+	FilePath string
+	// ArchivePath is where the file backend archives expired URLs
+	ArchivePath string
+	// DynamoDBEndpoint overrides the DynamoDB endpoint, e.g. for DynamoDB Local
+	DynamoDBEndpoint string
+	// CounterLeaseSize is how many counter values the DynamoDB backend
+	// reserves per write
+	CounterLeaseSize int
+	// CounterShards is how many items each day of the DynamoDB counter is
+	// spread over
+	CounterShards int
+	// CounterRetentionDays is how many past days of counter buckets the
+	// cleanup keeps
+	CounterRetentionDays int
+}
+
+// AnalyticsConfig configures click recording
+type AnalyticsConfig struct {
+	// Salt is mixed into visitor IP hashes so they cannot be reversed by
+	// hashing every address
+	Salt string
+	// FilePath is where the file backend logs click events
+	FilePath string
+	// BufferSize is how many clicks can wait to be written before new ones
+	// are dropped
+	BufferSize int
+	// FlushInterval is how long clicks wait for a batch to fill up
+	FlushInterval time.Duration
+	// GeoIPPath is an MMDB country database used to resolve click
+	// countries, empty to disable
+	GeoIPPath string
+	// GeoIPReloadInterval is how often the database file is checked for
+	// changes
+	GeoIPReloadInterval time.Duration
+	// TrustedProxyHops is how many proxies we run in front of the servers,
+	// and so how many X-Forwarded-For entries can be trusted
+	TrustedProxyHops int
+	// BotRulesPath replaces the embedded rules tagging bot clicks, empty to
+	// use the embedded ones
+	BotRulesPath string
+	// MinuteRetention, HourRetention and DayRetention are how long the time
+	// series buckets of each granularity are kept, zero to keep them forever
+	MinuteRetention time.Duration
+	HourRetention   time.Duration
+	DayRetention    time.Duration
+	// EventRetention is how long the dynamodb backend keeps raw click
+	// events for export, zero to keep them forever
+	EventRetention time.Duration
+}
+
+// Load reads the configuration from the environment
+func Load() (*Config, error) {
+	cfg := &Config{
+		BaseURL:         getEnv("BASE_URL", defaultBaseURL),
+		HTTPAddr:        getEnv("HTTP_ADDR", defaultHTTPAddr),
+		GRPCAddr:        getEnv("GRPC_ADDR", defaultGRPCAddr),
+		ReservedAliases: getEnvList("RESERVED_ALIASES"),
+		ShortCode: ShortCodeConfig{
+			Strategy: getEnv("SHORT_CODE_STRATEGY", StrategyCounter),
+			Mode:     getEnv("SHORT_CODE_MODE", ShortCodeModeSequential),
+			Key:      os.Getenv("SHORT_CODE_KEY"),
+			Alphabet: getEnv("SHORT_CODE_ALPHABET", defaultAlphabet),
+		},
+		URLPolicy: URLPolicyConfig{
+			AllowedSchemes: getEnvList("ALLOWED_URL_SCHEMES"),
+			ShortDomains:   getEnvList("SHORT_DOMAINS"),
+		},
+		Domains: DomainConfig{
+			RulesSource:   os.Getenv("DOMAIN_RULES_SOURCE"),
+			RulesPath:     os.Getenv("DOMAIN_RULES_PATH"),
+			BlockPagePath: os.Getenv("BLOCK_PAGE_PATH"),
+		},
+		Screening: ScreeningConfig{
+			ListsDir: os.Getenv("SCREENING_LISTS_DIR"),
+			Action:   getEnv("SCREENING_ACTION", ScreeningRefuse),
+		},
+		Storage: StorageConfig{
+			Backend:          getEnv("STORAGE_BACKEND", BackendDynamoDB),
+			FilePath:         getEnv("STORAGE_FILE_PATH", defaultFilePath),
+			ArchivePath:      getEnv("STORAGE_ARCHIVE_PATH", defaultArchivePath),
+			DynamoDBEndpoint: os.Getenv("DYNAMODB_ENDPOINT"),
+		},
+		Analytics: AnalyticsConfig{
+			Salt:         os.Getenv("ANALYTICS_SALT"),
+			FilePath:     getEnv("ANALYTICS_FILE_PATH", defaultClicksPath),
+			GeoIPPath:    os.Getenv("GEOIP_DATABASE_PATH"),
+			BotRulesPath: os.Getenv("BOT_RULES_PATH"),
+		},
+	}
+
+	var err error
+	if cfg.MaxTTL, err = getEnvDuration("MAX_TTL", 0); err != nil {
+		return nil, err
+	}
+	if cfg.DedupURLs, err = getEnvBool("DEDUP_URLS", false); err != nil {
+		return nil, err
+	}
+	if len(cfg.URLPolicy.AllowedSchemes) == 0 {
+		cfg.URLPolicy.AllowedSchemes = []string{"http", "https"}
+	}
+	if cfg.URLPolicy.MaxLength, err = getEnvInt("MAX_URL_LENGTH", defaultURLLength); err != nil {
+		return nil, err
+	}
+	if cfg.URLPolicy.AllowIPHosts, err = getEnvBool("ALLOW_IP_HOSTS", false); err != nil {
+		return nil, err
+	}
+	if cfg.URLPolicy.AllowPrivateHosts, err = getEnvBool("ALLOW_PRIVATE_HOSTS", false); err != nil {
+		return nil, err
+	}
+	if cfg.Domains.ReloadInterval, err = getEnvDuration("DOMAIN_RULES_RELOAD_INTERVAL", defaultRuleReload); err != nil {
+		return nil, err
+	}
+	if cfg.Domains.CheckRedirects, err = getEnvBool("DOMAIN_CHECK_REDIRECTS", false); err != nil {
+		return nil, err
+	}
+	if cfg.Domains.BlockPageStatus, err = getEnvInt("BLOCK_PAGE_STATUS", defaultBlockStatus); err != nil {
+		return nil, err
+	}
+	if cfg.Screening.ReloadInterval, err = getEnvDuration("SCREENING_RELOAD_INTERVAL", defaultListReload); err != nil {
+		return nil, err
+	}
+	if cfg.Storage.CounterLeaseSize, err = getEnvInt("COUNTER_LEASE_SIZE", defaultLeaseSize); err != nil {
+		return nil, err
+	}
+	if cfg.Storage.CounterShards, err = getEnvInt("COUNTER_SHARDS", 1); err != nil {
+		return nil, err
+	}
+	if cfg.Storage.CounterRetentionDays, err = getEnvInt("COUNTER_RETENTION_DAYS", defaultRetention); err != nil {
+		return nil, err
+	}
+	if cfg.ShortCode.Length, err = getEnvInt("SHORT_CODE_LENGTH", defaultCodeLength); err != nil {
+		return nil, err
+	}
+	if cfg.ShortCode.NodeID, err = getEnvInt("SHORT_CODE_NODE_ID", 0); err != nil {
+		return nil, err
+	}
+	if cfg.Analytics.BufferSize, err = getEnvInt("ANALYTICS_BUFFER_SIZE", defaultBufferSize); err != nil {
+		return nil, err
+	}
+	if cfg.Analytics.FlushInterval, err = getEnvDuration("ANALYTICS_FLUSH_INTERVAL", defaultFlushDelay); err != nil {
+		return nil, err
+	}
+	if cfg.Analytics.GeoIPReloadInterval, err = getEnvDuration("GEOIP_RELOAD_INTERVAL", defaultGeoIPReload); err != nil {
+		return nil, err
+	}
+	if cfg.Analytics.TrustedProxyHops, err = getEnvInt("TRUSTED_PROXY_HOPS", 0); err != nil {
+		return nil, err
+	}
+	if cfg.Analytics.MinuteRetention, err = getEnvDuration("ANALYTICS_MINUTE_RETENTION", defaultMinuteKeep); err != nil {
+		return nil, err
+	}
+	if cfg.Analytics.HourRetention, err = getEnvDuration("ANALYTICS_HOUR_RETENTION", defaultHourKeep); err != nil {
+		return nil, err
+	}
+	if cfg.Analytics.DayRetention, err = getEnvDuration("ANALYTICS_DAY_RETENTION", 0); err != nil {
+		return nil, err
+	}
+	if cfg.Analytics.EventRetention, err = getEnvDuration("ANALYTICS_EVENT_RETENTION", defaultEventKeep); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that the configuration is usable
+func (c *Config) Validate() error {
+	switch c.Storage.Backend {
+	case BackendDynamoDB, BackendMemory:
+	case BackendFile:
+		if c.Storage.FilePath == "" {
+			return fmt.Errorf("STORAGE_FILE_PATH is required for the %s backend", BackendFile)
+		}
+	default:
+		return fmt.Errorf("unknown storage backend %q", c.Storage.Backend)
+	}
+	if c.Storage.CounterLeaseSize < 1 {
+		return fmt.Errorf("COUNTER_LEASE_SIZE must be positive")
+	}
+	if c.Storage.CounterShards < 1 {
+		return fmt.Errorf("COUNTER_SHARDS must be positive")
+	}
+	if c.Storage.CounterRetentionDays < 1 {
+		return fmt.Errorf("COUNTER_RETENTION_DAYS must be at least 1")
+	}
+	if c.MaxTTL < 0 {
+		return fmt.Errorf("MAX_TTL must not be negative")
+	}
+	if c.URLPolicy.MaxLength < 0 {
+		return fmt.Errorf("MAX_URL_LENGTH must not be negative")
+	}
+	if err := c.Domains.Validate(); err != nil {
+		return err
+	}
+	if err := c.Screening.Validate(); err != nil {
+		return err
+	}
+	if c.Analytics.BufferSize < 1 {
+		return fmt.Errorf("ANALYTICS_BUFFER_SIZE must be positive")
+	}
+	if c.Analytics.FlushInterval <= 0 {
+		return fmt.Errorf("ANALYTICS_FLUSH_INTERVAL must be positive")
+	}
+	if c.Analytics.GeoIPReloadInterval <= 0 {
+		return fmt.Errorf("GEOIP_RELOAD_INTERVAL must be positive")
+	}
+	if c.Analytics.TrustedProxyHops < 0 {
+		return fmt.Errorf("TRUSTED_PROXY_HOPS must not be negative")
+	}
+	if c.Analytics.MinuteRetention < 0 || c.Analytics.HourRetention < 0 || c.Analytics.DayRetention < 0 || c.Analytics.EventRetention < 0 {
+		return fmt.Errorf("ANALYTICS_*_RETENTION must not be negative")
+	}
+	return c.ShortCode.Validate()
+}
+
+// Validate checks that the short code settings are usable. The alphabet is
+// checked when the generator is created.
+func (c *ShortCodeConfig) Validate() error {
+	switch c.Strategy {
+	case StrategyCounter, StrategyRandom, StrategyHash, StrategySnowflake:
+	default:
+		return fmt.Errorf("unknown short code strategy %q", c.Strategy)
+	}
+
+	switch c.Mode {
+	case ShortCodeModeSequential:
+	case ShortCodeModeObfuscated:
+		if c.Strategy != StrategyCounter {
+			return fmt.Errorf("SHORT_CODE_MODE %s requires the %s strategy", ShortCodeModeObfuscated, StrategyCounter)
+		}
+		if len(c.Key) < minShortCodeKeyLength {
+			return fmt.Errorf("SHORT_CODE_KEY must be at least %d bytes in %s mode", minShortCodeKeyLength, ShortCodeModeObfuscated)
+		}
+	default:
+		return fmt.Errorf("unknown short code mode %q", c.Mode)
+	}
+
+	if c.Length < 1 {
+		return fmt.Errorf("SHORT_CODE_LENGTH must be positive")
+	}
+	return nil
+}
+
+// Validate checks that the domain rule settings are usable
+func (c *DomainConfig) Validate() error {
+	switch c.RulesSource {
+	case "", DomainRulesTable:
+	case DomainRulesFile:
+		if c.RulesPath == "" {
+			return fmt.Errorf("DOMAIN_RULES_PATH is required for the %s domain rules source", DomainRulesFile)
+		}
+	default:
+		return fmt.Errorf("unknown domain rules source %q", c.RulesSource)
+	}
+	if c.ReloadInterval <= 0 {
+		return fmt.Errorf("DOMAIN_RULES_RELOAD_INTERVAL must be positive")
+	}
+	if c.BlockPageStatus < 200 || c.BlockPageStatus > 599 {
+		return fmt.Errorf("BLOCK_PAGE_STATUS must be an HTTP status code")
+	}
+	return nil
+}
+
+// Validate checks that the screening settings are usable
+func (c *ScreeningConfig) Validate() error {
+	switch c.Action {
+	case ScreeningRefuse, ScreeningQuarantine:
+	default:
+		return fmt.Errorf("unknown screening action %q", c.Action)
+	}
+	if c.ReloadInterval <= 0 {
+		return fmt.Errorf("SCREENING_RELOAD_INTERVAL must be positive")
+	}
+	return nil
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+func getEnvInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n, nil
+}
+
+func getEnvBool(key string, fallback bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return b, nil
+}
+
+// getEnvList splits a comma separated variable, dropping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
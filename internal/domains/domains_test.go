@@ -0,0 +1,260 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/testutils"
+)
+
+const testRules = `
+# Phishing
+block  evil.com
+block  *.evil.com
+block  *.phish.example
+
+# Competitors, except their status page
+BLOCK  *.rival.io
+allow  status.rival.io
+block  Bücher.example
+block  203.0.113.7
+`
+
+func TestRules_Check(t *testing.T) {
+	rules, err := Parse(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		host    string
+		blocked bool
+	}{
+		{"evil.com", true},
+		{"EVIL.com.", true},
+		{"login.evil.com", true},
+		{"a.b.evil.com", true},
+		{"notevil.com", false},
+		{"phish.example", false},
+		{"bank.phish.example", true},
+		{"www.rival.io", true},
+		{"status.rival.io", false},
+		{"api.status.rival.io", true},
+		{"xn--bcher-kva.example", true},
+		{"bücher.example", true},
+		{"203.0.113.7", true},
+		{"203.0.113.8", false},
+		{"example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			err := rules.Check(tt.host)
+			if tt.blocked && !errors.Is(err, models.ErrDomainBlocked) {
+				t.Errorf("Check(%q) error = %v, want %v", tt.host, err, models.ErrDomainBlocked)
+			}
+			if !tt.blocked && err != nil {
+				t.Errorf("Check(%q) error = %v, want nil", tt.host, err)
+			}
+		})
+	}
+}
+
+func TestRules_Allowlist(t *testing.T) {
+	rules, err := NewRules([]Rule{
+		{Pattern: "*", Action: Block},
+		{Pattern: "example.com", Action: Allow},
+		{Pattern: "*.example.com", Action: Allow},
+		{Pattern: "ads.example.com", Action: Block},
+	})
+	if err != nil {
+		t.Fatalf("NewRules() error = %v", err)
+	}
+
+	for host, blocked := range map[string]bool{
+		"example.com":     false,
+		"www.example.com": false,
+		"ads.example.com": true,
+		"example.org":     true,
+	} {
+		if err := rules.Check(host); (err != nil) != blocked {
+			t.Errorf("Check(%q) error = %v, want blocked %v", host, err, blocked)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+	}{
+		{"missing pattern", "block"},
+		{"extra field", "block evil.com now"},
+		{"unknown action", "deny evil.com"},
+		{"inner wildcard", "block evil.*.com"},
+		{"bare suffix", "block *."},
+		{"conflict", "block evil.com\nallow EVIL.com"},
+		{"wildcard conflict", "block *\nallow *"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tt.rules)); err == nil {
+				t.Errorf("Parse(%q) should fail", tt.rules)
+			}
+		})
+	}
+}
+
+func TestPolicy_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.txt")
+	writeRules := func(rules string) {
+		if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+			t.Fatalf("Failed to write rules: %v", err)
+		}
+	}
+	writeRules("block evil.com\n")
+
+	policy, err := Open(context.Background(), File(path), WithReloadInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer policy.Close()
+
+	if err := policy.Check("evil.com"); !errors.Is(err, models.ErrDomainBlocked) {
+		t.Fatalf("Check() error = %v, want %v", err, models.ErrDomainBlocked)
+	}
+
+	// The rules change without reopening the policy
+	writeRules("block *.rival.io\n")
+	deadline := time.Now().Add(2 * time.Second)
+	for policy.Check("evil.com") != nil || policy.Check("www.rival.io") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("rules were not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Broken rules are rejected and the loaded ones stay in use
+	writeRules("block\n")
+	if err := policy.Reload(context.Background()); err == nil {
+		t.Error("Reload() of broken rules should fail")
+	}
+	if err := policy.Check("www.rival.io"); err == nil {
+		t.Error("Check() after a failed reload should still block www.rival.io")
+	}
+}
+
+func TestPolicy_Nil(t *testing.T) {
+	var policy *Policy
+	if err := policy.Check("evil.com"); err != nil {
+		t.Errorf("Check() on a nil policy error = %v, want nil", err)
+	}
+	if err := policy.Close(); err != nil {
+		t.Errorf("Close() on a nil policy error = %v", err)
+	}
+}
+
+func TestOpen_Missing(t *testing.T) {
+	if _, err := Open(context.Background(), File(filepath.Join(t.TempDir(), "missing.txt"))); err == nil {
+		t.Error("Open() of a missing file should fail")
+	}
+}
+
+// mockTable keeps rule items in memory, serving scans a page of one item at
+// a time
+func mockTable() (*testutils.MockDynamoDBClient, map[string]map[string]types.AttributeValue) {
+	items := make(map[string]map[string]types.AttributeValue)
+	client := testutils.NewMockDynamoDBClient()
+	client.PutItemFunc = func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+		items[params.Item["Pattern"].(*types.AttributeValueMemberS).Value] = params.Item
+		return &dynamodb.PutItemOutput{}, nil
+	}
+	client.DeleteItemFunc = func(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+		delete(items, params.Key["Pattern"].(*types.AttributeValueMemberS).Value)
+		return &dynamodb.DeleteItemOutput{}, nil
+	}
+	client.ScanFunc = func(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+		var after string
+		if params.ExclusiveStartKey != nil {
+			after = params.ExclusiveStartKey["Pattern"].(*types.AttributeValueMemberS).Value
+		}
+		// Return the smallest pattern after the start key
+		var next string
+		for pattern := range items {
+			if pattern > after && (next == "" || pattern < next) {
+				next = pattern
+			}
+		}
+		if next == "" {
+			return &dynamodb.ScanOutput{}, nil
+		}
+		return &dynamodb.ScanOutput{
+			Items:            []map[string]types.AttributeValue{items[next]},
+			LastEvaluatedKey: map[string]types.AttributeValue{"Pattern": items[next]["Pattern"]},
+		}, nil
+	}
+	return client, items
+}
+
+func TestTable(t *testing.T) {
+	ctx := context.Background()
+	client, items := mockTable()
+	table := NewTable(client)
+
+	for _, rule := range []Rule{
+		{Pattern: "*.Evil.com", Action: Block},
+		{Pattern: "rival.io", Action: Block},
+		{Pattern: "RIVAL.io", Action: Allow},
+	} {
+		if err := table.Put(ctx, rule); err != nil {
+			t.Fatalf("Put(%v) error = %v", rule, err)
+		}
+	}
+	if err := table.Put(ctx, Rule{Pattern: "evil.*", Action: Block}); err == nil {
+		t.Error("Put() of an invalid pattern should fail")
+	}
+
+	// Patterns are stored normalized, so the second rival.io rule replaced
+	// the first
+	rules, err := table.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []Rule{{Pattern: "*.evil.com", Action: Block}, {Pattern: "rival.io", Action: Allow}}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("List() = %v, want %v", rules, want)
+	}
+
+	loaded, err := table.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Check("www.evil.com") == nil || loaded.Check("rival.io") != nil {
+		t.Error("Load() rules do not match the table")
+	}
+
+	if err := table.Delete(ctx, "*.EVIL.com"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok := items["*.evil.com"]; ok {
+		t.Error("Delete() kept the rule")
+	}
+
+	// Items written by hand are validated on load
+	items["bad"], _ = attributevalue.MarshalMap(Rule{Pattern: "bad", Action: "deny"})
+	if _, err := table.Load(ctx); err == nil {
+		t.Error("Load() of an invalid item should fail")
+	}
+}
@@ -0,0 +1,32 @@
+package domains
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/storage"
+)
+
+// OpenFromConfig opens the Policy of the configured rules source. It
+// returns a nil Policy, which allows every domain, when no source is
+// configured.
+func OpenFromConfig(ctx context.Context, cfg *config.Config) (*Policy, error) {
+	var source Source
+	switch cfg.Domains.RulesSource {
+	case "":
+		return nil, nil
+	case config.DomainRulesFile:
+		source = File(cfg.Domains.RulesPath)
+	case config.DomainRulesTable:
+		client, err := storage.NewDynamoDBClient(ctx, cfg.Storage)
+		if err != nil {
+			return nil, err
+		}
+		source = NewTable(client)
+	default:
+		return nil, fmt.Errorf("unknown domain rules source %q", cfg.Domains.RulesSource)
+	}
+
+	return Open(ctx, source, WithReloadInterval(cfg.Domains.ReloadInterval))
+}
@@ -0,0 +1,122 @@
+package domains
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultReloadInterval = time.Minute
+	// reloadTimeout bounds a single reload from a slow source
+	reloadTimeout = 30 * time.Second
+)
+
+// Source loads the current rules, e.g. from a file or a table
+type Source interface {
+	Load(ctx context.Context) (*Rules, error)
+}
+
+// File is a Source reading rules in the text format from a file
+type File string
+
+// Load reads the file
+func (f File) Load(ctx context.Context) (*Rules, error) {
+	return Load(string(f))
+}
+
+// Policy checks domains against rules that are reloaded from a source in the
+// background, so rules can change without a restart. Rules that fail to
+// load are logged and the previous ones stay in use. A nil Policy allows
+// every domain.
+type Policy struct {
+	source   Source
+	interval time.Duration
+	rules    atomic.Pointer[Rules]
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Option configures a Policy
+type Option func(*Policy)
+
+// WithReloadInterval sets how often the rules are loaded again
+func WithReloadInterval(interval time.Duration) Option {
+	return func(p *Policy) {
+		if interval > 0 {
+			p.interval = interval
+		}
+	}
+}
+
+// Open loads the rules of source and starts reloading them periodically
+func Open(ctx context.Context, source Source, opts ...Option) (*Policy, error) {
+	p := &Policy{
+		source:   source,
+		interval: defaultReloadInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if err := p.Reload(ctx); err != nil {
+		return nil, err
+	}
+
+	go p.watch()
+	return p, nil
+}
+
+// Check returns models.ErrDomainBlocked when host is blocked by the current
+// rules
+func (p *Policy) Check(host string) error {
+	if p == nil {
+		return nil
+	}
+	return p.rules.Load().Check(host)
+}
+
+// Reload loads the rules from the source and swaps them in
+func (p *Policy) Reload(ctx context.Context) error {
+	rules, err := p.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+	p.rules.Store(rules)
+	return nil
+}
+
+// watch reloads the rules every interval until Close is called
+func (p *Policy) watch() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), reloadTimeout)
+			if err := p.Reload(ctx); err != nil {
+				log.Printf("Failed to reload domain rules: %v", err)
+			}
+			cancel()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Close stops reloading the rules
+func (p *Policy) Close() error {
+	if p == nil {
+		return nil
+	}
+	close(p.stop)
+	<-p.done
+	return nil
+}
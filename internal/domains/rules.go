@@ -0,0 +1,194 @@
+// Package domains decides which destination domains may be shortened and
+// redirected to, from exact and wildcard rules kept in a local file or a
+// DynamoDB table.
+package domains
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+// Action is what a rule does with the domains it matches
+type Action string
+
+const (
+	// Allow lets matching domains through, e.g. as an exception to a
+	// wildcard block
+	Allow Action = "allow"
+	// Block refuses matching domains
+	Block Action = "block"
+)
+
+// Wildcard is the pattern matching every domain, used to block everything
+// that is not explicitly allowed
+const Wildcard = "*"
+
+// Rule applies an action to the domains matching a pattern. The pattern is a
+// domain matching itself only, "*.domain" matching every subdomain of domain
+// but not domain itself, or Wildcard.
+type Rule struct {
+	Pattern string `json:"pattern" dynamodbav:"Pattern"`
+	Action  Action `json:"action" dynamodbav:"Action"`
+}
+
+// Rules is an immutable set of rules. The most specific rule matching a
+// domain wins: an exact rule, then the wildcard rule with the longest
+// suffix, then Wildcard. Domains matching no rule are allowed.
+type Rules struct {
+	exact map[string]Action
+	// suffixes holds the wildcard rules by the suffix they match, with
+	// Wildcard as the empty suffix
+	suffixes map[string]Action
+}
+
+// NewRules validates rules and indexes them for lookups
+func NewRules(rules []Rule) (*Rules, error) {
+	r := &Rules{
+		exact:    make(map[string]Action),
+		suffixes: make(map[string]Action),
+	}
+	for _, rule := range rules {
+		if err := r.add(rule); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *Rules) add(rule Rule) error {
+	rule, err := rule.normalize()
+	if err != nil {
+		return err
+	}
+
+	index, key := r.exact, rule.Pattern
+	if key == Wildcard {
+		index, key = r.suffixes, ""
+	} else if suffix, ok := strings.CutPrefix(key, "*."); ok {
+		index, key = r.suffixes, suffix
+	}
+	if existing, ok := index[key]; ok && existing != rule.Action {
+		return fmt.Errorf("domain rule %q is both allowed and blocked", rule.Pattern)
+	}
+	index[key] = rule.Action
+	return nil
+}
+
+// normalize validates rule and returns it with its pattern normalized like
+// hosts, so patterns may be written in any case or as Unicode
+func (rule Rule) normalize() (Rule, error) {
+	if rule.Action != Allow && rule.Action != Block {
+		return rule, fmt.Errorf("domain rule %q has unknown action %q", rule.Pattern, rule.Action)
+	}
+
+	pattern := strings.TrimSpace(rule.Pattern)
+	if pattern == Wildcard {
+		rule.Pattern = pattern
+		return rule, nil
+	}
+	suffix, wildcard := strings.CutPrefix(pattern, "*.")
+	domain := normalizeHost(suffix)
+	if domain == "" || strings.Contains(domain, "*") {
+		return rule, fmt.Errorf("invalid domain rule pattern %q", rule.Pattern)
+	}
+	if wildcard {
+		domain = "*." + domain
+	}
+	rule.Pattern = domain
+	return rule, nil
+}
+
+// Parse reads rules in the text format: one rule per line made of an action
+// and a pattern separated by spaces. Blank lines and lines starting with #
+// are ignored.
+func Parse(rd io.Reader) (*Rules, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(rd)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(text, unicode.IsSpace)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("domain rule at line %d needs an action and a pattern", line)
+		}
+		rules = append(rules, Rule{Action: Action(strings.ToLower(fields[0])), Pattern: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read domain rules: %w", err)
+	}
+
+	return NewRules(rules)
+}
+
+// Load reads rules from a file in the text format
+func Load(path string) (*Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open domain rules: %w", err)
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Check returns models.ErrDomainBlocked when host is blocked
+func (r *Rules) Check(host string) error {
+	host = normalizeHost(host)
+	if r.action(host) == Block {
+		return fmt.Errorf("%w: %s", models.ErrDomainBlocked, host)
+	}
+	return nil
+}
+
+// action returns the action of the most specific rule matching host
+func (r *Rules) action(host string) Action {
+	if action, ok := r.exact[host]; ok {
+		return action
+	}
+	// Subdomains of IP addresses do not exist, so their suffixes are not
+	// looked up
+	if net.ParseIP(host) == nil {
+		for rest := host; ; {
+			i := strings.IndexByte(rest, '.')
+			if i < 0 {
+				break
+			}
+			rest = rest[i+1:]
+			if action, ok := r.suffixes[rest]; ok {
+				return action
+			}
+		}
+	}
+	if action, ok := r.suffixes[""]; ok {
+		return action
+	}
+	return Allow
+}
+
+// normalizeHost lowercases host, drops a trailing dot and converts
+// internationalized names to punycode. Invalid names are returned as empty.
+func normalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return ""
+	}
+	return ascii
+}
@@ -0,0 +1,110 @@
+package domains
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tableName is the DynamoDB table of rules, keyed by Pattern
+const tableName = "url-domain-rules"
+
+// TableAPI is the subset of the DynamoDB client used by Table
+type TableAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+// Table is a Source keeping rules in DynamoDB, so every instance shares
+// them and they can be changed without touching the deployment
+type Table struct {
+	client TableAPI
+}
+
+func NewTable(client TableAPI) *Table {
+	return &Table{client: client}
+}
+
+// Load reads and validates every rule of the table
+func (t *Table) Load(ctx context.Context) (*Rules, error) {
+	rules, err := t.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewRules(rules)
+}
+
+// List returns the rules of the table sorted by pattern
+func (t *Table) List(ctx context.Context) ([]Rule, error) {
+	var rules []Rule
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+	}
+	for {
+		result, err := t.client.Scan(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan domain rules: %w", err)
+		}
+		for _, item := range result.Items {
+			var rule Rule
+			if err := attributevalue.UnmarshalMap(item, &rule); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal domain rule: %w", err)
+			}
+			rules = append(rules, rule)
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Pattern < rules[j].Pattern })
+	return rules, nil
+}
+
+// Put adds rule or replaces the action of its pattern. The pattern is
+// stored normalized, so the same domain written differently replaces it too.
+func (t *Table) Put(ctx context.Context, rule Rule) error {
+	rule, err := rule.normalize()
+	if err != nil {
+		return err
+	}
+
+	av, err := attributevalue.MarshalMap(rule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal domain rule: %w", err)
+	}
+	_, err = t.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item:      av,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put domain rule: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the rule of pattern, if any
+func (t *Table) Delete(ctx context.Context, pattern string) error {
+	// Any action normalizes the pattern the same way
+	rule, err := Rule{Pattern: pattern, Action: Block}.normalize()
+	if err != nil {
+		return err
+	}
+	_, err = t.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"Pattern": &types.AttributeValueMemberS{Value: rule.Pattern},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete domain rule: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Package export writes raw click events and per-link aggregates as CSV,
+// JSON Lines or Parquet. Rows are written as they are read from the stores,
+// so exports of any size run in bounded memory.
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/storage"
+)
+
+// Format is the file format of an export
+type Format string
+
+const (
+	CSV     Format = "csv"
+	JSONL   Format = "jsonl"
+	Parquet Format = "parquet"
+)
+
+// ParseFormat parses "csv", "jsonl" or "parquet"
+func ParseFormat(s string) (Format, error) {
+	switch format := Format(s); format {
+	case CSV, JSONL, Parquet:
+		return format, nil
+	}
+	return "", fmt.Errorf("%w: unknown format %q", models.ErrInvalidExport, s)
+}
+
+// Kind is what an export contains
+type Kind string
+
+const (
+	// Clicks exports one row per click event
+	Clicks Kind = "clicks"
+	// Links exports one row of all-time aggregates per short URL
+	Links Kind = "links"
+)
+
+// ParseKind parses "clicks" or "links"
+func ParseKind(s string) (Kind, error) {
+	switch kind := Kind(s); kind {
+	case Clicks, Links:
+		return kind, nil
+	}
+	return "", fmt.Errorf("%w: unknown kind %q", models.ErrInvalidExport, s)
+}
+
+// Request selects what to export. An empty ShortCode exports every short
+// URL; short URLs have no owner to export by. From and To only apply to
+// click events.
+type Request struct {
+	Kind   Kind
+	Format Format
+	analytics.ExportQuery
+}
+
+// Validate checks the kind, format and time range of r
+func (r Request) Validate() error {
+	if _, err := ParseKind(string(r.Kind)); err != nil {
+		return err
+	}
+	if _, err := ParseFormat(string(r.Format)); err != nil {
+		return err
+	}
+	if !r.From.IsZero() && !r.To.IsZero() && !r.From.Before(r.To) {
+		return fmt.Errorf("%w: start must be before end", models.ErrInvalidExport)
+	}
+	return nil
+}
+
+// Export writes the rows selected by req to w and returns how many were
+// written. A failed export may leave a partial file behind.
+func Export(ctx context.Context, req Request, urls storage.URLStore, stats analytics.Store, w io.Writer) (int64, error) {
+	if err := req.Validate(); err != nil {
+		return 0, err
+	}
+	if req.Kind == Links {
+		return exportLinks(ctx, req, urls, stats, w)
+	}
+	return exportClicks(ctx, req, stats, w)
+}
+
+func exportClicks(ctx context.Context, req Request, stats analytics.Store, w io.Writer) (int64, error) {
+	writer, err := NewWriter[ClickRow](w, req.Format)
+	if err != nil {
+		return 0, err
+	}
+
+	var rows int64
+	err = stats.ExportClicks(ctx, req.ExportQuery, func(event analytics.ClickEvent) error {
+		rows++
+		return writer.Write(clickRow(event))
+	})
+	if err != nil {
+		return rows, err
+	}
+	return rows, writer.Close()
+}
+
+// exportLinks writes the aggregates of one short URL, or pages through all
+// of them including the expired ones
+func exportLinks(ctx context.Context, req Request, urls storage.URLStore, stats analytics.Store, w io.Writer) (int64, error) {
+	writer, err := NewWriter[LinkRow](w, req.Format)
+	if err != nil {
+		return 0, err
+	}
+
+	var rows int64
+	write := func(url *models.URL) error {
+		linkStats, err := stats.GetStats(ctx, url.ShortCode)
+		if err != nil {
+			return err
+		}
+		rows++
+		return writer.Write(linkRow(url, linkStats))
+	}
+
+	if req.ShortCode != "" {
+		url, err := urls.Get(ctx, req.ShortCode)
+		if err != nil {
+			return 0, err
+		}
+		if err := write(url); err != nil {
+			return rows, err
+		}
+		return rows, writer.Close()
+	}
+
+	opts := storage.ListOptions{IncludeExpired: true}
+	for {
+		page, err := urls.List(ctx, opts)
+		if err != nil {
+			return rows, err
+		}
+		for _, url := range page.URLs {
+			if err := write(url); err != nil {
+				return rows, err
+			}
+		}
+		if page.NextCursor == "" {
+			return rows, writer.Close()
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
@@ -0,0 +1,219 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/storage"
+)
+
+var (
+	day1 = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	day2 = time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+)
+
+// testStores holds three short URLs, one of them expired, and their clicks
+func testStores(t *testing.T) (storage.URLStore, analytics.Store) {
+	ctx := context.Background()
+	urls := storage.NewMemoryStorage()
+	expired := models.NewURL("https://example.com/old", "old")
+	expired.SetExpiresAt(day2)
+	for _, url := range []*models.URL{
+		models.NewURL("https://example.com/a", "abc123"),
+		models.NewURL("https://example.com/x", "xyz789"),
+		expired,
+	} {
+		if err := urls.Create(ctx, url); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	stats := analytics.NewMemoryStore()
+	err := stats.RecordClicks(ctx, []analytics.ClickEvent{
+		{ShortCode: "abc123", Timestamp: day1.Add(10 * time.Hour), VisitorID: "a", Country: "US", UserAgent: `Mozilla/5.0 "quoted", comma`},
+		{ShortCode: "abc123", Timestamp: day2.Add(10 * time.Hour), VisitorID: "b", Referrer: "https://news.ycombinator.com/"},
+		{ShortCode: "abc123", Timestamp: day2.Add(11 * time.Hour), VisitorID: "c", Bot: "crawler", Device: "bot"},
+		{ShortCode: "xyz789", Timestamp: day2.Add(12 * time.Hour), VisitorID: "a"},
+		{ShortCode: "old", Timestamp: day1.Add(12 * time.Hour), VisitorID: "a"},
+	})
+	if err != nil {
+		t.Fatalf("RecordClicks() error = %v", err)
+	}
+	return urls, stats
+}
+
+// decode reads the rows of a JSON Lines or Parquet export back
+func decode[T Row](t *testing.T, format Format, data []byte) []T {
+	var rows []T
+	switch format {
+	case JSONL:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var row T
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Fatalf("failed to read JSON line %q: %v", scanner.Text(), err)
+			}
+			rows = append(rows, row)
+		}
+	case Parquet:
+		var err error
+		rows, err = parquet.Read[T](bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("failed to read Parquet: %v", err)
+		}
+	}
+	return rows
+}
+
+func TestExport_Clicks(t *testing.T) {
+	urls, stats := testStores(t)
+
+	tests := []struct {
+		name  string
+		query analytics.ExportQuery
+		want  []string
+	}{
+		{"all", analytics.ExportQuery{}, []string{"abc123", "abc123", "abc123", "xyz789", "old"}},
+		{"one link", analytics.ExportQuery{ShortCode: "abc123"}, []string{"abc123", "abc123", "abc123"}},
+		{"range", analytics.ExportQuery{From: day2, To: day2.Add(12 * time.Hour)}, []string{"abc123", "abc123"}},
+	}
+
+	for _, format := range []Format{JSONL, Parquet} {
+		for _, tt := range tests {
+			t.Run(string(format)+"/"+tt.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				req := Request{Kind: Clicks, Format: format, ExportQuery: tt.query}
+				n, err := Export(context.Background(), req, urls, stats, &buf)
+				if err != nil {
+					t.Fatalf("Export() error = %v", err)
+				}
+				if n != int64(len(tt.want)) {
+					t.Errorf("Export() = %d rows, want %d", n, len(tt.want))
+				}
+
+				rows := decode[ClickRow](t, format, buf.Bytes())
+				if len(rows) != len(tt.want) {
+					t.Fatalf("decoded %d rows, want %d", len(rows), len(tt.want))
+				}
+				for i, row := range rows {
+					if row.ShortCode != tt.want[i] {
+						t.Errorf("row %d short code = %s, want %s", i, row.ShortCode, tt.want[i])
+					}
+				}
+				if tt.name == "all" && rows[0].UserAgent != `Mozilla/5.0 "quoted", comma` {
+					t.Errorf("user agent = %q, want it unchanged", rows[0].UserAgent)
+				}
+			})
+		}
+	}
+}
+
+func TestExport_ClicksCSV(t *testing.T) {
+	urls, stats := testStores(t)
+
+	var buf bytes.Buffer
+	req := Request{Kind: Clicks, Format: CSV, ExportQuery: analytics.ExportQuery{ShortCode: "abc123", To: day2}}
+	if _, err := Export(context.Background(), req, urls, stats, &buf); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read CSV: %v", err)
+	}
+	want := [][]string{
+		ClickRow{}.header(),
+		{"abc123", "2024-05-01T10:00:00Z", "a", "", `Mozilla/5.0 "quoted", comma`, "", "US", "", "", "", "", "", "", ""},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("CSV = %v, want %v", records, want)
+	}
+}
+
+func TestExport_Links(t *testing.T) {
+	urls, stats := testStores(t)
+
+	for _, format := range []Format{JSONL, Parquet} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := Export(context.Background(), Request{Kind: Links, Format: format}, urls, stats, &buf)
+			if err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+			// Expired links keep their history
+			if n != 3 {
+				t.Errorf("Export() = %d rows, want 3", n)
+			}
+
+			rows := decode[LinkRow](t, format, buf.Bytes())
+			byCode := make(map[string]LinkRow)
+			for _, row := range rows {
+				byCode[row.ShortCode] = row
+			}
+			abc := byCode["abc123"]
+			if abc.OriginalURL != "https://example.com/a" || abc.TotalClicks != 3 || abc.HumanClicks != 2 || abc.BotClicks != 1 || abc.UniqueVisitors != 2 {
+				t.Errorf("abc123 row = %+v", abc)
+			}
+			if abc.ExpiresAt != nil {
+				t.Errorf("abc123 expires at %v, want nil", abc.ExpiresAt)
+			}
+			if old := byCode["old"]; old.ExpiresAt == nil || !old.ExpiresAt.Equal(day2) {
+				t.Errorf("old row = %+v, want it to expire on May 2", old)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	req := Request{Kind: Links, Format: CSV, ExportQuery: analytics.ExportQuery{ShortCode: "xyz789"}}
+	if _, err := Export(context.Background(), req, urls, stats, &buf); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	records, _ := csv.NewReader(&buf).ReadAll()
+	if len(records) != 2 || records[1][0] != "xyz789" || records[1][3] != "" || records[1][4] != "1" {
+		t.Errorf("CSV = %v, want one row of xyz789 with 1 click and no expiry", records)
+	}
+
+	_, err := Export(context.Background(), Request{Kind: Links, Format: CSV, ExportQuery: analytics.ExportQuery{ShortCode: "missing"}}, urls, stats, &buf)
+	if !errors.Is(err, models.ErrURLNotFound) {
+		t.Errorf("Export() of a missing link error = %v, want %v", err, models.ErrURLNotFound)
+	}
+}
+
+func TestRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     Request
+		wantErr bool
+	}{
+		{"clicks", Request{Kind: Clicks, Format: CSV}, false},
+		{"links in a range", Request{Kind: Links, Format: Parquet, ExportQuery: analytics.ExportQuery{From: day1, To: day2}}, false},
+		{"open range", Request{Kind: Clicks, Format: JSONL, ExportQuery: analytics.ExportQuery{From: day2}}, false},
+		{"unknown kind", Request{Kind: "visitors", Format: CSV}, true},
+		{"unknown format", Request{Kind: Clicks, Format: "xlsx"}, true},
+		{"reversed range", Request{Kind: Clicks, Format: CSV, ExportQuery: analytics.ExportQuery{From: day2, To: day1}}, true},
+		{"empty range", Request{Kind: Clicks, Format: CSV, ExportQuery: analytics.ExportQuery{From: day1, To: day1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, models.ErrInvalidExport) {
+				t.Errorf("Validate() error = %v, want %v", err, models.ErrInvalidExport)
+			}
+		})
+	}
+}
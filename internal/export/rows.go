@@ -0,0 +1,121 @@
+package export
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+// Row is a record of an export. The same snake_case column names are used
+// for CSV headers, JSON keys and Parquet columns.
+type Row interface {
+	ClickRow | LinkRow
+	header() []string
+	record() []string
+}
+
+// ClickRow is one click event
+type ClickRow struct {
+	ShortCode   string    `json:"short_code" parquet:"short_code"`
+	Timestamp   time.Time `json:"timestamp" parquet:"timestamp"`
+	VisitorID   string    `json:"visitor_id" parquet:"visitor_id"`
+	IPHash      string    `json:"ip_hash" parquet:"ip_hash"`
+	UserAgent   string    `json:"user_agent" parquet:"user_agent"`
+	Referrer    string    `json:"referrer" parquet:"referrer"`
+	Country     string    `json:"country" parquet:"country"`
+	Bot         string    `json:"bot" parquet:"bot"`
+	UTMSource   string    `json:"utm_source" parquet:"utm_source"`
+	UTMMedium   string    `json:"utm_medium" parquet:"utm_medium"`
+	UTMCampaign string    `json:"utm_campaign" parquet:"utm_campaign"`
+	Device      string    `json:"device" parquet:"device"`
+	OS          string    `json:"os" parquet:"os"`
+	Browser     string    `json:"browser" parquet:"browser"`
+}
+
+func clickRow(event analytics.ClickEvent) ClickRow {
+	return ClickRow{
+		ShortCode:   event.ShortCode,
+		Timestamp:   event.Timestamp.UTC(),
+		VisitorID:   event.VisitorID,
+		IPHash:      event.IPHash,
+		UserAgent:   event.UserAgent,
+		Referrer:    event.Referrer,
+		Country:     event.Country,
+		Bot:         event.Bot,
+		UTMSource:   event.UTMSource,
+		UTMMedium:   event.UTMMedium,
+		UTMCampaign: event.UTMCampaign,
+		Device:      event.Device,
+		OS:          event.OS,
+		Browser:     event.Browser,
+	}
+}
+
+func (ClickRow) header() []string {
+	return []string{
+		"short_code", "timestamp", "visitor_id", "ip_hash", "user_agent", "referrer", "country",
+		"bot", "utm_source", "utm_medium", "utm_campaign", "device", "os", "browser",
+	}
+}
+
+func (r ClickRow) record() []string {
+	return []string{
+		r.ShortCode, formatTime(r.Timestamp), r.VisitorID, r.IPHash, r.UserAgent, r.Referrer, r.Country,
+		r.Bot, r.UTMSource, r.UTMMedium, r.UTMCampaign, r.Device, r.OS, r.Browser,
+	}
+}
+
+// LinkRow holds the all-time aggregates of one short URL
+type LinkRow struct {
+	ShortCode   string    `json:"short_code" parquet:"short_code"`
+	OriginalURL string    `json:"original_url" parquet:"original_url"`
+	CreatedAt   time.Time `json:"created_at" parquet:"created_at"`
+	// ExpiresAt is nil for URLs that never expire
+	ExpiresAt      *time.Time `json:"expires_at" parquet:"expires_at,optional"`
+	TotalClicks    int64      `json:"total_clicks" parquet:"total_clicks"`
+	HumanClicks    int64      `json:"human_clicks" parquet:"human_clicks"`
+	BotClicks      int64      `json:"bot_clicks" parquet:"bot_clicks"`
+	UniqueVisitors int64      `json:"unique_visitors" parquet:"unique_visitors"`
+}
+
+func linkRow(url *models.URL, stats *analytics.Stats) LinkRow {
+	row := LinkRow{
+		ShortCode:      url.ShortCode,
+		OriginalURL:    url.OriginalURL,
+		CreatedAt:      url.CreatedAt.UTC(),
+		TotalClicks:    stats.TotalClicks,
+		HumanClicks:    stats.HumanClicks(),
+		BotClicks:      stats.TotalClicks - stats.HumanClicks(),
+		UniqueVisitors: stats.Visitors.All.Estimate(),
+	}
+	if !url.ExpiresAt.IsZero() {
+		expiresAt := url.ExpiresAt.UTC()
+		row.ExpiresAt = &expiresAt
+	}
+	return row
+}
+
+func (LinkRow) header() []string {
+	return []string{
+		"short_code", "original_url", "created_at", "expires_at",
+		"total_clicks", "human_clicks", "bot_clicks", "unique_visitors",
+	}
+}
+
+func (r LinkRow) record() []string {
+	var expiresAt string
+	if r.ExpiresAt != nil {
+		expiresAt = formatTime(*r.ExpiresAt)
+	}
+	return []string{
+		r.ShortCode, r.OriginalURL, formatTime(r.CreatedAt), expiresAt,
+		strconv.FormatInt(r.TotalClicks, 10), strconv.FormatInt(r.HumanClicks, 10),
+		strconv.FormatInt(r.BotClicks, 10), strconv.FormatInt(r.UniqueVisitors, 10),
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,107 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/snappy"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+const (
+	// parquetBatchSize is how many rows are handed to the Parquet writer at once
+	parquetBatchSize = 512
+	// maxRowsPerRowGroup bounds the rows the Parquet writer buffers before
+	// flushing a row group
+	maxRowsPerRowGroup = 10000
+)
+
+// Writer encodes rows of type T in one of the export formats. Close must be
+// called to flush buffered rows; it does not close the underlying writer.
+type Writer[T Row] struct {
+	format  Format
+	csv     *csv.Writer
+	json    *json.Encoder
+	parquet *parquet.GenericWriter[T]
+	batch   []T
+}
+
+// NewWriter returns a Writer of format on w. CSV headers are written
+// immediately so an empty export still names its columns.
+func NewWriter[T Row](w io.Writer, format Format) (*Writer[T], error) {
+	writer := &Writer[T]{format: format}
+	switch format {
+	case CSV:
+		writer.csv = csv.NewWriter(w)
+		var zero T
+		if err := writer.csv.Write(zero.header()); err != nil {
+			return nil, fmt.Errorf("failed to write CSV header: %w", err)
+		}
+	case JSONL:
+		writer.json = json.NewEncoder(w)
+		writer.json.SetEscapeHTML(false)
+	case Parquet:
+		writer.parquet = parquet.NewGenericWriter[T](w,
+			parquet.Compression(&snappy.Codec{}),
+			parquet.MaxRowsPerRowGroup(maxRowsPerRowGroup),
+		)
+		writer.batch = make([]T, 0, parquetBatchSize)
+	default:
+		return nil, fmt.Errorf("%w: unknown format %q", models.ErrInvalidExport, format)
+	}
+	return writer, nil
+}
+
+// Write encodes row
+func (w *Writer[T]) Write(row T) error {
+	switch w.format {
+	case CSV:
+		if err := w.csv.Write(row.record()); err != nil {
+			return fmt.Errorf("failed to write CSV row: %w", err)
+		}
+	case JSONL:
+		if err := w.json.Encode(row); err != nil {
+			return fmt.Errorf("failed to write JSON row: %w", err)
+		}
+	case Parquet:
+		w.batch = append(w.batch, row)
+		if len(w.batch) == parquetBatchSize {
+			return w.flushBatch()
+		}
+	}
+	return nil
+}
+
+// Close flushes buffered rows and, for Parquet, writes the file footer
+func (w *Writer[T]) Close() error {
+	switch w.format {
+	case CSV:
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return fmt.Errorf("failed to write CSV: %w", err)
+		}
+	case Parquet:
+		if err := w.flushBatch(); err != nil {
+			return err
+		}
+		if err := w.parquet.Close(); err != nil {
+			return fmt.Errorf("failed to write Parquet footer: %w", err)
+		}
+	}
+	return nil
+}
+
+func (w *Writer[T]) flushBatch() error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+	if _, err := w.parquet.Write(w.batch); err != nil {
+		return fmt.Errorf("failed to write Parquet rows: %w", err)
+	}
+	w.batch = w.batch[:0]
+	return nil
+}
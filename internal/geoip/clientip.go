@@ -0,0 +1,50 @@
+package geoip
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// forwardedForHeader lists the client and each proxy a request went through
+const forwardedForHeader = "X-Forwarded-For"
+
+// ClientIP returns the IP of the client that sent a request. X-Forwarded-For
+// can be forged by clients, so it is only used when trustedHops proxies we
+// control sit in front of the server: the entry the outermost of them
+// appended is the client. Otherwise, or when the header is too short, the
+// address of the connection is used.
+func ClientIP(remoteAddr, forwardedFor string, trustedHops int) string {
+	if trustedHops > 0 && forwardedFor != "" {
+		hops := strings.Split(forwardedFor, ",")
+		if len(hops) >= trustedHops {
+			if ip := strings.TrimSpace(hops[len(hops)-trustedHops]); net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// PeerIP returns the IP of the client of a gRPC call, honouring
+// x-forwarded-for metadata like ClientIP
+func PeerIP(ctx context.Context, trustedHops int) string {
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+
+	var forwardedFor string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		forwardedFor = strings.Join(md.Get(forwardedForHeader), ",")
+	}
+	return ClientIP(remoteAddr, forwardedFor, trustedHops)
+}
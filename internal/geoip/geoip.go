@@ -0,0 +1,165 @@
+// Package geoip resolves client IPs to countries with a local MaxMind DB
+// (MMDB) file, such as GeoLite2-Country, so no external service is called.
+package geoip
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+const defaultReloadInterval = time.Minute
+
+// countryRecord is the part of a GeoIP2/GeoLite2 Country or City record we use
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Resolver looks up countries in an MMDB file. The file is polled and
+// reloaded when it changes, so a new database can be dropped in place
+// without a restart. A database that fails to load is logged and the
+// previous one stays in use.
+type Resolver struct {
+	path     string
+	interval time.Duration
+
+	mu      sync.RWMutex
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Option configures a Resolver
+type Option func(*Resolver)
+
+// WithReloadInterval sets how often the file is checked for changes
+func WithReloadInterval(interval time.Duration) Option {
+	return func(r *Resolver) {
+		if interval > 0 {
+			r.interval = interval
+		}
+	}
+}
+
+// Open loads the database at path and starts watching it for changes
+func Open(path string, opts ...Option) (*Resolver, error) {
+	r := &Resolver{
+		path:     path,
+		interval: defaultReloadInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	go r.watch()
+	return r, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of ip, or an empty string when
+// ip is invalid or not in the database
+func (r *Resolver) Country(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var record countryRecord
+	if err := r.reader.Lookup(parsed, &record); err != nil {
+		return ""
+	}
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode
+	}
+	// Fall back to the country the network is registered in, e.g. for
+	// anycast ranges without a located country
+	return record.RegisteredCountry.ISOCode
+}
+
+// Reload loads the file again if its size or modification time changed and
+// reports whether it did
+func (r *Resolver) Reload() (bool, error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat GeoIP database: %w", err)
+	}
+
+	r.mu.RLock()
+	unchanged := r.reader != nil && info.ModTime().Equal(r.modTime) && info.Size() == r.size
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	// Read the file into memory instead of mapping it, so replacing it in
+	// place cannot pull pages from under running lookups
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read GeoIP database: %w", err)
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	if err := reader.Verify(); err != nil {
+		return false, fmt.Errorf("invalid GeoIP database: %w", err)
+	}
+
+	r.mu.Lock()
+	r.reader = reader
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+	r.mu.Unlock()
+
+	return true, nil
+}
+
+// watch reloads the database every interval until Close is called
+func (r *Resolver) watch() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				log.Printf("Failed to reload GeoIP database: %v", err)
+			} else if reloaded {
+				log.Printf("Reloaded GeoIP database %s", r.path)
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Close stops watching the file
+func (r *Resolver) Close() error {
+	close(r.stop)
+	<-r.done
+	return nil
+}
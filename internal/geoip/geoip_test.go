@@ -0,0 +1,301 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// mmdbEncoder writes values in the MaxMind DB data format
+type mmdbEncoder struct {
+	bytes.Buffer
+}
+
+const (
+	mmdbString = 2
+	mmdbUint16 = 5
+	mmdbUint32 = 6
+	mmdbMap    = 7
+	mmdbUint64 = 9
+	mmdbArray  = 11
+)
+
+func (e *mmdbEncoder) control(kind, size int) {
+	if kind < 8 {
+		e.WriteByte(byte(kind<<5 | size))
+		return
+	}
+	// Extended types store their type in a second byte
+	e.WriteByte(byte(size))
+	e.WriteByte(byte(kind - 7))
+}
+
+func (e *mmdbEncoder) string(s string) {
+	e.control(mmdbString, len(s))
+	e.WriteString(s)
+}
+
+func (e *mmdbEncoder) uint(kind int, v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	trimmed := bytes.TrimLeft(buf[:], "\x00")
+	e.control(kind, len(trimmed))
+	e.Write(trimmed)
+}
+
+// record encodes a country record. A "registered:" prefix stores the code
+// as the registered country only.
+func (e *mmdbEncoder) record(country string) {
+	key := "country"
+	if code, ok := strings.CutPrefix(country, "registered:"); ok {
+		key, country = "registered_country", code
+	}
+	e.control(mmdbMap, 1)
+	e.string(key)
+	e.control(mmdbMap, 1)
+	e.string("iso_code")
+	e.string(country)
+}
+
+// testNode is a node of the search tree under construction. Each side holds
+// either the next node or a data offset, or neither.
+type testNode struct {
+	next [2]int
+	data [2]int
+}
+
+// writeTestDatabase writes an IPv6 country database mapping each CIDR of
+// networks to a country
+func writeTestDatabase(t *testing.T, path string, networks map[string]string) {
+	t.Helper()
+
+	// Data section: one record per distinct country
+	var data mmdbEncoder
+	offsets := make(map[string]int)
+	countries := make([]string, 0, len(networks))
+	for _, country := range networks {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+	for _, country := range countries {
+		if _, ok := offsets[country]; !ok {
+			offsets[country] = data.Len()
+			data.record(country)
+		}
+	}
+
+	// Search tree: IPv4 networks live under ::/96
+	nodes := []testNode{{next: [2]int{-1, -1}, data: [2]int{-1, -1}}}
+	for cidr, country := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("invalid test network %s: %v", cidr, err)
+		}
+		ones, _ := network.Mask.Size()
+		ip := network.IP.To16()
+		if ipv4 := network.IP.To4(); ipv4 != nil {
+			ip = append(make(net.IP, 12), ipv4...)
+			ones += 96
+		}
+
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i/8]>>(7-i%8)) & 1
+			if i == ones-1 {
+				nodes[node].data[bit] = offsets[country]
+				break
+			}
+			if nodes[node].next[bit] < 0 {
+				nodes = append(nodes, testNode{next: [2]int{-1, -1}, data: [2]int{-1, -1}})
+				nodes[node].next[bit] = len(nodes) - 1
+			}
+			node = nodes[node].next[bit]
+		}
+	}
+
+	var file bytes.Buffer
+	nodeCount := len(nodes)
+	for _, node := range nodes {
+		for side := 0; side < 2; side++ {
+			record := nodeCount
+			if node.next[side] >= 0 {
+				record = node.next[side]
+			} else if node.data[side] >= 0 {
+				record = nodeCount + 16 + node.data[side]
+			}
+			file.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	file.Write(make([]byte, 16))
+	file.Write(data.Bytes())
+
+	// Metadata
+	var meta mmdbEncoder
+	meta.control(mmdbMap, 9)
+	meta.string("binary_format_major_version")
+	meta.uint(mmdbUint16, 2)
+	meta.string("binary_format_minor_version")
+	meta.uint(mmdbUint16, 0)
+	meta.string("build_epoch")
+	meta.uint(mmdbUint64, uint64(time.Now().Unix()))
+	meta.string("database_type")
+	meta.string("Test-Country")
+	meta.string("description")
+	meta.control(mmdbMap, 1)
+	meta.string("en")
+	meta.string("Test country database")
+	meta.string("ip_version")
+	meta.uint(mmdbUint16, 6)
+	meta.string("languages")
+	meta.control(mmdbArray, 1)
+	meta.string("en")
+	meta.string("node_count")
+	meta.uint(mmdbUint32, uint64(nodeCount))
+	meta.string("record_size")
+	meta.uint(mmdbUint16, 24)
+	file.WriteString("\xab\xcd\xefMaxMind.com")
+	file.Write(meta.Bytes())
+
+	// Replace the file atomically like a database update would
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, file.Bytes(), 0o644); err != nil {
+		t.Fatalf("Failed to write test database: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("Failed to write test database: %v", err)
+	}
+}
+
+func testNetworks() map[string]string {
+	return map[string]string{
+		"81.2.69.0/24":    "GB",
+		"203.0.113.0/24":  "NL",
+		"2001:db8::/32":   "JP",
+		"198.51.100.0/24": "registered:US",
+	}
+}
+
+func TestResolver_Country(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeTestDatabase(t, path, testNetworks())
+
+	resolver, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer resolver.Close()
+
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"81.2.69.142", "GB"},
+		{"203.0.113.7", "NL"},
+		{"2001:db8::1", "JP"},
+		{"198.51.100.1", "US"},
+		{"8.8.8.8", ""},
+		{"2001:db9::1", ""},
+		{"not-an-ip", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := resolver.Country(tt.ip); got != tt.expected {
+			t.Errorf("Country(%q) = %q, want %q", tt.ip, got, tt.expected)
+		}
+	}
+}
+
+func TestResolver_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeTestDatabase(t, path, testNetworks())
+
+	resolver, err := Open(path, WithReloadInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer resolver.Close()
+
+	// The network moves to another country in the new database
+	writeTestDatabase(t, path, map[string]string{"81.2.69.0/24": "IE"})
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for resolver.Country("81.2.69.142") != "IE" {
+		if time.Now().After(deadline) {
+			t.Fatal("database was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A broken file is rejected and the loaded database stays in use
+	if err := os.WriteFile(path, []byte("not a database"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := resolver.Reload(); err == nil {
+		t.Error("Reload() of a broken file should fail")
+	}
+	if got := resolver.Country("81.2.69.142"); got != "IE" {
+		t.Errorf("Country() after a failed reload = %q, want IE", got)
+	}
+}
+
+func TestOpen_Missing(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Error("Open() of a missing file should fail")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		trustedHops  int
+		expected     string
+	}{
+		{"remote address", "203.0.113.7:5000", "", 0, "203.0.113.7"},
+		{"IPv6 remote address", "[2001:db8::1]:5000", "", 0, "2001:db8::1"},
+		{"untrusted header", "10.0.0.2:5000", "203.0.113.7", 0, "10.0.0.2"},
+		{"one proxy", "10.0.0.2:5000", "198.51.100.1, 203.0.113.7", 1, "203.0.113.7"},
+		{"two proxies", "10.0.0.2:5000", "198.51.100.1, 203.0.113.7, 10.0.0.1", 2, "203.0.113.7"},
+		{"short header", "10.0.0.2:5000", "203.0.113.7", 2, "10.0.0.2"},
+		{"invalid entry", "10.0.0.2:5000", "unknown", 1, "10.0.0.2"},
+		{"no port", "203.0.113.7", "", 0, "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClientIP(tt.remoteAddr, tt.forwardedFor, tt.trustedHops); got != tt.expected {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPeerIP(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5000},
+	})
+	if got := PeerIP(ctx, 1); got != "10.0.0.2" {
+		t.Errorf("PeerIP() = %q, want the peer address", got)
+	}
+
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", "203.0.113.7"))
+	if got := PeerIP(ctx, 1); got != "203.0.113.7" {
+		t.Errorf("PeerIP() = %q, want the forwarded address", got)
+	}
+	if got := PeerIP(ctx, 0); got != "10.0.0.2" {
+		t.Errorf("PeerIP() = %q, want the peer address without trusted proxies", got)
+	}
+}
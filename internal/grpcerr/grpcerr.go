@@ -0,0 +1,131 @@
+// Package grpcerr translates domain errors into gRPC statuses so clients in
+// any language can act on the status code instead of parsing messages.
+package grpcerr
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Domain is the ErrorInfo domain of errors raised by this service
+	Domain = "urlshortener"
+
+	// ReasonURLExpired is the ErrorInfo reason of an expired short URL
+	ReasonURLExpired = "URL_EXPIRED"
+	// PreconditionExpired is the PreconditionFailure type of an expired URL
+	PreconditionExpired = "EXPIRED"
+
+	internalMessage = "internal error"
+)
+
+// shortCodeRequest matches the generated requests that carry a short code
+type shortCodeRequest interface {
+	GetShortCode() string
+}
+
+// UnaryServerInterceptor translates the errors returned by every unary
+// handler. Short codes of the request are attached to the error details.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+
+		var shortCode string
+		if r, ok := req.(shortCodeRequest); ok {
+			shortCode = r.GetShortCode()
+		}
+		st := Status(err, shortCode)
+		if st.Code() == codes.Internal {
+			log.Printf("%s: %v", info.FullMethod, err)
+		}
+		return nil, st.Err()
+	}
+}
+
+// StreamServerInterceptor translates the errors returned by every streaming
+// handler
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		if err == nil {
+			return nil
+		}
+
+		st := Status(err, "")
+		if st.Code() == codes.Internal {
+			log.Printf("%s: %v", info.FullMethod, err)
+		}
+		return st.Err()
+	}
+}
+
+// Status maps err to a gRPC status. Errors that already carry a status are
+// kept; errors that are not part of the domain are reported as Internal
+// without their message so storage details never reach clients. shortCode
+// is optional and identifies the URL in the details of expired errors.
+func Status(err error, shortCode string) *status.Status {
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.New(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.New(codes.DeadlineExceeded, err.Error())
+	case models.IsValidationError(err):
+		return status.New(codes.InvalidArgument, err.Error())
+	case errors.Is(err, models.ErrURLNotFound):
+		return status.New(codes.NotFound, err.Error())
+	case errors.Is(err, models.ErrURLExpired):
+		return expiredStatus(err, shortCode)
+	case errors.Is(err, models.ErrURLQuarantined), errors.Is(err, models.ErrNotQuarantined):
+		return status.New(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, models.ErrURLChanged):
+		return status.New(codes.Aborted, err.Error())
+	case errors.Is(err, models.ErrDuplicateShortCode), errors.Is(err, models.ErrAliasTaken):
+		return status.New(codes.AlreadyExists, err.Error())
+	case errors.Is(err, models.ErrResumeExpired):
+		return status.New(codes.OutOfRange, err.Error())
+	case errors.Is(err, models.ErrSubscriberLagging):
+		return status.New(codes.ResourceExhausted, err.Error())
+	}
+	return status.New(codes.Internal, internalMessage)
+}
+
+// expiredStatus describes an expired URL with ErrorInfo and
+// PreconditionFailure details
+func expiredStatus(err error, shortCode string) *status.Status {
+	st := status.New(codes.FailedPrecondition, err.Error())
+
+	info := &errdetails.ErrorInfo{
+		Reason: ReasonURLExpired,
+		Domain: Domain,
+	}
+	if shortCode != "" {
+		info.Metadata = map[string]string{"short_code": shortCode}
+	}
+	failure := &errdetails.PreconditionFailure{
+		Violations: []*errdetails.PreconditionFailure_Violation{{
+			Type:        PreconditionExpired,
+			Subject:     shortCode,
+			Description: "the short URL has passed its expiration time",
+		}},
+	}
+
+	detailed, detailErr := st.WithDetails(info, failure)
+	if detailErr != nil {
+		return st
+	}
+	return detailed
+}
@@ -0,0 +1,75 @@
+package grpcerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		expectedCode    codes.Code
+		expectedMessage string
+	}{
+		{"existing status", status.Error(codes.Unauthenticated, "no token"), codes.Unauthenticated, "no token"},
+		{"canceled", fmt.Errorf("lookup: %w", context.Canceled), codes.Canceled, "lookup: context canceled"},
+		{"deadline", context.DeadlineExceeded, codes.DeadlineExceeded, "context deadline exceeded"},
+		{"empty URL", models.ErrEmptyURL, codes.InvalidArgument, models.ErrEmptyURL.Error()},
+		{"wrapped validation", fmt.Errorf("alias %q: %w", "a b", models.ErrInvalidAlias), codes.InvalidArgument, `alias "a b": invalid alias`},
+		{"not found", fmt.Errorf("failed to get URL: %w", models.ErrURLNotFound), codes.NotFound, "failed to get URL: URL not found"},
+		{"expired", models.ErrURLExpired, codes.FailedPrecondition, models.ErrURLExpired.Error()},
+		{"quarantined", models.ErrURLQuarantined, codes.FailedPrecondition, models.ErrURLQuarantined.Error()},
+		{"changed", models.ErrURLChanged, codes.Aborted, models.ErrURLChanged.Error()},
+		{"flagged", fmt.Errorf("%w: malware", models.ErrURLFlagged), codes.InvalidArgument, "URL is flagged as malicious: malware"},
+		{"duplicate", models.ErrDuplicateShortCode, codes.AlreadyExists, models.ErrDuplicateShortCode.Error()},
+		{"alias taken", models.ErrAliasTaken, codes.AlreadyExists, models.ErrAliasTaken.Error()},
+		{"resume expired", models.ErrResumeExpired, codes.OutOfRange, models.ErrResumeExpired.Error()},
+		{"lagging subscriber", models.ErrSubscriberLagging, codes.ResourceExhausted, models.ErrSubscriberLagging.Error()},
+		{"storage failure", errors.New("dial tcp 10.0.0.1:8000: connection refused"), codes.Internal, internalMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := Status(tt.err, "abc123")
+			if st.Code() != tt.expectedCode {
+				t.Errorf("Status() code = %v, want %v", st.Code(), tt.expectedCode)
+			}
+			if st.Message() != tt.expectedMessage {
+				t.Errorf("Status() message = %q, want %q", st.Message(), tt.expectedMessage)
+			}
+		})
+	}
+}
+
+func TestStatus_ExpiredDetails(t *testing.T) {
+	st := Status(models.ErrURLExpired, "abc123")
+
+	var info *errdetails.ErrorInfo
+	var failure *errdetails.PreconditionFailure
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			info = d
+		case *errdetails.PreconditionFailure:
+			failure = d
+		}
+	}
+
+	if info == nil || failure == nil {
+		t.Fatalf("Status() details = %v, want ErrorInfo and PreconditionFailure", st.Details())
+	}
+	if info.Reason != ReasonURLExpired || info.Domain != Domain || info.Metadata["short_code"] != "abc123" {
+		t.Errorf("ErrorInfo = %v", info)
+	}
+	if len(failure.Violations) != 1 || failure.Violations[0].Type != PreconditionExpired || failure.Violations[0].Subject != "abc123" {
+		t.Errorf("PreconditionFailure = %v", failure)
+	}
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+//go:embed blocked.html
+var defaultBlockPage string
+
+// defaultBlock is the page served when no other is given
+var defaultBlock = &BlockPage{
+	status:   http.StatusForbidden,
+	template: template.Must(template.New("blocked").Parse(defaultBlockPage)),
+}
+
+// Reasons a block page is served, completing "which ..."
+const (
+	reasonDomainBlocked = "is no longer allowed"
+	reasonFlagged       = "has been flagged as unsafe"
+)
+
+// BlockPage is served instead of redirecting to a blocked domain or a
+// quarantined URL
+type BlockPage struct {
+	status   int
+	template *template.Template
+}
+
+// blockPageData is what block page templates can refer to
+type blockPageData struct {
+	ShortCode string
+	Domain    string
+	Reason    string
+}
+
+// NewBlockPage parses the HTML template at path, or uses the embedded page
+// when path is empty, to be served with status. Templates can refer to
+// {{.ShortCode}}, {{.Domain}} and {{.Reason}}, which completes a sentence
+// like "which is no longer allowed".
+func NewBlockPage(path string, status int) (*BlockPage, error) {
+	if path == "" {
+		return &BlockPage{status: status, template: defaultBlock.template}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block page: %w", err)
+	}
+	tmpl, err := template.New("blocked").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse block page: %w", err)
+	}
+	return &BlockPage{status: status, template: tmpl}, nil
+}
+
+// render returns the page for a link to domain blocked for reason
+func (p *BlockPage) render(shortCode, domain, reason string) Response {
+	var body strings.Builder
+	data := blockPageData{ShortCode: shortCode, Domain: domain, Reason: reason}
+	if err := p.template.Execute(&body, data); err != nil {
+		return errorResponse(p.status, "Link is blocked")
+	}
+
+	return Response{
+		StatusCode: p.status,
+		Headers: map[string]string{
+			"Content-Type": "text/html; charset=utf-8",
+			// Rules and lists can change, so caches must not keep the page in place of
+			// the redirect
+			"Cache-Control": "no-store",
+		},
+		Body: body.String(),
+	}
+}
+
+// destinationHost returns the host of a stored URL, or an empty string when
+// it cannot be parsed
+func destinationHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,372 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/screening"
+	"github.com/jingy/Go-Shortener/internal/storage"
+	"github.com/jingy/Go-Shortener/pkg/shortener"
+)
+
+// Response is a transport-agnostic HTTP response. The Lambda functions and the
+// standalone REST server translate it into their own response types so both
+// behave identically.
+type Response struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       string
+}
+
+// Handler implements the REST API on top of the shortener and storage layers
+type Handler struct {
+	shortener *shortener.Shortener
+	storage   storage.URLStore
+	recorder  *analytics.Recorder
+	stats     analytics.Store
+	// trustedHops is how many X-Forwarded-For entries come from our proxies
+	trustedHops int
+	domains     shortener.DomainChecker
+	screener    *screening.Screener
+	blockPage   *BlockPage
+}
+
+// Option configures a Handler
+type Option func(*Handler)
+
+// WithRecorder records a click event for every successful redirect
+func WithRecorder(recorder *analytics.Recorder) Option {
+	return func(h *Handler) {
+		h.recorder = recorder
+	}
+}
+
+// WithStats serves the click statistics of stats
+func WithStats(stats analytics.Store) Option {
+	return func(h *Handler) {
+		h.stats = stats
+	}
+}
+
+// WithTrustedProxyHops trusts the last hops entries of X-Forwarded-For to
+// find the client IP of a click
+func WithTrustedProxyHops(hops int) Option {
+	return func(h *Handler) {
+		h.trustedHops = hops
+	}
+}
+
+// WithDomainPolicy checks the destination domain of every redirect against
+// checker and serves page instead of redirecting to a blocked one. A nil
+// page keeps the one of another option, or the embedded page with status
+// 403.
+func WithDomainPolicy(checker shortener.DomainChecker, page *BlockPage) Option {
+	return func(h *Handler) {
+		h.domains = checker
+		if page != nil {
+			h.blockPage = page
+		}
+	}
+}
+
+// WithScreener screens the destination of every redirect with screener,
+// quarantining links that became flagged, and serves page instead of
+// redirecting to a quarantined one, or like WithDomainPolicy when page is
+// nil. Links quarantined in storage are blocked even without a screener.
+func WithScreener(screener *screening.Screener, page *BlockPage) Option {
+	return func(h *Handler) {
+		h.screener = screener
+		if page != nil {
+			h.blockPage = page
+		}
+	}
+}
+
+func New(shortener *shortener.Shortener, storage storage.URLStore, opts ...Option) *Handler {
+	h := &Handler{
+		shortener: shortener,
+		storage:   storage,
+		blockPage: defaultBlock,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Create handles POST /create with a JSON models.CreateURLRequest body
+func (h *Handler) Create(ctx context.Context, body []byte) Response {
+	// Parse request body
+	var req models.CreateURLRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return errorResponse(400, "Invalid request body")
+	}
+
+	if err := req.Validate(); err != nil {
+		return errorResponse(400, err.Error())
+	}
+
+	// Create and store the short URL
+	url, err := h.shortener.CreateShortURL(ctx, req.URL, createOptions(&req))
+	if err != nil {
+		if errors.Is(err, models.ErrAliasTaken) {
+			return errorResponse(409, err.Error())
+		}
+		if models.IsValidationError(err) {
+			return errorResponse(400, err.Error())
+		}
+		return errorResponse(500, "Failed to create short URL")
+	}
+
+	// Prepare response
+	response := models.CreateURLResponse{
+		ShortCode: url.ShortCode,
+		ShortURL:  url.ShortURL,
+	}
+	if url.IsQuarantined() {
+		response.Status = url.Status
+	}
+	if !url.ExpiresAt.IsZero() {
+		response.ExpiresAt = &url.ExpiresAt
+	}
+
+	return jsonResponse(201, response)
+}
+
+// createOptions converts a validated request into shortener options
+func createOptions(req *models.CreateURLRequest) shortener.CreateOptions {
+	var opts shortener.CreateOptions
+	if req.ExpiresAt != nil {
+		opts.ExpiresAt = *req.ExpiresAt
+	}
+	opts.ExpiresIn, _ = req.ExpiresInDuration()
+	opts.Alias = req.Alias
+	return opts
+}
+
+// Redirect handles GET /{shortCode} for visitor
+func (h *Handler) Redirect(ctx context.Context, shortCode string, visitor analytics.Visitor) Response {
+	if shortCode == "" {
+		return errorResponse(400, "Missing short code")
+	}
+
+	// Get URL from storage
+	url, err := h.storage.Get(ctx, shortCode)
+	if err != nil {
+		return lookupError(err)
+	}
+
+	// Domains blocked after the link was created are not redirected to
+	if h.domains != nil {
+		host := destinationHost(url.OriginalURL)
+		if err := h.domains.Check(host); err != nil {
+			return h.blockPage.render(url.ShortCode, host, reasonDomainBlocked)
+		}
+	}
+	// Neither are quarantined links and links flagged since
+	if err := h.screener.CheckRedirect(ctx, h.storage, url); err != nil {
+		return h.blockPage.render(url.ShortCode, destinationHost(url.OriginalURL), reasonFlagged)
+	}
+
+	// Queue the click without delaying the redirect
+	if h.recorder != nil {
+		h.recorder.Record(url.ShortCode, visitor)
+	}
+
+	// Return redirect response
+	return Response{
+		StatusCode: 302,
+		Headers: map[string]string{
+			"Location": url.OriginalURL,
+		},
+	}
+}
+
+// StatsResponse is the body of GET /stats/{shortCode}
+type StatsResponse struct {
+	ShortCode          string     `json:"shortCode"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	ExpiresAt          *time.Time `json:"expiresAt,omitempty"`
+	TotalClicks        int64      `json:"totalClicks"`
+	HumanClicks        int64      `json:"humanClicks"`
+	UniqueVisitors     int64      `json:"uniqueVisitors"`
+	UniqueVisitorsDay  int64      `json:"uniqueVisitorsDay"`
+	UniqueVisitorsWeek int64      `json:"uniqueVisitorsWeek"`
+	// ClicksByHour is keyed by the UTC hour of day
+	ClicksByHour        map[int]int64    `json:"clicksByHour"`
+	ClicksByCountry     map[string]int64 `json:"clicksByCountry"`
+	BotClicks           map[string]int64 `json:"botClicks"`
+	ClicksByReferrer    map[string]int64 `json:"clicksByReferrer"`
+	ClicksByUTMSource   map[string]int64 `json:"clicksByUtmSource"`
+	ClicksByUTMMedium   map[string]int64 `json:"clicksByUtmMedium"`
+	ClicksByUTMCampaign map[string]int64 `json:"clicksByUtmCampaign"`
+	ClicksByDevice      map[string]int64 `json:"clicksByDevice"`
+	ClicksByOS          map[string]int64 `json:"clicksByOs"`
+	ClicksByBrowser     map[string]int64 `json:"clicksByBrowser"`
+}
+
+// Stats handles GET /stats/{shortCode}. The optional top param sets how
+// many values are kept per referrer, UTM, device, OS and browser breakdown.
+func (h *Handler) Stats(ctx context.Context, shortCode string, params map[string]string) Response {
+	if h.stats == nil {
+		return errorResponse(404, "Not found")
+	}
+
+	var top int
+	var err error
+	if value := params["top"]; value != "" {
+		if top, err = strconv.Atoi(value); err != nil {
+			return errorResponse(400, fmt.Sprintf("%v: top must be a number", models.ErrInvalidTop))
+		}
+	}
+	if top, err = analytics.TopLimit(top); err != nil {
+		return errorResponse(400, err.Error())
+	}
+
+	url, err := h.storage.Get(ctx, shortCode)
+	if err != nil {
+		return lookupError(err)
+	}
+
+	stats, err := h.stats.GetStats(ctx, shortCode)
+	if err != nil {
+		return errorResponse(500, "Failed to retrieve stats")
+	}
+	stats = stats.Top(top)
+
+	now := time.Now()
+	response := StatsResponse{
+		ShortCode:           shortCode,
+		CreatedAt:           url.CreatedAt,
+		TotalClicks:         stats.TotalClicks,
+		HumanClicks:         stats.HumanClicks(),
+		UniqueVisitors:      stats.Visitors.All.Estimate(),
+		UniqueVisitorsDay:   stats.Visitors.Since(now),
+		UniqueVisitorsWeek:  stats.Visitors.Since(now.AddDate(0, 0, -6)),
+		ClicksByHour:        stats.ClicksByHour,
+		ClicksByCountry:     stats.ClicksByCountry,
+		BotClicks:           stats.BotClicks,
+		ClicksByReferrer:    stats.ClicksByReferrer,
+		ClicksByUTMSource:   stats.ClicksBySource,
+		ClicksByUTMMedium:   stats.ClicksByMedium,
+		ClicksByUTMCampaign: stats.ClicksByCampaign,
+		ClicksByDevice:      stats.ClicksByDevice,
+		ClicksByOS:          stats.ClicksByOS,
+		ClicksByBrowser:     stats.ClicksByBrowser,
+	}
+	if !url.ExpiresAt.IsZero() {
+		response.ExpiresAt = &url.ExpiresAt
+	}
+
+	return jsonResponse(200, response)
+}
+
+// TimeSeriesResponse is the body of GET /stats/{shortCode}/timeseries
+type TimeSeriesResponse struct {
+	ShortCode   string            `json:"shortCode"`
+	Granularity string            `json:"granularity"`
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	Points      []analytics.Point `json:"points"`
+}
+
+// TimeSeries handles GET /stats/{shortCode}/timeseries. The optional params
+// are granularity (minute, hour or day) and the RFC 3339 times from and to.
+func (h *Handler) TimeSeries(ctx context.Context, shortCode string, params map[string]string) Response {
+	if h.stats == nil {
+		return errorResponse(404, "Not found")
+	}
+
+	query, err := timeSeriesQuery(params)
+	if err == nil {
+		err = query.Normalize(time.Now())
+	}
+	if err != nil {
+		return errorResponse(400, err.Error())
+	}
+
+	if _, err := h.storage.Get(ctx, shortCode); err != nil {
+		return lookupError(err)
+	}
+
+	points, err := h.stats.GetTimeSeries(ctx, shortCode, query)
+	if err != nil {
+		return errorResponse(500, "Failed to retrieve time series")
+	}
+
+	return jsonResponse(200, TimeSeriesResponse{
+		ShortCode:   shortCode,
+		Granularity: query.Granularity.String(),
+		From:        query.From,
+		To:          query.To,
+		Points:      points,
+	})
+}
+
+// timeSeriesQuery parses the query parameters of a time series request
+func timeSeriesQuery(params map[string]string) (analytics.TimeSeriesQuery, error) {
+	var query analytics.TimeSeriesQuery
+	var err error
+	if value := params["granularity"]; value != "" {
+		if query.Granularity, err = analytics.ParseGranularity(value); err != nil {
+			return query, err
+		}
+	}
+	if value := params["from"]; value != "" {
+		if query.From, err = time.Parse(time.RFC3339, value); err != nil {
+			return query, fmt.Errorf("%w: from must be an RFC 3339 time", models.ErrInvalidTimeRange)
+		}
+	}
+	if value := params["to"]; value != "" {
+		if query.To, err = time.Parse(time.RFC3339, value); err != nil {
+			return query, fmt.Errorf("%w: to must be an RFC 3339 time", models.ErrInvalidTimeRange)
+		}
+	}
+	return query, nil
+}
+
+// lookupError maps a failed storage lookup of a short code to a response
+func lookupError(err error) Response {
+	if errors.Is(err, models.ErrURLNotFound) {
+		return errorResponse(404, "URL not found")
+	}
+	if errors.Is(err, models.ErrURLExpired) {
+		return errorResponse(410, "URL has expired")
+	}
+	return errorResponse(500, "Failed to retrieve URL")
+}
+
+// jsonResponse marshals v as the response body
+func jsonResponse(statusCode int, v interface{}) Response {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return errorResponse(500, "Failed to generate response")
+	}
+
+	return Response{
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(body),
+	}
+}
+
+// errorResponse returns a JSON body of the form {"error": message}
+func errorResponse(statusCode int, message string) Response {
+	body, _ := json.Marshal(map[string]string{"error": message})
+
+	return Response{
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(body),
+	}
+}
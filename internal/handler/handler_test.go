@@ -0,0 +1,619 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/domains"
+	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/screening"
+	"github.com/jingy/Go-Shortener/internal/storage"
+	"github.com/jingy/Go-Shortener/pkg/shortener"
+)
+
+// setupTestHandler creates a handler backed by in-memory storage with a few
+// seeded URLs
+func setupTestHandler(t *testing.T) *Handler {
+	store := storage.NewMemoryStorage()
+
+	active := models.NewURL("https://example.com/active", "active")
+	expired := models.NewURL("https://example.com/expired", "expired")
+	expired.ExpiresAt = time.Now().Add(-time.Hour)
+	for _, url := range []*models.URL{active, expired} {
+		if err := store.Create(context.Background(), url); err != nil {
+			t.Fatalf("Failed to seed storage: %v", err)
+		}
+	}
+
+	return New(shortener.NewShortener("https://sho.rt", storage.NewMemoryCounter(), shortener.WithStore(store)), store)
+}
+
+func TestHandler_Create(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "valid URL",
+			method:         http.MethodPost,
+			body:           `{"url": "https://example.com"}`,
+			expectedStatus: 201,
+		},
+		{
+			name:           "invalid JSON",
+			method:         http.MethodPost,
+			body:           `{"url":`,
+			expectedStatus: 400,
+		},
+		{
+			name:           "invalid URL",
+			method:         http.MethodPost,
+			body:           `{"url": "not-a-url"}`,
+			expectedStatus: 400,
+		},
+		{
+			name:           "relative expiration",
+			method:         http.MethodPost,
+			body:           `{"url": "https://example.com", "expiresIn": "24h"}`,
+			expectedStatus: 201,
+		},
+		{
+			name:           "absolute expiration",
+			method:         http.MethodPost,
+			body:           `{"url": "https://example.com", "expiresAt": "2999-01-01T00:00:00Z"}`,
+			expectedStatus: 201,
+		},
+		{
+			name:           "expiration in the past",
+			method:         http.MethodPost,
+			body:           `{"url": "https://example.com", "expiresAt": "2000-01-01T00:00:00Z"}`,
+			expectedStatus: 400,
+		},
+		{
+			name:           "invalid duration",
+			method:         http.MethodPost,
+			body:           `{"url": "https://example.com", "expiresIn": "soon"}`,
+			expectedStatus: 400,
+		},
+		{
+			name:           "alias",
+			method:         http.MethodPost,
+			body:           `{"url": "https://example.com", "alias": "spring-sale"}`,
+			expectedStatus: 201,
+		},
+		{
+			name:           "alias already taken",
+			method:         http.MethodPost,
+			body:           `{"url": "https://example.com", "alias": "active"}`,
+			expectedStatus: 409,
+		},
+		{
+			name:           "reserved alias",
+			method:         http.MethodPost,
+			body:           `{"url": "https://example.com", "alias": "health"}`,
+			expectedStatus: 400,
+		},
+		{
+			name:           "wrong method",
+			method:         http.MethodGet,
+			body:           "",
+			expectedStatus: 405,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTestHandler(t)
+
+			req := httptest.NewRequest(tt.method, "/create", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("status = %v, expected %v, body %s", rec.Code, tt.expectedStatus, rec.Body.String())
+			}
+
+			if tt.expectedStatus == 201 {
+				var resp models.CreateURLResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("Failed to decode response: %v", err)
+				}
+				if resp.ShortURL != "https://sho.rt/"+resp.ShortCode {
+					t.Errorf("ShortURL = %v, expected it to end with %v", resp.ShortURL, resp.ShortCode)
+				}
+				if expectsExpiry := strings.Contains(tt.body, "expires"); expectsExpiry != (resp.ExpiresAt != nil) {
+					t.Errorf("ExpiresAt = %v, expected set = %v", resp.ExpiresAt, expectsExpiry)
+				}
+			} else {
+				var resp map[string]string
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp["error"] == "" {
+					t.Errorf("expected JSON error body, got %s", rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_Redirect(t *testing.T) {
+	tests := []struct {
+		name             string
+		path             string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{
+			name:             "existing short code",
+			path:             "/active",
+			expectedStatus:   302,
+			expectedLocation: "https://example.com/active",
+		},
+		{
+			name:           "unknown short code",
+			path:           "/missing",
+			expectedStatus: 404,
+		},
+		{
+			name:           "expired short code",
+			path:           "/expired",
+			expectedStatus: 410,
+		},
+		{
+			name:           "missing short code",
+			path:           "/",
+			expectedStatus: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTestHandler(t)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("status = %v, expected %v", rec.Code, tt.expectedStatus)
+			}
+			if location := rec.Header().Get("Location"); location != tt.expectedLocation {
+				t.Errorf("Location = %v, expected %v", location, tt.expectedLocation)
+			}
+		})
+	}
+}
+
+func TestHandler_CreateThenRedirect(t *testing.T) {
+	h := setupTestHandler(t)
+
+	created := h.Create(context.Background(), []byte(`{"url": "https://example.com/new"}`))
+	if created.StatusCode != 201 {
+		t.Fatalf("Create() status = %v, body %s", created.StatusCode, created.Body)
+	}
+
+	var resp models.CreateURLResponse
+	if err := json.Unmarshal([]byte(created.Body), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	// The Lambda adapter must carry the same status and headers
+	redirect := ToAPIGateway(h.Redirect(context.Background(), resp.ShortCode, analytics.Visitor{}))
+	if redirect.StatusCode != 302 || redirect.Headers["Location"] != "https://example.com/new" {
+		t.Errorf("Redirect() = %v %v, expected 302 to https://example.com/new", redirect.StatusCode, redirect.Headers)
+	}
+}
+
+func TestHandler_RedirectRecordsClicks(t *testing.T) {
+	stats := analytics.NewMemoryStore()
+	recorder := analytics.NewRecorder(stats, analytics.WithSalt([]byte("salt")))
+	h := setupTestHandler(t)
+	h.recorder = recorder
+	WithTrustedProxyHops(1)(h)
+
+	// Over HTTP, behind CloudFront
+	req := httptest.NewRequest(http.MethodGet, "/active", nil)
+	req.RemoteAddr = "203.0.113.7:54321"
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("CloudFront-Viewer-Country", "NL")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Through API Gateway
+	request := events.APIGatewayProxyRequest{
+		Headers: map[string]string{"user-agent": "test-agent", "referer": "https://news.example"},
+	}
+	request.RequestContext.Identity.SourceIP = "203.0.113.7"
+	visitor := h.VisitorFromAPIGateway(request)
+	if visitor.IP != "203.0.113.7" || visitor.UserAgent != "test-agent" || visitor.Referrer != "https://news.example" {
+		t.Errorf("VisitorFromAPIGateway() = %+v", visitor)
+	}
+	h.Redirect(context.Background(), "active", visitor)
+
+	// Failed redirects are not clicks
+	h.Redirect(context.Background(), "expired", visitor)
+	h.Redirect(context.Background(), "missing", visitor)
+
+	if err := recorder.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	got, err := stats.GetStats(context.Background(), "active")
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	if got.TotalClicks != 2 {
+		t.Errorf("TotalClicks = %d, expected 2", got.TotalClicks)
+	}
+	if got.ClicksByCountry["NL"] != 1 {
+		t.Errorf("ClicksByCountry = %v, expected NL: 1", got.ClicksByCountry)
+	}
+	if expired, _ := stats.GetStats(context.Background(), "expired"); expired.TotalClicks != 0 {
+		t.Errorf("expired TotalClicks = %d, expected 0", expired.TotalClicks)
+	}
+}
+
+func TestHandler_VisitorCountry(t *testing.T) {
+	tests := []struct {
+		name        string
+		trustedHops int
+		header      string
+		expected    string
+	}{
+		{"behind a trusted proxy", 1, "NL", "NL"},
+		{"lower case", 1, "nl", "NL"},
+		{"without trusted proxies", 0, "NL", ""},
+		{"too long", 1, "NLD", ""},
+		{"not letters", 1, "N1", ""},
+		{"not ASCII", 1, "ñl", ""},
+		{"missing", 1, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTestHandler(t)
+			WithTrustedProxyHops(tt.trustedHops)(h)
+
+			req := httptest.NewRequest(http.MethodGet, "/active", nil)
+			req.Header.Set("CloudFront-Viewer-Country", tt.header)
+			if got := h.visitorFromRequest(req).Country; got != tt.expected {
+				t.Errorf("visitorFromRequest() country = %q, expected %q", got, tt.expected)
+			}
+
+			request := events.APIGatewayProxyRequest{Headers: map[string]string{"cloudfront-viewer-country": tt.header}}
+			if got := h.VisitorFromAPIGateway(request).Country; got != tt.expected {
+				t.Errorf("VisitorFromAPIGateway() country = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHandler_BotRedirect(t *testing.T) {
+	stats := analytics.NewMemoryStore()
+	recorder := analytics.NewRecorder(stats)
+	h := setupTestHandler(t)
+	WithRecorder(recorder)(h)
+
+	// Bots are redirected like people and only tagged in the stats
+	req := httptest.NewRequest(http.MethodGet, "/active", nil)
+	req.Header.Set("User-Agent", "Twitterbot/1.0")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Errorf("status = %d, expected %d", rec.Code, http.StatusFound)
+	}
+
+	if err := recorder.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	got, _ := stats.GetStats(context.Background(), "active")
+	if got.TotalClicks != 1 || got.BotClicks["preview"] != 1 {
+		t.Errorf("stats = %+v, expected 1 preview click", got)
+	}
+}
+
+func TestHandler_BlockedRedirect(t *testing.T) {
+	stats := analytics.NewMemoryStore()
+	recorder := analytics.NewRecorder(stats)
+	h := setupTestHandler(t)
+	WithRecorder(recorder)(h)
+
+	// example.com is blocked after the links were created
+	rules, err := domains.NewRules([]domains.Rule{{Pattern: "example.com", Action: domains.Block}})
+	if err != nil {
+		t.Fatalf("NewRules() error = %v", err)
+	}
+
+	t.Run("embedded page", func(t *testing.T) {
+		WithDomainPolicy(rules, nil)(h)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/active", nil))
+
+		if rec.Code != http.StatusForbidden || rec.Header().Get("Location") != "" {
+			t.Fatalf("status = %d, Location = %q, expected 403 without a redirect", rec.Code, rec.Header().Get("Location"))
+		}
+		if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") || !strings.Contains(rec.Body.String(), "example.com") {
+			t.Errorf("body = %s, expected an HTML page naming the domain", rec.Body.String())
+		}
+	})
+
+	t.Run("custom page", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "blocked.html")
+		if err := os.WriteFile(path, []byte(`<p>{{.ShortCode}} to {{.Domain}} is unavailable</p>`), 0o644); err != nil {
+			t.Fatalf("Failed to write page: %v", err)
+		}
+		page, err := NewBlockPage(path, http.StatusUnavailableForLegalReasons)
+		if err != nil {
+			t.Fatalf("NewBlockPage() error = %v", err)
+		}
+		WithDomainPolicy(rules, page)(h)
+
+		resp := h.Redirect(context.Background(), "active", analytics.Visitor{})
+		if resp.StatusCode != http.StatusUnavailableForLegalReasons || resp.Body != "<p>active to example.com is unavailable</p>" {
+			t.Errorf("Redirect() = %d %q", resp.StatusCode, resp.Body)
+		}
+	})
+
+	// Blocked redirects are not counted as clicks
+	if err := recorder.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got, _ := stats.GetStats(context.Background(), "active"); got.TotalClicks != 0 {
+		t.Errorf("TotalClicks = %d, expected 0", got.TotalClicks)
+	}
+}
+
+func TestHandler_QuarantinedRedirect(t *testing.T) {
+	h := setupTestHandler(t)
+	ctx := context.Background()
+
+	// Links stored quarantined are blocked without a screener
+	held := models.NewURL("https://held.example/", "held")
+	held.Quarantine([]string{"malware"})
+	if err := h.storage.Create(ctx, held); err != nil {
+		t.Fatalf("Failed to seed storage: %v", err)
+	}
+	resp := h.Redirect(ctx, "held", analytics.Visitor{})
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(resp.Body, "flagged as unsafe") {
+		t.Errorf("Redirect() = %d %q, expected the block page", resp.StatusCode, resp.Body)
+	}
+
+	// example.com is listed after the links were created
+	dir := t.TempDir()
+	hash := sha256.Sum256([]byte("example.com/"))
+	if err := os.WriteFile(filepath.Join(dir, "phishing.txt"), []byte(hex.EncodeToString(hash[:])+"\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write list: %v", err)
+	}
+	screener, err := screening.Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer screener.Close()
+	WithScreener(screener, nil)(h)
+
+	if resp := h.Redirect(ctx, "active", analytics.Visitor{}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Redirect() status = %d, expected %d", resp.StatusCode, http.StatusForbidden)
+	}
+	url, err := h.storage.Get(ctx, "active")
+	if err != nil || !url.IsQuarantined() || !reflect.DeepEqual(url.Threats, []string{"phishing"}) {
+		t.Fatalf("stored URL = %+v, %v, expected quarantined by phishing", url, err)
+	}
+
+	// Released links are redirected to again
+	url.Status = models.StatusReleased
+	if err := h.storage.Update(ctx, url); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if resp := h.Redirect(ctx, "active", analytics.Visitor{}); resp.StatusCode != http.StatusFound {
+		t.Errorf("Redirect() of a released link status = %d, expected %d", resp.StatusCode, http.StatusFound)
+	}
+}
+
+func TestHandler_VisitorBehindProxy(t *testing.T) {
+	tests := []struct {
+		name        string
+		trustedHops int
+		expected    string
+	}{
+		{"no trusted proxies", 0, "10.0.0.2"},
+		{"one trusted proxy", 1, "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTestHandler(t)
+			WithTrustedProxyHops(tt.trustedHops)(h)
+
+			req := httptest.NewRequest(http.MethodGet, "/active", nil)
+			req.RemoteAddr = "10.0.0.2:54321"
+			req.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7")
+			if got := h.visitorFromRequest(req).IP; got != tt.expected {
+				t.Errorf("visitor IP = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHandler_TimeSeries(t *testing.T) {
+	stats := analytics.NewMemoryStore()
+	clicks := []analytics.ClickEvent{
+		{ShortCode: "active", Timestamp: time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)},
+		{ShortCode: "active", Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+	}
+	if err := stats.RecordClicks(context.Background(), clicks); err != nil {
+		t.Fatalf("Failed to seed stats: %v", err)
+	}
+	h := setupTestHandler(t)
+	WithStats(stats)(h)
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedClicks []int64
+	}{
+		{
+			name:           "hours",
+			path:           "/stats/active/timeseries?granularity=hour&from=2024-05-01T10:00:00Z&to=2024-05-01T13:00:00Z",
+			expectedStatus: 200,
+			expectedClicks: []int64{1, 0, 1},
+		},
+		{
+			name:           "day",
+			path:           "/stats/active/timeseries?granularity=day&from=2024-05-01T00:00:00Z&to=2024-05-02T00:00:00Z",
+			expectedStatus: 200,
+			expectedClicks: []int64{2},
+		},
+		{
+			name:           "invalid granularity",
+			path:           "/stats/active/timeseries?granularity=week",
+			expectedStatus: 400,
+		},
+		{
+			name:           "invalid time",
+			path:           "/stats/active/timeseries?from=yesterday",
+			expectedStatus: 400,
+		},
+		{
+			name:           "unknown short code",
+			path:           "/stats/missing/timeseries",
+			expectedStatus: 404,
+		},
+		{
+			name:           "expired short code",
+			path:           "/stats/expired/timeseries",
+			expectedStatus: 410,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, expected %d: %s", rec.Code, tt.expectedStatus, rec.Body.String())
+			}
+			if tt.expectedStatus != 200 {
+				return
+			}
+
+			var resp TimeSeriesResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			got := make([]int64, len(resp.Points))
+			for i, point := range resp.Points {
+				got[i] = point.Clicks
+			}
+			if !reflect.DeepEqual(got, tt.expectedClicks) {
+				t.Errorf("clicks = %v, expected %v", got, tt.expectedClicks)
+			}
+		})
+	}
+}
+
+func TestHandler_Stats(t *testing.T) {
+	stats := analytics.NewMemoryStore()
+	recorder := analytics.NewRecorder(stats)
+	h := setupTestHandler(t)
+	WithRecorder(recorder)(h)
+	WithStats(stats)(h)
+
+	// UTM parameters come from the query string of the short link
+	for _, referrer := range []string{"https://www.google.com/", "https://www.google.com/search", "https://t.co/x"} {
+		req := httptest.NewRequest(http.MethodGet, "/active?utm_source=newsletter&utm_campaign=spring", nil)
+		req.Header.Set("Referer", referrer)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	request := events.APIGatewayProxyRequest{
+		QueryStringParameters: map[string]string{"utm_source": "ads", "utm_medium": "cpc"},
+	}
+	h.Redirect(context.Background(), "active", h.VisitorFromAPIGateway(request))
+	if err := recorder.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	tests := []struct {
+		name             string
+		path             string
+		expectedStatus   int
+		expectedReferrer map[string]int64
+		expectedSource   map[string]int64
+	}{
+		{
+			name:             "default top",
+			path:             "/stats/active",
+			expectedStatus:   200,
+			expectedReferrer: map[string]int64{"google.com": 2, "t.co": 1},
+			expectedSource:   map[string]int64{"newsletter": 3, "ads": 1},
+		},
+		{
+			name:             "top one",
+			path:             "/stats/active?top=1",
+			expectedStatus:   200,
+			expectedReferrer: map[string]int64{"google.com": 2, analytics.OtherKey: 1},
+			expectedSource:   map[string]int64{"newsletter": 3, analytics.OtherKey: 1},
+		},
+		{
+			name:           "invalid top",
+			path:           "/stats/active?top=many",
+			expectedStatus: 400,
+		},
+		{
+			name:           "top out of range",
+			path:           "/stats/active?top=1000",
+			expectedStatus: 400,
+		},
+		{
+			name:           "unknown short code",
+			path:           "/stats/missing",
+			expectedStatus: 404,
+		},
+		{
+			name:           "expired short code",
+			path:           "/stats/expired",
+			expectedStatus: 410,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, expected %d: %s", rec.Code, tt.expectedStatus, rec.Body.String())
+			}
+			if tt.expectedStatus != 200 {
+				return
+			}
+
+			var resp StatsResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if resp.TotalClicks != 4 || resp.HumanClicks != 4 {
+				t.Errorf("clicks = %d with %d human, expected 4", resp.TotalClicks, resp.HumanClicks)
+			}
+			if !reflect.DeepEqual(resp.ClicksByReferrer, tt.expectedReferrer) {
+				t.Errorf("ClicksByReferrer = %v, expected %v", resp.ClicksByReferrer, tt.expectedReferrer)
+			}
+			if !reflect.DeepEqual(resp.ClicksByUTMSource, tt.expectedSource) {
+				t.Errorf("ClicksByUTMSource = %v, expected %v", resp.ClicksByUTMSource, tt.expectedSource)
+			}
+			if expected := map[string]int64{"cpc": 1}; !reflect.DeepEqual(resp.ClicksByUTMMedium, expected) {
+				t.Errorf("ClicksByUTMMedium = %v, expected %v", resp.ClicksByUTMMedium, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/geoip"
+)
+
+const (
+	// maxRequestBodySize bounds the JSON body accepted by POST /create
+	maxRequestBodySize = 64 * 1024
+	// countryHeader carries the viewer country when requests pass through
+	// CloudFront. Clients can send it too, so it is only honored behind
+	// trusted proxies.
+	countryHeader = "CloudFront-Viewer-Country"
+)
+
+// ServeHTTP routes requests the same way API Gateway routes them to the
+// Lambda functions: POST /create, GET /stats/{shortCode},
+// GET /stats/{shortCode}/timeseries and GET /{shortCode}
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+
+	switch {
+	case path == "create":
+		if r.Method != http.MethodPost {
+			writeResponse(w, errorResponse(http.StatusMethodNotAllowed, "Method not allowed"))
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodySize))
+		if err != nil {
+			writeResponse(w, errorResponse(http.StatusBadRequest, "Invalid request body"))
+			return
+		}
+		writeResponse(w, h.Create(r.Context(), body))
+
+	case strings.HasPrefix(path, "stats/") && strings.HasSuffix(path, "/timeseries"):
+		if r.Method != http.MethodGet {
+			writeResponse(w, errorResponse(http.StatusMethodNotAllowed, "Method not allowed"))
+			return
+		}
+		shortCode := strings.TrimSuffix(strings.TrimPrefix(path, "stats/"), "/timeseries")
+		writeResponse(w, h.TimeSeries(r.Context(), shortCode, queryParams(r)))
+
+	case strings.HasPrefix(path, "stats/") && !strings.Contains(strings.TrimPrefix(path, "stats/"), "/"):
+		if r.Method != http.MethodGet {
+			writeResponse(w, errorResponse(http.StatusMethodNotAllowed, "Method not allowed"))
+			return
+		}
+		writeResponse(w, h.Stats(r.Context(), strings.TrimPrefix(path, "stats/"), queryParams(r)))
+
+	case !strings.Contains(path, "/"):
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeResponse(w, errorResponse(http.StatusMethodNotAllowed, "Method not allowed"))
+			return
+		}
+		writeResponse(w, h.Redirect(r.Context(), path, h.visitorFromRequest(r)))
+
+	default:
+		writeResponse(w, errorResponse(http.StatusNotFound, "Not found"))
+	}
+}
+
+// visitorFromRequest describes the client of r. The country header is only
+// taken from the proxies we run in front of the server; otherwise the
+// country is left to the GeoIP database.
+func (h *Handler) visitorFromRequest(r *http.Request) analytics.Visitor {
+	visitor := analytics.Visitor{
+		IP:        geoip.ClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), h.trustedHops),
+		UserAgent: r.UserAgent(),
+		Referrer:  r.Referer(),
+		Campaign:  analytics.CampaignFromQuery(r.URL.Query()),
+	}
+	if h.trustedHops > 0 {
+		visitor.Country = viewerCountry(r.Header.Get(countryHeader))
+	}
+	return visitor
+}
+
+// viewerCountry returns the upper case country code of a country header
+// value, or an empty string when it is not a two-letter ISO 3166 code
+func viewerCountry(value string) string {
+	if len(value) != 2 {
+		return ""
+	}
+	code := []byte(strings.ToUpper(value))
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+	}
+	return string(code)
+}
+
+// queryParams flattens the query string of r into the single-value map API
+// Gateway passes to the Lambda functions
+func queryParams(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	for key := range r.URL.Query() {
+		params[key] = r.URL.Query().Get(key)
+	}
+	return params
+}
+
+// writeResponse copies a Response onto an http.ResponseWriter
+func writeResponse(w http.ResponseWriter, resp Response) {
+	for key, value := range resp.Headers {
+		w.Header().Set(key, value)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.WriteString(w, resp.Body)
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/jingy/Go-Shortener/internal/analytics"
+	"github.com/jingy/Go-Shortener/internal/geoip"
+)
+
+// ToAPIGateway converts a Response into an API Gateway proxy response
+func ToAPIGateway(resp Response) events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Headers,
+		Body:       resp.Body,
+	}
+}
+
+// VisitorFromAPIGateway describes the client of an API Gateway proxy request.
+// API Gateway resolves the client IP itself; X-Forwarded-For is only used
+// when it is missing, taking the entry API Gateway appended. Like over HTTP,
+// the country header is only trusted behind trusted proxies, such as the
+// CloudFront distribution of an edge-optimized API, and only kept when it is
+// a valid country code.
+func (h *Handler) VisitorFromAPIGateway(request events.APIGatewayProxyRequest) analytics.Visitor {
+	ip := request.RequestContext.Identity.SourceIP
+	if ip == "" {
+		ip = geoip.ClientIP("", headerValue(request.Headers, "X-Forwarded-For"), 1)
+	}
+	visitor := analytics.Visitor{
+		IP:        ip,
+		UserAgent: headerValue(request.Headers, "User-Agent"),
+		Referrer:  headerValue(request.Headers, "Referer"),
+		Campaign:  analytics.CampaignFromQuery(queryValues(request)),
+	}
+	if h.trustedHops > 0 {
+		visitor.Country = viewerCountry(headerValue(request.Headers, countryHeader))
+	}
+	return visitor
+}
+
+// queryValues returns the query string parameters of an API Gateway proxy
+// request, which only has the single-value map when built by hand
+func queryValues(request events.APIGatewayProxyRequest) url.Values {
+	if request.MultiValueQueryStringParameters != nil {
+		return url.Values(request.MultiValueQueryStringParameters)
+	}
+	query := make(url.Values, len(request.QueryStringParameters))
+	for key, value := range request.QueryStringParameters {
+		query.Set(key, value)
+	}
+	return query
+}
+
+// headerValue looks up a header by name regardless of case, since API
+// Gateway passes header names as the client sent them
+func headerValue(headers map[string]string, name string) string {
+	if value, ok := headers[name]; ok {
+		return value
+	}
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
@@ -3,9 +3,67 @@ package models
 import "errors"
 
 var (
-	ErrEmptyURL          = errors.New("empty URL provided")
-	ErrInvalidURL        = errors.New("invalid URL format")
-	ErrURLNotFound       = errors.New("URL not found")
-	ErrURLExpired        = errors.New("URL has expired")
+	ErrEmptyURL           = errors.New("empty URL provided")
+	ErrInvalidURL         = errors.New("invalid URL format")
+	ErrURLTooLong         = errors.New("URL exceeds the maximum length")
+	ErrSchemeNotAllowed   = errors.New("URL scheme is not allowed")
+	ErrIPHost             = errors.New("URL host is an IP address")
+	ErrPrivateHost        = errors.New("URL host is private")
+	ErrRedirectLoop       = errors.New("URL points back at a short URL")
+	ErrDomainBlocked      = errors.New("URL domain is blocked")
+	ErrURLFlagged         = errors.New("URL is flagged as malicious")
+	ErrURLQuarantined     = errors.New("URL is quarantined")
+	ErrNotQuarantined     = errors.New("URL is not quarantined")
+	ErrURLNotFound        = errors.New("URL not found")
+	ErrURLExpired         = errors.New("URL has expired")
+	ErrURLNotExpired      = errors.New("URL has not expired")
+	ErrURLChanged         = errors.New("URL has changed")
+	ErrStorageLocked      = errors.New("storage is in use by another process")
 	ErrDuplicateShortCode = errors.New("duplicate short code")
-) 
\ No newline at end of file
+	ErrInvalidExpiration  = errors.New("invalid expiration")
+	ErrExpirationInPast   = errors.New("expiration is in the past")
+	ErrExpirationTooFar   = errors.New("expiration exceeds the maximum allowed TTL")
+	ErrInvalidAlias       = errors.New("invalid alias")
+	ErrReservedAlias      = errors.New("alias is reserved")
+	ErrAliasTaken         = errors.New("alias is already in use")
+	ErrInvalidGranularity = errors.New("invalid granularity")
+	ErrInvalidTimeRange   = errors.New("invalid time range")
+	ErrInvalidTop         = errors.New("invalid number of top values")
+	ErrInvalidWatch       = errors.New("invalid watch request")
+	ErrInvalidExport      = errors.New("invalid export request")
+	ErrResumeExpired      = errors.New("resume point is no longer available")
+	ErrSubscriberLagging  = errors.New("subscriber fell too far behind")
+)
+
+// validationErrors are returned for requests that can never succeed as sent
+var validationErrors = []error{
+	ErrEmptyURL,
+	ErrInvalidURL,
+	ErrURLTooLong,
+	ErrSchemeNotAllowed,
+	ErrIPHost,
+	ErrPrivateHost,
+	ErrRedirectLoop,
+	ErrDomainBlocked,
+	ErrURLFlagged,
+	ErrInvalidExpiration,
+	ErrExpirationInPast,
+	ErrExpirationTooFar,
+	ErrInvalidAlias,
+	ErrReservedAlias,
+	ErrInvalidGranularity,
+	ErrInvalidTimeRange,
+	ErrInvalidTop,
+	ErrInvalidWatch,
+	ErrInvalidExport,
+}
+
+// IsValidationError reports whether err was caused by invalid client input
+func IsValidationError(err error) bool {
+	for _, target := range validationErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,27 +1,90 @@
 package models
 
 import (
+	"fmt"
 	"time"
 )
 
+// URL statuses. URLs stored without a status are active.
+const (
+	// StatusActive URLs are redirected to
+	StatusActive = "active"
+	// StatusQuarantined URLs were flagged as malicious and are not
+	// redirected to until released
+	StatusQuarantined = "quarantined"
+	// StatusReleased URLs were flagged but released after review, so they
+	// are redirected to without being screened again
+	StatusReleased = "released"
+)
+
 // URL represents a shortened URL entry in the database
 type URL struct {
 	ShortCode   string    `json:"shortCode" dynamodbav:"ShortCode"`
 	OriginalURL string    `json:"originalUrl" dynamodbav:"OriginalURL"`
+	ShortURL    string    `json:"shortUrl,omitempty" dynamodbav:"-"`
 	CreatedAt   time.Time `json:"createdAt" dynamodbav:"CreatedAt"`
 	ExpiresAt   time.Time `json:"expiresAt,omitempty" dynamodbav:"ExpiresAt,omitempty"`
+	// TTL mirrors ExpiresAt as Unix epoch seconds so DynamoDB's native TTL
+	// can delete expired items. Zero means the URL never expires.
+	TTL int64 `json:"ttl,omitempty" dynamodbav:"TTL,omitempty"`
+	// CanonicalHash identifies the canonical destination of URLs that later
+	// creates of the same destination may reuse, and is empty for the others
+	CanonicalHash string `json:"canonicalHash,omitempty" dynamodbav:"CanonicalHash,omitempty"`
+	// Status is one of the URL statuses, empty meaning StatusActive
+	Status string `json:"status,omitempty" dynamodbav:"Status,omitempty"`
+	// Threats names the screening lists a flagged URL matched
+	Threats []string `json:"threats,omitempty" dynamodbav:"Threats,omitempty"`
+}
+
+// SetExpiresAt sets the expiration time and keeps TTL in sync
+func (u *URL) SetExpiresAt(expiresAt time.Time) {
+	u.ExpiresAt = expiresAt
+	u.TTL = 0
+	if !expiresAt.IsZero() {
+		u.TTL = expiresAt.Unix()
+	}
+}
+
+// IsExpired reports whether the URL has an expiration time that has passed
+func (u *URL) IsExpired(now time.Time) bool {
+	return !u.ExpiresAt.IsZero() && now.After(u.ExpiresAt)
+}
+
+// IsQuarantined reports whether the URL must not be redirected to
+func (u *URL) IsQuarantined() bool {
+	return u.Status == StatusQuarantined
+}
+
+// Quarantine flags the URL as matching the threats lists
+func (u *URL) Quarantine(threats []string) {
+	u.Status = StatusQuarantined
+	u.Threats = threats
 }
 
-// CreateURLRequest represents the request body for creating a new short URL
+// Release lets a quarantined URL be redirected to again. The threats are
+// kept as a record of why it was flagged.
+func (u *URL) Release() {
+	u.Status = StatusReleased
+}
+
+// CreateURLRequest represents the request body for creating a new short URL.
+// Expiration is either an absolute RFC3339 ExpiresAt or a relative ExpiresIn
+// duration such as "72h", never both. Alias optionally requests a specific
+// short code.
 type CreateURLRequest struct {
-	URL      string     `json:"url"`
+	URL       string     `json:"url"`
 	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	ExpiresIn string     `json:"expiresIn,omitempty"`
+	Alias     string     `json:"alias,omitempty"`
 }
 
 // CreateURLResponse represents the response for creating a new short URL
 type CreateURLResponse struct {
-	ShortCode string `json:"shortCode"`
-	ShortURL  string `json:"shortUrl"`
+	ShortCode string     `json:"shortCode"`
+	ShortURL  string     `json:"shortUrl"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Status is set when the URL was quarantined instead of refused
+	Status string `json:"status,omitempty"`
 }
 
 // Validate checks if the URL is valid
@@ -29,9 +92,27 @@ func (r *CreateURLRequest) Validate() error {
 	if r.URL == "" {
 		return ErrEmptyURL
 	}
+	if r.ExpiresAt != nil && r.ExpiresIn != "" {
+		return fmt.Errorf("%w: expiresAt and expiresIn are mutually exclusive", ErrInvalidExpiration)
+	}
+	if _, err := r.ExpiresInDuration(); err != nil {
+		return err
+	}
 	return nil
 }
 
+// ExpiresInDuration parses ExpiresIn, returning zero when it is not set
+func (r *CreateURLRequest) ExpiresInDuration() (time.Duration, error) {
+	if r.ExpiresIn == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(r.ExpiresIn)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidExpiration, err)
+	}
+	return d, nil
+}
+
 // NewURL creates a new URL instance
 func NewURL(originalURL, shortCode string) *URL {
 	return &URL{
@@ -39,4 +120,4 @@ func NewURL(originalURL, shortCode string) *URL {
 		OriginalURL: originalURL,
 		CreatedAt:   time.Now().UTC(),
 	}
-} 
\ No newline at end of file
+}
@@ -0,0 +1,306 @@
+package screening
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxHostSuffixes and maxPathPrefixes bound the expressions looked up
+	// per URL besides the exact host and path
+	maxHostSuffixes = 4
+	maxPathPrefixes = 4
+	// maxHostComponents is how many trailing host components the suffixes
+	// start from
+	maxHostComponents = 5
+)
+
+var errInvalidURL = errors.New("invalid URL")
+
+// controlReplacer removes tabs and line breaks, keeping invalid UTF-8 bytes
+var controlReplacer = strings.NewReplacer("\t", "", "\r", "", "\n", "")
+
+// Canonicalize returns rawURL in the canonical form of the Safe Browsing
+// lists: tabs and line breaks are removed along with the fragment, escapes
+// are decoded until none are left, the host is lowercased without its port,
+// credentials and extra dots, numeric IPv4 hosts are written as four
+// decimal numbers, dot segments and repeated slashes are removed from the
+// path, and control, non-ASCII, "#" and "%" characters are escaped again.
+// URLs without a scheme are read as http.
+func Canonicalize(rawURL string) (string, error) {
+	rawURL = controlReplacer.Replace(rawURL)
+	rawURL = strings.TrimSpace(rawURL)
+	rawURL, _, _ = strings.Cut(rawURL, "#")
+
+	scheme := "http"
+	if i := strings.Index(rawURL, "://"); i > 0 && isScheme(rawURL[:i]) {
+		scheme = strings.ToLower(rawURL[:i])
+		rawURL = rawURL[i+3:]
+	}
+
+	authority, rest := rawURL, ""
+	if i := strings.IndexAny(rawURL, "/?"); i >= 0 {
+		authority, rest = rawURL[:i], rawURL[i:]
+	}
+	host := canonicalHost(authority)
+	if host == "" {
+		return "", errInvalidURL
+	}
+
+	path, query, hasQuery := strings.Cut(rest, "?")
+	canonical := scheme + "://" + escape(host) + escape(canonicalPath(unescape(path)))
+	if hasQuery {
+		canonical += "?" + escape(unescape(query))
+	}
+	return canonical, nil
+}
+
+// Expressions returns the host suffix and path prefix combinations of a
+// canonical URL that list entries are made of, most specific first: the
+// exact host and up to four suffixes of its last five components, each
+// with the exact path and query, the exact path, and up to four prefixes
+// of the path.
+func Expressions(canonical string) ([]string, error) {
+	_, rest, ok := strings.Cut(canonical, "://")
+	if !ok {
+		return nil, errInvalidURL
+	}
+	host, pathQuery := rest, "/"
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		host, pathQuery = rest[:i], rest[i:]
+	}
+
+	var expressions []string
+	for _, suffix := range hostSuffixes(host) {
+		for _, prefix := range pathPrefixes(pathQuery) {
+			expressions = append(expressions, suffix+prefix)
+		}
+	}
+	return expressions, nil
+}
+
+func hostSuffixes(host string) []string {
+	suffixes := []string{host}
+	if isIPv4(host) {
+		return suffixes
+	}
+	components := strings.Split(host, ".")
+	for n := min(maxHostComponents, len(components)-1); n >= 2 && len(suffixes) <= maxHostSuffixes; n-- {
+		suffix := strings.Join(components[len(components)-n:], ".")
+		if suffix != host {
+			suffixes = append(suffixes, suffix)
+		}
+	}
+	return suffixes
+}
+
+func pathPrefixes(pathQuery string) []string {
+	path, _, hasQuery := strings.Cut(pathQuery, "?")
+	prefixes := []string{pathQuery}
+	if hasQuery {
+		prefixes = append(prefixes, path)
+	}
+
+	// Only directories make prefixes, not a trailing file name
+	var dirs []string
+	if trimmed := strings.Trim(path, "/"); trimmed != "" {
+		dirs = strings.Split(trimmed, "/")
+		if !strings.HasSuffix(path, "/") {
+			dirs = dirs[:len(dirs)-1]
+		}
+	}
+
+	seen := map[string]bool{pathQuery: true, path: true}
+	prefix := "/"
+	for i := 0; i <= len(dirs) && i < maxPathPrefixes; i++ {
+		if i > 0 {
+			prefix += dirs[i-1] + "/"
+		}
+		if !seen[prefix] {
+			seen[prefix] = true
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// canonicalHost extracts the host of an authority and canonicalizes it
+func canonicalHost(authority string) string {
+	if i := strings.LastIndexByte(authority, '@'); i >= 0 {
+		authority = authority[i+1:]
+	}
+	if strings.HasPrefix(authority, "[") {
+		// IPv6 literals are kept as they are
+		if end := strings.IndexByte(authority, ']'); end >= 0 {
+			return lowerASCII(authority[:end+1])
+		}
+		return ""
+	}
+	if i := strings.LastIndexByte(authority, ':'); i >= 0 {
+		authority = authority[:i]
+	}
+
+	host := lowerASCII(unescape(authority))
+	var labels []string
+	for _, label := range strings.Split(host, ".") {
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	host = strings.Join(labels, ".")
+	if ip, ok := parseIPv4(host); ok {
+		return ip
+	}
+	return host
+}
+
+// canonicalPath resolves dot segments and repeated slashes, keeping a
+// trailing slash
+func canonicalPath(path string) string {
+	var segments []string
+	parts := strings.Split(path, "/")
+	for _, part := range parts {
+		switch part {
+		case "", ".":
+		case "..":
+			if len(segments) > 0 {
+				segments = segments[:len(segments)-1]
+			}
+		default:
+			segments = append(segments, part)
+		}
+	}
+
+	last := parts[len(parts)-1]
+	trailing := last == "" || last == "." || last == ".."
+	if len(segments) == 0 {
+		return "/"
+	}
+	canonical := "/" + strings.Join(segments, "/")
+	if trailing {
+		canonical += "/"
+	}
+	return canonical
+}
+
+// parseIPv4 reads host the way browsers do, as one to four numbers in
+// decimal, octal or hex, the last one filling the remaining bytes
+func parseIPv4(host string) (string, bool) {
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return "", false
+	}
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		base := 10
+		if digits, ok := cutPrefixFold(part, "0x"); ok {
+			part, base = digits, 16
+		} else if len(part) > 1 && part[0] == '0' {
+			part, base = part[1:], 8
+		}
+		value, err := strconv.ParseUint(part, base, 32)
+		if err != nil {
+			return "", false
+		}
+		values[i] = value
+	}
+
+	var ip uint64
+	for i, value := range values[:len(values)-1] {
+		if value > 255 {
+			return "", false
+		}
+		ip |= value << (8 * (3 - i))
+	}
+	last := values[len(values)-1]
+	if last >= 1<<(8*(5-len(values))) {
+		return "", false
+	}
+	ip |= last
+	return fmt.Sprintf("%d.%d.%d.%d", ip>>24, ip>>16&0xff, ip>>8&0xff, ip&0xff), true
+}
+
+// lowerASCII lowercases ASCII letters only, leaving invalid UTF-8 as it is
+// unlike strings.ToLower
+func lowerASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+func isIPv4(host string) bool {
+	ip, ok := parseIPv4(host)
+	return ok && ip == host
+}
+
+func isScheme(s string) bool {
+	for i, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// unescape decodes percent escapes until none are left. Invalid escapes are
+// kept as they are.
+func unescape(s string) string {
+	for {
+		decoded := unescapeOnce(s)
+		if decoded == s {
+			return s
+		}
+		s = decoded
+	}
+}
+
+func unescapeOnce(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			value, _ := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			b.WriteByte(byte(value))
+			i += 2
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// escape percent-encodes control, space, non-ASCII, "#" and "%" bytes
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '#' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,129 @@
+package screening
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ListExt is the extension of list files. Other files, and hidden ones such
+// as lists still being written, are ignored.
+const ListExt = ".txt"
+
+// List is a set of full SHA-256 hashes of URL expressions
+type List struct {
+	hashes map[[sha256.Size]byte]struct{}
+}
+
+// ParseList reads a list with one hex encoded hash per line. Blank lines and
+// lines starting with # are ignored. Hash prefixes, the form Safe Browsing
+// distributes, are refused: a prefix matches unrelated expressions too and
+// cannot be confirmed offline.
+func ParseList(r io.Reader) (*List, error) {
+	list := &List{hashes: make(map[[sha256.Size]byte]struct{})}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hash, err := hex.DecodeString(text)
+		if err != nil || len(hash) > sha256.Size {
+			return nil, fmt.Errorf("line %d is not a hex SHA-256 hash", line)
+		}
+		if len(hash) < sha256.Size {
+			return nil, fmt.Errorf("line %d is a hash prefix of %d bytes, only full %d byte hashes are supported", line, len(hash), sha256.Size)
+		}
+		list.hashes[[sha256.Size]byte(hash)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Contains reports whether the full hash is in the list
+func (l *List) Contains(hash []byte) bool {
+	if len(hash) != sha256.Size {
+		return false
+	}
+	_, ok := l.hashes[[sha256.Size]byte(hash)]
+	return ok
+}
+
+// Lists are the lists of a directory by name
+type Lists map[string]*List
+
+// LoadLists reads every list file of dir. A list is named after its file
+// without the extension, e.g. malware.txt holds the malware list.
+func LoadLists(dir string) (Lists, error) {
+	entries, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	lists := make(Lists, len(entries))
+	for _, entry := range entries {
+		list, err := loadList(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		lists[strings.TrimSuffix(entry.Name(), ListExt)] = list
+	}
+	return lists, nil
+}
+
+func loadList(path string) (*List, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open screening list: %w", err)
+	}
+	defer f.Close()
+
+	list, err := ParseList(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid screening list %s: %w", filepath.Base(path), err)
+	}
+	return list, nil
+}
+
+// listFiles returns the list files of dir sorted by name
+func listFiles(dir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read screening lists: %w", err)
+	}
+
+	var files []os.DirEntry
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && filepath.Ext(name) == ListExt && !strings.HasPrefix(name, ".") {
+			files = append(files, entry)
+		}
+	}
+	return files, nil
+}
+
+// Match returns the sorted names of the lists containing one of hashes
+func (l Lists) Match(hashes [][]byte) []string {
+	var names []string
+	for name, list := range l {
+		for _, hash := range hashes {
+			if list.Contains(hash) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
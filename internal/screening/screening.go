@@ -0,0 +1,238 @@
+// Package screening checks URLs against local lists of SHA-256 hashes of
+// malicious URL expressions, hashed the way Safe Browsing hashes them, so no
+// external service is called. Lists are files in a directory that is
+// watched, so new lists are picked up by dropping files in place.
+package screening
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/storage"
+)
+
+const defaultReloadInterval = time.Minute
+
+// Screener matches URLs against the lists of a directory. The directory is
+// polled and reloaded when its list files change. Lists that fail to load
+// are logged and the previous ones stay in use. A nil Screener flags
+// nothing.
+type Screener struct {
+	dir      string
+	interval time.Duration
+
+	mu          sync.RWMutex
+	lists       Lists
+	fingerprint string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Option configures a Screener
+type Option func(*Screener)
+
+// WithReloadInterval sets how often the directory is checked for changes
+func WithReloadInterval(interval time.Duration) Option {
+	return func(s *Screener) {
+		if interval > 0 {
+			s.interval = interval
+		}
+	}
+}
+
+// Open loads the lists of dir and starts watching it for changes
+func Open(dir string, opts ...Option) (*Screener, error) {
+	s := &Screener{
+		dir:      dir,
+		interval: defaultReloadInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	go s.watch()
+	return s, nil
+}
+
+// Screen returns the sorted names of the lists rawURL matches, or nil when
+// it matches none or cannot be canonicalized
+func (s *Screener) Screen(rawURL string) []string {
+	if s == nil {
+		return nil
+	}
+	hashes, err := Hashes(rawURL)
+	if err != nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lists.Match(hashes)
+}
+
+// CheckRedirect decides whether the stored url may be redirected to. It
+// fails with models.ErrURLQuarantined for quarantined URLs, and for active
+// URLs that match a list now, which are quarantined in store first.
+// Released URLs are not screened again.
+func (s *Screener) CheckRedirect(ctx context.Context, store storage.URLStore, url *models.URL) error {
+	switch {
+	case url.IsQuarantined():
+		return models.ErrURLQuarantined
+	case url.Status == models.StatusReleased:
+		return nil
+	}
+
+	threats := s.Screen(url.OriginalURL)
+	if threats == nil {
+		return nil
+	}
+	// The redirect is refused even when the status cannot be saved
+	if err := quarantine(ctx, store, url, threats); err != nil {
+		log.Printf("Failed to quarantine %s: %v", url.ShortCode, err)
+	}
+	url.Quarantine(threats)
+	return fmt.Errorf("%w: %s", models.ErrURLQuarantined, strings.Join(threats, ", "))
+}
+
+// quarantine flags the stored url with threats, only setting its status when
+// the store supports it so a concurrent update is not overwritten. A URL
+// whose destination or status changed since it was read is left as is.
+func quarantine(ctx context.Context, store storage.URLStore, url *models.URL, threats []string) error {
+	if quarantiner, ok := store.(storage.Quarantiner); ok {
+		err := quarantiner.Quarantine(ctx, url.ShortCode, url.OriginalURL, threats)
+		if errors.Is(err, models.ErrURLChanged) {
+			return nil
+		}
+		return err
+	}
+
+	flagged := *url
+	flagged.Quarantine(threats)
+	return store.Update(ctx, &flagged)
+}
+
+// Hashes returns the SHA-256 hashes of the expressions of rawURL
+func Hashes(rawURL string) ([][]byte, error) {
+	canonical, err := Canonicalize(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	expressions, err := Expressions(canonical)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([][]byte, len(expressions))
+	for i, expression := range expressions {
+		sum := sha256.Sum256([]byte(expression))
+		hashes[i] = sum[:]
+	}
+	return hashes, nil
+}
+
+// Reload loads the lists again if a list file was added, removed or changed
+// and reports whether it did
+func (s *Screener) Reload() (bool, error) {
+	fingerprint, err := s.fingerprintFiles()
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	unchanged := s.lists != nil && fingerprint == s.fingerprint
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	lists, err := LoadLists(s.dir)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.lists = lists
+	s.fingerprint = fingerprint
+	s.mu.Unlock()
+
+	return true, nil
+}
+
+// fingerprintFiles describes the names, sizes and modification times of
+// the list files, which change whenever a list is replaced
+func (s *Screener) fingerprintFiles() (string, error) {
+	entries, err := listFiles(s.dir)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, entry := range entries {
+		// Stat follows symlinks, e.g. of mounted config maps
+		info, err := os.Stat(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return "", fmt.Errorf("failed to stat screening list: %w", err)
+		}
+		fmt.Fprintf(&b, "%s:%d:%d\n", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// watch reloads the lists every interval until Close is called
+func (s *Screener) watch() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := s.Reload()
+			if err != nil {
+				log.Printf("Failed to reload screening lists: %v", err)
+			} else if reloaded {
+				log.Printf("Reloaded screening lists from %s", s.dir)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops watching the directory
+func (s *Screener) Close() error {
+	if s == nil {
+		return nil
+	}
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+// OpenFromConfig opens the Screener of the configured lists directory. It
+// returns a nil Screener, which flags nothing, when no directory is
+// configured.
+func OpenFromConfig(cfg config.ScreeningConfig) (*Screener, error) {
+	if cfg.ListsDir == "" {
+		return nil, nil
+	}
+	return Open(cfg.ListsDir, WithReloadInterval(cfg.ReloadInterval))
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+const (
+	archiveTableName = "url-shortener-archive"
+)
+
+// Archiver keeps a copy of expired URLs before they are deleted
+type Archiver interface {
+	Archive(ctx context.Context, urls []*models.URL) error
+}
+
+// ArchivedURL is the record written by archivers
+type ArchivedURL struct {
+	models.URL
+	ArchivedAt time.Time `json:"archivedAt" dynamodbav:"ArchivedAt"`
+}
+
+// ArchiveTableAPI is the subset of the DynamoDB client used by DynamoDBArchiver
+type ArchiveTableAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// DynamoDBArchiver copies URLs into the archive table
+type DynamoDBArchiver struct {
+	client ArchiveTableAPI
+}
+
+func NewDynamoDBArchiver(client ArchiveTableAPI) *DynamoDBArchiver {
+	return &DynamoDBArchiver{
+		client: client,
+	}
+}
+
+func (a *DynamoDBArchiver) Archive(ctx context.Context, urls []*models.URL) error {
+	now := time.Now().UTC()
+	for _, url := range urls {
+		// The archive must outlive the TTL, so the attribute is dropped
+		record := ArchivedURL{URL: *url, ArchivedAt: now}
+		record.TTL = 0
+
+		av, err := attributevalue.MarshalMap(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal archived URL: %w", err)
+		}
+
+		_, err = a.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(archiveTableName),
+			Item:      av,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to archive %s: %w", url.ShortCode, err)
+		}
+	}
+	return nil
+}
+
+// FileArchiver appends URLs to a JSON lines file
+type FileArchiver struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileArchiver(path string) *FileArchiver {
+	return &FileArchiver{
+		path: path,
+	}
+}
+
+func (a *FileArchiver) Archive(ctx context.Context, urls []*models.URL) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(a.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	file, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer file.Close()
+
+	now := time.Now().UTC()
+	encoder := json.NewEncoder(file)
+	for _, url := range urls {
+		if err := encoder.Encode(ArchivedURL{URL: *url, ArchivedAt: now}); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", url.ShortCode, err)
+		}
+	}
+	return file.Sync()
+}
+
+// MemoryArchiver keeps archived URLs in process memory
+type MemoryArchiver struct {
+	mu       sync.Mutex
+	archived []ArchivedURL
+}
+
+func NewMemoryArchiver() *MemoryArchiver {
+	return &MemoryArchiver{}
+}
+
+func (a *MemoryArchiver) Archive(ctx context.Context, urls []*models.URL) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, url := range urls {
+		a.archived = append(a.archived, ArchivedURL{URL: *url, ArchivedAt: now})
+	}
+	return nil
+}
+
+// Archived returns a copy of everything archived so far
+func (a *MemoryArchiver) Archived() []ArchivedURL {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]ArchivedURL(nil), a.archived...)
+}
@@ -3,7 +3,10 @@ package storage
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -15,27 +18,166 @@ const (
 	counterTableName = "url-counter"
 	// Format: YYYY-MM-DD
 	dateFormat = "2006-01-02"
+	// maxPairSum keeps the Cantor pairing of a day and counter within int64
+	maxPairSum = 4_000_000_000
+	// shardSeparator separates the day from the shard in bucket keys
+	shardSeparator = "#"
+
+	// MaxCounterShards is the most shards a day bucket can be split into. It
+	// is part of the sequence layout and must never change.
+	MaxCounterShards = 32
 )
 
-// getBucketKey returns a string key for the current day bucket
-func getBucketKey() string {
-	return time.Now().UTC().Format(dateFormat)
+// sequenceEpoch is day zero of the day buckets used for sequence values
+var sequenceEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// getBucketKey returns a string key for a shard of the day bucket of now.
+// Shard 0 uses the plain date so unsharded tables keep their keys.
+func getBucketKey(now time.Time, shard int) string {
+	key := now.UTC().Format(dateFormat)
+	if shard > 0 {
+		key += shardSeparator + strconv.Itoa(shard)
+	}
+	return key
+}
+
+// parseBucketKey splits a bucket key into its day and shard
+func parseBucketKey(bucketKey string) (time.Time, int, error) {
+	datePart, shardPart, sharded := strings.Cut(bucketKey, shardSeparator)
+	bucketDate, err := time.Parse(dateFormat, datePart)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid bucket key %q: %w", bucketKey, err)
+	}
+	if !sharded {
+		return bucketDate, 0, nil
+	}
+
+	shard, err := strconv.Atoi(shardPart)
+	if err != nil || shard < 1 || shard >= MaxCounterShards {
+		return time.Time{}, 0, fmt.Errorf("invalid shard in bucket key %q", bucketKey)
+	}
+	return bucketDate, shard, nil
+}
+
+// sequenceValue combines a bucket and the counter within it into a single
+// value with the Cantor pairing function. Each shard of each day gets its own
+// row, day*MaxCounterShards+shard, and pairing is a bijection between pairs
+// of naturals and naturals, so distinct (row, counter) pairs never map to the
+// same value no matter how large a shard's counter grows.
+func sequenceValue(bucketKey string, counter int64) (int64, error) {
+	bucketDate, shard, err := parseBucketKey(bucketKey)
+	if err != nil {
+		return 0, err
+	}
+
+	day := int64(bucketDate.Sub(sequenceEpoch) / (24 * time.Hour))
+	row := day*MaxCounterShards + int64(shard)
+	if day < 0 || counter < 0 || row+counter > maxPairSum {
+		return 0, fmt.Errorf("counter %d in bucket %s is outside the sequence space", counter, bucketKey)
+	}
+
+	sum := row + counter
+	return sum*(sum+1)/2 + counter, nil
+}
+
+// CounterAPI is the subset of the DynamoDB client used by CounterStorage
+type CounterAPI interface {
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
 }
 
 type CounterStorage struct {
-	client *dynamodb.Client
+	client    CounterAPI
+	leaseSize int64
+	shards    int
+	now       func() time.Time
+	leases    []counterLease
+	// retryDelay is the first backoff before unprocessed cleanup deletes
+	// are retried
+	retryDelay time.Duration
+}
+
+// counterLease is the range (next, end] of the counter of bucket that has
+// been reserved but not handed out yet. Each shard has its own lease so
+// goroutines using different shards do not wait for each other.
+type counterLease struct {
+	mu     sync.Mutex
+	bucket string
+	next   int64
+	end    int64
 }
 
-func NewCounterStorage(client *dynamodb.Client) *CounterStorage {
-	return &CounterStorage{
-		client: client,
+// CounterOption configures a CounterStorage
+type CounterOption func(*CounterStorage)
+
+// WithLeaseSize makes the counter reserve size values per UpdateItem and hand
+// them out from memory. Values left in a lease when the process exits are
+// never used, so larger leases trade gaps in the sequence for fewer writes.
+// Every shard holds its own lease.
+func WithLeaseSize(size int64) CounterOption {
+	return func(s *CounterStorage) {
+		if size > 0 {
+			s.leaseSize = size
+		}
 	}
 }
 
-// GetNextCounter retrieves and increments the counter atomically within the current day bucket
+// WithShards spreads each day bucket over shards items, which DynamoDB can
+// place on different partitions. Each call draws from a random shard.
+// Values outside 1 to MaxCounterShards are ignored.
+func WithShards(shards int) CounterOption {
+	return func(s *CounterStorage) {
+		if shards > 0 && shards <= MaxCounterShards {
+			s.shards = shards
+		}
+	}
+}
+
+func NewCounterStorage(client CounterAPI, opts ...CounterOption) *CounterStorage {
+	s := &CounterStorage{
+		client:     client,
+		leaseSize:  1,
+		shards:     1,
+		now:        time.Now,
+		retryDelay: defaultRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.leases = make([]counterLease, s.shards)
+	return s
+}
+
+// GetNextCounter returns the next counter of a shard of the current day
+// bucket paired with the day and shard, so values are unique across buckets.
+// Counters come from the current lease; a new lease is taken when it runs out
+// or the day changes.
 func (s *CounterStorage) GetNextCounter(ctx context.Context) (int64, error) {
-	bucketKey := getBucketKey()
-	
+	shard := rand.Intn(s.shards)
+	lease := &s.leases[shard]
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+
+	bucketKey := getBucketKey(s.now(), shard)
+	if bucketKey != lease.bucket || lease.next >= lease.end {
+		end, err := s.lease(ctx, bucketKey)
+		if err != nil {
+			return 0, err
+		}
+		lease.bucket = bucketKey
+		lease.next = end - s.leaseSize
+		lease.end = end
+	}
+
+	lease.next++
+	return sequenceValue(lease.bucket, lease.next)
+}
+
+// lease atomically reserves the next leaseSize counters of a bucket and
+// returns the last one
+func (s *CounterStorage) lease(ctx context.Context, bucketKey string) (int64, error) {
 	input := &dynamodb.UpdateItemInput{
 		TableName: aws.String(counterTableName),
 		Key: map[string]types.AttributeValue{
@@ -44,7 +186,7 @@ func (s *CounterStorage) GetNextCounter(ctx context.Context) (int64, error) {
 		UpdateExpression: aws.String("SET CounterValue = if_not_exists(CounterValue, :zero) + :inc"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":zero": &types.AttributeValueMemberN{Value: "0"},
-			":inc":  &types.AttributeValueMemberN{Value: "1"},
+			":inc":  &types.AttributeValueMemberN{Value: strconv.FormatInt(s.leaseSize, 10)},
 		},
 		ReturnValues: types.ReturnValueAllNew,
 	}
@@ -61,43 +203,3 @@ func (s *CounterStorage) GetNextCounter(ctx context.Context) (int64, error) {
 
 	return newValue, nil
 }
-
-// CleanupOldBuckets removes counter entries older than the specified number of days
-func (s *CounterStorage) CleanupOldBuckets(ctx context.Context, daysToKeep int) error {
-	cutoffDate := time.Now().UTC().AddDate(0, 0, -daysToKeep)
-	
-	// List all items in the table
-	input := &dynamodb.ScanInput{
-		TableName: aws.String(counterTableName),
-	}
-	
-	result, err := s.client.Scan(ctx, input)
-	if err != nil {
-		return fmt.Errorf("failed to scan counter table: %w", err)
-	}
-	
-	// Delete old buckets
-	for _, item := range result.Items {
-		bucketKey := item["BucketKey"].(*types.AttributeValueMemberS).Value
-		bucketDate, err := time.Parse(dateFormat, bucketKey)
-		if err != nil {
-			continue // Skip items that don't match our date format
-		}
-		
-		if bucketDate.Before(cutoffDate) {
-			deleteInput := &dynamodb.DeleteItemInput{
-				TableName: aws.String(counterTableName),
-				Key: map[string]types.AttributeValue{
-					"BucketKey": &types.AttributeValueMemberS{Value: bucketKey},
-				},
-			}
-			
-			_, err := s.client.DeleteItem(ctx, deleteInput)
-			if err != nil {
-				return fmt.Errorf("failed to delete old bucket %s: %w", bucketKey, err)
-			}
-		}
-	}
-	
-	return nil
-} 
\ No newline at end of file
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// maxBatchWriteItems is the most requests DynamoDB accepts per BatchWriteItem
+	maxBatchWriteItems = 25
+	// maxBatchRetries bounds how often unprocessed deletes are retried
+	maxBatchRetries    = 5
+	defaultRetryDelay  = 50 * time.Millisecond
+	defaultConcurrency = 4
+)
+
+// BucketCleaner is implemented by counters that keep one item per day bucket
+type BucketCleaner interface {
+	CleanupOldBuckets(ctx context.Context, opts CleanupOptions) (*CleanupReport, error)
+}
+
+// CleanupOptions controls a single cleanup
+type CleanupOptions struct {
+	// DaysToKeep is how many days before today keep their buckets, at
+	// least 1
+	DaysToKeep int
+	// DryRun reports what would be deleted without deleting
+	DryRun bool
+	// Concurrency bounds the BatchWriteItem calls in flight, defaults to 4
+	Concurrency int
+}
+
+// CleanupReport summarises a cleanup
+type CleanupReport struct {
+	Scanned int `json:"scanned"`
+	// Stale is the number of buckets older than the cutoff
+	Stale   int `json:"stale"`
+	Deleted int `json:"deleted"`
+	// Skipped is the number of buckets kept, either recent or not a bucket
+	Skipped int      `json:"skipped"`
+	Failed  []string `json:"failed,omitempty"`
+	DryRun  bool     `json:"dryRun"`
+}
+
+// CleanupOldBuckets deletes the buckets of every shard that are older than
+// opts.DaysToKeep days. It pages through the whole table and deletes in
+// batches; a batch that cannot be deleted is recorded in the report rather
+// than aborting the cleanup. Buckets of days before yesterday are never
+// written again, so deleting them cannot make the counter repeat a value.
+// Keeping less than a day would delete the live bucket of today, or of a
+// server whose clock is behind, so DaysToKeep below 1 is rejected.
+func (s *CounterStorage) CleanupOldBuckets(ctx context.Context, opts CleanupOptions) (*CleanupReport, error) {
+	if opts.DaysToKeep < 1 {
+		return nil, fmt.Errorf("days to keep must be at least 1, got %d", opts.DaysToKeep)
+	}
+
+	report := &CleanupReport{DryRun: opts.DryRun}
+	cutoffDate := s.now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -opts.DaysToKeep)
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		sem = make(chan struct{}, concurrency)
+	)
+	deleteBatch := func(keys []string) {
+		defer wg.Done()
+		defer func() { <-sem }()
+
+		failed := s.deleteBuckets(ctx, keys)
+		mu.Lock()
+		report.Deleted += len(keys) - len(failed)
+		report.Failed = append(report.Failed, failed...)
+		mu.Unlock()
+	}
+
+	var batch []string
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go deleteBatch(batch)
+		batch = nil
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(counterTableName),
+		ProjectionExpression: aws.String("BucketKey"),
+	}
+	var scanErr error
+	for {
+		result, err := s.client.Scan(ctx, input)
+		if err != nil {
+			scanErr = fmt.Errorf("failed to scan counter table: %w", err)
+			break
+		}
+
+		for _, item := range result.Items {
+			report.Scanned++
+			key, ok := item["BucketKey"].(*types.AttributeValueMemberS)
+			if !ok {
+				report.Skipped++
+				continue
+			}
+			bucketDate, _, err := parseBucketKey(key.Value)
+			if err != nil || !bucketDate.Before(cutoffDate) {
+				report.Skipped++ // Recent, or not one of our buckets
+				continue
+			}
+
+			report.Stale++
+			if opts.DryRun {
+				continue
+			}
+			batch = append(batch, key.Value)
+			if len(batch) == maxBatchWriteItems {
+				flush()
+			}
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	flush()
+	wg.Wait()
+
+	return report, scanErr
+}
+
+// deleteBuckets deletes one batch of buckets, retrying unprocessed deletes
+// with exponential backoff, and returns the keys it could not delete
+func (s *CounterStorage) deleteBuckets(ctx context.Context, keys []string) []string {
+	requests := make([]types.WriteRequest, len(keys))
+	for i, key := range keys {
+		requests[i] = types.WriteRequest{
+			DeleteRequest: &types.DeleteRequest{
+				Key: map[string]types.AttributeValue{
+					"BucketKey": &types.AttributeValueMemberS{Value: key},
+				},
+			},
+		}
+	}
+
+	delay := s.retryDelay
+	for attempt := 1; ; attempt++ {
+		result, err := s.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{counterTableName: requests},
+		})
+		if err != nil {
+			return bucketKeys(requests)
+		}
+
+		requests = result.UnprocessedItems[counterTableName]
+		if len(requests) == 0 {
+			return nil
+		}
+		if attempt == maxBatchRetries {
+			return bucketKeys(requests)
+		}
+
+		select {
+		case <-ctx.Done():
+			return bucketKeys(requests)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func bucketKeys(requests []types.WriteRequest) []string {
+	keys := make([]string, 0, len(requests))
+	for _, request := range requests {
+		if key, ok := request.DeleteRequest.Key["BucketKey"].(*types.AttributeValueMemberS); ok {
+			keys = append(keys, key.Value)
+		}
+	}
+	return keys
+}
@@ -2,6 +2,10 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,9 +16,10 @@ import (
 
 // MockDynamoDBClient is a mock implementation of the DynamoDB client
 type MockDynamoDBClient struct {
-	UpdateItemFunc func(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
-	ScanFunc       func(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
-	DeleteItemFunc func(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	UpdateItemFunc     func(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	ScanFunc           func(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	DeleteItemFunc     func(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	BatchWriteItemFunc func(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
 }
 
 func (m *MockDynamoDBClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
@@ -29,14 +34,18 @@ func (m *MockDynamoDBClient) DeleteItem(ctx context.Context, params *dynamodb.De
 	return m.DeleteItemFunc(ctx, params, optFns...)
 }
 
+func (m *MockDynamoDBClient) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	return m.BatchWriteItemFunc(ctx, params, optFns...)
+}
+
 func TestCounterStorage_GetNextCounter(t *testing.T) {
 	// Get current bucket key
 	currentBucket := time.Now().UTC().Format("2006-01-02")
-	
+
 	tests := []struct {
 		name           string
 		mockUpdateItem func(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
-		expectedValue  int64
+		expectedCount  int64
 		expectError    bool
 	}{
 		{
@@ -46,14 +55,14 @@ func TestCounterStorage_GetNextCounter(t *testing.T) {
 				if params.Key["BucketKey"].(*types.AttributeValueMemberS).Value != currentBucket {
 					t.Errorf("Expected bucket key %s, got %s", currentBucket, params.Key["BucketKey"].(*types.AttributeValueMemberS).Value)
 				}
-				
+
 				return &dynamodb.UpdateItemOutput{
 					Attributes: map[string]types.AttributeValue{
 						"CounterValue": &types.AttributeValueMemberN{Value: "42"},
 					},
 				}, nil
 			},
-			expectedValue: 42,
+			expectedCount: 42,
 			expectError:   false,
 		},
 		{
@@ -65,15 +74,15 @@ func TestCounterStorage_GetNextCounter(t *testing.T) {
 					},
 				}, nil
 			},
-			expectedValue: 1,
+			expectedCount: 1,
 			expectError:   false,
 		},
 		{
 			name: "error from dynamodb",
 			mockUpdateItem: func(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
-				return nil, aws.NewError("InternalServerError")
+				return nil, &types.InternalServerError{Message: aws.String("internal error")}
 			},
-			expectedValue: 0,
+			expectedCount: 0,
 			expectError:   true,
 		},
 	}
@@ -98,8 +107,10 @@ func TestCounterStorage_GetNextCounter(t *testing.T) {
 			}
 
 			// Check value if no error expected
-			if !tt.expectError && value != tt.expectedValue {
-				t.Errorf("GetNextCounter() value = %v, expected %v", value, tt.expectedValue)
+			// The bucket counter is paired with the current day
+			expectedValue, _ := sequenceValue(currentBucket, tt.expectedCount)
+			if !tt.expectError && value != expectedValue {
+				t.Errorf("GetNextCounter() value = %v, expected %v", value, expectedValue)
 			}
 		})
 	}
@@ -107,25 +118,29 @@ func TestCounterStorage_GetNextCounter(t *testing.T) {
 
 func TestCounterStorage_GetNextCounter_Concurrent(t *testing.T) {
 	// This test verifies that the counter is thread-safe by simulating concurrent access
-	
+
 	// Create a counter that returns sequential values
 	currentBucket := time.Now().UTC().Format("2006-01-02")
 	counters := make(map[string]int64)
-	
+	var mu sync.Mutex
+
 	mockClient := &MockDynamoDBClient{
 		UpdateItemFunc: func(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
 			bucketKey := params.Key["BucketKey"].(*types.AttributeValueMemberS).Value
-			
+
 			// Verify we're using the current bucket
 			if bucketKey != currentBucket {
 				t.Errorf("Expected bucket key %s, got %s", currentBucket, bucketKey)
 			}
-			
+
 			// Simulate atomic increment
+			mu.Lock()
 			counters[bucketKey]++
+			value := counters[bucketKey]
+			mu.Unlock()
 			return &dynamodb.UpdateItemOutput{
 				Attributes: map[string]types.AttributeValue{
-					"CounterValue": &types.AttributeValueMemberN{Value: aws.String(counters[bucketKey])},
+					"CounterValue": &types.AttributeValueMemberN{Value: strconv.FormatInt(value, 10)},
 				},
 			}, nil
 		},
@@ -169,109 +184,462 @@ func TestCounterStorage_GetNextCounter_Concurrent(t *testing.T) {
 		t.Errorf("Expected %d unique values, got %d", expectedCount, len(values))
 	}
 
-	// Check that all values are in the expected range
-	for value := range values {
-		if value < 1 || value > int64(expectedCount) {
-			t.Errorf("Value %d is outside the expected range [1, %d]", value, expectedCount)
+	// Check that the values are exactly the day's counters 1..expectedCount
+	for i := int64(1); i <= int64(expectedCount); i++ {
+		expectedValue, _ := sequenceValue(currentBucket, i)
+		if !values[expectedValue] {
+			t.Errorf("Missing value for counter %d", i)
+		}
+	}
+}
+
+// leasingClient simulates the atomic UpdateItem of the counter table
+type leasingClient struct {
+	MockDynamoDBClient
+	mu       sync.Mutex
+	counters map[string]int64
+	calls    int
+}
+
+func newLeasingClient() *leasingClient {
+	c := &leasingClient{counters: make(map[string]int64)}
+	c.UpdateItemFunc = func(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+		bucketKey := params.Key["BucketKey"].(*types.AttributeValueMemberS).Value
+		inc, _ := strconv.ParseInt(params.ExpressionAttributeValues[":inc"].(*types.AttributeValueMemberN).Value, 10, 64)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.calls++
+		c.counters[bucketKey] += inc
+		return &dynamodb.UpdateItemOutput{
+			Attributes: map[string]types.AttributeValue{
+				"CounterValue": &types.AttributeValueMemberN{Value: strconv.FormatInt(c.counters[bucketKey], 10)},
+			},
+		}, nil
+	}
+	return c
+}
+
+func TestCounterStorage_GetNextCounter_Leased(t *testing.T) {
+	client := newLeasingClient()
+	// Another process already holds the first lease of the day
+	currentBucket := time.Now().UTC().Format(dateFormat)
+	client.counters[currentBucket] = 1000
+
+	counterStorage := NewCounterStorage(client, WithLeaseSize(1000))
+
+	numGoroutines := 10
+	incrementsPerGoroutine := 250
+	results := make(chan int64, numGoroutines*incrementsPerGoroutine)
+
+	var wg sync.WaitGroup
+	for i := 0; i < numGoroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < incrementsPerGoroutine; j++ {
+				value, err := counterStorage.GetNextCounter(context.Background())
+				if err != nil {
+					t.Errorf("GetNextCounter() error = %v", err)
+					return
+				}
+				results <- value
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	values := make(map[int64]bool)
+	for value := range results {
+		if values[value] {
+			t.Fatalf("GetNextCounter() returned %d twice", value)
+		}
+		values[value] = true
+	}
+
+	// 2500 values need three leases, handed out after the other process's
+	for i := int64(1001); i <= 3500; i++ {
+		expectedValue, _ := sequenceValue(currentBucket, i)
+		if !values[expectedValue] {
+			t.Errorf("Missing value for counter %d", i)
+		}
+	}
+	if client.calls != 3 {
+		t.Errorf("UpdateItem called %d times, expected 3", client.calls)
+	}
+}
+
+func TestCounterStorage_GetNextCounter_LeaseRollsOverWithDay(t *testing.T) {
+	client := newLeasingClient()
+	counterStorage := NewCounterStorage(client, WithLeaseSize(1000))
+
+	now := time.Date(2025, 3, 1, 23, 59, 59, 0, time.UTC)
+	counterStorage.now = func() time.Time { return now }
+
+	first, _ := counterStorage.GetNextCounter(context.Background())
+	now = now.Add(time.Second)
+	second, _ := counterStorage.GetNextCounter(context.Background())
+
+	expectedFirst, _ := sequenceValue("2025-03-01", 1)
+	expectedSecond, _ := sequenceValue("2025-03-02", 1)
+	if first != expectedFirst || second != expectedSecond {
+		t.Errorf("GetNextCounter() = %d, %d, expected %d, %d", first, second, expectedFirst, expectedSecond)
+	}
+	if client.calls != 2 {
+		t.Errorf("UpdateItem called %d times, expected a new lease for the new day", client.calls)
+	}
+}
+
+func TestCounterStorage_GetNextCounter_Sharded(t *testing.T) {
+	client := newLeasingClient()
+	counterStorage := NewCounterStorage(client, WithShards(4), WithLeaseSize(10))
+
+	var mu sync.Mutex
+	values := make(map[int64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 250; j++ {
+				value, err := counterStorage.GetNextCounter(context.Background())
+				if err != nil {
+					t.Errorf("GetNextCounter() error = %v", err)
+					return
+				}
+				mu.Lock()
+				if values[value] {
+					t.Errorf("GetNextCounter() returned %d twice", value)
+				}
+				values[value] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every shard was used and each has its own item
+	if len(client.counters) != 4 {
+		t.Errorf("counter table holds %d buckets, expected 4: %v", len(client.counters), client.counters)
+	}
+	for bucketKey := range client.counters {
+		if _, _, err := parseBucketKey(bucketKey); err != nil {
+			t.Errorf("unexpected bucket key %s: %v", bucketKey, err)
+		}
+	}
+}
+
+// partitionClient is a local stand-in for DynamoDB in which every item lives
+// on its own partition that serves one write at a time
+type partitionClient struct {
+	MockDynamoDBClient
+	latency    time.Duration
+	mu         sync.Mutex
+	partitions map[string]*sync.Mutex
+	counters   map[string]int64
+}
+
+func newPartitionClient(latency time.Duration) *partitionClient {
+	c := &partitionClient{
+		latency:    latency,
+		partitions: make(map[string]*sync.Mutex),
+		counters:   make(map[string]int64),
+	}
+	c.UpdateItemFunc = func(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+		bucketKey := params.Key["BucketKey"].(*types.AttributeValueMemberS).Value
+
+		c.mu.Lock()
+		partition, ok := c.partitions[bucketKey]
+		if !ok {
+			partition = &sync.Mutex{}
+			c.partitions[bucketKey] = partition
+		}
+		c.mu.Unlock()
+
+		partition.Lock()
+		time.Sleep(c.latency)
+		c.mu.Lock()
+		c.counters[bucketKey]++
+		value := c.counters[bucketKey]
+		c.mu.Unlock()
+		partition.Unlock()
+
+		return &dynamodb.UpdateItemOutput{
+			Attributes: map[string]types.AttributeValue{
+				"CounterValue": &types.AttributeValueMemberN{Value: strconv.FormatInt(value, 10)},
+			},
+		}, nil
+	}
+	return c
+}
+
+// BenchmarkCounterStorage_Shards shows create throughput against a hot bucket
+// growing with the shard count, e.g.
+//
+//	go test ./internal/storage -run '^$' -bench Shards
+func BenchmarkCounterStorage_Shards(b *testing.B) {
+	for _, shards := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			counterStorage := NewCounterStorage(newPartitionClient(100*time.Microsecond), WithShards(shards))
+
+			b.SetParallelism(8)
+			start := time.Now()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := counterStorage.GetNextCounter(context.Background()); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "creates/s")
+		})
+	}
+}
+
+// unpair inverts the Cantor pairing used by sequenceValue
+func unpair(z int64) (row, counter int64) {
+	w := int64((math.Sqrt(float64(8*z+1)) - 1) / 2)
+	// Correct floating point rounding at large values
+	for w*(w+1)/2 > z {
+		w--
+	}
+	for (w+1)*(w+2)/2 <= z {
+		w++
+	}
+	counter = z - w*(w+1)/2
+	return w - counter, counter
+}
+
+func TestSequenceValue_Bijective(t *testing.T) {
+	days := 400
+	countersPerDay := int64(2000)
+	if testing.Short() {
+		days = 40
+	}
+	shards := []int{0, 1, MaxCounterShards - 1}
+
+	seen := make(map[int64]struct{}, days*len(shards)*int(countersPerDay))
+	for d := 0; d < days; d++ {
+		for _, shard := range shards {
+			bucketKey := getBucketKey(sequenceEpoch.AddDate(0, 0, d), shard)
+			for c := int64(1); c <= countersPerDay; c++ {
+				value, err := sequenceValue(bucketKey, c)
+				if err != nil {
+					t.Fatalf("sequenceValue(%s, %d) error = %v", bucketKey, c, err)
+				}
+				if _, ok := seen[value]; ok {
+					t.Fatalf("sequenceValue(%s, %d) = %d was already produced", bucketKey, c, value)
+				}
+				seen[value] = struct{}{}
+
+				expectedRow := int64(d*MaxCounterShards + shard)
+				if gotRow, gotCounter := unpair(value); gotRow != expectedRow || gotCounter != c {
+					t.Fatalf("unpair(%d) = (%d, %d), expected (%d, %d)", value, gotRow, gotCounter, expectedRow, c)
+				}
+			}
+		}
+	}
+
+	// The largest supported counter still fits in an int64
+	if _, err := sequenceValue(getBucketKey(time.Now(), MaxCounterShards-1), maxPairSum-1_000_000); err != nil {
+		t.Errorf("sequenceValue() large counter error = %v", err)
+	}
+	for _, bucketKey := range []string{"2023-12-31", "2025-01-01#0", "2025-01-01#32", "2025-01-01#x"} {
+		if _, err := sequenceValue(bucketKey, 1); err == nil {
+			t.Errorf("sequenceValue(%s) expected an error", bucketKey)
 		}
 	}
 }
 
+// bucketTable simulates a paginated counter table for cleanup tests
+type bucketTable struct {
+	mu       sync.Mutex
+	keys     []string
+	deleted  map[string]bool
+	pageSize int
+	// unprocessed is how many times each batch reports its last item as
+	// unprocessed before deleting it
+	unprocessed int
+	retries     map[string]int
+	failBatch   string
+}
+
+func (b *bucketTable) client() *MockDynamoDBClient {
+	return &MockDynamoDBClient{
+		ScanFunc: func(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+			start := 0
+			if params.ExclusiveStartKey != nil {
+				start, _ = strconv.Atoi(params.ExclusiveStartKey["offset"].(*types.AttributeValueMemberN).Value)
+			}
+			end := start + b.pageSize
+			if end > len(b.keys) {
+				end = len(b.keys)
+			}
+
+			output := &dynamodb.ScanOutput{}
+			for _, key := range b.keys[start:end] {
+				output.Items = append(output.Items, map[string]types.AttributeValue{
+					"BucketKey": &types.AttributeValueMemberS{Value: key},
+				})
+			}
+			if end < len(b.keys) {
+				output.LastEvaluatedKey = map[string]types.AttributeValue{
+					"offset": &types.AttributeValueMemberN{Value: strconv.Itoa(end)},
+				}
+			}
+			return output, nil
+		},
+		BatchWriteItemFunc: func(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+			requests := params.RequestItems[counterTableName]
+			if len(requests) > maxBatchWriteItems {
+				return nil, fmt.Errorf("batch of %d requests exceeds the limit", len(requests))
+			}
+
+			for _, request := range requests {
+				if request.DeleteRequest.Key["BucketKey"].(*types.AttributeValueMemberS).Value == b.failBatch {
+					return nil, &types.InternalServerError{Message: aws.String("internal error")}
+				}
+			}
+
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			output := &dynamodb.BatchWriteItemOutput{}
+			for i, request := range requests {
+				key := request.DeleteRequest.Key["BucketKey"].(*types.AttributeValueMemberS).Value
+				if i == len(requests)-1 && b.retries[key] < b.unprocessed {
+					b.retries[key]++
+					output.UnprocessedItems = map[string][]types.WriteRequest{counterTableName: {request}}
+					continue
+				}
+				b.deleted[key] = true
+			}
+			return output, nil
+		},
+	}
+}
+
 func TestCounterStorage_CleanupOldBuckets(t *testing.T) {
+	now := time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	// 60 days with two shards each plus an unrelated item
+	var keys []string
+	for d := 0; d < 60; d++ {
+		day := now.AddDate(0, 0, -d)
+		keys = append(keys, getBucketKey(day, 0), getBucketKey(day, 1))
+	}
+	keys = append(keys, "url_counter")
+
 	tests := []struct {
-		name           string
-		daysToKeep     int
-		mockScan       func(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
-		mockDeleteItem func(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
-		expectError    bool
+		name            string
+		opts            CleanupOptions
+		unprocessed     int
+		failBatch       string
+		expectedStale   int
+		expectedDeleted int
+		expectedFailed  int
 	}{
 		{
-			name:       "successful cleanup",
-			daysToKeep: 7,
-			mockScan: func(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
-				// Create some test items with different dates
-				now := time.Now().UTC()
-				oldDate := now.AddDate(0, 0, -10).Format("2006-01-02")
-				recentDate := now.AddDate(0, 0, -5).Format("2006-01-02")
-				today := now.Format("2006-01-02")
-				
-				return &dynamodb.ScanOutput{
-					Items: []map[string]types.AttributeValue{
-						{
-							"BucketKey": &types.AttributeValueMemberS{Value: oldDate},
-						},
-						{
-							"BucketKey": &types.AttributeValueMemberS{Value: recentDate},
-						},
-						{
-							"BucketKey": &types.AttributeValueMemberS{Value: today},
-						},
-					},
-				}, nil
-			},
-			mockDeleteItem: func(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
-				// Verify we're only deleting old buckets
-				bucketKey := params.Key["BucketKey"].(*types.AttributeValueMemberS).Value
-				bucketDate, _ := time.Parse("2006-01-02", bucketKey)
-				cutoffDate := time.Now().UTC().AddDate(0, 0, -7)
-				
-				if !bucketDate.Before(cutoffDate) {
-					t.Errorf("Attempting to delete recent bucket: %s", bucketKey)
-				}
-				
-				return &dynamodb.DeleteItemOutput{}, nil
-			},
-			expectError: false,
+			name:            "deletes old buckets of every shard",
+			opts:            CleanupOptions{DaysToKeep: 7},
+			expectedStale:   104,
+			expectedDeleted: 104,
 		},
 		{
-			name:       "scan error",
-			daysToKeep: 7,
-			mockScan: func(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
-				return nil, aws.NewError("InternalServerError")
-			},
-			mockDeleteItem: nil,
-			expectError:    true,
+			name:            "retries unprocessed deletes",
+			opts:            CleanupOptions{DaysToKeep: 7, Concurrency: 2},
+			unprocessed:     2,
+			expectedStale:   104,
+			expectedDeleted: 104,
 		},
 		{
-			name:       "delete error",
-			daysToKeep: 7,
-			mockScan: func(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
-				now := time.Now().UTC()
-				oldDate := now.AddDate(0, 0, -10).Format("2006-01-02")
-				
-				return &dynamodb.ScanOutput{
-					Items: []map[string]types.AttributeValue{
-						{
-							"BucketKey": &types.AttributeValueMemberS{Value: oldDate},
-						},
-					},
-				}, nil
-			},
-			mockDeleteItem: func(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
-				return nil, aws.NewError("InternalServerError")
-			},
-			expectError: true,
+			name:            "gives up on deletes that stay unprocessed",
+			opts:            CleanupOptions{DaysToKeep: 7},
+			unprocessed:     maxBatchRetries,
+			expectedStale:   104,
+			expectedDeleted: 99,
+			expectedFailed:  5,
+		},
+		{
+			name:            "failed batch does not abort the cleanup",
+			opts:            CleanupOptions{DaysToKeep: 7},
+			failBatch:       getBucketKey(now.AddDate(0, 0, -30), 1),
+			expectedStale:   104,
+			expectedDeleted: 79,
+			expectedFailed:  25,
+		},
+		{
+			name:          "dry run",
+			opts:          CleanupOptions{DaysToKeep: 7, DryRun: true},
+			expectedStale: 104,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Create mock client
-			mockClient := &MockDynamoDBClient{
-				ScanFunc:       tt.mockScan,
-				DeleteItemFunc: tt.mockDeleteItem,
+			table := &bucketTable{
+				keys:        keys,
+				deleted:     make(map[string]bool),
+				pageSize:    17,
+				unprocessed: tt.unprocessed,
+				retries:     make(map[string]int),
+				failBatch:   tt.failBatch,
 			}
+			counterStorage := NewCounterStorage(table.client())
+			counterStorage.now = func() time.Time { return now }
+			counterStorage.retryDelay = 0
 
-			// Create counter storage with mock client
-			counterStorage := NewCounterStorage(mockClient)
+			report, err := counterStorage.CleanupOldBuckets(context.Background(), tt.opts)
+			if err != nil {
+				t.Fatalf("CleanupOldBuckets() error = %v", err)
+			}
 
-			// Call CleanupOldBuckets
-			err := counterStorage.CleanupOldBuckets(context.Background(), tt.daysToKeep)
+			if report.Scanned != len(keys) || report.Stale != tt.expectedStale ||
+				report.Skipped != len(keys)-tt.expectedStale || report.Deleted != tt.expectedDeleted ||
+				len(report.Failed) != tt.expectedFailed {
+				t.Errorf("CleanupOldBuckets() report = %+v, expected stale=%d deleted=%d failed=%d",
+					report, tt.expectedStale, tt.expectedDeleted, tt.expectedFailed)
+			}
+			if len(table.deleted) != tt.expectedDeleted {
+				t.Errorf("table deleted %d buckets, expected %d", len(table.deleted), tt.expectedDeleted)
+			}
 
-			// Check error
-			if (err != nil) != tt.expectError {
-				t.Errorf("CleanupOldBuckets() error = %v, expectError %v", err, tt.expectError)
+			// Today and the seven days before it are always kept
+			for d := 0; d <= 7; d++ {
+				if bucketKey := getBucketKey(now.AddDate(0, 0, -d), 1); table.deleted[bucketKey] {
+					t.Errorf("recent bucket %s was deleted", bucketKey)
+				}
 			}
 		})
 	}
-} 
\ No newline at end of file
+}
+
+func TestCounterStorage_CleanupOldBuckets_ScanError(t *testing.T) {
+	mockClient := &MockDynamoDBClient{
+		ScanFunc: func(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+			return nil, &types.InternalServerError{Message: aws.String("internal error")}
+		},
+	}
+
+	_, err := NewCounterStorage(mockClient).CleanupOldBuckets(context.Background(), CleanupOptions{DaysToKeep: 7})
+	if err == nil {
+		t.Error("CleanupOldBuckets() expected an error when the scan fails")
+	}
+}
+
+func TestCounterStorage_CleanupOldBuckets_InvalidDays(t *testing.T) {
+	mockClient := &MockDynamoDBClient{
+		ScanFunc: func(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+			t.Fatal("CleanupOldBuckets() scanned with invalid days")
+			return nil, nil
+		},
+	}
+
+	// Today's live bucket must never be deleted
+	for _, days := range []int{0, -1} {
+		if _, err := NewCounterStorage(mockClient).CleanupOldBuckets(context.Background(), CleanupOptions{DaysToKeep: days}); err == nil {
+			t.Errorf("CleanupOldBuckets() with DaysToKeep %d expected an error", days)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -14,27 +16,53 @@ import (
 
 const (
 	tableName = "url-shortener"
+	// canonicalIndexName is a global secondary index keyed by CanonicalHash.
+	// Only URLs with a hash have the attribute, so the index stays sparse.
+	canonicalIndexName = "CanonicalHash-index"
 )
 
+// URLTableAPI is the subset of the DynamoDB client used by DynamoDBStorage
+type URLTableAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
 type DynamoDBStorage struct {
-	client *dynamodb.Client
+	client URLTableAPI
 }
 
-func NewDynamoDBStorage(client *dynamodb.Client) *DynamoDBStorage {
+func NewDynamoDBStorage(client URLTableAPI) *DynamoDBStorage {
 	return &DynamoDBStorage{
 		client: client,
 	}
 }
 
+// marshalURL converts a URL to a DynamoDB item, deriving the TTL attribute
+// from ExpiresAt so native TTL applies to every write
+func marshalURL(url *models.URL) (map[string]types.AttributeValue, error) {
+	item := *url
+	item.SetExpiresAt(url.ExpiresAt)
+
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal URL: %w", err)
+	}
+	return av, nil
+}
+
 func (s *DynamoDBStorage) Create(ctx context.Context, url *models.URL) error {
-	av, err := attributevalue.MarshalMap(url)
+	av, err := marshalURL(url)
 	if err != nil {
-		return fmt.Errorf("failed to marshal URL: %w", err)
+		return err
 	}
 
 	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
+		Item:                av,
+		TableName:           aws.String(tableName),
 		ConditionExpression: aws.String("attribute_not_exists(ShortCode)"),
 	}
 
@@ -73,25 +101,293 @@ func (s *DynamoDBStorage) Get(ctx context.Context, shortCode string) (*models.UR
 		return nil, fmt.Errorf("failed to unmarshal URL: %w", err)
 	}
 
-	if !url.ExpiresAt.IsZero() && time.Now().After(url.ExpiresAt) {
+	// Native TTL deletion is lazy and can lag by up to two days, so the
+	// expiration check here stays authoritative
+	if url.IsExpired(time.Now()) {
 		return nil, models.ErrURLExpired
 	}
 
 	return &url, nil
 }
 
+// Update replaces an existing URL, failing if the short code does not exist
+func (s *DynamoDBStorage) Update(ctx context.Context, url *models.URL) error {
+	av, err := marshalURL(url)
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_exists(ShortCode)"),
+	}
+
+	_, err = s.client.PutItem(ctx, input)
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return models.ErrURLNotFound
+		}
+		return fmt.Errorf("failed to put item: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateIf replaces the URL on condition that it still points to
+// originalURL with status
+func (s *DynamoDBStorage) UpdateIf(ctx context.Context, url *models.URL, originalURL, status string) error {
+	av, err := marshalURL(url)
+	if err != nil {
+		return err
+	}
+
+	// Active URLs are stored without a status
+	condition := "OriginalURL = :url AND attribute_not_exists(#status)"
+	values := map[string]types.AttributeValue{
+		":url": &types.AttributeValueMemberS{Value: originalURL},
+	}
+	if status != "" {
+		condition = "OriginalURL = :url AND #status = :status"
+		values[":status"] = &types.AttributeValueMemberS{Value: status}
+	}
+	input := &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String(condition),
+		// Status is a reserved word
+		ExpressionAttributeNames:  map[string]string{"#status": "Status"},
+		ExpressionAttributeValues: values,
+		// The old item tells a missing URL from one that changed
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+
+	_, err = s.client.PutItem(ctx, input)
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			if condErr.Item == nil {
+				return models.ErrURLNotFound
+			}
+			return models.ErrURLChanged
+		}
+		return fmt.Errorf("failed to put item: %w", err)
+	}
+
+	return nil
+}
+
 func (s *DynamoDBStorage) Delete(ctx context.Context, shortCode string) error {
 	input := &dynamodb.DeleteItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"ShortCode": &types.AttributeValueMemberS{Value: shortCode},
 		},
+		ConditionExpression: aws.String("attribute_exists(ShortCode)"),
 	}
 
 	_, err := s.client.DeleteItem(ctx, input)
 	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return models.ErrURLNotFound
+		}
 		return fmt.Errorf("failed to delete item: %w", err)
 	}
 
 	return nil
-} 
\ No newline at end of file
+}
+
+// DeleteExpired deletes the item on condition that its TTL attribute has
+// passed. The TTL has second precision, which is what native TTL uses too.
+func (s *DynamoDBStorage) DeleteExpired(ctx context.Context, shortCode string, now time.Time) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"ShortCode": &types.AttributeValueMemberS{Value: shortCode},
+		},
+		// TTL is a reserved word
+		ConditionExpression:      aws.String("attribute_exists(ShortCode) AND #ttl <= :now"),
+		ExpressionAttributeNames: map[string]string{"#ttl": "TTL"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+		// The old item tells a missing URL from one that is not expired
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+
+	_, err := s.client.DeleteItem(ctx, input)
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			if condErr.Item == nil {
+				return models.ErrURLNotFound
+			}
+			return models.ErrURLNotExpired
+		}
+		return fmt.Errorf("failed to delete item: %w", err)
+	}
+
+	return nil
+}
+
+// Quarantine sets the status and threats of the URL on condition that it is
+// active and still points to originalURL, leaving its other attributes as
+// they are
+func (s *DynamoDBStorage) Quarantine(ctx context.Context, shortCode, originalURL string, threats []string) error {
+	threatList := make([]types.AttributeValue, len(threats))
+	for i, threat := range threats {
+		threatList[i] = &types.AttributeValueMemberS{Value: threat}
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"ShortCode": &types.AttributeValueMemberS{Value: shortCode},
+		},
+		UpdateExpression:    aws.String("SET #status = :quarantined, Threats = :threats"),
+		ConditionExpression: aws.String("OriginalURL = :url AND (attribute_not_exists(#status) OR #status = :active)"),
+		// Status is a reserved word
+		ExpressionAttributeNames: map[string]string{"#status": "Status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":quarantined": &types.AttributeValueMemberS{Value: models.StatusQuarantined},
+			":active":      &types.AttributeValueMemberS{Value: models.StatusActive},
+			":threats":     &types.AttributeValueMemberL{Value: threatList},
+			":url":         &types.AttributeValueMemberS{Value: originalURL},
+		},
+		// The old item tells a missing URL from one that changed
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+
+	_, err := s.client.UpdateItem(ctx, input)
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			if condErr.Item == nil {
+				return models.ErrURLNotFound
+			}
+			return models.ErrURLChanged
+		}
+		return fmt.Errorf("failed to quarantine item: %w", err)
+	}
+
+	return nil
+}
+
+// Release sets the status of the URL to released on condition that it is
+// quarantined and still points to originalURL, keeping its threats
+func (s *DynamoDBStorage) Release(ctx context.Context, shortCode, originalURL string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"ShortCode": &types.AttributeValueMemberS{Value: shortCode},
+		},
+		UpdateExpression:    aws.String("SET #status = :released"),
+		ConditionExpression: aws.String("OriginalURL = :url AND #status = :quarantined"),
+		// Status is a reserved word
+		ExpressionAttributeNames: map[string]string{"#status": "Status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":released":    &types.AttributeValueMemberS{Value: models.StatusReleased},
+			":quarantined": &types.AttributeValueMemberS{Value: models.StatusQuarantined},
+			":url":         &types.AttributeValueMemberS{Value: originalURL},
+		},
+		// The old item tells a missing URL from one that changed
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+
+	_, err := s.client.UpdateItem(ctx, input)
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			if condErr.Item == nil {
+				return models.ErrURLNotFound
+			}
+			return models.ErrURLChanged
+		}
+		return fmt.Errorf("failed to release item: %w", err)
+	}
+
+	return nil
+}
+
+// FindByCanonicalHash queries the canonical hash index. The index is
+// eventually consistent, so a URL created a moment ago may not be found yet.
+func (s *DynamoDBStorage) FindByCanonicalHash(ctx context.Context, hash string) (*models.URL, error) {
+	now := time.Now()
+	var startKey map[string]types.AttributeValue
+	for {
+		output, err := s.client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			IndexName:              aws.String(canonicalIndexName),
+			KeyConditionExpression: aws.String("CanonicalHash = :hash"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":hash": &types.AttributeValueMemberS{Value: hash},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query canonical index: %w", err)
+		}
+
+		for _, item := range output.Items {
+			var url models.URL
+			if err := attributevalue.UnmarshalMap(item, &url); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal URL: %w", err)
+			}
+			if !url.IsExpired(now) {
+				return &url, nil
+			}
+		}
+
+		if len(output.LastEvaluatedKey) == 0 {
+			return nil, models.ErrURLNotFound
+		}
+		startKey = output.LastEvaluatedKey
+	}
+}
+
+// List scans the table one page at a time. The cursor is the short code of
+// the last item returned, which is also the table's only key attribute.
+func (s *DynamoDBStorage) List(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	limit := opts.limit()
+	result := &ListResult{}
+	now := time.Now()
+
+	var startKey map[string]types.AttributeValue
+	if opts.Cursor != "" {
+		startKey = map[string]types.AttributeValue{
+			"ShortCode": &types.AttributeValueMemberS{Value: opts.Cursor},
+		}
+	}
+
+	for len(result.URLs) < limit {
+		output, err := s.client.Scan(ctx, &dynamodb.ScanInput{
+			TableName:         aws.String(tableName),
+			ExclusiveStartKey: startKey,
+			Limit:             aws.Int32(int32(limit - len(result.URLs))),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan table: %w", err)
+		}
+
+		for _, item := range output.Items {
+			var url models.URL
+			if err := attributevalue.UnmarshalMap(item, &url); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal URL: %w", err)
+			}
+			result.NextCursor = url.ShortCode
+			if !opts.IncludeExpired && url.IsExpired(now) {
+				continue
+			}
+			result.URLs = append(result.URLs, &url)
+		}
+
+		if len(output.LastEvaluatedKey) == 0 {
+			result.NextCursor = ""
+			break
+		}
+		startKey = output.LastEvaluatedKey
+	}
+
+	return result, nil
+}
@@ -0,0 +1,346 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+const (
+	fileOpPut     = "put"
+	fileOpDelete  = "delete"
+	fileOpCounter = "counter"
+
+	// maxFileRecordSize bounds a single log line when replaying the file
+	maxFileRecordSize = 1024 * 1024
+)
+
+// fileRecord is a single line of the FileStorage log
+type fileRecord struct {
+	Op        string      `json:"op"`
+	URL       *models.URL `json:"url,omitempty"`
+	ShortCode string      `json:"shortCode,omitempty"`
+	Counter   int64       `json:"counter,omitempty"`
+}
+
+// FileStorage is an embedded URLStore and Counter for single-node
+// deployments. The full state is kept in memory and every change is appended
+// to a JSON lines log, which is replayed and compacted when the file is opened.
+//
+// Compaction replaces the log, so a process that still had the old one open
+// would lose its writes. A single process may open the log at a time: it holds
+// an exclusive lock on a .lock file next to it until closed.
+type FileStorage struct {
+	mu      sync.RWMutex
+	path    string
+	lock    *os.File
+	file    *os.File
+	urls    map[string]models.URL
+	counter int64
+}
+
+// OpenFileStorage opens or creates the log at path
+func OpenFileStorage(path string) (*FileStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	// The lock file is never replaced, unlike the log
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage lock: %w", err)
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to lock storage file %s: %w", path, err)
+	}
+
+	s := &FileStorage{
+		path: path,
+		lock: lock,
+		urls: make(map[string]models.URL),
+	}
+	if err := s.open(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// open replays and compacts the log, then opens it for appending
+func (s *FileStorage) open() error {
+	if err := s.replay(); err != nil {
+		return err
+	}
+	if err := s.compact(); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open storage file: %w", err)
+	}
+	s.file = file
+	return nil
+}
+
+// replay rebuilds the in-memory state from the log. A malformed final line is
+// treated as a write interrupted by a crash and ignored.
+func (s *FileStorage) replay() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open storage file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxFileRecordSize)
+
+	var badLine error
+	for line := 1; scanner.Scan(); line++ {
+		if badLine != nil {
+			return badLine
+		}
+
+		var rec fileRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			badLine = fmt.Errorf("corrupt storage file at line %d: %w", line, err)
+			continue
+		}
+		s.apply(rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read storage file: %w", err)
+	}
+
+	return nil
+}
+
+// compact rewrites the log so it holds exactly one record per live URL
+func (s *FileStorage) compact() error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create storage file: %w", err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	if err := encoder.Encode(fileRecord{Op: fileOpCounter, Counter: s.counter}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write storage file: %w", err)
+	}
+	for _, url := range s.urls {
+		url := url
+		if err := encoder.Encode(fileRecord{Op: fileOpPut, URL: &url}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write storage file: %w", err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write storage file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync storage file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close storage file: %w", err)
+	}
+
+	return os.Rename(tmpPath, s.path)
+}
+
+// apply updates the in-memory state for a record. Callers must hold s.mu.
+func (s *FileStorage) apply(rec fileRecord) {
+	switch rec.Op {
+	case fileOpPut:
+		if rec.URL != nil {
+			s.urls[rec.URL.ShortCode] = *rec.URL
+		}
+	case fileOpDelete:
+		delete(s.urls, rec.ShortCode)
+	case fileOpCounter:
+		if rec.Counter > s.counter {
+			s.counter = rec.Counter
+		}
+	}
+}
+
+// write appends a record to the log and applies it once it is durable.
+// Callers must hold s.mu.
+func (s *FileStorage) write(rec fileRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write storage file: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync storage file: %w", err)
+	}
+
+	s.apply(rec)
+	return nil
+}
+
+func (s *FileStorage) Create(ctx context.Context, url *models.URL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[url.ShortCode]; ok {
+		return models.ErrDuplicateShortCode
+	}
+	return s.write(fileRecord{Op: fileOpPut, URL: url})
+}
+
+func (s *FileStorage) Get(ctx context.Context, shortCode string) (*models.URL, error) {
+	s.mu.RLock()
+	url, ok := s.urls[shortCode]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, models.ErrURLNotFound
+	}
+	if url.IsExpired(time.Now()) {
+		return nil, models.ErrURLExpired
+	}
+	return &url, nil
+}
+
+func (s *FileStorage) Update(ctx context.Context, url *models.URL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[url.ShortCode]; !ok {
+		return models.ErrURLNotFound
+	}
+	return s.write(fileRecord{Op: fileOpPut, URL: url})
+}
+
+// UpdateIf replaces the URL if it still points to originalURL with status
+func (s *FileStorage) UpdateIf(ctx context.Context, url *models.URL, originalURL, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.urls[url.ShortCode]
+	if !ok {
+		return models.ErrURLNotFound
+	}
+	if stored.OriginalURL != originalURL || stored.Status != status {
+		return models.ErrURLChanged
+	}
+	return s.write(fileRecord{Op: fileOpPut, URL: url})
+}
+
+func (s *FileStorage) Delete(ctx context.Context, shortCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[shortCode]; !ok {
+		return models.ErrURLNotFound
+	}
+	return s.write(fileRecord{Op: fileOpDelete, ShortCode: shortCode})
+}
+
+// DeleteExpired deletes the URL if it is expired at now
+func (s *FileStorage) DeleteExpired(ctx context.Context, shortCode string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, ok := s.urls[shortCode]
+	if !ok {
+		return models.ErrURLNotFound
+	}
+	if !url.IsExpired(now) {
+		return models.ErrURLNotExpired
+	}
+	return s.write(fileRecord{Op: fileOpDelete, ShortCode: shortCode})
+}
+
+// Quarantine flags the URL with threats if it is active and still points to
+// originalURL
+func (s *FileStorage) Quarantine(ctx context.Context, shortCode, originalURL string, threats []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, ok := s.urls[shortCode]
+	if !ok {
+		return models.ErrURLNotFound
+	}
+	if !quarantinable(url, originalURL) {
+		return models.ErrURLChanged
+	}
+	url.Quarantine(threats)
+	return s.write(fileRecord{Op: fileOpPut, URL: &url})
+}
+
+// Release marks the URL released if it is quarantined and still points to
+// originalURL
+func (s *FileStorage) Release(ctx context.Context, shortCode, originalURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, ok := s.urls[shortCode]
+	if !ok {
+		return models.ErrURLNotFound
+	}
+	if !releasable(url, originalURL) {
+		return models.ErrURLChanged
+	}
+	url.Release()
+	return s.write(fileRecord{Op: fileOpPut, URL: &url})
+}
+
+// List returns URLs ordered by short code
+func (s *FileStorage) List(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return listSorted(s.urls, opts), nil
+}
+
+// FindByCanonicalHash scans the stored URLs for hash
+func (s *FileStorage) FindByCanonicalHash(ctx context.Context, hash string) (*models.URL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return findCanonical(s.urls, hash)
+}
+
+// GetNextCounter increments the persisted counter
+func (s *FileStorage) GetNextCounter(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.write(fileRecord{Op: fileOpCounter, Counter: s.counter + 1}); err != nil {
+		return 0, err
+	}
+	return s.counter, nil
+}
+
+// Close closes the underlying log file
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.file.Close()
+	// Closing the lock file releases the lock
+	if lockErr := s.lock.Close(); err == nil {
+		err = lockErr
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// lockFile does nothing where flock is not available
+func lockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package storage
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+// lockFile takes an exclusive lock on file without waiting, returning
+// models.ErrStorageLocked when another process holds it
+func lockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return models.ErrStorageLocked
+	}
+	return err
+}
@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+// MemoryStorage is a URLStore that keeps everything in process memory. It is
+// meant for local development and tests; data is lost when the process exits.
+type MemoryStorage struct {
+	mu   sync.RWMutex
+	urls map[string]models.URL
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		urls: make(map[string]models.URL),
+	}
+}
+
+func (s *MemoryStorage) Create(ctx context.Context, url *models.URL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[url.ShortCode]; ok {
+		return models.ErrDuplicateShortCode
+	}
+	s.urls[url.ShortCode] = *url
+	return nil
+}
+
+func (s *MemoryStorage) Get(ctx context.Context, shortCode string) (*models.URL, error) {
+	s.mu.RLock()
+	url, ok := s.urls[shortCode]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, models.ErrURLNotFound
+	}
+	if url.IsExpired(time.Now()) {
+		return nil, models.ErrURLExpired
+	}
+	return &url, nil
+}
+
+func (s *MemoryStorage) Update(ctx context.Context, url *models.URL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[url.ShortCode]; !ok {
+		return models.ErrURLNotFound
+	}
+	s.urls[url.ShortCode] = *url
+	return nil
+}
+
+// UpdateIf replaces the URL if it still points to originalURL with status
+func (s *MemoryStorage) UpdateIf(ctx context.Context, url *models.URL, originalURL, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.urls[url.ShortCode]
+	if !ok {
+		return models.ErrURLNotFound
+	}
+	if stored.OriginalURL != originalURL || stored.Status != status {
+		return models.ErrURLChanged
+	}
+	s.urls[url.ShortCode] = *url
+	return nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, shortCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[shortCode]; !ok {
+		return models.ErrURLNotFound
+	}
+	delete(s.urls, shortCode)
+	return nil
+}
+
+// DeleteExpired deletes the URL if it is expired at now
+func (s *MemoryStorage) DeleteExpired(ctx context.Context, shortCode string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, ok := s.urls[shortCode]
+	if !ok {
+		return models.ErrURLNotFound
+	}
+	if !url.IsExpired(now) {
+		return models.ErrURLNotExpired
+	}
+	delete(s.urls, shortCode)
+	return nil
+}
+
+// Quarantine flags the URL with threats if it is active and still points to
+// originalURL
+func (s *MemoryStorage) Quarantine(ctx context.Context, shortCode, originalURL string, threats []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, ok := s.urls[shortCode]
+	if !ok {
+		return models.ErrURLNotFound
+	}
+	if !quarantinable(url, originalURL) {
+		return models.ErrURLChanged
+	}
+	url.Quarantine(threats)
+	s.urls[shortCode] = url
+	return nil
+}
+
+// Release marks the URL released if it is quarantined and still points to
+// originalURL
+func (s *MemoryStorage) Release(ctx context.Context, shortCode, originalURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, ok := s.urls[shortCode]
+	if !ok {
+		return models.ErrURLNotFound
+	}
+	if !releasable(url, originalURL) {
+		return models.ErrURLChanged
+	}
+	url.Release()
+	s.urls[shortCode] = url
+	return nil
+}
+
+// quarantinable reports whether url is active and points to originalURL
+func quarantinable(url models.URL, originalURL string) bool {
+	return url.OriginalURL == originalURL && (url.Status == "" || url.Status == models.StatusActive)
+}
+
+// releasable reports whether url is quarantined and points to originalURL
+func releasable(url models.URL, originalURL string) bool {
+	return url.OriginalURL == originalURL && url.IsQuarantined()
+}
+
+// List returns URLs ordered by short code. The cursor is the last short code
+// of the previous page.
+func (s *MemoryStorage) List(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return listSorted(s.urls, opts), nil
+}
+
+// FindByCanonicalHash scans the stored URLs for hash
+func (s *MemoryStorage) FindByCanonicalHash(ctx context.Context, hash string) (*models.URL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return findCanonical(s.urls, hash)
+}
+
+// findCanonical returns the unexpired URL with the lowest short code among
+// those created with hash. Callers must hold whatever lock protects urls.
+func findCanonical(urls map[string]models.URL, hash string) (*models.URL, error) {
+	now := time.Now()
+	var found *models.URL
+	for _, url := range urls {
+		if url.CanonicalHash != hash || url.IsExpired(now) {
+			continue
+		}
+		if found == nil || url.ShortCode < found.ShortCode {
+			url := url
+			found = &url
+		}
+	}
+	if found == nil {
+		return nil, models.ErrURLNotFound
+	}
+	return found, nil
+}
+
+// listSorted pages through a map of URLs in short code order. Callers must
+// hold whatever lock protects urls.
+func listSorted(urls map[string]models.URL, opts ListOptions) *ListResult {
+	codes := make([]string, 0, len(urls))
+	for code := range urls {
+		if code > opts.Cursor {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+
+	limit := opts.limit()
+	now := time.Now()
+	result := &ListResult{}
+	for i, code := range codes {
+		url := urls[code]
+		if !opts.IncludeExpired && url.IsExpired(now) {
+			continue
+		}
+		result.URLs = append(result.URLs, &url)
+		if len(result.URLs) == limit {
+			if i < len(codes)-1 {
+				result.NextCursor = code
+			}
+			break
+		}
+	}
+	return result
+}
+
+// MemoryCounter is a process-local Counter
+type MemoryCounter struct {
+	mu    sync.Mutex
+	value int64
+}
+
+func NewMemoryCounter() *MemoryCounter {
+	return &MemoryCounter{}
+}
+
+// GetNextCounter increments and returns the counter
+func (c *MemoryCounter) GetNextCounter(ctx context.Context) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.value++
+	return c.value, nil
+}
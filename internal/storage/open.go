@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/jingy/Go-Shortener/internal/config"
+)
+
+// Backend bundles the stores selected by configuration
+type Backend struct {
+	URLs     URLStore
+	Counter  Counter
+	Archiver Archiver
+	closer   io.Closer
+}
+
+// Close releases any resources held by the backend
+func (b *Backend) Close() error {
+	if b.closer == nil {
+		return nil
+	}
+	return b.closer.Close()
+}
+
+// Open creates the storage backend described by cfg
+func Open(ctx context.Context, cfg config.StorageConfig) (*Backend, error) {
+	switch cfg.Backend {
+	case config.BackendMemory:
+		return &Backend{
+			URLs:     NewMemoryStorage(),
+			Counter:  NewMemoryCounter(),
+			Archiver: NewMemoryArchiver(),
+		}, nil
+
+	case config.BackendFile:
+		fileStorage, err := OpenFileStorage(cfg.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		return &Backend{
+			URLs:     fileStorage,
+			Counter:  fileStorage,
+			Archiver: NewFileArchiver(cfg.ArchivePath),
+			closer:   fileStorage,
+		}, nil
+
+	case config.BackendDynamoDB:
+		if cfg.CounterShards > MaxCounterShards {
+			return nil, fmt.Errorf("counter shards must be at most %d", MaxCounterShards)
+		}
+		client, err := NewDynamoDBClient(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		counter := NewCounterStorage(client,
+			WithLeaseSize(int64(cfg.CounterLeaseSize)),
+			WithShards(cfg.CounterShards),
+		)
+		return &Backend{
+			URLs:     NewDynamoDBStorage(client),
+			Counter:  counter,
+			Archiver: NewDynamoDBArchiver(client),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+}
+
+// NewDynamoDBClient creates a DynamoDB client from the default AWS config,
+// honouring the endpoint override of cfg
+func NewDynamoDBClient(ctx context.Context, cfg config.StorageConfig) (*dynamodb.Client, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %w", err)
+	}
+
+	return dynamodb.NewFromConfig(awsCfg, func(o *dynamodb.Options) {
+		if cfg.DynamoDBEndpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.DynamoDBEndpoint)
+		}
+	}), nil
+}
+
+// NewDynamoDBStreamsClient creates a DynamoDB Streams client from the default
+// AWS config, honouring the endpoint override of cfg, which DynamoDB Local
+// serves streams on too
+func NewDynamoDBStreamsClient(ctx context.Context, cfg config.StorageConfig) (*dynamodbstreams.Client, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %w", err)
+	}
+
+	return dynamodbstreams.NewFromConfig(awsCfg, func(o *dynamodbstreams.Options) {
+		if cfg.DynamoDBEndpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.DynamoDBEndpoint)
+		}
+	}), nil
+}
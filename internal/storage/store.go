@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+const (
+	// defaultListLimit is used when ListOptions.Limit is not set
+	defaultListLimit = 100
+)
+
+// URLStore persists short URL mappings. Every backend (DynamoDB, in-memory,
+// embedded file) implements it so callers never depend on a concrete store.
+type URLStore interface {
+	// Create stores a new URL and returns models.ErrDuplicateShortCode if the
+	// short code is already taken
+	Create(ctx context.Context, url *models.URL) error
+	// Get returns the URL for a short code, models.ErrURLNotFound if it does
+	// not exist or models.ErrURLExpired if it has expired
+	Get(ctx context.Context, shortCode string) (*models.URL, error)
+	// Update replaces an existing URL and returns models.ErrURLNotFound if it
+	// does not exist
+	Update(ctx context.Context, url *models.URL) error
+	// Delete removes a URL and returns models.ErrURLNotFound if it does not exist
+	Delete(ctx context.Context, shortCode string) error
+	// List returns a page of URLs ordered by the backend's natural key order
+	List(ctx context.Context, opts ListOptions) (*ListResult, error)
+}
+
+// ExpiredDeleter is implemented by stores that can delete a URL only while
+// it is expired, so a URL whose expiration was extended or cleared after it
+// was found expired is kept
+type ExpiredDeleter interface {
+	// DeleteExpired removes the URL if it expired at now. It returns
+	// models.ErrURLNotFound if it does not exist and models.ErrURLNotExpired
+	// if it is no longer expired.
+	DeleteExpired(ctx context.Context, shortCode string, now time.Time) error
+}
+
+// ConditionalUpdater is implemented by stores that can replace a URL on
+// condition that its destination and status are still the ones read, so a
+// concurrent quarantine is not overwritten with the copy being updated
+type ConditionalUpdater interface {
+	// UpdateIf replaces the URL if it still points to originalURL with
+	// status. It returns models.ErrURLNotFound if it does not exist and
+	// models.ErrURLChanged if its destination or status changed.
+	UpdateIf(ctx context.Context, url *models.URL, originalURL, status string) error
+}
+
+// Quarantiner is implemented by stores that can quarantine a URL by setting
+// its status and threats alone, so a concurrent update of the URL is not
+// overwritten with the copy that was screened
+type Quarantiner interface {
+	// Quarantine flags the URL with threats if it is active and still
+	// points to originalURL. It returns models.ErrURLNotFound if it does not
+	// exist and models.ErrURLChanged if its destination or status changed.
+	Quarantine(ctx context.Context, shortCode, originalURL string, threats []string) error
+}
+
+// Releaser is implemented by stores that can release a quarantined URL by
+// setting its status alone, so a concurrent change of the URL is not
+// overwritten with the copy that was reviewed
+type Releaser interface {
+	// Release marks the URL released if it is quarantined and still points
+	// to originalURL. It returns models.ErrURLNotFound if it does not exist
+	// and models.ErrURLChanged if its destination or status changed.
+	Release(ctx context.Context, shortCode, originalURL string) error
+}
+
+// CanonicalIndex is implemented by stores that can look URLs up by the
+// CanonicalHash they were created with
+type CanonicalIndex interface {
+	// FindByCanonicalHash returns an unexpired URL with the given hash or
+	// models.ErrURLNotFound
+	FindByCanonicalHash(ctx context.Context, hash string) (*models.URL, error)
+}
+
+// Counter hands out values for short code generation. A counter never
+// returns the same value twice, which is what makes generated codes unique.
+type Counter interface {
+	GetNextCounter(ctx context.Context) (int64, error)
+}
+
+// ListOptions controls pagination for URLStore.List
+type ListOptions struct {
+	// Cursor is the NextCursor of the previous page, empty for the first page
+	Cursor string
+	// Limit is the maximum number of URLs to return
+	Limit int
+	// IncludeExpired returns expired URLs instead of skipping them
+	IncludeExpired bool
+}
+
+// ListResult is a single page returned by URLStore.List
+type ListResult struct {
+	URLs []*models.URL
+	// NextCursor is empty when there are no more pages
+	NextCursor string
+}
+
+func (o ListOptions) limit() int {
+	if o.Limit <= 0 {
+		return defaultListLimit
+	}
+	return o.Limit
+}
@@ -0,0 +1,416 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/testutils"
+)
+
+// testURLStore runs the URLStore contract against a backend
+func testURLStore(t *testing.T, store URLStore) {
+	ctx := context.Background()
+
+	url := models.NewURL("https://example.com", "abc123")
+	if err := store.Create(ctx, url); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if err := store.Create(ctx, url); !errors.Is(err, models.ErrDuplicateShortCode) {
+		t.Errorf("Create() duplicate error = %v, expected %v", err, models.ErrDuplicateShortCode)
+	}
+
+	got, err := store.Get(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.OriginalURL != url.OriginalURL {
+		t.Errorf("Get() OriginalURL = %v, expected %v", got.OriginalURL, url.OriginalURL)
+	}
+
+	if _, err := store.Get(ctx, "missing"); !errors.Is(err, models.ErrURLNotFound) {
+		t.Errorf("Get() missing error = %v, expected %v", err, models.ErrURLNotFound)
+	}
+
+	got.OriginalURL = "https://example.org"
+	if err := store.Update(ctx, got); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	got, _ = store.Get(ctx, "abc123")
+	if got.OriginalURL != "https://example.org" {
+		t.Errorf("Update() did not persist, OriginalURL = %v", got.OriginalURL)
+	}
+
+	if err := store.Update(ctx, models.NewURL("https://example.com", "missing")); !errors.Is(err, models.ErrURLNotFound) {
+		t.Errorf("Update() missing error = %v, expected %v", err, models.ErrURLNotFound)
+	}
+
+	// Conditional updates only replace the URL that was read
+	if updater, ok := store.(ConditionalUpdater); ok {
+		if err := updater.UpdateIf(ctx, got, "https://example.com", ""); !errors.Is(err, models.ErrURLChanged) {
+			t.Errorf("UpdateIf() of an old destination error = %v, expected %v", err, models.ErrURLChanged)
+		}
+		if err := updater.UpdateIf(ctx, got, "https://example.org", models.StatusQuarantined); !errors.Is(err, models.ErrURLChanged) {
+			t.Errorf("UpdateIf() of another status error = %v, expected %v", err, models.ErrURLChanged)
+		}
+		if err := updater.UpdateIf(ctx, models.NewURL("https://example.com", "missing"), "https://example.com", ""); !errors.Is(err, models.ErrURLNotFound) {
+			t.Errorf("UpdateIf() missing error = %v, expected %v", err, models.ErrURLNotFound)
+		}
+		got.SetExpiresAt(time.Now().Add(time.Hour))
+		if err := updater.UpdateIf(ctx, got, "https://example.org", ""); err != nil {
+			t.Errorf("UpdateIf() error = %v", err)
+		}
+		if stored, _ := store.Get(ctx, "abc123"); stored.ExpiresAt.IsZero() {
+			t.Errorf("UpdateIf() did not persist, ExpiresAt = %v", stored.ExpiresAt)
+		}
+		got.SetExpiresAt(time.Time{})
+		if err := store.Update(ctx, got); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+
+	expired := models.NewURL("https://example.com/old", "expired")
+	expired.ExpiresAt = time.Now().Add(-time.Hour)
+	if err := store.Create(ctx, expired); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := store.Get(ctx, "expired"); !errors.Is(err, models.ErrURLExpired) {
+		t.Errorf("Get() expired error = %v, expected %v", err, models.ErrURLExpired)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := store.Create(ctx, models.NewURL("https://example.com", fmt.Sprintf("page%d", i))); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	// Page through everything two at a time, skipping the expired URL
+	var listed []string
+	opts := ListOptions{Limit: 2}
+	for {
+		page, err := store.List(ctx, opts)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		for _, u := range page.URLs {
+			listed = append(listed, u.ShortCode)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	if len(listed) != 6 {
+		t.Errorf("List() returned %d URLs, expected 6: %v", len(listed), listed)
+	}
+
+	page, err := store.List(ctx, ListOptions{IncludeExpired: true})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(page.URLs) != 7 {
+		t.Errorf("List() with expired returned %d URLs, expected 7", len(page.URLs))
+	}
+
+	// Expired URLs and URLs without the hash are not found by it
+	if index, ok := store.(CanonicalIndex); ok {
+		old := models.NewURL("https://example.com/a", "canon0")
+		old.CanonicalHash = "hash-a"
+		old.ExpiresAt = time.Now().Add(-time.Hour)
+		live := models.NewURL("https://example.com/a", "canon1")
+		live.CanonicalHash = "hash-a"
+		for _, u := range []*models.URL{old, live} {
+			if err := store.Create(ctx, u); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+		}
+
+		found, err := index.FindByCanonicalHash(ctx, "hash-a")
+		if err != nil || found.ShortCode != "canon1" {
+			t.Errorf("FindByCanonicalHash() = %v, %v, expected canon1", found, err)
+		}
+		if _, err := index.FindByCanonicalHash(ctx, "hash-b"); !errors.Is(err, models.ErrURLNotFound) {
+			t.Errorf("FindByCanonicalHash() missing error = %v, expected %v", err, models.ErrURLNotFound)
+		}
+	}
+
+	// Only URLs that are still expired are deleted
+	if deleter, ok := store.(ExpiredDeleter); ok {
+		now := time.Now()
+		if err := deleter.DeleteExpired(ctx, "page0", now); !errors.Is(err, models.ErrURLNotExpired) {
+			t.Errorf("DeleteExpired() live error = %v, expected %v", err, models.ErrURLNotExpired)
+		}
+		if err := deleter.DeleteExpired(ctx, "missing", now); !errors.Is(err, models.ErrURLNotFound) {
+			t.Errorf("DeleteExpired() missing error = %v, expected %v", err, models.ErrURLNotFound)
+		}
+		if err := deleter.DeleteExpired(ctx, "expired", now); err != nil {
+			t.Errorf("DeleteExpired() error = %v", err)
+		}
+		if _, err := store.Get(ctx, "page0"); err != nil {
+			t.Errorf("Get() after DeleteExpired() of a live URL error = %v", err)
+		}
+	}
+
+	// Only active URLs that still point to the screened destination are
+	// quarantined
+	if quarantiner, ok := store.(Quarantiner); ok {
+		threats := []string{"malware"}
+		if err := quarantiner.Quarantine(ctx, "abc123", "https://example.com", threats); !errors.Is(err, models.ErrURLChanged) {
+			t.Errorf("Quarantine() of an old destination error = %v, expected %v", err, models.ErrURLChanged)
+		}
+		if err := quarantiner.Quarantine(ctx, "missing", "https://example.com", threats); !errors.Is(err, models.ErrURLNotFound) {
+			t.Errorf("Quarantine() missing error = %v, expected %v", err, models.ErrURLNotFound)
+		}
+		if err := quarantiner.Quarantine(ctx, "abc123", "https://example.org", threats); err != nil {
+			t.Errorf("Quarantine() error = %v", err)
+		}
+		got, _ = store.Get(ctx, "abc123")
+		if !got.IsQuarantined() || !reflect.DeepEqual(got.Threats, threats) || got.OriginalURL != "https://example.org" {
+			t.Errorf("Quarantine() stored %+v, expected quarantined by malware", got)
+		}
+		if err := quarantiner.Quarantine(ctx, "abc123", "https://example.org", threats); !errors.Is(err, models.ErrURLChanged) {
+			t.Errorf("Quarantine() of a quarantined URL error = %v, expected %v", err, models.ErrURLChanged)
+		}
+	}
+
+	// Only quarantined URLs that still point to the reviewed destination are
+	// released, keeping their threats
+	if releaser, ok := store.(Releaser); ok {
+		if err := releaser.Release(ctx, "abc123", "https://example.com"); !errors.Is(err, models.ErrURLChanged) {
+			t.Errorf("Release() of an old destination error = %v, expected %v", err, models.ErrURLChanged)
+		}
+		if err := releaser.Release(ctx, "missing", "https://example.com"); !errors.Is(err, models.ErrURLNotFound) {
+			t.Errorf("Release() missing error = %v, expected %v", err, models.ErrURLNotFound)
+		}
+		if err := releaser.Release(ctx, "abc123", "https://example.org"); err != nil {
+			t.Errorf("Release() error = %v", err)
+		}
+		got, _ = store.Get(ctx, "abc123")
+		if got.Status != models.StatusReleased || len(got.Threats) == 0 {
+			t.Errorf("Release() stored %+v, expected released with its threats", got)
+		}
+		if err := releaser.Release(ctx, "abc123", "https://example.org"); !errors.Is(err, models.ErrURLChanged) {
+			t.Errorf("Release() of a released URL error = %v, expected %v", err, models.ErrURLChanged)
+		}
+	}
+
+	if err := store.Delete(ctx, "abc123"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := store.Delete(ctx, "abc123"); !errors.Is(err, models.ErrURLNotFound) {
+		t.Errorf("Delete() missing error = %v, expected %v", err, models.ErrURLNotFound)
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testURLStore(t, NewMemoryStorage())
+}
+
+func TestFileStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.db")
+
+	store, err := OpenFileStorage(path)
+	if err != nil {
+		t.Fatalf("OpenFileStorage() error = %v", err)
+	}
+	testURLStore(t, store)
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.GetNextCounter(context.Background()); err != nil {
+			t.Fatalf("GetNextCounter() error = %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Reopen and verify state survived the restart
+	store, err = OpenFileStorage(path)
+	if err != nil {
+		t.Fatalf("OpenFileStorage() reopen error = %v", err)
+	}
+	defer store.Close()
+
+	// Only one process may hold the log, since opening it compacts it
+	if _, err := OpenFileStorage(path); !errors.Is(err, models.ErrStorageLocked) {
+		t.Errorf("OpenFileStorage() of an open log error = %v, expected %v", err, models.ErrStorageLocked)
+	}
+
+	if _, err := store.Get(context.Background(), "page0"); err != nil {
+		t.Errorf("Get() after reopen error = %v", err)
+	}
+	if _, err := store.Get(context.Background(), "abc123"); !errors.Is(err, models.ErrURLNotFound) {
+		t.Errorf("Get() deleted URL after reopen error = %v, expected %v", err, models.ErrURLNotFound)
+	}
+
+	value, err := store.GetNextCounter(context.Background())
+	if err != nil {
+		t.Fatalf("GetNextCounter() error = %v", err)
+	}
+	if value != 4 {
+		t.Errorf("GetNextCounter() after reopen = %v, expected 4", value)
+	}
+}
+
+func TestDynamoDBStorage_DeleteExpired(t *testing.T) {
+	// The mock applies the condition to a single stored item
+	item, err := marshalURL(models.NewURL("https://example.com", "abc123"))
+	if err != nil {
+		t.Fatalf("marshalURL() error = %v", err)
+	}
+	client := testutils.NewMockDynamoDBClient()
+	client.DeleteItemFunc = func(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+		if params.Key["ShortCode"].(*types.AttributeValueMemberS).Value != "abc123" {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+		ttl, ok := item["TTL"].(*types.AttributeValueMemberN)
+		now := params.ExpressionAttributeValues[":now"].(*types.AttributeValueMemberN).Value
+		if !ok || ttl.Value > now || params.ReturnValuesOnConditionCheckFailure != types.ReturnValuesOnConditionCheckFailureAllOld {
+			return nil, &types.ConditionalCheckFailedException{Item: item}
+		}
+		return &dynamodb.DeleteItemOutput{}, nil
+	}
+	store := NewDynamoDBStorage(client)
+	ctx := context.Background()
+
+	if err := store.DeleteExpired(ctx, "missing", time.Now()); !errors.Is(err, models.ErrURLNotFound) {
+		t.Errorf("DeleteExpired() missing error = %v, expected %v", err, models.ErrURLNotFound)
+	}
+	if err := store.DeleteExpired(ctx, "abc123", time.Now()); !errors.Is(err, models.ErrURLNotExpired) {
+		t.Errorf("DeleteExpired() without expiration error = %v, expected %v", err, models.ErrURLNotExpired)
+	}
+
+	expired := models.NewURL("https://example.com", "abc123")
+	expired.SetExpiresAt(time.Now().Add(-time.Hour))
+	item, _ = marshalURL(expired)
+	if err := store.DeleteExpired(ctx, "abc123", time.Now()); err != nil {
+		t.Errorf("DeleteExpired() error = %v", err)
+	}
+}
+
+func TestDynamoDBStorage_Quarantine(t *testing.T) {
+	// The mock applies the condition to a single stored item
+	url := models.NewURL("https://example.com", "abc123")
+	client := testutils.NewMockDynamoDBClient()
+	var update *dynamodb.UpdateItemInput
+	client.UpdateItemFunc = func(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+		if params.Key["ShortCode"].(*types.AttributeValueMemberS).Value != url.ShortCode {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+		item, _ := marshalURL(url)
+		if params.ExpressionAttributeValues[":url"].(*types.AttributeValueMemberS).Value != url.OriginalURL || url.Status != "" {
+			return nil, &types.ConditionalCheckFailedException{Item: item}
+		}
+		update = params
+		return &dynamodb.UpdateItemOutput{}, nil
+	}
+	store := NewDynamoDBStorage(client)
+	ctx := context.Background()
+
+	if err := store.Quarantine(ctx, "missing", "https://example.com", []string{"malware"}); !errors.Is(err, models.ErrURLNotFound) {
+		t.Errorf("Quarantine() missing error = %v, expected %v", err, models.ErrURLNotFound)
+	}
+	if err := store.Quarantine(ctx, "abc123", "https://example.org", []string{"malware"}); !errors.Is(err, models.ErrURLChanged) {
+		t.Errorf("Quarantine() of an old destination error = %v, expected %v", err, models.ErrURLChanged)
+	}
+	if err := store.Quarantine(ctx, "abc123", "https://example.com", []string{"malware"}); err != nil {
+		t.Fatalf("Quarantine() error = %v", err)
+	}
+
+	// Only the status and threats are written
+	if *update.UpdateExpression != "SET #status = :quarantined, Threats = :threats" {
+		t.Errorf("UpdateExpression = %q", *update.UpdateExpression)
+	}
+	threats := update.ExpressionAttributeValues[":threats"].(*types.AttributeValueMemberL).Value
+	if len(threats) != 1 || threats[0].(*types.AttributeValueMemberS).Value != "malware" {
+		t.Errorf("threats = %v, expected [malware]", threats)
+	}
+}
+
+func TestDynamoDBStorage_Release(t *testing.T) {
+	// The mock applies the condition to a single stored item
+	url := models.NewURL("https://example.com", "abc123")
+	url.Quarantine([]string{"malware"})
+	client := testutils.NewMockDynamoDBClient()
+	var update *dynamodb.UpdateItemInput
+	client.UpdateItemFunc = func(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+		if params.Key["ShortCode"].(*types.AttributeValueMemberS).Value != url.ShortCode {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+		item, _ := marshalURL(url)
+		if params.ExpressionAttributeValues[":url"].(*types.AttributeValueMemberS).Value != url.OriginalURL || !url.IsQuarantined() {
+			return nil, &types.ConditionalCheckFailedException{Item: item}
+		}
+		update = params
+		return &dynamodb.UpdateItemOutput{}, nil
+	}
+	store := NewDynamoDBStorage(client)
+	ctx := context.Background()
+
+	if err := store.Release(ctx, "missing", "https://example.com"); !errors.Is(err, models.ErrURLNotFound) {
+		t.Errorf("Release() missing error = %v, expected %v", err, models.ErrURLNotFound)
+	}
+	if err := store.Release(ctx, "abc123", "https://example.org"); !errors.Is(err, models.ErrURLChanged) {
+		t.Errorf("Release() of an old destination error = %v, expected %v", err, models.ErrURLChanged)
+	}
+	if err := store.Release(ctx, "abc123", "https://example.com"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+
+	// Only the status is written, the threats are kept
+	if *update.UpdateExpression != "SET #status = :released" {
+		t.Errorf("UpdateExpression = %q", *update.UpdateExpression)
+	}
+	if *update.ConditionExpression != "OriginalURL = :url AND #status = :quarantined" {
+		t.Errorf("ConditionExpression = %q", *update.ConditionExpression)
+	}
+}
+
+func TestDynamoDBStorage_UpdateIf(t *testing.T) {
+	// The mock applies the condition to a single stored item
+	url := models.NewURL("https://example.com", "abc123")
+	url.Quarantine([]string{"malware"})
+	client := testutils.NewMockDynamoDBClient()
+	var put *dynamodb.PutItemInput
+	client.PutItemFunc = func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+		put = params
+		if params.Item["ShortCode"].(*types.AttributeValueMemberS).Value != url.ShortCode {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+		item, _ := marshalURL(url)
+		status, _ := params.ExpressionAttributeValues[":status"].(*types.AttributeValueMemberS)
+		if params.ExpressionAttributeValues[":url"].(*types.AttributeValueMemberS).Value != url.OriginalURL || status == nil || status.Value != url.Status {
+			return nil, &types.ConditionalCheckFailedException{Item: item}
+		}
+		return &dynamodb.PutItemOutput{}, nil
+	}
+	store := NewDynamoDBStorage(client)
+	ctx := context.Background()
+
+	updated := *url
+	updated.SetExpiresAt(time.Now().Add(time.Hour))
+	if err := store.UpdateIf(ctx, models.NewURL("https://example.com", "missing"), "https://example.com", ""); !errors.Is(err, models.ErrURLNotFound) {
+		t.Errorf("UpdateIf() missing error = %v, expected %v", err, models.ErrURLNotFound)
+	}
+	if err := store.UpdateIf(ctx, &updated, "https://example.com", ""); !errors.Is(err, models.ErrURLChanged) {
+		t.Errorf("UpdateIf() of an active copy error = %v, expected %v", err, models.ErrURLChanged)
+	}
+	if *put.ConditionExpression != "OriginalURL = :url AND attribute_not_exists(#status)" {
+		t.Errorf("ConditionExpression of an active copy = %q", *put.ConditionExpression)
+	}
+	if err := store.UpdateIf(ctx, &updated, "https://example.com", models.StatusQuarantined); err != nil {
+		t.Fatalf("UpdateIf() error = %v", err)
+	}
+	if *put.ConditionExpression != "OriginalURL = :url AND #status = :status" {
+		t.Errorf("ConditionExpression = %q", *put.ConditionExpression)
+	}
+}
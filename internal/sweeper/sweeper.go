@@ -0,0 +1,121 @@
+package sweeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/storage"
+)
+
+const (
+	defaultPageSize = 100
+)
+
+// Sweeper archives and deletes expired URLs. Backends with native TTL such as
+// DynamoDB delete expired items on their own but without archiving them, so
+// running the sweeper ahead of TTL keeps a copy of everything that expires.
+type Sweeper struct {
+	store    storage.URLStore
+	archiver storage.Archiver
+	pageSize int
+	now      func() time.Time
+}
+
+// Options controls a single sweep
+type Options struct {
+	// DryRun reports what would be swept without archiving or deleting
+	DryRun bool
+}
+
+// Report summarises a sweep. Renewed counts URLs whose expiration was
+// extended or cleared after they were found expired, which were archived but
+// kept.
+type Report struct {
+	Scanned  int      `json:"scanned"`
+	Expired  int      `json:"expired"`
+	Archived int      `json:"archived"`
+	Deleted  int      `json:"deleted"`
+	Renewed  int      `json:"renewed"`
+	Failed   []string `json:"failed,omitempty"`
+	DryRun   bool     `json:"dryRun"`
+}
+
+func New(store storage.URLStore, archiver storage.Archiver) *Sweeper {
+	return &Sweeper{
+		store:    store,
+		archiver: archiver,
+		pageSize: defaultPageSize,
+		now:      time.Now,
+	}
+}
+
+// Sweep walks the whole store once. A URL is only deleted after it has been
+// archived, and a failed delete is recorded in the report rather than
+// aborting the sweep.
+func (s *Sweeper) Sweep(ctx context.Context, opts Options) (*Report, error) {
+	report := &Report{DryRun: opts.DryRun}
+	listOpts := storage.ListOptions{Limit: s.pageSize, IncludeExpired: true}
+
+	for {
+		page, err := s.store.List(ctx, listOpts)
+		if err != nil {
+			return report, fmt.Errorf("failed to list URLs: %w", err)
+		}
+
+		now := s.now()
+		var expired []*models.URL
+		for _, url := range page.URLs {
+			report.Scanned++
+			if url.IsExpired(now) {
+				expired = append(expired, url)
+			}
+		}
+		report.Expired += len(expired)
+
+		if len(expired) > 0 && !opts.DryRun {
+			if err := s.sweepPage(ctx, expired, report); err != nil {
+				return report, err
+			}
+		}
+
+		if page.NextCursor == "" {
+			return report, nil
+		}
+		listOpts.Cursor = page.NextCursor
+	}
+}
+
+// sweepPage archives and then deletes one page of expired URLs
+func (s *Sweeper) sweepPage(ctx context.Context, expired []*models.URL, report *Report) error {
+	if err := s.archiver.Archive(ctx, expired); err != nil {
+		return fmt.Errorf("failed to archive expired URLs: %w", err)
+	}
+	report.Archived += len(expired)
+
+	for _, url := range expired {
+		err := s.delete(ctx, url.ShortCode)
+		switch {
+		case err == nil:
+			report.Deleted++
+		case errors.Is(err, models.ErrURLNotFound):
+			// Already removed, e.g. by native TTL
+		case errors.Is(err, models.ErrURLNotExpired):
+			report.Renewed++
+		default:
+			report.Failed = append(report.Failed, url.ShortCode)
+		}
+	}
+	return nil
+}
+
+// delete removes a URL found expired, only while it is still expired when the
+// store supports it, since it may have been updated since the scan
+func (s *Sweeper) delete(ctx context.Context, shortCode string) error {
+	if deleter, ok := s.store.(storage.ExpiredDeleter); ok {
+		return deleter.DeleteExpired(ctx, shortCode, s.now())
+	}
+	return s.store.Delete(ctx, shortCode)
+}
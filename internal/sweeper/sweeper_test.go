@@ -0,0 +1,164 @@
+package sweeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+	"github.com/jingy/Go-Shortener/internal/storage"
+)
+
+// seedStore creates a store with the given number of active and expired URLs
+func seedStore(t *testing.T, active, expired int) *storage.MemoryStorage {
+	store := storage.NewMemoryStorage()
+	for i := 0; i < active; i++ {
+		url := models.NewURL("https://example.com", fmt.Sprintf("active%d", i))
+		if err := store.Create(context.Background(), url); err != nil {
+			t.Fatalf("Failed to seed storage: %v", err)
+		}
+	}
+	for i := 0; i < expired; i++ {
+		url := models.NewURL("https://example.com", fmt.Sprintf("expired%d", i))
+		url.SetExpiresAt(time.Now().Add(-time.Hour))
+		if err := store.Create(context.Background(), url); err != nil {
+			t.Fatalf("Failed to seed storage: %v", err)
+		}
+	}
+	return store
+}
+
+func TestSweeper_Sweep(t *testing.T) {
+	tests := []struct {
+		name             string
+		active           int
+		expired          int
+		dryRun           bool
+		expectedScanned  int
+		expectedExpired  int
+		expectedArchived int
+		expectedDeleted  int
+	}{
+		{
+			name:             "nothing expired",
+			active:           3,
+			expectedScanned:  3,
+			expectedExpired:  0,
+			expectedArchived: 0,
+			expectedDeleted:  0,
+		},
+		{
+			name:             "expired across several pages",
+			active:           5,
+			expired:          7,
+			expectedScanned:  12,
+			expectedExpired:  7,
+			expectedArchived: 7,
+			expectedDeleted:  7,
+		},
+		{
+			name:             "dry run",
+			active:           1,
+			expired:          4,
+			dryRun:           true,
+			expectedScanned:  5,
+			expectedExpired:  4,
+			expectedArchived: 0,
+			expectedDeleted:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := seedStore(t, tt.active, tt.expired)
+			archiver := storage.NewMemoryArchiver()
+
+			sweeper := New(store, archiver)
+			sweeper.pageSize = 3
+
+			report, err := sweeper.Sweep(context.Background(), Options{DryRun: tt.dryRun})
+			if err != nil {
+				t.Fatalf("Sweep() error = %v", err)
+			}
+
+			if report.Scanned != tt.expectedScanned || report.Expired != tt.expectedExpired ||
+				report.Archived != tt.expectedArchived || report.Deleted != tt.expectedDeleted {
+				t.Errorf("Sweep() report = %+v, expected scanned=%d expired=%d archived=%d deleted=%d",
+					report, tt.expectedScanned, tt.expectedExpired, tt.expectedArchived, tt.expectedDeleted)
+			}
+
+			if got := len(archiver.Archived()); got != tt.expectedArchived {
+				t.Errorf("archiver holds %d URLs, expected %d", got, tt.expectedArchived)
+			}
+
+			page, _ := store.List(context.Background(), storage.ListOptions{IncludeExpired: true})
+			if remaining := tt.active + tt.expired - tt.expectedDeleted; len(page.URLs) != remaining {
+				t.Errorf("store holds %d URLs, expected %d", len(page.URLs), remaining)
+			}
+		})
+	}
+}
+
+// failingArchiver refuses every archive request
+type failingArchiver struct{}
+
+func (failingArchiver) Archive(ctx context.Context, urls []*models.URL) error {
+	return errors.New("archive unavailable")
+}
+
+func TestSweeper_ArchiveFailureKeepsURLs(t *testing.T) {
+	store := seedStore(t, 0, 2)
+
+	_, err := New(store, failingArchiver{}).Sweep(context.Background(), Options{})
+	if err == nil {
+		t.Fatal("Sweep() expected an error when archiving fails")
+	}
+
+	page, _ := store.List(context.Background(), storage.ListOptions{IncludeExpired: true})
+	if len(page.URLs) != 2 {
+		t.Errorf("store holds %d URLs, expected expired URLs to be kept", len(page.URLs))
+	}
+}
+
+// renewingArchiver archives URLs and then extends one of them, like an
+// update racing the sweep between the scan and the delete
+type renewingArchiver struct {
+	storage.Archiver
+	store     storage.URLStore
+	shortCode string
+}
+
+func (a renewingArchiver) Archive(ctx context.Context, urls []*models.URL) error {
+	if err := a.Archiver.Archive(ctx, urls); err != nil {
+		return err
+	}
+	page, err := a.store.List(ctx, storage.ListOptions{IncludeExpired: true})
+	if err != nil {
+		return err
+	}
+	for _, url := range page.URLs {
+		if url.ShortCode == a.shortCode {
+			url.SetExpiresAt(time.Now().Add(time.Hour))
+			return a.store.Update(ctx, url)
+		}
+	}
+	return nil
+}
+
+func TestSweeper_KeepsRenewedURLs(t *testing.T) {
+	store := seedStore(t, 0, 2)
+	archiver := renewingArchiver{Archiver: storage.NewMemoryArchiver(), store: store, shortCode: "expired0"}
+
+	report, err := New(store, archiver).Sweep(context.Background(), Options{})
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if report.Deleted != 1 || report.Renewed != 1 || len(report.Failed) != 0 {
+		t.Errorf("Sweep() report = %+v, expected 1 deleted and 1 renewed", report)
+	}
+	if _, err := store.Get(context.Background(), "expired0"); err != nil {
+		t.Errorf("Get() of the renewed URL error = %v", err)
+	}
+}
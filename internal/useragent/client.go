@@ -0,0 +1,93 @@
+package useragent
+
+import "strings"
+
+// Device classes
+const (
+	Desktop = "desktop"
+	Mobile  = "mobile"
+	Tablet  = "tablet"
+	// Bot is the device class callers give to clicks classified as bots
+	Bot   = "bot"
+	Other = "other"
+)
+
+// Client describes the device, operating system and browser of a user agent.
+// Fields that cannot be told are empty.
+type Client struct {
+	Device  string
+	OS      string
+	Browser string
+}
+
+// match is a user agent substring and the name it maps to
+type match struct {
+	pattern string
+	name    string
+}
+
+// systems are checked in order: iPads and iPhones claim to be "like Mac OS X"
+// and Android user agents contain "Linux"
+var systems = []match{
+	{"windows", "Windows"},
+	{"iphone", "iOS"},
+	{"ipad", "iOS"},
+	{"ipod", "iOS"},
+	{"android", "Android"},
+	{"cros", "ChromeOS"},
+	{"mac os x", "macOS"},
+	{"macintosh", "macOS"},
+	{"linux", "Linux"},
+}
+
+// browsers are checked in order since most user agents also name the engines
+// they are compatible with, e.g. Edge claims to be Chrome and Safari
+var browsers = []match{
+	{"edg/", "Edge"},
+	{"edga/", "Edge"},
+	{"edgios/", "Edge"},
+	{"opr/", "Opera"},
+	{"opera", "Opera"},
+	{"samsungbrowser/", "Samsung Internet"},
+	{"firefox/", "Firefox"},
+	{"fxios/", "Firefox"},
+	{"crios/", "Chrome"},
+	{"chrome/", "Chrome"},
+	{"chromium/", "Chrome"},
+	{"safari/", "Safari"},
+}
+
+// Detect describes the client of userAgent
+func Detect(userAgent string) Client {
+	if userAgent == "" {
+		return Client{}
+	}
+	lower := strings.ToLower(userAgent)
+
+	client := Client{
+		OS:      first(lower, systems),
+		Browser: first(lower, browsers),
+	}
+	switch {
+	case strings.Contains(lower, "ipad") || strings.Contains(lower, "tablet") ||
+		client.OS == "Android" && !strings.Contains(lower, "mobile"):
+		client.Device = Tablet
+	case strings.Contains(lower, "mobi") || client.OS == "iOS" || client.OS == "Android":
+		client.Device = Mobile
+	case client.OS != "":
+		client.Device = Desktop
+	default:
+		client.Device = Other
+	}
+	return client
+}
+
+// first returns the name of the first match found in userAgent
+func first(userAgent string, matches []match) string {
+	for _, m := range matches {
+		if strings.Contains(userAgent, m.pattern) {
+			return m.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+// Package useragent classifies the user agents of clicks so automated
+// traffic can be told apart from people following links.
+package useragent
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Bot categories. Human is the category of exception rules and is never
+// returned by Classify.
+const (
+	Human    = "human"
+	Preview  = "preview"
+	Headless = "headless"
+	Tool     = "tool"
+	Crawler  = "crawler"
+)
+
+// categories lists the categories a rule may use
+var categories = map[string]bool{
+	Human:    true,
+	Preview:  true,
+	Headless: true,
+	Tool:     true,
+	Crawler:  true,
+}
+
+//go:embed bots.txt
+var defaultRules string
+
+// rule maps user agents containing pattern to category
+type rule struct {
+	category string
+	pattern  string
+}
+
+// Rules classify user agents by case-insensitive substring rules, the first
+// matching rule winning
+type Rules struct {
+	rules []rule
+}
+
+// Default returns the rules embedded in the binary
+func Default() *Rules {
+	rules, err := Parse(strings.NewReader(defaultRules))
+	if err != nil {
+		panic(fmt.Sprintf("invalid embedded bot rules: %v", err))
+	}
+	return rules
+}
+
+// Load reads rules from a file in the format of the embedded rules
+func Load(path string) (*Rules, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bot rules: %w", err)
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse reads rules of the form "category pattern", one per line. Blank lines
+// and lines starting with '#' are ignored.
+func Parse(r io.Reader) (*Rules, error) {
+	rules := &Rules{}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		i := strings.IndexFunc(text, unicode.IsSpace)
+		if i < 0 {
+			return nil, fmt.Errorf("bot rule at line %d needs a category and a pattern", line)
+		}
+		category, pattern := text[:i], strings.TrimSpace(text[i:])
+		if !categories[category] {
+			return nil, fmt.Errorf("bot rule at line %d has unknown category %q", line, category)
+		}
+		rules.rules = append(rules.rules, rule{category: category, pattern: strings.ToLower(pattern)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read bot rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// Classify returns the bot category of userAgent, or an empty string when it
+// looks like a person
+func (r *Rules) Classify(userAgent string) string {
+	lower := strings.ToLower(userAgent)
+	for _, rule := range r.rules {
+		if strings.Contains(lower, rule.pattern) {
+			if rule.category == Human {
+				return ""
+			}
+			return rule.category
+		}
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+package useragent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefault_Classify(t *testing.T) {
+	rules := Default()
+
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{"desktop browser", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36", ""},
+		{"mobile browser", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", ""},
+		{"phone maker containing bot", "Mozilla/5.0 (Linux; Android 10; Cubot KingKong) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Mobile Safari/537.36", ""},
+		{"empty", "", ""},
+		{"search crawler", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", Crawler},
+		{"generic spider", "Mozilla/5.0 (compatible; ExampleSpider/1.0)", Crawler},
+		{"link preview", "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", Preview},
+		{"chat preview", "Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", Preview},
+		{"headless browser", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/124.0.0.0 Safari/537.36", Headless},
+		{"command line", "curl/8.4.0", Tool},
+		{"library", "python-requests/2.31.0", Tool},
+		{"case insensitive", "GO-HTTP-CLIENT/1.1", Tool},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.Classify(tt.userAgent); got != tt.want {
+				t.Errorf("Classify(%q) = %q, want %q", tt.userAgent, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		wantErr bool
+	}{
+		{"comments and blank lines", "# comment\n\npreview  Example Preview\n", false},
+		{"missing pattern", "crawler\n", true},
+		{"unknown category", "robot examplebot\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.rules))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Patterns may contain spaces and the first match wins
+	rules, err := Parse(strings.NewReader("human example bot friendly\ncrawler example bot\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got := rules.Classify("Example Bot Friendly/1.0"); got != "" {
+		t.Errorf("Classify() = %q, want the human exception", got)
+	}
+	if got := rules.Classify("Example Bot/1.0"); got != Crawler {
+		t.Errorf("Classify() = %q, want %q", got, Crawler)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bots.txt")
+	if err := os.WriteFile(path, []byte("tool examplefetch\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	// Only the loaded rules apply
+	if got := rules.Classify("ExampleFetch/1.0"); got != Tool {
+		t.Errorf("Classify() = %q, want %q", got, Tool)
+	}
+	if got := rules.Classify("Googlebot/2.1"); got != "" {
+		t.Errorf("Classify() = %q, want no match outside the loaded rules", got)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("Load() of a missing file succeeded")
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      Client
+	}{
+		{"empty", "", Client{}},
+		{"Chrome on Windows", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36", Client{Desktop, "Windows", "Chrome"}},
+		{"Edge on Windows", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.51", Client{Desktop, "Windows", "Edge"}},
+		{"Safari on macOS", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15", Client{Desktop, "macOS", "Safari"}},
+		{"Firefox on Linux", "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0", Client{Desktop, "Linux", "Firefox"}},
+		{"Safari on iPhone", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", Client{Mobile, "iOS", "Safari"}},
+		{"Chrome on iPad", "Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0.6367.88 Mobile/15E148 Safari/604.1", Client{Tablet, "iOS", "Chrome"}},
+		{"Samsung phone", "Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36", Client{Mobile, "Android", "Samsung Internet"}},
+		{"Android tablet", "Mozilla/5.0 (Linux; Android 13; SM-X710) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36", Client{Tablet, "Android", "Chrome"}},
+		{"command line", "curl/8.4.0", Client{Device: Other}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.userAgent); got != tt.want {
+				t.Errorf("Detect() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package shortener
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+const (
+	minAliasLength = 3
+	maxAliasLength = 32
+)
+
+// DefaultReservedAliases are path segments used by the service itself that
+// can never be claimed as aliases
+var DefaultReservedAliases = []string{
+	"admin",
+	"api",
+	"create",
+	"favicon.ico",
+	"health",
+	"healthz",
+	"metrics",
+	"robots.txt",
+	"static",
+	"stats",
+}
+
+// WithReservedAliases adds aliases that cannot be claimed on top of
+// DefaultReservedAliases
+func WithReservedAliases(aliases ...string) Option {
+	return func(s *Shortener) {
+		for _, alias := range aliases {
+			s.reservedAliases[strings.ToLower(alias)] = struct{}{}
+		}
+	}
+}
+
+// ValidateAlias checks a requested vanity short code. Aliases are 3 to 32
+// characters of letters, digits, '-' and '_' and must not be reserved.
+// Reserved words are matched case-insensitively.
+func (s *Shortener) ValidateAlias(alias string) error {
+	if len(alias) < minAliasLength || len(alias) > maxAliasLength {
+		return fmt.Errorf("%w: must be between %d and %d characters", models.ErrInvalidAlias, minAliasLength, maxAliasLength)
+	}
+
+	for _, r := range alias {
+		if !isAliasChar(r) {
+			return fmt.Errorf("%w: only letters, digits, '-' and '_' are allowed", models.ErrInvalidAlias)
+		}
+	}
+
+	if s.isReserved(alias) {
+		return models.ErrReservedAlias
+	}
+
+	return nil
+}
+
+// isReserved reports whether a short code is a reserved word
+func (s *Shortener) isReserved(shortCode string) bool {
+	_, ok := s.reservedAliases[strings.ToLower(shortCode)]
+	return ok
+}
+
+func isAliasChar(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_'
+}
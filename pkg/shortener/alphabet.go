@@ -0,0 +1,108 @@
+package shortener
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// Base62Chars are the digits and ASCII letters
+	Base62Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// UnambiguousChars are Base62Chars without 0, O, o, 1, I and l, which are
+	// easily confused when a code is read aloud or retyped
+	UnambiguousChars = "23456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz"
+)
+
+var (
+	// Base62 is the default alphabet
+	Base62 = mustNewAlphabet(Base62Chars)
+	// Unambiguous is an alphabet without look-alike characters
+	Unambiguous = mustNewAlphabet(UnambiguousChars)
+)
+
+// Alphabet is the ordered set of characters codes are written in. The first
+// character is the zero digit and is used to pad codes to their length.
+type Alphabet struct {
+	chars string
+	index [256]int16
+}
+
+// NewAlphabet creates an alphabet from at least two distinct characters.
+// Only characters that are also valid in aliases are accepted so generated
+// codes are always URL safe.
+func NewAlphabet(chars string) (*Alphabet, error) {
+	if len(chars) < 2 {
+		return nil, errors.New("alphabet needs at least two characters")
+	}
+
+	a := &Alphabet{chars: chars}
+	for i := range a.index {
+		a.index[i] = -1
+	}
+	for i := 0; i < len(chars); i++ {
+		c := chars[i]
+		if !isAliasChar(rune(c)) {
+			return nil, fmt.Errorf("alphabet character %q is not allowed in short codes", c)
+		}
+		if a.index[c] >= 0 {
+			return nil, fmt.Errorf("alphabet character %q appears more than once", c)
+		}
+		a.index[c] = int16(i)
+	}
+	return a, nil
+}
+
+func mustNewAlphabet(chars string) *Alphabet {
+	a, err := NewAlphabet(chars)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Base returns the number of characters in the alphabet
+func (a *Alphabet) Base() int {
+	return len(a.chars)
+}
+
+// String returns the characters of the alphabet
+func (a *Alphabet) String() string {
+	return a.chars
+}
+
+// Encode converts a number to its canonical representation without leading
+// zero digits
+func (a *Alphabet) Encode(n uint64) string {
+	if n == 0 {
+		return a.chars[:1]
+	}
+
+	base := uint64(len(a.chars))
+	var buf [64]byte // enough for base 2
+	i := len(buf)
+	for n > 0 {
+		i--
+		buf[i] = a.chars[n%base]
+		n /= base
+	}
+	return string(buf[i:])
+}
+
+// Decode converts a code back to a number. Leading zero digits are ignored,
+// so it also decodes padded codes.
+func (a *Alphabet) Decode(s string) (uint64, error) {
+	base := uint64(len(a.chars))
+	var n uint64
+	for i := 0; i < len(s); i++ {
+		digit := a.index[s[i]]
+		if digit < 0 {
+			return 0, fmt.Errorf("invalid code character %q", s[i])
+		}
+		if n > (math.MaxUint64-uint64(digit))/base {
+			return 0, fmt.Errorf("code %q overflows uint64", s)
+		}
+		n = n*base + uint64(digit)
+	}
+	return n, nil
+}
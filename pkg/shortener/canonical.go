@@ -0,0 +1,76 @@
+package shortener
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+// defaultPorts are dropped from canonical URLs of their scheme
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+	"ftp":   "21",
+}
+
+// Canonicalize returns the form of rawURL used to recognise the same
+// destination written differently: the scheme and host are lowercased,
+// internationalized hosts are converted to punycode, default ports and
+// trailing dots are dropped, an empty path becomes "/" and query parameters
+// are sorted by name. Fragments are kept since they change where the page
+// opens, but an empty one is dropped.
+func Canonicalize(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", models.ErrInvalidURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	host := strings.TrimSuffix(u.Hostname(), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else if host, err = idna.Lookup.ToASCII(host); err != nil {
+		return "", models.ErrInvalidURL
+	}
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	if u.Path == "" && u.Opaque == "" {
+		u.Path = "/"
+	}
+	// Queries with malformed escapes are kept as they are
+	if query, err := url.ParseQuery(u.RawQuery); err == nil {
+		u.RawQuery = query.Encode()
+	}
+	u.ForceQuery = false
+	return u.String(), nil
+}
+
+// CanonicalHash returns the hash of the canonical form of rawURL that URLs
+// are looked up by when deduplicating
+func CanonicalHash(rawURL string) (string, error) {
+	canonical, err := Canonicalize(rawURL)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,234 @@
+package shortener
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/storage"
+)
+
+// DefaultCodeLength is the length of generated codes unless configured
+// otherwise
+const DefaultCodeLength = 6
+
+// CodeGenerator produces candidate short codes. A candidate may already be
+// taken, in which case CreateShortURL asks again with the next attempt.
+type CodeGenerator interface {
+	// Generate returns a code for originalURL. attempt starts at 1 and grows
+	// each time the previous candidate was already taken.
+	Generate(ctx context.Context, originalURL string, attempt int) (string, error)
+}
+
+// CodeFormat is the alphabet and length of generated codes
+type CodeFormat struct {
+	Alphabet *Alphabet
+	// Length is the exact length of random and hash codes and the minimum
+	// length of counter and Snowflake codes, which grow once the numbers
+	// they encode no longer fit
+	Length int
+}
+
+// DefaultCodeFormat returns six base62 characters
+func DefaultCodeFormat() CodeFormat {
+	return CodeFormat{Alphabet: Base62, Length: DefaultCodeLength}
+}
+
+// NewCodeFormat validates a code length for the given alphabet
+func NewCodeFormat(alphabet *Alphabet, length int) (CodeFormat, error) {
+	if length < 1 || length > maxAliasLength {
+		return CodeFormat{}, fmt.Errorf("code length must be between 1 and %d", maxAliasLength)
+	}
+	return CodeFormat{Alphabet: alphabet, Length: length}, nil
+}
+
+// encode writes value in the alphabet, padded to Length with the zero digit.
+// The unpadded form never starts with the zero digit, so padding keeps the
+// mapping injective.
+func (f CodeFormat) encode(value uint64) string {
+	code := f.Alphabet.Encode(value)
+	if len(code) < f.Length {
+		code = strings.Repeat(f.Alphabet.chars[:1], f.Length-len(code)) + code
+	}
+	return code
+}
+
+// randomCode draws Length characters uniformly from the alphabet using the
+// bytes of r. Bytes that would bias the draw are rejected.
+func (f CodeFormat) randomCode(r io.Reader) (string, error) {
+	base := f.Alphabet.Base()
+	limit := 256 - 256%base
+
+	code := make([]byte, 0, f.Length)
+	buf := make([]byte, f.Length*2)
+	for len(code) < f.Length {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", fmt.Errorf("failed to read random bytes: %w", err)
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(code) < f.Length {
+				code = append(code, f.Alphabet.chars[int(b)%base])
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// CounterGenerator encodes the next value of a counter. Counters never repeat
+// a value, so its codes are unique without relying on retries.
+type CounterGenerator struct {
+	counter     storage.Counter
+	format      CodeFormat
+	permutation *permutation
+}
+
+// CounterOption configures a CounterGenerator
+type CounterOption func(*CounterGenerator)
+
+// WithObfuscation makes counter codes non-sequential by passing each counter
+// value through a permutation keyed with key before encoding. Codes stay
+// unique and keep the length they would have had without obfuscation.
+func WithObfuscation(key []byte) CounterOption {
+	return func(g *CounterGenerator) {
+		g.permutation = newPermutation(key, g.format)
+	}
+}
+
+func NewCounterGenerator(counter storage.Counter, format CodeFormat, opts ...CounterOption) *CounterGenerator {
+	g := &CounterGenerator{
+		counter: counter,
+		format:  format,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+func (g *CounterGenerator) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	// Get next counter value
+	counter, err := g.counter.GetNextCounter(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get counter: %w", err)
+	}
+	if counter < 0 {
+		return "", fmt.Errorf("counter returned negative value %d", counter)
+	}
+
+	value := uint64(counter)
+	if g.permutation != nil {
+		value = g.permutation.permute(value)
+	}
+	return g.format.encode(value), nil
+}
+
+// RandomGenerator draws codes from crypto/rand. Collisions become likely once
+// the number of codes approaches the square root of the code space and are
+// resolved by retrying.
+type RandomGenerator struct {
+	format CodeFormat
+	rand   io.Reader
+}
+
+func NewRandomGenerator(format CodeFormat) *RandomGenerator {
+	return &RandomGenerator{
+		format: format,
+		rand:   rand.Reader,
+	}
+}
+
+func (g *RandomGenerator) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	return g.format.randomCode(g.rand)
+}
+
+// HashGenerator derives the code from a SHA-256 hash of the URL, so the same
+// URL always gets the same first candidate. Later attempts hash the attempt
+// number along with the URL.
+type HashGenerator struct {
+	format CodeFormat
+}
+
+func NewHashGenerator(format CodeFormat) *HashGenerator {
+	return &HashGenerator{format: format}
+}
+
+func (g *HashGenerator) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	seed := make([]byte, 4, 4+len(originalURL))
+	binary.BigEndian.PutUint32(seed, uint32(attempt))
+	seed = append(seed, originalURL...)
+	return g.format.randomCode(&hashStream{seed: seed})
+}
+
+// hashStream is an endless deterministic byte stream made of
+// SHA-256(block || seed) for block = 0, 1, ...
+type hashStream struct {
+	seed  []byte
+	block uint32
+	buf   []byte
+}
+
+func (h *hashStream) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(h.buf) == 0 {
+			var block [4]byte
+			binary.BigEndian.PutUint32(block[:], h.block)
+			h.block++
+			sum := sha256.Sum256(append(block[:], h.seed...))
+			h.buf = sum[:]
+		}
+		copied := copy(p[n:], h.buf)
+		h.buf = h.buf[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// NewGenerator creates the code generator described by cfg
+func NewGenerator(cfg config.ShortCodeConfig, counter storage.Counter) (CodeGenerator, error) {
+	alphabet, err := alphabetByName(cfg.Alphabet)
+	if err != nil {
+		return nil, err
+	}
+	format, err := NewCodeFormat(alphabet, cfg.Length)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Strategy {
+	case config.StrategyCounter:
+		var opts []CounterOption
+		if cfg.Mode == config.ShortCodeModeObfuscated {
+			opts = append(opts, WithObfuscation([]byte(cfg.Key)))
+		}
+		return NewCounterGenerator(counter, format, opts...), nil
+	case config.StrategyRandom:
+		return NewRandomGenerator(format), nil
+	case config.StrategyHash:
+		return NewHashGenerator(format), nil
+	case config.StrategySnowflake:
+		generator, err := NewSnowflakeGenerator(int64(cfg.NodeID), format)
+		if err != nil {
+			return nil, err
+		}
+		return generator, nil
+	}
+	return nil, fmt.Errorf("unknown short code strategy %q", cfg.Strategy)
+}
+
+// alphabetByName resolves a named alphabet or treats name as the characters
+// of a custom one
+func alphabetByName(name string) (*Alphabet, error) {
+	switch name {
+	case "", "base62":
+		return Base62, nil
+	case "unambiguous":
+		return Unambiguous, nil
+	}
+	return NewAlphabet(name)
+}
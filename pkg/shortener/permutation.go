@@ -0,0 +1,108 @@
+package shortener
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+const feistelRounds = 8
+
+// permutation is a keyed bijection over sequence values. It is applied
+// independently to each code length tier, so a permuted value encodes to a
+// code of the same length as the original one and two distinct sequence
+// values never share a code.
+type permutation struct {
+	key    []byte
+	format CodeFormat
+}
+
+func newPermutation(key []byte, format CodeFormat) *permutation {
+	return &permutation{key: key, format: format}
+}
+
+// permute maps a sequence value to its obfuscated value
+func (p *permutation) permute(value uint64) uint64 {
+	offset, size := p.codeTier(value)
+	return offset + p.cycleWalk(value-offset, size, p.encrypt)
+}
+
+// invert maps an obfuscated value back to its sequence value
+func (p *permutation) invert(value uint64) uint64 {
+	offset, size := p.codeTier(value)
+	return offset + p.cycleWalk(value-offset, size, p.decrypt)
+}
+
+// cycleWalk applies the Feistel network, which permutes a power of two
+// domain, until the result lands back in [0, size)
+func (p *permutation) cycleWalk(value, size uint64, round func(value uint64, halfBits uint) uint64) uint64 {
+	halfBits := uint(bits.Len64(size-1)+1) / 2
+	for {
+		value = round(value, halfBits)
+		if value < size {
+			return value
+		}
+	}
+}
+
+func (p *permutation) encrypt(value uint64, halfBits uint) uint64 {
+	mask := uint64(1)<<halfBits - 1
+	left, right := value>>halfBits, value&mask
+	for round := 0; round < feistelRounds; round++ {
+		left, right = right, left^(p.roundFunc(round, halfBits, right)&mask)
+	}
+	return left<<halfBits | right
+}
+
+func (p *permutation) decrypt(value uint64, halfBits uint) uint64 {
+	mask := uint64(1)<<halfBits - 1
+	left, right := value>>halfBits, value&mask
+	for round := feistelRounds - 1; round >= 0; round-- {
+		left, right = right^(p.roundFunc(round, halfBits, left)&mask), left
+	}
+	return left<<halfBits | right
+}
+
+// roundFunc derives a round key from the secret, the round number and the
+// domain width so that every tier gets an independent permutation
+func (p *permutation) roundFunc(round int, halfBits uint, half uint64) uint64 {
+	var msg [10]byte
+	msg[0] = byte(round)
+	msg[1] = byte(halfBits)
+	binary.BigEndian.PutUint64(msg[2:], half)
+
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write(msg[:])
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// codeTier returns the range of sequence values that encode to codes of the
+// same length as value. Values below base^Length are all padded to Length;
+// above that every extra digit starts a new tier. The last tier ends at
+// math.MaxInt64 because counters are never negative.
+func (p *permutation) codeTier(value uint64) (offset, size uint64) {
+	base := uint64(p.format.Alphabet.Base())
+	upper := uint64(1)
+	for i := 0; i < p.format.Length; i++ {
+		if upper > math.MaxInt64/base {
+			return 0, math.MaxInt64 + 1
+		}
+		upper *= base
+	}
+	if value < upper {
+		return 0, upper
+	}
+
+	for {
+		lower := upper
+		if upper > math.MaxInt64/base {
+			return lower, math.MaxInt64 - lower + 1
+		}
+		upper *= base
+		if value < upper {
+			return lower, upper - lower
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package shortener
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/models"
+)
+
+// DefaultMaxURLLength is the longest URL accepted by DefaultPolicy, which is
+// also the limit of several browsers and CDNs
+const DefaultMaxURLLength = 2048
+
+// privateSuffixes are special-use domains that only resolve on local networks
+var privateSuffixes = []string{".localhost", ".local", ".internal", ".home.arpa"}
+
+// sharedAddressSpace is the carrier-grade NAT range, which is not routable
+// on the internet either
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Policy decides which destination URLs may be shortened
+type Policy struct {
+	// AllowedSchemes lists the accepted schemes, matched case-insensitively
+	AllowedSchemes []string
+	// MaxLength bounds the length of URLs in bytes, zero means unbounded
+	MaxLength int
+	// AllowIPHosts accepts public IP addresses as hosts
+	AllowIPHosts bool
+	// AllowPrivateHosts accepts loopback, private and link-local addresses,
+	// localhost and single-label hosts
+	AllowPrivateHosts bool
+	// ShortDomains are hosts serving our short URLs besides the host of the
+	// base URL. Links to short URLs on any of them are rejected as loops.
+	ShortDomains []string
+}
+
+// DefaultPolicy accepts public http and https URLs of up to
+// DefaultMaxURLLength bytes
+func DefaultPolicy() Policy {
+	return Policy{
+		AllowedSchemes: []string{"http", "https"},
+		MaxLength:      DefaultMaxURLLength,
+	}
+}
+
+// PolicyFromConfig builds the policy configured in the environment
+func PolicyFromConfig(cfg config.URLPolicyConfig) Policy {
+	return Policy{
+		AllowedSchemes:    cfg.AllowedSchemes,
+		MaxLength:         cfg.MaxLength,
+		AllowIPHosts:      cfg.AllowIPHosts,
+		AllowPrivateHosts: cfg.AllowPrivateHosts,
+		ShortDomains:      cfg.ShortDomains,
+	}
+}
+
+// WithPolicy replaces DefaultPolicy
+func WithPolicy(policy Policy) Option {
+	return func(s *Shortener) {
+		s.policy = policy
+	}
+}
+
+// check parses rawURL and applies every rule but the loop detection, which
+// needs the base URL
+func (p Policy) check(rawURL string) (*url.URL, error) {
+	if p.MaxLength > 0 && len(rawURL) > p.MaxLength {
+		return nil, fmt.Errorf("%w of %d bytes", models.ErrURLTooLong, p.MaxLength)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return nil, models.ErrInvalidURL
+	}
+	if !p.allowsScheme(u.Scheme) {
+		return nil, fmt.Errorf("%w: %s", models.ErrSchemeNotAllowed, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, models.ErrInvalidURL
+	}
+
+	host := normalizeHost(u.Hostname())
+	if host == "" {
+		return nil, models.ErrInvalidURL
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !p.AllowPrivateHosts && isPrivateIP(ip) {
+			return nil, fmt.Errorf("%w: %s", models.ErrPrivateHost, host)
+		}
+		if !p.AllowIPHosts {
+			return nil, fmt.Errorf("%w: %s", models.ErrIPHost, host)
+		}
+		return u, nil
+	}
+	// Browsers read hosts ending in a number as IPv4 addresses in decimal,
+	// octal or hex, which would hide private addresses
+	if endsInNumber(host) {
+		return nil, fmt.Errorf("%w: %s", models.ErrIPHost, host)
+	}
+	if !p.AllowPrivateHosts && isPrivateName(host) {
+		return nil, fmt.Errorf("%w: %s", models.ErrPrivateHost, host)
+	}
+	return u, nil
+}
+
+func (p Policy) allowsScheme(scheme string) bool {
+	for _, allowed := range p.AllowedSchemes {
+		if strings.EqualFold(scheme, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHost lowercases host, drops a trailing dot and converts
+// internationalized names to punycode. IP literals are returned as they are
+// and invalid names as empty.
+func normalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return ""
+	}
+	return ascii
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// isPrivateName reports whether host is localhost, a single label resolved
+// through local search domains, or under a special-use local domain
+func isPrivateName(host string) bool {
+	if host == "localhost" || !strings.Contains(host, ".") {
+		return true
+	}
+	for _, suffix := range privateSuffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// endsInNumber reports whether the last label of host is a decimal, octal or
+// hex number
+func endsInNumber(host string) bool {
+	label := host[strings.LastIndex(host, ".")+1:]
+	if hex, ok := strings.CutPrefix(label, "0x"); ok {
+		return strings.Trim(hex, "0123456789abcdef") == ""
+	}
+	return label != "" && strings.Trim(label, "0123456789") == ""
+}
@@ -2,98 +2,437 @@ package shortener
 
 import (
 	"context"
-	"encoding/base62"
+	"errors"
 	"fmt"
 	"net/url"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jingy/Go-Shortener/internal/config"
 	"github.com/jingy/Go-Shortener/internal/models"
 	"github.com/jingy/Go-Shortener/internal/storage"
 )
 
 const (
-	shortCodeLength = 6 // Reduced from 8 to 6 for shorter URLs
+	// maxCreateAttempts bounds how often a generated code is retried after
+	// colliding with an existing one, e.g. an alias that happens to look
+	// like a generated code
+	maxCreateAttempts = 5
 )
 
 type Shortener struct {
-	baseURL string
-	counter *storage.CounterStorage
+	baseURL         string
+	generator       CodeGenerator
+	store           storage.URLStore
+	maxTTL          time.Duration
+	reservedAliases map[string]struct{}
+	dedup           bool
+	policy          Policy
+	domains         DomainChecker
+	screener        URLScreener
+	quarantine      bool
+	// shortHosts are the hosts of our short URLs, whose paths start with
+	// basePath
+	shortHosts map[string]struct{}
+	basePath   string
 }
 
-func NewShortener(baseURL string, counter *storage.CounterStorage) *Shortener {
-	return &Shortener{
-		baseURL: strings.TrimRight(baseURL, "/"),
-		counter: counter,
+// Option configures optional Shortener behaviour
+type Option func(*Shortener)
+
+// WithMaxTTL bounds how far in the future a requested expiration may be.
+// Zero means expirations are unbounded.
+func WithMaxTTL(maxTTL time.Duration) Option {
+	return func(s *Shortener) {
+		s.maxTTL = maxTTL
 	}
 }
 
-// GenerateShortCode generates a unique short code using a counter within the current day bucket
-func (s *Shortener) GenerateShortCode(ctx context.Context) (string, error) {
-	// Get next counter value
-	counter, err := s.counter.GetNextCounter(ctx)
+// WithStore makes CreateShortURL persist the URLs it creates, retrying with
+// a fresh code when a generated code is already taken
+func WithStore(store storage.URLStore) Option {
+	return func(s *Shortener) {
+		s.store = store
+	}
+}
+
+// WithDedup makes CreateShortURL return the existing short URL of the same
+// canonical destination instead of minting a new code. It only applies to
+// requests without an alias or expiration, and only to stores implementing
+// storage.CanonicalIndex.
+func WithDedup(enabled bool) Option {
+	return func(s *Shortener) {
+		s.dedup = enabled
+	}
+}
+
+// DomainChecker decides whether a destination host may be shortened,
+// returning models.ErrDomainBlocked when it may not
+type DomainChecker interface {
+	Check(host string) error
+}
+
+// WithDomainPolicy checks the host of every destination URL against
+// checker, e.g. a *domains.Policy
+func WithDomainPolicy(checker DomainChecker) Option {
+	return func(s *Shortener) {
+		s.domains = checker
+	}
+}
+
+// URLScreener names the lists of malicious URLs a destination matches, e.g.
+// a *screening.Screener
+type URLScreener interface {
+	Screen(rawURL string) []string
+}
+
+// WithScreener screens every new destination URL with screener. Flagged URLs
+// fail with models.ErrURLFlagged, or are stored quarantined when quarantine
+// is set.
+func WithScreener(screener URLScreener, quarantine bool) Option {
+	return func(s *Shortener) {
+		s.screener = screener
+		s.quarantine = quarantine
+	}
+}
+
+// WithGenerator replaces the default counter based code generator
+func WithGenerator(generator CodeGenerator) Option {
+	return func(s *Shortener) {
+		s.generator = generator
+	}
+}
+
+// CreateOptions holds the optional parameters of CreateShortURL
+type CreateOptions struct {
+	// ExpiresAt is an absolute expiration time
+	ExpiresAt time.Time
+	// ExpiresIn is an expiration relative to the creation time. It is
+	// mutually exclusive with ExpiresAt.
+	ExpiresIn time.Duration
+	// Alias requests a specific short code instead of a generated one
+	Alias string
+}
+
+// UpdateOptions holds the changes UpdateShortURL applies. Zero fields are
+// left unchanged.
+type UpdateOptions struct {
+	// OriginalURL is the new destination
+	OriginalURL string
+	// ExpiresAt and ExpiresIn set a new expiration like in CreateOptions
+	ExpiresAt time.Time
+	ExpiresIn time.Duration
+	// ClearExpiration removes the expiration
+	ClearExpiration bool
+}
+
+// NewShortener creates a Shortener that generates codes from counter in the
+// default format unless WithGenerator is given
+func NewShortener(baseURL string, counter storage.Counter, opts ...Option) *Shortener {
+	s := &Shortener{
+		baseURL:         strings.TrimRight(baseURL, "/"),
+		generator:       NewCounterGenerator(counter, DefaultCodeFormat()),
+		reservedAliases: make(map[string]struct{}),
+		policy:          DefaultPolicy(),
+		shortHosts:      make(map[string]struct{}),
+	}
+	WithReservedAliases(DefaultReservedAliases...)(s)
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if base, err := url.Parse(s.baseURL); err == nil {
+		s.shortHosts[normalizeHost(base.Hostname())] = struct{}{}
+		s.basePath = base.Path
+	}
+	for _, domain := range s.policy.ShortDomains {
+		s.shortHosts[normalizeHost(domain)] = struct{}{}
+	}
+	return s
+}
+
+// NewFromConfig creates a Shortener configured from the environment settings
+// that persists to the given backend, followed by extra options
+func NewFromConfig(cfg *config.Config, backend *storage.Backend, extra ...Option) (*Shortener, error) {
+	generator, err := NewGenerator(cfg.ShortCode, backend.Counter)
 	if err != nil {
-		return "", fmt.Errorf("failed to get counter: %w", err)
+		return nil, err
 	}
 
-	// Get current timestamp (seconds since epoch)
-	timestamp := time.Now().UTC().Unix()
-	
-	// Combine timestamp and counter to create a unique value
-	// We use the last 6 digits of the timestamp (to keep it manageable)
-	// and combine it with the counter value
-	timestampLast6 := timestamp % 1000000 // Last 6 digits of timestamp
-	combinedValue := timestampLast6*1000 + counter // Combine with counter (assuming counter < 1000)
-	
-	// Convert to base62 string
-	shortCode := base62.Encode(uint64(combinedValue))
-	
-	// Pad with leading zeros if needed
-	if len(shortCode) < shortCodeLength {
-		shortCode = strings.Repeat("0", shortCodeLength-len(shortCode)) + shortCode
+	opts := []Option{
+		WithGenerator(generator),
+		WithStore(backend.URLs),
+		WithMaxTTL(cfg.MaxTTL),
+		WithReservedAliases(cfg.ReservedAliases...),
+		WithDedup(cfg.DedupURLs),
+		WithPolicy(PolicyFromConfig(cfg.URLPolicy)),
 	}
+	return NewShortener(cfg.BaseURL, backend.Counter, append(opts, extra...)...), nil
+}
 
-	return shortCode, nil
+// GenerateShortCode generates a code that is not tied to a particular URL
+func (s *Shortener) GenerateShortCode(ctx context.Context) (string, error) {
+	return s.generator.Generate(ctx, "", 1)
 }
 
-// ValidateURL checks if the provided URL is valid
+// ValidateURL checks that a destination URL is well formed, allowed by the
+// policy and the domain rules, and that it is not one of our own short URLs
 func (s *Shortener) ValidateURL(urlStr string) error {
 	if urlStr == "" {
 		return models.ErrEmptyURL
 	}
 
-	parsedURL, err := url.Parse(urlStr)
+	parsedURL, err := s.policy.check(urlStr)
 	if err != nil {
-		return models.ErrInvalidURL
+		return err
 	}
-
-	if parsedURL.Scheme == "" || parsedURL.Host == "" {
-		return models.ErrInvalidURL
+	if s.isShortURL(parsedURL) {
+		return models.ErrRedirectLoop
+	}
+	if s.domains != nil {
+		if err := s.domains.Check(parsedURL.Hostname()); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// CreateShortURL creates a new short URL
-func (s *Shortener) CreateShortURL(ctx context.Context, originalURL string) (*models.URL, error) {
+// isShortURL reports whether u would be served as a redirect by us, i.e. it
+// is a single path segment below the base path of one of our hosts that is
+// not a reserved route
+func (s *Shortener) isShortURL(u *url.URL) bool {
+	if _, ok := s.shortHosts[normalizeHost(u.Hostname())]; !ok {
+		return false
+	}
+	shortCode, ok := strings.CutPrefix(u.Path, strings.TrimRight(s.basePath, "/")+"/")
+	if !ok || shortCode == "" || strings.Contains(shortCode, "/") {
+		return false
+	}
+	return !s.isReserved(shortCode)
+}
+
+// ResolveExpiration turns the expiration in opts into an absolute time
+// relative to now. It returns the zero time when no expiration is requested.
+func (s *Shortener) ResolveExpiration(now time.Time, opts CreateOptions) (time.Time, error) {
+	if !opts.ExpiresAt.IsZero() && opts.ExpiresIn != 0 {
+		return time.Time{}, fmt.Errorf("%w: absolute and relative expiration are mutually exclusive", models.ErrInvalidExpiration)
+	}
+
+	expiresAt := opts.ExpiresAt
+	if opts.ExpiresIn != 0 {
+		expiresAt = now.Add(opts.ExpiresIn)
+	}
+	if expiresAt.IsZero() {
+		return time.Time{}, nil
+	}
+
+	if !expiresAt.After(now) {
+		return time.Time{}, models.ErrExpirationInPast
+	}
+	if s.maxTTL > 0 && expiresAt.Sub(now) > s.maxTTL {
+		return time.Time{}, models.ErrExpirationTooFar
+	}
+
+	return expiresAt.UTC(), nil
+}
+
+// CreateShortURL creates a new short URL. When a store is configured the URL
+// is also persisted: a taken alias fails with models.ErrAliasTaken while a
+// taken or reserved generated code is retried with the next code, unless it
+// is a permanent link to the same destination, which is returned. In dedup mode the
+// existing short URL of the same canonical destination is returned instead.
+func (s *Shortener) CreateShortURL(ctx context.Context, originalURL string, opts CreateOptions) (*models.URL, error) {
 	if err := s.ValidateURL(originalURL); err != nil {
 		return nil, err
 	}
 
-	shortCode, err := s.GenerateShortCode(ctx)
+	url := models.NewURL(originalURL, "")
+	if err := s.screen(url); err != nil {
+		return nil, err
+	}
+	expiresAt, err := s.ResolveExpiration(url.CreatedAt, opts)
 	if err != nil {
 		return nil, err
 	}
+	url.SetExpiresAt(expiresAt)
 
-	url := models.NewURL(originalURL, shortCode)
-	url.ShortURL = s.GetShortURL(shortCode)
+	// Only permanent generated codes are shared, so nobody gets a link that
+	// expires earlier or under another name than they asked for, and
+	// quarantined URLs are kept apart from the clean ones
+	if index, ok := s.store.(storage.CanonicalIndex); ok && s.dedup && opts.Alias == "" && expiresAt.IsZero() && !url.IsQuarantined() {
+		hash, err := CanonicalHash(originalURL)
+		if err != nil {
+			return nil, err
+		}
+		existing, err := index.FindByCanonicalHash(ctx, hash)
+		if err == nil {
+			existing.ShortURL = s.GetShortURL(existing.ShortCode)
+			return existing, nil
+		}
+		if !errors.Is(err, models.ErrURLNotFound) {
+			return nil, fmt.Errorf("failed to look up canonical URL: %w", err)
+		}
+		url.CanonicalHash = hash
+	}
+
+	if opts.Alias != "" {
+		if err := s.ValidateAlias(opts.Alias); err != nil {
+			return nil, err
+		}
+		s.setShortCode(url, opts.Alias)
+
+		err := s.persist(ctx, url)
+		if errors.Is(err, models.ErrDuplicateShortCode) {
+			return nil, models.ErrAliasTaken
+		}
+		if err != nil {
+			return nil, err
+		}
+		return url, nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		shortCode, err := s.generator.Generate(ctx, originalURL, attempt)
+		if err != nil {
+			return nil, err
+		}
+		s.setShortCode(url, shortCode)
+
+		// A generated code that is a reserved word is skipped like a taken one
+		err = models.ErrDuplicateShortCode
+		if !s.isReserved(shortCode) {
+			err = s.persist(ctx, url)
+		}
+		if err == nil {
+			return url, nil
+		}
+		if errors.Is(err, models.ErrDuplicateShortCode) {
+			if existing := s.sameLink(ctx, url); existing != nil {
+				return existing, nil
+			}
+		}
+		if !errors.Is(err, models.ErrDuplicateShortCode) || attempt == maxCreateAttempts {
+			return nil, err
+		}
+	}
+}
 
+// sameLink returns the stored URL holding the code of url when both are
+// permanent, unflagged links to the same destination. The hash strategy
+// generates the same codes for every create of a URL, so it would run out
+// of attempts once a URL has been shortened maxCreateAttempts times.
+func (s *Shortener) sameLink(ctx context.Context, url *models.URL) *models.URL {
+	if !url.ExpiresAt.IsZero() || url.IsQuarantined() {
+		return nil
+	}
+	existing, err := s.store.Get(ctx, url.ShortCode)
+	if err != nil || existing.OriginalURL != url.OriginalURL || !existing.ExpiresAt.IsZero() || existing.IsQuarantined() {
+		return nil
+	}
+	existing.ShortURL = s.GetShortURL(existing.ShortCode)
+	return existing
+}
+
+// UpdateShortURL changes the destination or expiration of a stored URL. A
+// new expiration is validated like on create, relative to now. The URL is
+// only written if its destination and status are still the ones read,
+// failing with models.ErrURLChanged otherwise, so a concurrent quarantine is
+// not undone.
+func (s *Shortener) UpdateShortURL(ctx context.Context, shortCode string, opts UpdateOptions) (*models.URL, error) {
+	if s.store == nil {
+		return nil, errors.New("updating requires a store")
+	}
+	if opts.ClearExpiration && (!opts.ExpiresAt.IsZero() || opts.ExpiresIn != 0) {
+		return nil, fmt.Errorf("%w: cannot both set and clear the expiration", models.ErrInvalidExpiration)
+	}
+	if opts.OriginalURL != "" {
+		if err := s.ValidateURL(opts.OriginalURL); err != nil {
+			return nil, err
+		}
+	}
+	expiresAt, err := s.ResolveExpiration(time.Now(), CreateOptions{ExpiresAt: opts.ExpiresAt, ExpiresIn: opts.ExpiresIn})
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := s.store.Get(ctx, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	originalURL, status := url.OriginalURL, url.Status
+	// A changed URL is no longer shared with later creates, and is screened
+	// again from scratch
+	if opts.OriginalURL != "" {
+		url.OriginalURL = opts.OriginalURL
+		url.CanonicalHash = ""
+		url.Status = ""
+		url.Threats = nil
+		if err := s.screen(url); err != nil {
+			return nil, err
+		}
+	}
+	if !expiresAt.IsZero() || opts.ClearExpiration {
+		url.SetExpiresAt(expiresAt)
+		url.CanonicalHash = ""
+	}
+
+	if err := s.update(ctx, url, originalURL, status); err != nil {
+		if errors.Is(err, models.ErrURLNotFound) || errors.Is(err, models.ErrURLChanged) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to update URL: %w", err)
+	}
+	url.ShortURL = s.GetShortURL(url.ShortCode)
 	return url, nil
 }
 
+// update writes url, on condition that it still points to originalURL with
+// status when the store supports it
+func (s *Shortener) update(ctx context.Context, url *models.URL, originalURL, status string) error {
+	if updater, ok := s.store.(storage.ConditionalUpdater); ok {
+		return updater.UpdateIf(ctx, url, originalURL, status)
+	}
+	return s.store.Update(ctx, url)
+}
+
+// screen quarantines url when its destination matches a screening list, or
+// fails with models.ErrURLFlagged when flagged URLs are refused
+func (s *Shortener) screen(url *models.URL) error {
+	if s.screener == nil {
+		return nil
+	}
+	threats := s.screener.Screen(url.OriginalURL)
+	if len(threats) == 0 {
+		return nil
+	}
+	if !s.quarantine {
+		return fmt.Errorf("%w: %s", models.ErrURLFlagged, strings.Join(threats, ", "))
+	}
+	url.Quarantine(threats)
+	return nil
+}
+
+func (s *Shortener) setShortCode(url *models.URL, shortCode string) {
+	url.ShortCode = shortCode
+	url.ShortURL = s.GetShortURL(shortCode)
+}
+
+// persist stores the URL when a store is configured
+func (s *Shortener) persist(ctx context.Context, url *models.URL) error {
+	if s.store == nil {
+		return nil
+	}
+	if err := s.store.Create(ctx, url); err != nil {
+		if errors.Is(err, models.ErrDuplicateShortCode) {
+			return err
+		}
+		return fmt.Errorf("failed to store URL: %w", err)
+	}
+	return nil
+}
+
 // GetShortURL returns the full short URL for a given short code
 func (s *Shortener) GetShortURL(shortCode string) string {
 	return s.baseURL + "/" + shortCode
-} 
-} 
\ No newline at end of file
+}
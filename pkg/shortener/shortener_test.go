@@ -2,8 +2,16 @@ package shortener
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/jingy/Go-Shortener/internal/config"
+	"github.com/jingy/Go-Shortener/internal/domains"
 	"github.com/jingy/Go-Shortener/internal/models"
 	"github.com/jingy/Go-Shortener/internal/storage"
 )
@@ -184,7 +192,7 @@ func TestShortener_CreateShortURL(t *testing.T) {
 			shortener := NewShortener("https://example.com", mockCounter)
 
 			// Call CreateShortURL
-			url, err := shortener.CreateShortURL(context.Background(), tt.url)
+			url, err := shortener.CreateShortURL(context.Background(), tt.url, CreateOptions{})
 
 			// Check error
 			if (err != nil) != tt.expectError {
@@ -217,10 +225,10 @@ func TestShortener_CreateShortURL(t *testing.T) {
 
 func TestShortener_GetShortURL(t *testing.T) {
 	tests := []struct {
-		name         string
-		baseURL      string
-		shortCode    string
-		expectedURL  string
+		name        string
+		baseURL     string
+		shortCode   string
+		expectedURL string
 	}{
 		{
 			name:        "simple URL",
@@ -256,4 +264,894 @@ func TestShortener_GetShortURL(t *testing.T) {
 			}
 		})
 	}
-} 
\ No newline at end of file
+}
+
+func TestShortener_ResolveExpiration(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		maxTTL      time.Duration
+		opts        CreateOptions
+		expected    time.Time
+		expectedErr error
+	}{
+		{
+			name:     "no expiration",
+			opts:     CreateOptions{},
+			expected: time.Time{},
+		},
+		{
+			name:     "relative expiration",
+			opts:     CreateOptions{ExpiresIn: time.Hour},
+			expected: now.Add(time.Hour),
+		},
+		{
+			name:     "absolute expiration",
+			opts:     CreateOptions{ExpiresAt: now.Add(24 * time.Hour)},
+			expected: now.Add(24 * time.Hour),
+		},
+		{
+			name:        "both set",
+			opts:        CreateOptions{ExpiresAt: now.Add(time.Hour), ExpiresIn: time.Hour},
+			expectedErr: models.ErrInvalidExpiration,
+		},
+		{
+			name:        "absolute in the past",
+			opts:        CreateOptions{ExpiresAt: now.Add(-time.Minute)},
+			expectedErr: models.ErrExpirationInPast,
+		},
+		{
+			name:        "negative relative",
+			opts:        CreateOptions{ExpiresIn: -time.Minute},
+			expectedErr: models.ErrExpirationInPast,
+		},
+		{
+			name:     "within max TTL",
+			maxTTL:   48 * time.Hour,
+			opts:     CreateOptions{ExpiresIn: 48 * time.Hour},
+			expected: now.Add(48 * time.Hour),
+		},
+		{
+			name:        "beyond max TTL",
+			maxTTL:      48 * time.Hour,
+			opts:        CreateOptions{ExpiresAt: now.Add(49 * time.Hour)},
+			expectedErr: models.ErrExpirationTooFar,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shortener := NewShortener("https://example.com", nil, WithMaxTTL(tt.maxTTL))
+
+			expiresAt, err := shortener.ResolveExpiration(now, tt.opts)
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("ResolveExpiration() error = %v, expected %v", err, tt.expectedErr)
+			}
+			if !expiresAt.Equal(tt.expected) {
+				t.Errorf("ResolveExpiration() = %v, expected %v", expiresAt, tt.expected)
+			}
+		})
+	}
+}
+
+func TestShortener_CreateShortURL_Expiration(t *testing.T) {
+	mockCounter := &MockCounterStorage{
+		GetNextCounterFunc: func(ctx context.Context) (int64, error) {
+			return 42, nil
+		},
+	}
+	shortener := NewShortener("https://example.com", mockCounter)
+
+	url, err := shortener.CreateShortURL(context.Background(), "https://example.com", CreateOptions{ExpiresIn: time.Hour})
+	if err != nil {
+		t.Fatalf("CreateShortURL() error = %v", err)
+	}
+
+	if got := url.ExpiresAt.Sub(url.CreatedAt); got != time.Hour {
+		t.Errorf("CreateShortURL() ExpiresAt - CreatedAt = %v, expected %v", got, time.Hour)
+	}
+}
+
+func TestShortener_ValidateAlias(t *testing.T) {
+	tests := []struct {
+		name        string
+		alias       string
+		expectedErr error
+	}{
+		{
+			name:  "valid alias",
+			alias: "spring-sale",
+		},
+		{
+			name:  "valid alias with underscore and digits",
+			alias: "Promo_2024",
+		},
+		{
+			name:        "too short",
+			alias:       "ab",
+			expectedErr: models.ErrInvalidAlias,
+		},
+		{
+			name:        "too long",
+			alias:       "this-alias-is-much-longer-than-allowed",
+			expectedErr: models.ErrInvalidAlias,
+		},
+		{
+			name:        "invalid characters",
+			alias:       "spring/sale",
+			expectedErr: models.ErrInvalidAlias,
+		},
+		{
+			name:        "reserved word",
+			alias:       "create",
+			expectedErr: models.ErrReservedAlias,
+		},
+		{
+			name:        "reserved word in another case",
+			alias:       "API",
+			expectedErr: models.ErrReservedAlias,
+		},
+		{
+			name:        "configured reserved word",
+			alias:       "login",
+			expectedErr: models.ErrReservedAlias,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shortener := NewShortener("https://example.com", nil, WithReservedAliases("login"))
+
+			err := shortener.ValidateAlias(tt.alias)
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("ValidateAlias() error = %v, expected %v", err, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func TestShortener_CreateShortURL_Alias(t *testing.T) {
+	mockCounter := &MockCounterStorage{
+		GetNextCounterFunc: func(ctx context.Context) (int64, error) {
+			t.Error("counter must not be used when an alias is requested")
+			return 0, nil
+		},
+	}
+	shortener := NewShortener("https://example.com", mockCounter)
+
+	url, err := shortener.CreateShortURL(context.Background(), "https://example.org", CreateOptions{Alias: "spring-sale"})
+	if err != nil {
+		t.Fatalf("CreateShortURL() error = %v", err)
+	}
+
+	if url.ShortCode != "spring-sale" || url.ShortURL != "https://example.com/spring-sale" {
+		t.Errorf("CreateShortURL() = %v %v, expected alias to be used as short code", url.ShortCode, url.ShortURL)
+	}
+}
+
+// MockURLStore is a mock implementation of storage.URLStore
+type MockURLStore struct {
+	storage.URLStore
+	CreateFunc func(ctx context.Context, url *models.URL) error
+}
+
+func (m *MockURLStore) Create(ctx context.Context, url *models.URL) error {
+	return m.CreateFunc(ctx, url)
+}
+
+func (m *MockURLStore) Get(ctx context.Context, shortCode string) (*models.URL, error) {
+	return nil, models.ErrURLNotFound
+}
+
+func TestShortener_GenerateShortCode_Unique(t *testing.T) {
+	// Property: every counter value yields a distinct code that decodes back
+	// to the same value
+	total := 3_000_000
+	if testing.Short() {
+		total = 100_000
+	}
+
+	shortener := NewShortener("https://example.com", storage.NewMemoryCounter())
+	seen := make(map[string]struct{}, total)
+	for i := 0; i < total; i++ {
+		shortCode, err := shortener.GenerateShortCode(context.Background())
+		if err != nil {
+			t.Fatalf("GenerateShortCode() error = %v", err)
+		}
+		if _, ok := seen[shortCode]; ok {
+			t.Fatalf("GenerateShortCode() produced duplicate %s after %d codes", shortCode, i)
+		}
+		seen[shortCode] = struct{}{}
+	}
+
+	// Sample values across the whole int64 range as well
+	for _, value := range []uint64{0, 1, 61, 62, 56_800_235_583, 56_800_235_584, 1<<63 - 1} {
+		decoded, err := Base62.Decode(DefaultCodeFormat().encode(value))
+		if err != nil || decoded != value {
+			t.Errorf("Decode(encode(%d)) = %d, %v", value, decoded, err)
+		}
+	}
+}
+
+func TestShortener_CreateShortURL_RetriesDuplicates(t *testing.T) {
+	tests := []struct {
+		name             string
+		duplicates       int
+		alias            string
+		expectedErr      error
+		expectedAttempts int
+	}{
+		{
+			name:             "no collision",
+			duplicates:       0,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "retried after collisions",
+			duplicates:       2,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "gives up after max attempts",
+			duplicates:       maxCreateAttempts,
+			expectedErr:      models.ErrDuplicateShortCode,
+			expectedAttempts: maxCreateAttempts,
+		},
+		{
+			name:             "alias is never retried",
+			duplicates:       1,
+			alias:            "spring-sale",
+			expectedErr:      models.ErrAliasTaken,
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			store := &MockURLStore{
+				CreateFunc: func(ctx context.Context, url *models.URL) error {
+					attempts++
+					if attempts <= tt.duplicates {
+						return models.ErrDuplicateShortCode
+					}
+					return nil
+				},
+			}
+			shortener := NewShortener("https://example.com", storage.NewMemoryCounter(), WithStore(store))
+
+			_, err := shortener.CreateShortURL(context.Background(), "https://example.com", CreateOptions{Alias: tt.alias})
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("CreateShortURL() error = %v, expected %v", err, tt.expectedErr)
+			}
+			if attempts != tt.expectedAttempts {
+				t.Errorf("CreateShortURL() attempts = %d, expected %d", attempts, tt.expectedAttempts)
+			}
+		})
+	}
+}
+
+func TestPermutation(t *testing.T) {
+	perm := newPermutation([]byte("0123456789abcdef"), DefaultCodeFormat())
+
+	// Values at the edges of every tier
+	values := []uint64{0, 1, 2, 56_800_235_583}
+	tierStart := uint64(56_800_235_584)
+	for tierStart <= math.MaxInt64/62 {
+		values = append(values, tierStart, tierStart+1, tierStart*62-1)
+		tierStart *= 62
+	}
+	values = append(values, tierStart, math.MaxInt64)
+
+	for _, value := range values {
+		permuted := perm.permute(value)
+		if permuted > math.MaxInt64 {
+			t.Errorf("permute(%d) = %d, outside the counter range", value, permuted)
+		}
+		if got, want := len(DefaultCodeFormat().encode(permuted)), len(DefaultCodeFormat().encode(value)); got != want {
+			t.Errorf("permute(%d) changed the code length from %d to %d", value, want, got)
+		}
+		if inverted := perm.invert(permuted); inverted != value {
+			t.Errorf("invert(permute(%d)) = %d", value, inverted)
+		}
+	}
+}
+
+func TestCounterGenerator_Obfuscated(t *testing.T) {
+	total := 200_000
+	if testing.Short() {
+		total = 10_000
+	}
+
+	ctx := context.Background()
+	key := []byte("0123456789abcdef")
+	generator := NewCounterGenerator(storage.NewMemoryCounter(), DefaultCodeFormat(), WithObfuscation(key))
+	plain := NewCounterGenerator(storage.NewMemoryCounter(), DefaultCodeFormat())
+	perm := newPermutation(key, DefaultCodeFormat())
+
+	seen := make(map[string]struct{}, total)
+	unchanged := 0
+	for i := 0; i < total; i++ {
+		shortCode, err := generator.Generate(ctx, "", 1)
+		if err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		plainCode, _ := plain.Generate(ctx, "", 1)
+
+		if _, ok := seen[shortCode]; ok {
+			t.Fatalf("Generate() produced duplicate %s after %d codes", shortCode, i)
+		}
+		seen[shortCode] = struct{}{}
+
+		if len(shortCode) != DefaultCodeLength {
+			t.Fatalf("Generate() = %s, expected length %d", shortCode, DefaultCodeLength)
+		}
+		if shortCode == plainCode {
+			unchanged++
+		}
+
+		// The code decodes back to the counter value through the inverse
+		value, _ := Base62.Decode(shortCode)
+		if got := perm.invert(value); got != uint64(i+1) {
+			t.Fatalf("invert(%s) = %d, expected %d", shortCode, got, i+1)
+		}
+	}
+
+	if unchanged > total/1000 {
+		t.Errorf("%d of %d obfuscated codes equal their sequential code", unchanged, total)
+	}
+
+	// A different key yields a different sequence
+	first, _ := NewCounterGenerator(storage.NewMemoryCounter(), DefaultCodeFormat(), WithObfuscation(key)).Generate(ctx, "", 1)
+	otherFirst, _ := NewCounterGenerator(storage.NewMemoryCounter(), DefaultCodeFormat(), WithObfuscation([]byte("fedcba9876543210"))).Generate(ctx, "", 1)
+	if first == otherFirst {
+		t.Errorf("different keys produced the same first code %s", first)
+	}
+}
+
+func TestAlphabet(t *testing.T) {
+	tests := []struct {
+		name        string
+		chars       string
+		expectError bool
+	}{
+		{name: "base62", chars: Base62Chars},
+		{name: "unambiguous", chars: UnambiguousChars},
+		{name: "binary", chars: "ab"},
+		{name: "too short", chars: "a", expectError: true},
+		{name: "duplicate character", chars: "abca", expectError: true},
+		{name: "not URL safe", chars: "ab/c", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alphabet, err := NewAlphabet(tt.chars)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("NewAlphabet() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			for _, value := range []uint64{0, 1, 12345, math.MaxUint64} {
+				decoded, err := alphabet.Decode(alphabet.Encode(value))
+				if err != nil || decoded != value {
+					t.Errorf("Decode(Encode(%d)) = %d, %v", value, decoded, err)
+				}
+			}
+		})
+	}
+
+	if strings.ContainsAny(UnambiguousChars, "0Oo1Il") {
+		t.Errorf("UnambiguousChars contains look-alike characters")
+	}
+}
+
+func TestCodeGenerators(t *testing.T) {
+	ctx := context.Background()
+	format, err := NewCodeFormat(Unambiguous, 8)
+	if err != nil {
+		t.Fatalf("NewCodeFormat() error = %v", err)
+	}
+	snowflake, err := NewSnowflakeGenerator(7, format)
+	if err != nil {
+		t.Fatalf("NewSnowflakeGenerator() error = %v", err)
+	}
+
+	generators := map[string]CodeGenerator{
+		"counter":    NewCounterGenerator(storage.NewMemoryCounter(), format),
+		"obfuscated": NewCounterGenerator(storage.NewMemoryCounter(), format, WithObfuscation([]byte("0123456789abcdef"))),
+		"random":     NewRandomGenerator(format),
+		"hash":       NewHashGenerator(format),
+		"snowflake":  snowflake,
+	}
+
+	for name, generator := range generators {
+		t.Run(name, func(t *testing.T) {
+			seen := make(map[string]struct{})
+			for i := 0; i < 10_000; i++ {
+				shortCode, err := generator.Generate(ctx, fmt.Sprintf("https://example.com/%d", i), 1)
+				if err != nil {
+					t.Fatalf("Generate() error = %v", err)
+				}
+				if len(shortCode) < format.Length {
+					t.Fatalf("Generate() = %s, expected at least %d characters", shortCode, format.Length)
+				}
+				if _, err := Unambiguous.Decode(shortCode); err != nil {
+					t.Fatalf("Generate() = %s, not in the alphabet: %v", shortCode, err)
+				}
+				if _, ok := seen[shortCode]; ok {
+					t.Fatalf("Generate() produced duplicate %s", shortCode)
+				}
+				seen[shortCode] = struct{}{}
+			}
+		})
+	}
+}
+
+func TestHashGenerator_Deterministic(t *testing.T) {
+	ctx := context.Background()
+	generator := NewHashGenerator(DefaultCodeFormat())
+
+	first, _ := generator.Generate(ctx, "https://example.com", 1)
+	again, _ := generator.Generate(ctx, "https://example.com", 1)
+	retry, _ := generator.Generate(ctx, "https://example.com", 2)
+	other, _ := generator.Generate(ctx, "https://example.org", 1)
+
+	if first != again {
+		t.Errorf("Generate() = %s then %s, expected the same code for the same URL", first, again)
+	}
+	if first == retry {
+		t.Errorf("Generate() returned %s again on the second attempt", retry)
+	}
+	if first == other {
+		t.Errorf("Generate() returned %s for different URLs", first)
+	}
+	if len(first) != DefaultCodeLength {
+		t.Errorf("Generate() = %s, expected length %d", first, DefaultCodeLength)
+	}
+}
+
+func TestSnowflakeGenerator(t *testing.T) {
+	if _, err := NewSnowflakeGenerator(MaxSnowflakeNode+1, DefaultCodeFormat()); err == nil {
+		t.Errorf("NewSnowflakeGenerator() expected an error for an out of range node")
+	}
+
+	generator, _ := NewSnowflakeGenerator(3, DefaultCodeFormat())
+	now := snowflakeEpoch.Add(time.Hour)
+	generator.now = func() time.Time { return now }
+
+	// More IDs than fit into one millisecond, then the clock goes backwards
+	var last int64
+	for i := 0; i < 3*(maxSnowflakeSequence+1); i++ {
+		if i == maxSnowflakeSequence {
+			now = now.Add(-time.Second)
+		}
+		id := generator.nextID()
+		if id <= last {
+			t.Fatalf("nextID() = %d after %d, expected increasing IDs", id, last)
+		}
+		if node := id >> snowflakeSequenceBits & MaxSnowflakeNode; node != 3 {
+			t.Fatalf("nextID() node = %d, expected 3", node)
+		}
+		last = id
+	}
+}
+
+func TestNewGenerator(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         config.ShortCodeConfig
+		expected    CodeGenerator
+		expectError bool
+	}{
+		{
+			name:     "counter",
+			cfg:      config.ShortCodeConfig{Strategy: config.StrategyCounter, Length: 6, Alphabet: "base62"},
+			expected: &CounterGenerator{},
+		},
+		{
+			name:     "random with unambiguous alphabet",
+			cfg:      config.ShortCodeConfig{Strategy: config.StrategyRandom, Length: 8, Alphabet: "unambiguous"},
+			expected: &RandomGenerator{},
+		},
+		{
+			name:     "hash with custom alphabet",
+			cfg:      config.ShortCodeConfig{Strategy: config.StrategyHash, Length: 10, Alphabet: "abcdef"},
+			expected: &HashGenerator{},
+		},
+		{
+			name:     "snowflake",
+			cfg:      config.ShortCodeConfig{Strategy: config.StrategySnowflake, Length: 6, NodeID: 12},
+			expected: &SnowflakeGenerator{},
+		},
+		{
+			name:        "invalid alphabet",
+			cfg:         config.ShortCodeConfig{Strategy: config.StrategyRandom, Length: 6, Alphabet: "a"},
+			expectError: true,
+		},
+		{
+			name:        "invalid node",
+			cfg:         config.ShortCodeConfig{Strategy: config.StrategySnowflake, Length: 6, NodeID: -1},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator, err := NewGenerator(tt.cfg, storage.NewMemoryCounter())
+			if (err != nil) != tt.expectError {
+				t.Fatalf("NewGenerator() error = %v, expectError %v", err, tt.expectError)
+			}
+			if !tt.expectError && reflect.TypeOf(generator) != reflect.TypeOf(tt.expected) {
+				t.Errorf("NewGenerator() = %T, expected %T", generator, tt.expected)
+			}
+		})
+	}
+}
+
+// sequenceGenerator hands out a fixed list of codes and records the attempts
+type sequenceGenerator struct {
+	codes    []string
+	attempts []int
+}
+
+func (g *sequenceGenerator) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	g.attempts = append(g.attempts, attempt)
+	return g.codes[len(g.attempts)-1], nil
+}
+
+func TestShortener_CreateShortURL_WithGenerator(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	if err := store.Create(context.Background(), models.NewURL("https://example.org", "taken")); err != nil {
+		t.Fatalf("Failed to seed storage: %v", err)
+	}
+
+	generator := &sequenceGenerator{codes: []string{"taken", "free"}}
+	shortener := NewShortener("https://example.com", nil, WithGenerator(generator), WithStore(store))
+
+	url, err := shortener.CreateShortURL(context.Background(), "https://example.com", CreateOptions{})
+	if err != nil {
+		t.Fatalf("CreateShortURL() error = %v", err)
+	}
+	if url.ShortCode != "free" {
+		t.Errorf("CreateShortURL() ShortCode = %s, expected free", url.ShortCode)
+	}
+	if !reflect.DeepEqual(generator.attempts, []int{1, 2}) {
+		t.Errorf("generator attempts = %v, expected [1 2]", generator.attempts)
+	}
+}
+
+func TestShortener_CreateShortURL_HashRepeated(t *testing.T) {
+	ctx := context.Background()
+	shortener := NewShortener("https://example.com", nil, WithGenerator(NewHashGenerator(DefaultCodeFormat())), WithStore(storage.NewMemoryStorage()))
+
+	// Every create of a URL gets the same candidates, so permanent links are
+	// shared rather than running out of attempts
+	first, err := shortener.CreateShortURL(ctx, "https://example.org/page", CreateOptions{})
+	if err != nil {
+		t.Fatalf("CreateShortURL() error = %v", err)
+	}
+	for i := 0; i < maxCreateAttempts+2; i++ {
+		url, err := shortener.CreateShortURL(ctx, "https://example.org/page", CreateOptions{})
+		if err != nil {
+			t.Fatalf("CreateShortURL() #%d error = %v", i+2, err)
+		}
+		if url.ShortCode != first.ShortCode || url.ShortURL != first.ShortURL {
+			t.Errorf("CreateShortURL() #%d = %s, expected %s", i+2, url.ShortURL, first.ShortURL)
+		}
+	}
+
+	// Expiring links are never shared
+	expiring, err := shortener.CreateShortURL(ctx, "https://example.org/page", CreateOptions{ExpiresIn: time.Hour})
+	if err != nil {
+		t.Fatalf("CreateShortURL() with expiration error = %v", err)
+	}
+	if expiring.ShortCode == first.ShortCode {
+		t.Errorf("CreateShortURL() with expiration reused %s", first.ShortCode)
+	}
+}
+
+// quarantiningStore quarantines every URL right after it is read, like a
+// redirect screening it concurrently
+type quarantiningStore struct {
+	*storage.MemoryStorage
+}
+
+func (s quarantiningStore) Get(ctx context.Context, shortCode string) (*models.URL, error) {
+	url, err := s.MemoryStorage.Get(ctx, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Quarantine(ctx, shortCode, url.OriginalURL, []string{"malware"}); err != nil {
+		return nil, err
+	}
+	return url, nil
+}
+
+func TestShortener_UpdateShortURL_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	if err := store.Create(ctx, models.NewURL("https://example.org", "abc123")); err != nil {
+		t.Fatalf("Failed to seed storage: %v", err)
+	}
+	shortener := NewShortener("https://example.com", nil, WithStore(quarantiningStore{store}))
+
+	// The quarantine is not overwritten with the copy that was read
+	if _, err := shortener.UpdateShortURL(ctx, "abc123", UpdateOptions{ExpiresIn: time.Hour}); !errors.Is(err, models.ErrURLChanged) {
+		t.Errorf("UpdateShortURL() error = %v, expected %v", err, models.ErrURLChanged)
+	}
+	url, _ := store.Get(ctx, "abc123")
+	if !url.IsQuarantined() || !url.ExpiresAt.IsZero() {
+		t.Errorf("UpdateShortURL() stored %+v, expected the quarantined URL unchanged", url)
+	}
+}
+
+func TestShortener_CreateShortURL_SkipsReservedCodes(t *testing.T) {
+	generator := &sequenceGenerator{codes: []string{"Health", "login", "free"}}
+	shortener := NewShortener("https://example.com", nil, WithGenerator(generator), WithStore(storage.NewMemoryStorage()), WithReservedAliases("login"))
+
+	url, err := shortener.CreateShortURL(context.Background(), "https://example.com", CreateOptions{})
+	if err != nil {
+		t.Fatalf("CreateShortURL() error = %v", err)
+	}
+	if url.ShortCode != "free" {
+		t.Errorf("CreateShortURL() ShortCode = %s, expected free", url.ShortCode)
+	}
+	if !reflect.DeepEqual(generator.attempts, []int{1, 2, 3}) {
+		t.Errorf("generator attempts = %v, expected [1 2 3]", generator.attempts)
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		expected string
+		wantErr  bool
+	}{
+		{"lowercase scheme and host", "HTTPS://Example.COM/Path", "https://example.com/Path", false},
+		{"default port", "https://example.com:443/a", "https://example.com/a", false},
+		{"default http port", "http://example.com:80", "http://example.com/", false},
+		{"other port kept", "https://example.com:8443/a", "https://example.com:8443/a", false},
+		{"empty path", "https://example.com", "https://example.com/", false},
+		{"trailing dot", "https://example.com./a", "https://example.com/a", false},
+		{"sorted query", "https://example.com/?b=2&a=1&a=0", "https://example.com/?a=1&a=0&b=2", false},
+		{"empty query", "https://example.com/a?", "https://example.com/a", false},
+		{"fragment kept", "https://example.com/a#Top", "https://example.com/a#Top", false},
+		{"empty fragment", "https://example.com/a#", "https://example.com/a", false},
+		{"IDN host", "https://Bücher.example/", "https://xn--bcher-kva.example/", false},
+		{"IPv6 host", "http://[2001:DB8::1]:80/", "http://[2001:db8::1]/", false},
+		{"IPv6 host with port", "http://[2001:db8::1]:8080/", "http://[2001:db8::1]:8080/", false},
+		{"no host", "/relative", "", true},
+		{"invalid host", "https://exa_mple..com/", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Canonicalize(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Canonicalize(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("Canonicalize(%q) = %q, expected %q", tt.url, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestShortener_CreateShortURL_Dedup(t *testing.T) {
+	ctx := context.Background()
+	var counter int64
+	mockCounter := &MockCounterStorage{
+		GetNextCounterFunc: func(ctx context.Context) (int64, error) {
+			counter++
+			return counter, nil
+		},
+	}
+	store := storage.NewMemoryStorage()
+	shortener := NewShortener("https://example.com", mockCounter, WithStore(store), WithDedup(true))
+
+	first, err := shortener.CreateShortURL(ctx, "https://Example.org:443/page?b=2&a=1", CreateOptions{})
+	if err != nil {
+		t.Fatalf("CreateShortURL() error = %v", err)
+	}
+
+	// The same destination written differently reuses the code
+	second, err := shortener.CreateShortURL(ctx, "https://example.org/page?a=1&b=2", CreateOptions{})
+	if err != nil {
+		t.Fatalf("CreateShortURL() error = %v", err)
+	}
+	if second.ShortCode != first.ShortCode || second.ShortURL != first.ShortURL {
+		t.Errorf("CreateShortURL() = %s, expected the existing %s", second.ShortCode, first.ShortCode)
+	}
+
+	// Aliases, expirations and other destinations get their own codes
+	others := []struct {
+		url  string
+		opts CreateOptions
+	}{
+		{"https://example.org/page?a=1&b=2", CreateOptions{Alias: "page"}},
+		{"https://example.org/page?a=1&b=2", CreateOptions{ExpiresIn: time.Hour}},
+		{"https://example.org/other", CreateOptions{}},
+	}
+	for _, other := range others {
+		url, err := shortener.CreateShortURL(ctx, other.url, other.opts)
+		if err != nil {
+			t.Fatalf("CreateShortURL() error = %v", err)
+		}
+		if url.ShortCode == first.ShortCode {
+			t.Errorf("CreateShortURL(%s, %+v) reused %s", other.url, other.opts, first.ShortCode)
+		}
+	}
+
+	// Changing the destination takes the URL out of dedup
+	if _, err := shortener.UpdateShortURL(ctx, first.ShortCode, UpdateOptions{OriginalURL: "https://example.org/moved"}); err != nil {
+		t.Fatalf("UpdateShortURL() error = %v", err)
+	}
+	third, err := shortener.CreateShortURL(ctx, "https://example.org/page?a=1&b=2", CreateOptions{})
+	if err != nil {
+		t.Fatalf("CreateShortURL() error = %v", err)
+	}
+	if third.ShortCode == first.ShortCode {
+		t.Errorf("CreateShortURL() reused %s after its destination changed", first.ShortCode)
+	}
+
+	// Without dedup every create mints a code
+	plain := NewShortener("https://example.com", mockCounter, WithStore(store))
+	url, err := plain.CreateShortURL(ctx, "https://example.org/other", CreateOptions{})
+	if err != nil {
+		t.Fatalf("CreateShortURL() error = %v", err)
+	}
+	if url.CanonicalHash != "" {
+		t.Errorf("CreateShortURL() without dedup set CanonicalHash = %q", url.CanonicalHash)
+	}
+}
+
+func TestShortener_ValidateURL_Policy(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		policy      Policy
+		expectedErr error
+	}{
+		{"public URL", "https://example.org/a?b=c", DefaultPolicy(), nil},
+		{"javascript", "javascript:alert(1)", DefaultPolicy(), models.ErrSchemeNotAllowed},
+		{"file", "file://host/etc/passwd", DefaultPolicy(), models.ErrSchemeNotAllowed},
+		{"data with host", "data://example.org/text", DefaultPolicy(), models.ErrSchemeNotAllowed},
+		{"uppercase scheme", "HTTPS://example.org", DefaultPolicy(), nil},
+		{"too long", "https://example.org/" + strings.Repeat("a", DefaultMaxURLLength), DefaultPolicy(), models.ErrURLTooLong},
+		{"unbounded length", "https://example.org/" + strings.Repeat("a", DefaultMaxURLLength), Policy{AllowedSchemes: []string{"https"}}, nil},
+		{"localhost", "http://localhost:8080/", DefaultPolicy(), models.ErrPrivateHost},
+		{"localhost subdomain", "http://app.localhost/", DefaultPolicy(), models.ErrPrivateHost},
+		{"single label", "http://intranet/wiki", DefaultPolicy(), models.ErrPrivateHost},
+		{"mDNS", "http://printer.local/", DefaultPolicy(), models.ErrPrivateHost},
+		{"loopback", "http://127.0.0.1/", DefaultPolicy(), models.ErrPrivateHost},
+		{"private range", "http://10.1.2.3/", DefaultPolicy(), models.ErrPrivateHost},
+		{"link-local metadata", "http://169.254.169.254/latest/meta-data", DefaultPolicy(), models.ErrPrivateHost},
+		{"IPv6 loopback", "http://[::1]:8080/", DefaultPolicy(), models.ErrPrivateHost},
+		{"IPv4-mapped loopback", "http://[::ffff:127.0.0.1]/", DefaultPolicy(), models.ErrPrivateHost},
+		{"private allowed", "http://10.1.2.3/", Policy{AllowedSchemes: []string{"http"}, AllowPrivateHosts: true}, models.ErrIPHost},
+		{"public IP", "http://93.184.216.34/", DefaultPolicy(), models.ErrIPHost},
+		{"public IP allowed", "http://93.184.216.34/", Policy{AllowedSchemes: []string{"http"}, AllowIPHosts: true}, nil},
+		{"private IP with IPs allowed", "http://192.168.1.1/", Policy{AllowedSchemes: []string{"http"}, AllowIPHosts: true}, models.ErrPrivateHost},
+		{"decimal IP", "http://2130706433/", DefaultPolicy(), models.ErrIPHost},
+		{"hex IP", "http://0x7f.1/", DefaultPolicy(), models.ErrIPHost},
+		{"short URL", "https://sho.rt/abc123", DefaultPolicy(), models.ErrRedirectLoop},
+		{"short URL with query", "https://SHO.RT./abc123?utm_source=x", DefaultPolicy(), models.ErrRedirectLoop},
+		{"short domain home page", "https://sho.rt/", DefaultPolicy(), nil},
+		{"short domain reserved route", "https://sho.rt/stats", DefaultPolicy(), nil},
+		{"short domain nested path", "https://sho.rt/stats/abc123", DefaultPolicy(), nil},
+		{"alternate short domain", "https://go.example.org/abc123", Policy{AllowedSchemes: []string{"https"}, ShortDomains: []string{"go.example.org"}}, models.ErrRedirectLoop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shortener := NewShortener("https://sho.rt", nil, WithPolicy(tt.policy))
+			err := shortener.ValidateURL(tt.url)
+			if !errors.Is(err, tt.expectedErr) || (err == nil) != (tt.expectedErr == nil) {
+				t.Errorf("ValidateURL(%q) error = %v, expected %v", tt.url, err, tt.expectedErr)
+			}
+			if err != nil && !models.IsValidationError(err) {
+				t.Errorf("ValidateURL(%q) error = %v is not a validation error", tt.url, err)
+			}
+		})
+	}
+
+	// Short URLs below a base path are recognised
+	shortener := NewShortener("https://example.com/s/", nil)
+	if err := shortener.ValidateURL("https://example.com/s/abc123"); !errors.Is(err, models.ErrRedirectLoop) {
+		t.Errorf("ValidateURL() error = %v, expected %v", err, models.ErrRedirectLoop)
+	}
+	if err := shortener.ValidateURL("https://example.com/abc123"); err != nil {
+		t.Errorf("ValidateURL() outside the base path error = %v", err)
+	}
+}
+
+func TestShortener_CreateShortURL_DomainPolicy(t *testing.T) {
+	rules, err := domains.NewRules([]domains.Rule{
+		{Pattern: "*.evil.com", Action: domains.Block},
+		{Pattern: "evil.com", Action: domains.Block},
+	})
+	if err != nil {
+		t.Fatalf("NewRules() error = %v", err)
+	}
+	store := storage.NewMemoryStorage()
+	shortener := NewShortener("https://sho.rt", storage.NewMemoryCounter(), WithStore(store), WithDomainPolicy(rules))
+	ctx := context.Background()
+
+	for _, url := range []string{"https://evil.com/login", "https://secure.EVIL.com./login"} {
+		_, err := shortener.CreateShortURL(ctx, url, CreateOptions{})
+		if !errors.Is(err, models.ErrDomainBlocked) || !models.IsValidationError(err) {
+			t.Errorf("CreateShortURL(%q) error = %v, expected %v", url, err, models.ErrDomainBlocked)
+		}
+	}
+
+	url, err := shortener.CreateShortURL(ctx, "https://notevil.com/", CreateOptions{})
+	if err != nil {
+		t.Fatalf("CreateShortURL() error = %v", err)
+	}
+	// Updates cannot move a link to a blocked domain either
+	if _, err := shortener.UpdateShortURL(ctx, url.ShortCode, UpdateOptions{OriginalURL: "https://evil.com/"}); !errors.Is(err, models.ErrDomainBlocked) {
+		t.Errorf("UpdateShortURL() error = %v, expected %v", err, models.ErrDomainBlocked)
+	}
+}
+
+// screenerFunc adapts a function to URLScreener
+type screenerFunc func(rawURL string) []string
+
+func (f screenerFunc) Screen(rawURL string) []string {
+	return f(rawURL)
+}
+
+func TestShortener_CreateShortURL_Screening(t *testing.T) {
+	flagged := screenerFunc(func(rawURL string) []string {
+		if strings.Contains(rawURL, "malware") {
+			return []string{"malware"}
+		}
+		return nil
+	})
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	// Flagged URLs are refused
+	refusing := NewShortener("https://sho.rt", storage.NewMemoryCounter(), WithStore(store), WithScreener(flagged, false))
+	_, err := refusing.CreateShortURL(ctx, "https://malware.example/", CreateOptions{})
+	if !errors.Is(err, models.ErrURLFlagged) || !models.IsValidationError(err) {
+		t.Errorf("CreateShortURL() error = %v, expected %v", err, models.ErrURLFlagged)
+	}
+
+	// or stored quarantined, and never shared with dedup
+	quarantining := NewShortener("https://sho.rt", storage.NewMemoryCounter(), WithStore(store), WithScreener(flagged, true), WithDedup(true))
+	first, err := quarantining.CreateShortURL(ctx, "https://malware.example/", CreateOptions{})
+	if err != nil {
+		t.Fatalf("CreateShortURL() error = %v", err)
+	}
+	if !first.IsQuarantined() || !reflect.DeepEqual(first.Threats, []string{"malware"}) {
+		t.Errorf("CreateShortURL() status = %q threats = %v, expected quarantined by malware", first.Status, first.Threats)
+	}
+	stored, err := store.Get(ctx, first.ShortCode)
+	if err != nil || !stored.IsQuarantined() {
+		t.Errorf("stored URL = %+v, %v, expected quarantined", stored, err)
+	}
+	second, err := quarantining.CreateShortURL(ctx, "https://malware.example/", CreateOptions{})
+	if err != nil || second.ShortCode == first.ShortCode {
+		t.Errorf("CreateShortURL() of the same flagged URL = %v, %v, expected a new code", second, err)
+	}
+
+	// A new clean destination lifts the quarantine
+	updated, err := quarantining.UpdateShortURL(ctx, first.ShortCode, UpdateOptions{OriginalURL: "https://example.com/"})
+	if err != nil {
+		t.Fatalf("UpdateShortURL() error = %v", err)
+	}
+	if updated.Status != "" || updated.Threats != nil {
+		t.Errorf("UpdateShortURL() status = %q threats = %v, expected active", updated.Status, updated.Threats)
+	}
+	if _, err := refusing.UpdateShortURL(ctx, first.ShortCode, UpdateOptions{OriginalURL: "https://malware.example/"}); !errors.Is(err, models.ErrURLFlagged) {
+		t.Errorf("UpdateShortURL() error = %v, expected %v", err, models.ErrURLFlagged)
+	}
+}
@@ -0,0 +1,74 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+
+	// MaxSnowflakeNode is the highest node ID a SnowflakeGenerator accepts
+	MaxSnowflakeNode = 1<<snowflakeNodeBits - 1
+
+	maxSnowflakeSequence = 1<<snowflakeSequenceBits - 1
+)
+
+// snowflakeEpoch is the zero point of Snowflake timestamps
+var snowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeGenerator builds Twitter Snowflake style IDs out of a millisecond
+// timestamp, a node ID and a per-millisecond sequence. Nodes generate codes
+// without coordinating with each other, so every node in a deployment must
+// have its own ID.
+type SnowflakeGenerator struct {
+	mu       sync.Mutex
+	node     int64
+	format   CodeFormat
+	now      func() time.Time
+	millis   int64
+	sequence int64
+}
+
+func NewSnowflakeGenerator(node int64, format CodeFormat) (*SnowflakeGenerator, error) {
+	if node < 0 || node > MaxSnowflakeNode {
+		return nil, fmt.Errorf("snowflake node ID must be between 0 and %d", MaxSnowflakeNode)
+	}
+	return &SnowflakeGenerator{
+		node:     node,
+		format:   format,
+		now:      time.Now,
+		millis:   -1,
+		sequence: maxSnowflakeSequence,
+	}, nil
+}
+
+func (g *SnowflakeGenerator) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	return g.format.encode(uint64(g.nextID())), nil
+}
+
+// nextID returns the next ID. The timestamp never goes backwards: when the
+// clock does, or when a millisecond's sequence is exhausted, the generator
+// keeps counting on the last timestamp it used instead of waiting.
+func (g *SnowflakeGenerator) nextID() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	millis := g.now().Sub(snowflakeEpoch).Milliseconds()
+	if millis > g.millis {
+		g.millis = millis
+		g.sequence = 0
+	} else if g.sequence < maxSnowflakeSequence {
+		g.sequence++
+	} else {
+		g.millis++
+		g.sequence = 0
+	}
+
+	return g.millis<<(snowflakeNodeBits+snowflakeSequenceBits) |
+		g.node<<snowflakeSequenceBits |
+		g.sequence
+}
@@ -0,0 +1,595 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.3
+// source: proto/urlshortener.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	URLShortener_CreateShortURL_FullMethodName       = "/urlshortener.URLShortener/CreateShortURL"
+	URLShortener_GetOriginalURL_FullMethodName       = "/urlshortener.URLShortener/GetOriginalURL"
+	URLShortener_GetURLStats_FullMethodName          = "/urlshortener.URLShortener/GetURLStats"
+	URLShortener_GetURLTimeSeries_FullMethodName     = "/urlshortener.URLShortener/GetURLTimeSeries"
+	URLShortener_WatchClicks_FullMethodName          = "/urlshortener.URLShortener/WatchClicks"
+	URLShortener_ExportClicks_FullMethodName         = "/urlshortener.URLShortener/ExportClicks"
+	URLShortener_UpdateShortURL_FullMethodName       = "/urlshortener.URLShortener/UpdateShortURL"
+	URLShortener_DeleteShortURL_FullMethodName       = "/urlshortener.URLShortener/DeleteShortURL"
+	URLShortener_ListShortURLs_FullMethodName        = "/urlshortener.URLShortener/ListShortURLs"
+	URLShortener_BatchCreateShortURLs_FullMethodName = "/urlshortener.URLShortener/BatchCreateShortURLs"
+	URLShortener_ListFlaggedURLs_FullMethodName      = "/urlshortener.URLShortener/ListFlaggedURLs"
+	URLShortener_ReleaseFlaggedURL_FullMethodName    = "/urlshortener.URLShortener/ReleaseFlaggedURL"
+)
+
+// URLShortenerClient is the client API for URLShortener service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type URLShortenerClient interface {
+	// CreateShortURL creates a shortened URL from a long URL
+	CreateShortURL(ctx context.Context, in *CreateShortURLRequest, opts ...grpc.CallOption) (*CreateShortURLResponse, error)
+	// GetOriginalURL retrieves the original URL from a short code
+	GetOriginalURL(ctx context.Context, in *GetOriginalURLRequest, opts ...grpc.CallOption) (*GetOriginalURLResponse, error)
+	// GetURLStats retrieves statistics for a shortened URL
+	GetURLStats(ctx context.Context, in *GetURLStatsRequest, opts ...grpc.CallOption) (*GetURLStatsResponse, error)
+	// GetURLTimeSeries retrieves the clicks of a shortened URL per time bucket
+	GetURLTimeSeries(ctx context.Context, in *GetURLTimeSeriesRequest, opts ...grpc.CallOption) (*GetURLTimeSeriesResponse, error)
+	// WatchClicks streams clicks of the selected short URLs as they happen
+	WatchClicks(ctx context.Context, in *WatchClicksRequest, opts ...grpc.CallOption) (URLShortener_WatchClicksClient, error)
+	// ExportClicks streams an export file of clicks or link aggregates in chunks
+	ExportClicks(ctx context.Context, in *ExportClicksRequest, opts ...grpc.CallOption) (URLShortener_ExportClicksClient, error)
+	// UpdateShortURL changes the destination or expiration of a short URL
+	UpdateShortURL(ctx context.Context, in *UpdateShortURLRequest, opts ...grpc.CallOption) (*ShortURL, error)
+	// DeleteShortURL removes a short URL
+	DeleteShortURL(ctx context.Context, in *DeleteShortURLRequest, opts ...grpc.CallOption) (*DeleteShortURLResponse, error)
+	// ListShortURLs pages through short URLs matching optional filters
+	ListShortURLs(ctx context.Context, in *ListShortURLsRequest, opts ...grpc.CallOption) (*ListShortURLsResponse, error)
+	// BatchCreateShortURLs creates several short URLs, reporting a result per item
+	BatchCreateShortURLs(ctx context.Context, in *BatchCreateShortURLsRequest, opts ...grpc.CallOption) (*BatchCreateShortURLsResponse, error)
+	// ListFlaggedURLs pages through the short URLs quarantined by screening
+	ListFlaggedURLs(ctx context.Context, in *ListFlaggedURLsRequest, opts ...grpc.CallOption) (*ListShortURLsResponse, error)
+	// ReleaseFlaggedURL lifts the quarantine of a short URL after review
+	ReleaseFlaggedURL(ctx context.Context, in *ReleaseFlaggedURLRequest, opts ...grpc.CallOption) (*ShortURL, error)
+}
+
+type uRLShortenerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewURLShortenerClient(cc grpc.ClientConnInterface) URLShortenerClient {
+	return &uRLShortenerClient{cc}
+}
+
+func (c *uRLShortenerClient) CreateShortURL(ctx context.Context, in *CreateShortURLRequest, opts ...grpc.CallOption) (*CreateShortURLResponse, error) {
+	out := new(CreateShortURLResponse)
+	err := c.cc.Invoke(ctx, URLShortener_CreateShortURL_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLShortenerClient) GetOriginalURL(ctx context.Context, in *GetOriginalURLRequest, opts ...grpc.CallOption) (*GetOriginalURLResponse, error) {
+	out := new(GetOriginalURLResponse)
+	err := c.cc.Invoke(ctx, URLShortener_GetOriginalURL_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLShortenerClient) GetURLStats(ctx context.Context, in *GetURLStatsRequest, opts ...grpc.CallOption) (*GetURLStatsResponse, error) {
+	out := new(GetURLStatsResponse)
+	err := c.cc.Invoke(ctx, URLShortener_GetURLStats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLShortenerClient) GetURLTimeSeries(ctx context.Context, in *GetURLTimeSeriesRequest, opts ...grpc.CallOption) (*GetURLTimeSeriesResponse, error) {
+	out := new(GetURLTimeSeriesResponse)
+	err := c.cc.Invoke(ctx, URLShortener_GetURLTimeSeries_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLShortenerClient) WatchClicks(ctx context.Context, in *WatchClicksRequest, opts ...grpc.CallOption) (URLShortener_WatchClicksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &URLShortener_ServiceDesc.Streams[0], URLShortener_WatchClicks_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &uRLShortenerWatchClicksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type URLShortener_WatchClicksClient interface {
+	Recv() (*ClickEvent, error)
+	grpc.ClientStream
+}
+
+type uRLShortenerWatchClicksClient struct {
+	grpc.ClientStream
+}
+
+func (x *uRLShortenerWatchClicksClient) Recv() (*ClickEvent, error) {
+	m := new(ClickEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *uRLShortenerClient) ExportClicks(ctx context.Context, in *ExportClicksRequest, opts ...grpc.CallOption) (URLShortener_ExportClicksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &URLShortener_ServiceDesc.Streams[1], URLShortener_ExportClicks_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &uRLShortenerExportClicksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type URLShortener_ExportClicksClient interface {
+	Recv() (*ExportChunk, error)
+	grpc.ClientStream
+}
+
+type uRLShortenerExportClicksClient struct {
+	grpc.ClientStream
+}
+
+func (x *uRLShortenerExportClicksClient) Recv() (*ExportChunk, error) {
+	m := new(ExportChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *uRLShortenerClient) UpdateShortURL(ctx context.Context, in *UpdateShortURLRequest, opts ...grpc.CallOption) (*ShortURL, error) {
+	out := new(ShortURL)
+	err := c.cc.Invoke(ctx, URLShortener_UpdateShortURL_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLShortenerClient) DeleteShortURL(ctx context.Context, in *DeleteShortURLRequest, opts ...grpc.CallOption) (*DeleteShortURLResponse, error) {
+	out := new(DeleteShortURLResponse)
+	err := c.cc.Invoke(ctx, URLShortener_DeleteShortURL_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLShortenerClient) ListShortURLs(ctx context.Context, in *ListShortURLsRequest, opts ...grpc.CallOption) (*ListShortURLsResponse, error) {
+	out := new(ListShortURLsResponse)
+	err := c.cc.Invoke(ctx, URLShortener_ListShortURLs_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLShortenerClient) BatchCreateShortURLs(ctx context.Context, in *BatchCreateShortURLsRequest, opts ...grpc.CallOption) (*BatchCreateShortURLsResponse, error) {
+	out := new(BatchCreateShortURLsResponse)
+	err := c.cc.Invoke(ctx, URLShortener_BatchCreateShortURLs_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLShortenerClient) ListFlaggedURLs(ctx context.Context, in *ListFlaggedURLsRequest, opts ...grpc.CallOption) (*ListShortURLsResponse, error) {
+	out := new(ListShortURLsResponse)
+	err := c.cc.Invoke(ctx, URLShortener_ListFlaggedURLs_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLShortenerClient) ReleaseFlaggedURL(ctx context.Context, in *ReleaseFlaggedURLRequest, opts ...grpc.CallOption) (*ShortURL, error) {
+	out := new(ShortURL)
+	err := c.cc.Invoke(ctx, URLShortener_ReleaseFlaggedURL_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// URLShortenerServer is the server API for URLShortener service.
+// All implementations must embed UnimplementedURLShortenerServer
+// for forward compatibility
+type URLShortenerServer interface {
+	// CreateShortURL creates a shortened URL from a long URL
+	CreateShortURL(context.Context, *CreateShortURLRequest) (*CreateShortURLResponse, error)
+	// GetOriginalURL retrieves the original URL from a short code
+	GetOriginalURL(context.Context, *GetOriginalURLRequest) (*GetOriginalURLResponse, error)
+	// GetURLStats retrieves statistics for a shortened URL
+	GetURLStats(context.Context, *GetURLStatsRequest) (*GetURLStatsResponse, error)
+	// GetURLTimeSeries retrieves the clicks of a shortened URL per time bucket
+	GetURLTimeSeries(context.Context, *GetURLTimeSeriesRequest) (*GetURLTimeSeriesResponse, error)
+	// WatchClicks streams clicks of the selected short URLs as they happen
+	WatchClicks(*WatchClicksRequest, URLShortener_WatchClicksServer) error
+	// ExportClicks streams an export file of clicks or link aggregates in chunks
+	ExportClicks(*ExportClicksRequest, URLShortener_ExportClicksServer) error
+	// UpdateShortURL changes the destination or expiration of a short URL
+	UpdateShortURL(context.Context, *UpdateShortURLRequest) (*ShortURL, error)
+	// DeleteShortURL removes a short URL
+	DeleteShortURL(context.Context, *DeleteShortURLRequest) (*DeleteShortURLResponse, error)
+	// ListShortURLs pages through short URLs matching optional filters
+	ListShortURLs(context.Context, *ListShortURLsRequest) (*ListShortURLsResponse, error)
+	// BatchCreateShortURLs creates several short URLs, reporting a result per item
+	BatchCreateShortURLs(context.Context, *BatchCreateShortURLsRequest) (*BatchCreateShortURLsResponse, error)
+	// ListFlaggedURLs pages through the short URLs quarantined by screening
+	ListFlaggedURLs(context.Context, *ListFlaggedURLsRequest) (*ListShortURLsResponse, error)
+	// ReleaseFlaggedURL lifts the quarantine of a short URL after review
+	ReleaseFlaggedURL(context.Context, *ReleaseFlaggedURLRequest) (*ShortURL, error)
+	mustEmbedUnimplementedURLShortenerServer()
+}
+
+// UnimplementedURLShortenerServer must be embedded to have forward compatible implementations.
+type UnimplementedURLShortenerServer struct {
+}
+
+func (UnimplementedURLShortenerServer) CreateShortURL(context.Context, *CreateShortURLRequest) (*CreateShortURLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateShortURL not implemented")
+}
+func (UnimplementedURLShortenerServer) GetOriginalURL(context.Context, *GetOriginalURLRequest) (*GetOriginalURLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetOriginalURL not implemented")
+}
+func (UnimplementedURLShortenerServer) GetURLStats(context.Context, *GetURLStatsRequest) (*GetURLStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetURLStats not implemented")
+}
+func (UnimplementedURLShortenerServer) GetURLTimeSeries(context.Context, *GetURLTimeSeriesRequest) (*GetURLTimeSeriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetURLTimeSeries not implemented")
+}
+func (UnimplementedURLShortenerServer) WatchClicks(*WatchClicksRequest, URLShortener_WatchClicksServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchClicks not implemented")
+}
+func (UnimplementedURLShortenerServer) ExportClicks(*ExportClicksRequest, URLShortener_ExportClicksServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportClicks not implemented")
+}
+func (UnimplementedURLShortenerServer) UpdateShortURL(context.Context, *UpdateShortURLRequest) (*ShortURL, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateShortURL not implemented")
+}
+func (UnimplementedURLShortenerServer) DeleteShortURL(context.Context, *DeleteShortURLRequest) (*DeleteShortURLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteShortURL not implemented")
+}
+func (UnimplementedURLShortenerServer) ListShortURLs(context.Context, *ListShortURLsRequest) (*ListShortURLsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListShortURLs not implemented")
+}
+func (UnimplementedURLShortenerServer) BatchCreateShortURLs(context.Context, *BatchCreateShortURLsRequest) (*BatchCreateShortURLsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchCreateShortURLs not implemented")
+}
+func (UnimplementedURLShortenerServer) ListFlaggedURLs(context.Context, *ListFlaggedURLsRequest) (*ListShortURLsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFlaggedURLs not implemented")
+}
+func (UnimplementedURLShortenerServer) ReleaseFlaggedURL(context.Context, *ReleaseFlaggedURLRequest) (*ShortURL, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseFlaggedURL not implemented")
+}
+func (UnimplementedURLShortenerServer) mustEmbedUnimplementedURLShortenerServer() {}
+
+// UnsafeURLShortenerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to URLShortenerServer will
+// result in compilation errors.
+type UnsafeURLShortenerServer interface {
+	mustEmbedUnimplementedURLShortenerServer()
+}
+
+func RegisterURLShortenerServer(s grpc.ServiceRegistrar, srv URLShortenerServer) {
+	s.RegisterService(&URLShortener_ServiceDesc, srv)
+}
+
+func _URLShortener_CreateShortURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateShortURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLShortenerServer).CreateShortURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLShortener_CreateShortURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLShortenerServer).CreateShortURL(ctx, req.(*CreateShortURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLShortener_GetOriginalURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOriginalURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLShortenerServer).GetOriginalURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLShortener_GetOriginalURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLShortenerServer).GetOriginalURL(ctx, req.(*GetOriginalURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLShortener_GetURLStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetURLStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLShortenerServer).GetURLStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLShortener_GetURLStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLShortenerServer).GetURLStats(ctx, req.(*GetURLStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLShortener_GetURLTimeSeries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetURLTimeSeriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLShortenerServer).GetURLTimeSeries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLShortener_GetURLTimeSeries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLShortenerServer).GetURLTimeSeries(ctx, req.(*GetURLTimeSeriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLShortener_WatchClicks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchClicksRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(URLShortenerServer).WatchClicks(m, &uRLShortenerWatchClicksServer{stream})
+}
+
+type URLShortener_WatchClicksServer interface {
+	Send(*ClickEvent) error
+	grpc.ServerStream
+}
+
+type uRLShortenerWatchClicksServer struct {
+	grpc.ServerStream
+}
+
+func (x *uRLShortenerWatchClicksServer) Send(m *ClickEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _URLShortener_ExportClicks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportClicksRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(URLShortenerServer).ExportClicks(m, &uRLShortenerExportClicksServer{stream})
+}
+
+type URLShortener_ExportClicksServer interface {
+	Send(*ExportChunk) error
+	grpc.ServerStream
+}
+
+type uRLShortenerExportClicksServer struct {
+	grpc.ServerStream
+}
+
+func (x *uRLShortenerExportClicksServer) Send(m *ExportChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _URLShortener_UpdateShortURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateShortURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLShortenerServer).UpdateShortURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLShortener_UpdateShortURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLShortenerServer).UpdateShortURL(ctx, req.(*UpdateShortURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLShortener_DeleteShortURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteShortURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLShortenerServer).DeleteShortURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLShortener_DeleteShortURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLShortenerServer).DeleteShortURL(ctx, req.(*DeleteShortURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLShortener_ListShortURLs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListShortURLsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLShortenerServer).ListShortURLs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLShortener_ListShortURLs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLShortenerServer).ListShortURLs(ctx, req.(*ListShortURLsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLShortener_BatchCreateShortURLs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchCreateShortURLsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLShortenerServer).BatchCreateShortURLs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLShortener_BatchCreateShortURLs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLShortenerServer).BatchCreateShortURLs(ctx, req.(*BatchCreateShortURLsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLShortener_ListFlaggedURLs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFlaggedURLsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLShortenerServer).ListFlaggedURLs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLShortener_ListFlaggedURLs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLShortenerServer).ListFlaggedURLs(ctx, req.(*ListFlaggedURLsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLShortener_ReleaseFlaggedURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseFlaggedURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLShortenerServer).ReleaseFlaggedURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLShortener_ReleaseFlaggedURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLShortenerServer).ReleaseFlaggedURL(ctx, req.(*ReleaseFlaggedURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// URLShortener_ServiceDesc is the grpc.ServiceDesc for URLShortener service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var URLShortener_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "urlshortener.URLShortener",
+	HandlerType: (*URLShortenerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateShortURL",
+			Handler:    _URLShortener_CreateShortURL_Handler,
+		},
+		{
+			MethodName: "GetOriginalURL",
+			Handler:    _URLShortener_GetOriginalURL_Handler,
+		},
+		{
+			MethodName: "GetURLStats",
+			Handler:    _URLShortener_GetURLStats_Handler,
+		},
+		{
+			MethodName: "GetURLTimeSeries",
+			Handler:    _URLShortener_GetURLTimeSeries_Handler,
+		},
+		{
+			MethodName: "UpdateShortURL",
+			Handler:    _URLShortener_UpdateShortURL_Handler,
+		},
+		{
+			MethodName: "DeleteShortURL",
+			Handler:    _URLShortener_DeleteShortURL_Handler,
+		},
+		{
+			MethodName: "ListShortURLs",
+			Handler:    _URLShortener_ListShortURLs_Handler,
+		},
+		{
+			MethodName: "BatchCreateShortURLs",
+			Handler:    _URLShortener_BatchCreateShortURLs_Handler,
+		},
+		{
+			MethodName: "ListFlaggedURLs",
+			Handler:    _URLShortener_ListFlaggedURLs_Handler,
+		},
+		{
+			MethodName: "ReleaseFlaggedURL",
+			Handler:    _URLShortener_ReleaseFlaggedURL_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchClicks",
+			Handler:       _URLShortener_WatchClicks_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ExportClicks",
+			Handler:       _URLShortener_ExportClicks_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/urlshortener.proto",
+}